@@ -126,7 +126,7 @@ func ExecuteClient() {
 		"repo", repo,
 	)
 
-	if command != "receive-pack" && command != "upload-pack" {
+	if command != "receive-pack" && command != "upload-pack" && command != "upload-archive" {
 		exitWithError("Invalid call")
 	}
 	command = "git-" + command
@@ -138,6 +138,9 @@ func ExecuteClient() {
 	if rawgit {
 		// This is a plain git repo
 		callGit(command, repo)
+	} else if command == "git-upload-archive" {
+		// With upload-archive, the client speaks first and there is no ref discovery
+		performService(os.Stdin, command, reponame)
 	} else {
 		// This might be a repo for us! Let's get to it.
 		performRefDiscovery(command, reponame)
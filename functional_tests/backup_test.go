@@ -0,0 +1,57 @@
+package functional_tests
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+
+	spawnNode(t, nodea)
+
+	createRepo(t, nodea, "test1", true)
+	createRepo(t, nodea, "test2", false)
+	wdir := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 2)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	fullbackup := path.Join(testDir, "backup-full.tar")
+	runCommand(t, nodea.Name(), "admin", "backup", fullbackup)
+	runFailingCommand(t, nodea.Name(), "admin", "backup", fullbackup)
+
+	writeTestFiles(t, wdir, 3, 3)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Pushing after the backup")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	incrbackup := path.Join(testDir, "backup-incr.tar")
+	runCommand(t, nodea.Name(), "admin", "backup", incrbackup, "--incremental", fullbackup)
+	fullinfo, err := os.Stat(fullbackup)
+	failIfErr(t, err, "checking full backup")
+	incrinfo, err := os.Stat(incrbackup)
+	failIfErr(t, err, "checking incremental backup")
+	if incrinfo.Size() >= fullinfo.Size() {
+		t.Errorf("Incremental backup is %d bytes, full backup is %d bytes", incrinfo.Size(), fullinfo.Size())
+	}
+
+	// Restore into a new region, with all the objects from both backups
+	killNode(t, nodea)
+	restoreNode(t, nodeb, incrbackup)
+
+	r1 := testRepoInfo{name: "test1", public: true}
+	r2 := testRepoInfo{name: "test2", public: false}
+	verifyReposExist(t, nodeb, r1, r2)
+	wdir = clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir, 0, 3)
+
+	// The restored region accepts pushes
+	writeTestFiles(t, wdir, 4, 4)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Pushing after the restore")
+	runRawCommand(t, "git", wdir, nil, "push")
+	wdir = clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir, 0, 4)
+}
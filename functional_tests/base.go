@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -83,6 +84,7 @@ var (
 	cloneDir      string
 	builtCa       bool
 	nodes         = make(map[nodeNrType]*nodeState)
+	nodeRegions   = make(map[nodeNrType]string)
 	doneC         = make(chan struct{})
 	useBubbleWrap bool
 )
@@ -136,6 +138,7 @@ func testCleanup(t *testing.T) {
 	cloneDir = ""
 	builtCa = false
 	nodes = make(map[nodeNrType]*nodeState)
+	nodeRegions = make(map[nodeNrType]string)
 	useBubbleWrap = false
 }
 
@@ -401,6 +404,19 @@ func (n nodeNrType) Name() string {
 	return "node" + strconv.Itoa(int(n))
 }
 
+// setNodeRegion sets the region of a node, which is testRegion by default. With multiple regions,
+// this needs to be done for all nodes before creating them, so that they can replicate from each other.
+func setNodeRegion(n nodeNrType, region string) {
+	nodeRegions[n] = region
+}
+
+func (n nodeNrType) Region() string {
+	if region, set := nodeRegions[n]; set {
+		return region
+	}
+	return testRegion
+}
+
 func (n nodeNrType) HTTPPort() int {
 	return int(n)*1000 + 443
 }
@@ -413,7 +429,7 @@ func (n nodeNrType) HTTPBase() string {
 	return fmt.Sprintf(
 		"https://%s.%s.%s:%d",
 		n.Name(),
-		testRegion,
+		n.Region(),
 		testCluster,
 		n.HTTPPort(),
 	)
@@ -423,7 +439,7 @@ func (n nodeNrType) RPCBase() string {
 	return fmt.Sprintf(
 		"https://%s.%s.%s:%d",
 		n.Name(),
-		testRegion,
+		n.Region(),
 		testCluster,
 		n.RPCPort(),
 	)
@@ -459,12 +475,24 @@ func joinNode(t *testing.T, newnodenr nodeNrType, joiningnode nodeNrType) {
 	startNode(t, newnodenr)
 }
 
+func joinLearnerNode(t *testing.T, newnodenr nodeNrType, joiningnode nodeNrType) {
+	createNodeCert(t, newnodenr)
+	runCommand(t, newnodenr.Name(), "serve", "--joinnode", joiningnode.RPCBase(), "--learner")
+	startNode(t, newnodenr)
+}
+
 func spawnNode(t *testing.T, nodenr nodeNrType) {
 	createNodeCert(t, nodenr)
 	runCommand(t, nodenr.Name(), "serve", "--spawn")
 	startNode(t, nodenr)
 }
 
+func restoreNode(t *testing.T, nodenr nodeNrType, backup string) {
+	createNodeCert(t, nodenr)
+	runCommand(t, nodenr.Name(), "restore", backup, "--spawn")
+	startNode(t, nodenr)
+}
+
 func startNode(t *testing.T, node nodeNrType) {
 	t.Log("Starting node", node.Name())
 
@@ -507,7 +535,7 @@ func createNodeCert(t *testing.T, node nodeNrType) {
 	createTestCA(t)
 	createTestConfig(t, node.Name(), node)
 	runCommand(t, "ca",
-		"ca", "node", testRegion, node.Name(),
+		"ca", "node", node.Region(), node.Name(),
 		insecureKeysFlag,
 	)
 }
@@ -559,6 +587,18 @@ func createTestConfig(t *testing.T, node string, nodenr nodeNrType) {
 	examplecfgB, err := ioutil.ReadFile("../config.yml.example")
 	failIfErr(t, err, "reading example config")
 	examplecfg := string(examplecfgB)
+	examplecfg = strings.Replace(
+		examplecfg,
+		"  regions:\n    regionb:\n    - https://nodea.regionb.repospanner.local:8443\n    - https://nodeb.regionb.repospanner.local:8443\n",
+		getReplicationConfig(nodenr),
+		-1,
+	)
+	examplecfg = strings.Replace(
+		examplecfg,
+		"interval: 30s",
+		"interval: 1s",
+		-1,
+	)
 	examplecfg = strings.Replace(
 		examplecfg,
 		"url:  https://nodea.regiona.repospanner.local/",
@@ -591,6 +631,12 @@ func createTestConfig(t *testing.T, node string, nodenr nodeNrType) {
 		"/ca/nodea.regiona.",
 		-1,
 	)
+	examplecfg = strings.Replace(
+		examplecfg,
+		"/ca/nodea.regiona.",
+		"/ca/nodea."+nodenr.Region()+".",
+		-1,
+	)
 	examplecfg = strings.Replace(
 		examplecfg,
 		"0.0.0.0:8443",
@@ -628,6 +674,35 @@ func createTestConfig(t *testing.T, node string, nodenr nodeNrType) {
 	t.Log("Config for", node, examplecfg)
 }
 
+// getReplicationConfig returns the replication regions config of a node, with the nodes of all
+// other regions
+func getReplicationConfig(nodenr nodeNrType) string {
+	regionnodes := make(map[string][]string)
+	for node, region := range nodeRegions {
+		if region != nodenr.Region() {
+			regionnodes[region] = append(regionnodes[region], node.RPCBase())
+		}
+	}
+	if len(regionnodes) == 0 {
+		return "  regions: {}\n"
+	}
+
+	var regions []string
+	for region := range regionnodes {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	cfg := "  regions:\n"
+	for _, region := range regions {
+		sort.Strings(regionnodes[region])
+		cfg += "    " + region + ":\n"
+		for _, url := range regionnodes[region] {
+			cfg += "    - " + url + "\n"
+		}
+	}
+	return cfg
+}
+
 func killTestIfTooLong(t *testing.T) {
 	timer := time.NewTicker(2 * time.Minute)
 
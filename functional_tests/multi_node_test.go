@@ -31,3 +31,36 @@ func TestJoin(t *testing.T) {
 	nodeInfoVerification(t, nodeb)
 	nodeInfoVerification(t, nodec)
 }
+
+func TestLearner(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+
+	spawnNode(t, nodea)
+	joinLearnerNode(t, nodeb, nodea)
+
+	status := runCommand(t, nodea.Name(), "admin", "cluster", "status")
+	if strings.Count(status, ", learner") != 1 {
+		t.Error("Node B is not reported as learner")
+	}
+
+	createRepo(t, nodea, "test1", true)
+	wdir := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 2)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	// Learners serve reads
+	wdir2 := clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir2, 0, 2)
+
+	// Learners don't count towards quorum, so node A can push on its own
+	killNode(t, nodeb)
+	writeTestFiles(t, wdir, 3, 3)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Pushing without the learner")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	wdir3 := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	testFiles(t, wdir3, 0, 3)
+}
@@ -324,3 +324,48 @@ func performCloneEditPushRecloneWithMajorityOfflineTest(t *testing.T, method clo
 		t.Fatal("Something went wrong in pushing")
 	}
 }
+
+func TestRemoteArchive(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+	nodec := nodeNrType(3)
+
+	createNodes(t, nodea, nodeb, nodec)
+
+	createRepo(t, nodeb, "test1", false)
+
+	wdir := clone(t, cloneMethodSSH, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 3)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	pushout := runRawCommand(t, "git", wdir, nil, "push")
+	if !strings.Contains(pushout, "* [new branch]      master -> master") {
+		t.Fatal("Something went wrong in pushing")
+	}
+
+	// git archive --remote only works over ssh, so this goes through repoclient
+	archive := wdir + ".tar"
+	runRawCommand(t, "git", wdir, nil,
+		"archive",
+		"--remote=ext::"+clientbinary+" test1",
+		"--format=tar",
+		"-o", archive,
+		"master",
+		"testdir",
+	)
+	listing := runRawCommand(t, "tar", wdir, nil, "-tf", archive)
+	if !strings.Contains(listing, "testdir/testfile1") || !strings.Contains(listing, "testdir/testfile2") {
+		t.Fatal("Archive is missing files")
+	}
+	if strings.Contains(listing, "testfile3") {
+		t.Fatal("Archive contains files outside of the requested path")
+	}
+
+	runFailingRawCommand(t, "git", wdir, nil,
+		"archive",
+		"--remote=ext::"+clientbinary+" test1",
+		"--format=tar",
+		"-o", archive,
+		"nonexistent",
+	)
+}
@@ -0,0 +1,61 @@
+package functional_tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForReplicatedPush waits until a push of commit to a branch of a repo got applied on node
+func waitForReplicatedPush(t *testing.T, node nodeNrType, reponame, branch, commit string) {
+	timeout := time.Now().Add(30 * time.Second)
+	for time.Now().Before(timeout) {
+		out, err := _runCommand(t, node.Name(), "admin", "repo", "reflog", reponame, branch)
+		if err == nil && strings.Contains(out, "-> "+commit) {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	t.Fatalf("Commit %s of %s was not replicated to %s", commit, reponame, node.Name())
+}
+
+func TestReplication(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+	setNodeRegion(nodea, testRegion)
+	setNodeRegion(nodeb, "regionb")
+
+	spawnNode(t, nodea)
+	spawnNode(t, nodeb)
+
+	createRepo(t, nodea, "test1", true)
+	runCommand(t, nodea.Name(),
+		"admin", "repo", "edit", "test1", "--regions", "regionb")
+
+	wdir := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 2)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	runRawCommand(t, "git", wdir, nil, "push")
+	commit := strings.TrimSpace(runRawCommand(t, "git", wdir, nil, "rev-parse", "HEAD"))
+
+	waitForReplicatedPush(t, nodeb, "test1", "master", commit)
+	wdir2 := clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir2, 0, 2)
+
+	// Replicas are read-only
+	writeTestFiles(t, wdir2, 3, 3)
+	runRawCommand(t, "git", wdir2, nil, "commit", "-sm", "Pushing to the replica")
+	runFailingRawCommand(t, "git", wdir2, nil, "push")
+
+	// Later pushes get replicated as well
+	writeTestFiles(t, wdir, 4, 4)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Pushing more")
+	runRawCommand(t, "git", wdir, nil, "push")
+	commit = strings.TrimSpace(runRawCommand(t, "git", wdir, nil, "rev-parse", "HEAD"))
+
+	waitForReplicatedPush(t, nodeb, "test1", "master", commit)
+	wdir3 := clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir3, 0, 2)
+	testFiles(t, wdir3, 4, 4)
+}
@@ -2,7 +2,11 @@ package functional_tests
 
 import (
 	"encoding/json"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"repospanner.org/repospanner/server/datastructures"
 )
@@ -93,3 +97,141 @@ func TestRepoManagement(t *testing.T) {
 	verifyReposExist(t, nodeb, r1, r2, r3)
 	verifyReposExist(t, nodec, r1, r2, r3)
 }
+
+func TestRepoDeletion(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+	nodec := nodeNrType(3)
+
+	createNodes(t, nodea, nodeb, nodec)
+
+	createRepo(t, nodea, "test1", true)
+	createRepo(t, nodea, "test2", false)
+	r1 := testRepoInfo{name: "test1", public: true}
+	r2 := testRepoInfo{name: "test2", public: false}
+
+	wdir := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 2)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	// Deleted repos are gone on all nodes
+	runCommand(t, nodeb.Name(),
+		"admin", "repo", "delete", "test1")
+	verifyReposExist(t, nodea, r2)
+	verifyReposExist(t, nodeb, r2)
+	verifyReposExist(t, nodec, r2)
+	clone(t, cloneMethodHTTPS, nodec, "test1", "admin", false)
+
+	// Until they are purged, they can be undeleted with all their contents
+	runCommand(t, nodec.Name(),
+		"admin", "repo", "undelete", "test1")
+	verifyReposExist(t, nodea, r1, r2)
+	verifyReposExist(t, nodeb, r1, r2)
+	verifyReposExist(t, nodec, r1, r2)
+	wdir = clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir, 0, 2)
+
+	runCommand(t, nodea.Name(),
+		"admin", "repo", "delete", "test1", "--purge")
+	verifyReposExist(t, nodeb, r2)
+	runFailingCommand(t, nodeb.Name(),
+		"admin", "repo", "undelete", "test1")
+
+	// A new repo with the name of a purged one starts out empty
+	createRepo(t, nodec, "test1", true)
+	wdir = clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	if branches := runRawCommand(t, "git", wdir, nil, "branch", "-r"); strings.TrimSpace(branches) != "" {
+		t.Errorf("Recreated repo has branches: %s", branches)
+	}
+}
+
+func TestRepoRename(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+	nodec := nodeNrType(3)
+
+	createNodes(t, nodea, nodeb, nodec)
+
+	createRepo(t, nodea, "test1", true)
+	createRepo(t, nodea, "test2", false)
+
+	wdir := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 2)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	runFailingCommand(t, nodeb.Name(),
+		"admin", "repo", "rename", "test1", "test2")
+	runFailingCommand(t, nodeb.Name(),
+		"admin", "repo", "rename", "test1", "../renamed")
+	runCommand(t, nodeb.Name(),
+		"admin", "repo", "rename", "test1", "renamed")
+	r1 := testRepoInfo{name: "renamed", public: true}
+	r2 := testRepoInfo{name: "test2", public: false}
+	verifyReposExist(t, nodea, r1, r2)
+	verifyReposExist(t, nodeb, r1, r2)
+	verifyReposExist(t, nodec, r1, r2)
+
+	wdir = clone(t, cloneMethodHTTPS, nodec, "renamed", "admin", true)
+	testFiles(t, wdir, 0, 2)
+
+	// The old name redirects to the new one
+	wdir = clone(t, cloneMethodHTTPS, nodeb, "test1", "admin", true)
+	testFiles(t, wdir, 0, 2)
+	writeTestFiles(t, wdir, 3, 3)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Pushing after the rename")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	wdir = clone(t, cloneMethodHTTPS, nodea, "renamed", "admin", true)
+	testFiles(t, wdir, 0, 3)
+}
+
+func TestRepoRefRestore(t *testing.T) {
+	defer testCleanup(t)
+	nodea := nodeNrType(1)
+	nodeb := nodeNrType(2)
+	nodec := nodeNrType(3)
+
+	createNodes(t, nodea, nodeb, nodec)
+
+	createRepo(t, nodea, "test1", true)
+
+	wdir := clone(t, cloneMethodHTTPS, nodea, "test1", "admin", true)
+	writeTestFiles(t, wdir, 0, 2)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Writing our tests")
+	runRawCommand(t, "git", wdir, nil, "push")
+
+	// Push times have a nanosecond resolution, but make sure the restore point is clearly between
+	// the pushes
+	time.Sleep(time.Second)
+	restorepoint := time.Now().Format(time.RFC3339Nano)
+	time.Sleep(time.Second)
+
+	writeTestFiles(t, wdir, 3, 3)
+	runRawCommand(t, "git", wdir, nil, "commit", "-sm", "Pushing a mistake")
+	runRawCommand(t, "git", wdir, nil, "push")
+	runRawCommand(t, "git", wdir, nil, "push", "origin", "HEAD:refs/heads/mistake")
+
+	reflog := runCommand(t, nodeb.Name(),
+		"admin", "repo", "reflog", "test1")
+	if strings.Count(reflog, "refs/heads/") != 3 {
+		t.Errorf("Reflog does not have 3 entries: %s", reflog)
+	}
+
+	runFailingCommand(t, nodeb.Name(),
+		"admin", "repo", "restore", "test1", "--at", "yesterday")
+	runCommand(t, nodeb.Name(),
+		"admin", "repo", "restore", "test1", "--at", restorepoint)
+
+	wdir = clone(t, cloneMethodHTTPS, nodec, "test1", "admin", true)
+	testFiles(t, wdir, 0, 2)
+	if _, err := os.Stat(path.Join(wdir, "testfile3")); !os.IsNotExist(err) {
+		t.Errorf("File pushed after the restore point exists: %v", err)
+	}
+	if branches := runRawCommand(t, "git", wdir, nil, "branch", "-r"); strings.Contains(branches, "mistake") {
+		t.Errorf("Branch created after the restore point exists: %s", branches)
+	}
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminBackupCmd = &cobra.Command{
+	Use:   "backup <dest>",
+	Short: "Back up a node",
+	Long: `Write a backup of the node to the file dest, with a snapshot of the state at a
+raft index and all objects it refers to. With --incremental, objects that are in
+an earlier backup in the same directory are left out.
+Restore the backup with "repospanner restore <dest> --spawn".`,
+	Run:  runAdminBackup,
+	Args: cobra.ExactArgs(1),
+}
+
+// readBackupManifest returns the manifest of a backup, which is its last entry
+func readBackupManifest(src string) (manifest datastructures.BackupManifest, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	var hasmanifest bool
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+		hasmanifest = hdr.Name == constants.BackupManifestName
+		if hasmanifest {
+			if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return
+			}
+		}
+	}
+	if !hasmanifest {
+		return manifest, fmt.Errorf("Backup %s is incomplete", src)
+	}
+	return manifest, nil
+}
+
+// receiveBackup copies the backup to dest, if it is complete
+func receiveBackup(dest string, r io.Reader) (manifest datastructures.BackupManifest, err error) {
+	partial := dest + ".partial"
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(partial)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(f)
+	var hasmanifest bool
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return
+		}
+		hasmanifest = hdr.Name == constants.BackupManifestName
+		if hasmanifest {
+			var cts bytes.Buffer
+			if _, err = io.Copy(tw, io.TeeReader(tr, &cts)); err != nil {
+				return
+			}
+			if err = json.Unmarshal(cts.Bytes(), &manifest); err != nil {
+				return
+			}
+		} else if _, err = io.Copy(tw, tr); err != nil {
+			return
+		}
+	}
+	if !hasmanifest {
+		err = errors.New("Backup is incomplete, see the node logs")
+		return
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	err = os.Rename(partial, dest)
+	return
+}
+
+func runAdminBackup(cmd *cobra.Command, args []string) {
+	dest := args[0]
+	if _, err := os.Stat(dest); err == nil {
+		fmt.Fprintf(os.Stderr, "Backup %s already exists\n", dest)
+		os.Exit(1)
+	}
+
+	var req datastructures.BackupRequest
+	incremental, _ := cmd.Flags().GetString("incremental")
+	if incremental != "" {
+		if filepath.Dir(incremental) != filepath.Dir(dest) {
+			fmt.Fprintln(os.Stderr, "Incremental backups need to be in the same directory as their base")
+			os.Exit(1)
+		}
+		base, err := readBackupManifest(incremental)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading base backup: %s\n", err)
+			os.Exit(1)
+		}
+		req.Base = filepath.Base(incremental)
+		req.BaseWants = base.Wants
+	}
+
+	clnt := getAdminClient()
+	resp := clnt.PerformDownload("admin/backup", req)
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "application/x-tar" {
+		var cmdresp datastructures.CommandResponse
+		if err := json.NewDecoder(resp.Body).Decode(&cmdresp); err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "Error creating backup: %s\n", cmdresp.Error)
+		os.Exit(1)
+	}
+
+	manifest, err := receiveBackup(dest, resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing backup: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Backup of node %s at index %d with %d objects written to %s\n",
+		manifest.NodeName,
+		manifest.Index,
+		manifest.NumObjects,
+		dest,
+	)
+}
+
+func init() {
+	adminCmd.AddCommand(adminBackupCmd)
+
+	adminBackupCmd.Flags().String("incremental", "", "Earlier backup to leave out the objects of")
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminClusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Cluster information",
+	Long:  `Inspect the state of the region.`,
+}
+
+var adminClusterStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Get cluster status",
+	Long: `Print the raft leader, terms and indexes of all nodes in the region,
+together with how far each node lags behind and which peers it can not reach.`,
+	Run:  runAdminClusterStatus,
+	Args: cobra.ExactArgs(0),
+}
+
+func sortedNodeIDs(nodes map[uint64]datastructures.NodeStatus) []uint64 {
+	var nodeids []uint64
+	for nodeid := range nodes {
+		nodeids = append(nodeids, nodeid)
+	}
+	sort.Slice(nodeids, func(i, j int) bool { return nodeids[i] < nodeids[j] })
+	return nodeids
+}
+
+func runAdminClusterStatus(cmd *cobra.Command, args []string) {
+	clnt := getAdminClient()
+	var resp datastructures.ClusterStatus
+
+	shouldExit := clnt.Perform(
+		"admin/clusterstatus",
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	fmt.Printf("Leader: %d\n", resp.Leader)
+	fmt.Printf("Term: %d\n", resp.Term)
+
+	leadercommit := resp.Nodes[resp.Leader].CommitIndex
+	for _, nodeid := range sortedNodeIDs(resp.Nodes) {
+		node := resp.Nodes[nodeid]
+		fmt.Println()
+		if node.Error != "" {
+			fmt.Printf("Node %d: unreachable: %s\n", nodeid, node.Error)
+			continue
+		}
+		fmt.Printf("Node %d (%s), version %s", nodeid, node.NodeName, node.Version)
+		if node.Learner {
+			fmt.Print(", learner")
+		}
+		fmt.Println()
+		fmt.Printf("\tLeader: %d, term: %d\n", node.Leader, node.Term)
+		fmt.Printf("\tCommit index: %d, applied index: %d", node.CommitIndex, node.AppliedIndex)
+		if leadercommit > node.AppliedIndex {
+			fmt.Printf(", lagging %d entries", leadercommit-node.AppliedIndex)
+		}
+		fmt.Println()
+		fmt.Printf("\tSnapshot index: %d, WAL size: %d bytes\n", node.SnapshotIndex, node.WALSize)
+		for _, peer := range sortedNodeIDs(resp.Nodes) {
+			if peer == nodeid {
+				continue
+			}
+			fmt.Printf("\tPeer %d:", peer)
+			if match, hasmatch := node.MatchIndexes[peer]; hasmatch {
+				fmt.Printf(" replicated up to %d,", match)
+			}
+			fmt.Printf(" %d pushes queued", node.OutqueueBacklog[peer])
+			if reported, unreachable := node.Unreachable[peer]; unreachable {
+				fmt.Printf(", unreachable since %s", time.Unix(reported, 0).Format(time.RFC3339))
+			}
+			fmt.Println()
+		}
+		for _, region := range sortedRegions(node.Replication) {
+			replication := node.Replication[region]
+			fmt.Printf("\tReplication from %s: %d repos, source index %d, synced up to %d",
+				region, replication.Repos, replication.SourceIndex, replication.SyncedIndex)
+			if replication.LastSync != 0 {
+				fmt.Printf(", last synced %s", time.Unix(replication.LastSync, 0).Format(time.RFC3339))
+			}
+			if replication.Error != "" {
+				fmt.Printf(", error: %s", replication.Error)
+			}
+			fmt.Println()
+		}
+	}
+}
+
+func sortedRegions(replication map[string]datastructures.ReplicationStatus) []string {
+	var regions []string
+	for region := range replication {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+func init() {
+	adminCmd.AddCommand(adminClusterCmd)
+	adminClusterCmd.AddCommand(adminClusterStatusCmd)
+}
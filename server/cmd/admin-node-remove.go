@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminNodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Node management",
+	Long:  `Perform region membership functions.`,
+}
+
+var adminRemoveNodeCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Node removal",
+	Long: `Remove a node from the region, for example because its machine died.
+The request must be sent to another node than the one being removed.
+The removed node ID can not be reused: to replace the node, start a new node with
+"serve --joinnode <node> --replace <nodeid>" instead.`,
+	Run:  runAdminRemoveNode,
+	Args: cobra.ExactArgs(1),
+}
+
+func runAdminRemoveNode(cmd *cobra.Command, args []string) {
+	nodeid, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid node ID: %s\n", args[0])
+		os.Exit(1)
+	}
+
+	req := datastructures.NodeRemoveRequest{
+		NodeID: nodeid,
+	}
+
+	clnt := getAdminClient()
+	var resp datastructures.CommandResponse
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/removenode",
+		req,
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error removing node: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Println("Node removed successfully")
+}
+
+func init() {
+	adminCmd.AddCommand(adminNodeCmd)
+	adminNodeCmd.AddCommand(adminRemoveNodeCmd)
+}
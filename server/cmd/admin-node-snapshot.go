@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminSnapshotNodeCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Snapshot the node state",
+	Long: `Make the node snapshot its state and compact its WAL now, instead of waiting
+until storage.snapshot_count entries got applied since the last snapshot.`,
+	Run:  runAdminSnapshotNode,
+	Args: cobra.ExactArgs(0),
+}
+
+func runAdminSnapshotNode(cmd *cobra.Command, args []string) {
+	clnt := getAdminClient()
+	var resp datastructures.CommandResponse
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/snapshot",
+		struct{}{},
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error creating snapshot: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Println(resp.Info)
+}
+
+func init() {
+	adminNodeCmd.AddCommand(adminSnapshotNodeCmd)
+}
@@ -19,10 +19,12 @@ var adminCreateRepoCmd = &cobra.Command{
 func runAdminCreateRepo(cmd *cobra.Command, args []string) {
 	reponame := args[0]
 	ispublic, _ := cmd.Flags().GetBool("public")
+	defaultbranch, _ := cmd.Flags().GetString("default-branch")
 
 	req := datastructures.RepoRequestInfo{
-		Reponame: reponame,
-		Public:   ispublic,
+		Reponame:      reponame,
+		Public:        ispublic,
+		DefaultBranch: defaultbranch,
 	}
 
 	clnt := getAdminClient()
@@ -49,4 +51,6 @@ func init() {
 
 	adminCreateRepoCmd.Flags().Bool("public", false,
 		"Create the repository as publicly readable")
+	adminCreateRepoCmd.Flags().String("default-branch", "",
+		"Branch HEAD points to (default: storage.default_branch of the cluster)")
 }
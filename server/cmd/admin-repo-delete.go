@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminDeleteRepoCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Repo deletion",
+	Long: `Delete a repository.
+Unless --purge is passed, the repository can be undeleted until the grace period ends.`,
+	Run:  runAdminDeleteRepo,
+	Args: cobra.ExactArgs(1),
+}
+
+func runAdminDeleteRepo(cmd *cobra.Command, args []string) {
+	reponame := args[0]
+	purge, _ := cmd.Flags().GetBool("purge")
+
+	req := datastructures.RepoDeleteRequest{
+		Reponame: reponame,
+		Purge:    purge,
+	}
+
+	clnt := getAdminClient()
+	var resp datastructures.CommandResponse
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/deleterepo",
+		req,
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error deleting repository: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	if purge {
+		fmt.Println("Repo purged successfully")
+	} else {
+		fmt.Println("Repo deleted successfully")
+	}
+}
+
+var adminUndeleteRepoCmd = &cobra.Command{
+	Use:   "undelete",
+	Short: "Repo undeletion",
+	Long:  `Restore a deleted repository that has not been purged yet.`,
+	Run:   runAdminUndeleteRepo,
+	Args:  cobra.ExactArgs(1),
+}
+
+func runAdminUndeleteRepo(cmd *cobra.Command, args []string) {
+	req := datastructures.RepoRequestInfo{
+		Reponame: args[0],
+	}
+
+	clnt := getAdminClient()
+	var resp datastructures.CommandResponse
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/undeleterepo",
+		req,
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error undeleting repository: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Println("Repo undeleted successfully")
+}
+
+func init() {
+	adminRepoCmd.AddCommand(adminDeleteRepoCmd)
+	adminRepoCmd.AddCommand(adminUndeleteRepoCmd)
+
+	adminDeleteRepoCmd.Flags().Bool("purge", false,
+		"Remove the repository and its objects immediately, without grace period")
+}
@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -14,9 +16,16 @@ import (
 var adminEditRepoCmd = &cobra.Command{
 	Use:   "edit",
 	Short: "Repo editing",
-	Long:  `Edit a repository.`,
-	Run:   runAdminEditRepo,
-	Args:  cobra.ExactArgs(1),
+	Long: `Edit a repository.
+
+Branch protection rules and creatable refs match refs by pattern. In a pattern,
+"*" matches any sequence of characters, including slashes, so "refs/heads/release/*"
+matches refs/heads/release/1.0 as well as refs/heads/release/1.0/hotfix. "**" is
+the same as "*", and all other characters only match themselves.
+Branch protection patterns that don't start with "refs/" are branch names:
+"main" protects refs/heads/main, and "*" protects all branches.`,
+	Run:  runAdminEditRepo,
+	Args: cobra.ExactArgs(1),
 }
 
 func addHook(cmd *cobra.Command, req *datastructures.RepoUpdateRequest, reponame, flagname string) {
@@ -67,6 +76,20 @@ func addHook(cmd *cobra.Command, req *datastructures.RepoUpdateRequest, reponame
 	}
 }
 
+func addBranchProtection(cmd *cobra.Command, req *datastructures.RepoUpdateRequest) {
+	var rule datastructures.BranchProtection
+	rule.Pattern, _ = cmd.Flags().GetString("protect-branch")
+	rule.DenyNonFastForward, _ = cmd.Flags().GetBool("deny-non-fast-forward")
+	rule.DenyDeletion, _ = cmd.Flags().GetBool("deny-deletion")
+	rule.AllowedPushers, _ = cmd.Flags().GetStringSlice("allowed-pushers")
+
+	encoded, err := json.Marshal(rule)
+	if err != nil {
+		panic(err)
+	}
+	req.UpdateRequest[datastructures.RepoUpdateProtectBranch] = string(encoded)
+}
+
 func runAdminEditRepo(cmd *cobra.Command, args []string) {
 	reponame := args[0]
 
@@ -83,11 +106,45 @@ func runAdminEditRepo(cmd *cobra.Command, args []string) {
 			} else {
 				request.UpdateRequest[datastructures.RepoUpdatePublic] = "false"
 			}
+		} else if f.Name == "hiderefs" {
+			hiderefs, _ := cmd.Flags().GetString("hiderefs")
+			request.UpdateRequest[datastructures.RepoUpdateHideRefs] = hiderefs
+		} else if f.Name == "lfs-quota" {
+			quota, _ := cmd.Flags().GetInt64("lfs-quota")
+			request.UpdateRequest[datastructures.RepoUpdateLFSQuota] = strconv.FormatInt(quota, 10)
+		} else if f.Name == "default-branch" {
+			branch, _ := cmd.Flags().GetString("default-branch")
+			request.UpdateRequest[datastructures.RepoUpdateDefaultBranch] = branch
+		} else if f.Name == "symref" {
+			symrefs, _ := cmd.Flags().GetStringArray("symref")
+			request.UpdateRequest[datastructures.RepoUpdateSymrefs] = strings.Join(symrefs, ",")
+		} else if f.Name == "protect-branch" {
+			addBranchProtection(cmd, &request)
+		} else if f.Name == "unprotect-branch" {
+			pattern, _ := cmd.Flags().GetString("unprotect-branch")
+			request.UpdateRequest[datastructures.RepoUpdateUnprotectBranch] = pattern
+		} else if f.Name == "creatable-refs" {
+			creatable, _ := cmd.Flags().GetString("creatable-refs")
+			request.UpdateRequest[datastructures.RepoUpdateCreatableRefs] = creatable
+		} else if f.Name == "consistent-reads" {
+			consistent, _ := cmd.Flags().GetBool("consistent-reads")
+			request.UpdateRequest[datastructures.RepoUpdateConsistentReads] = strconv.FormatBool(consistent)
+		} else if f.Name == "regions" {
+			regions, _ := cmd.Flags().GetString("regions")
+			request.UpdateRequest[datastructures.RepoUpdateRegions] = regions
 		} else if strings.HasPrefix(f.Name, "hook-") {
 			addHook(cmd, &request, reponame, f.Name)
 		}
 	})
 
+	_, hasrule := request.UpdateRequest[datastructures.RepoUpdateProtectBranch]
+	for _, ruleflag := range []string{"deny-non-fast-forward", "deny-deletion", "allowed-pushers"} {
+		if cmd.Flags().Changed(ruleflag) && !hasrule {
+			fmt.Fprintf(os.Stderr, "--%s can only be used with --protect-branch\n", ruleflag)
+			os.Exit(1)
+		}
+	}
+
 	if len(request.UpdateRequest) == 0 {
 		fmt.Println("No update request provided")
 		return
@@ -116,6 +173,30 @@ func init() {
 
 	adminEditRepoCmd.Flags().Bool("public", false,
 		"Make the repository publicly readable")
+	adminEditRepoCmd.Flags().String("hiderefs", "",
+		"Comma-separated ref prefixes to hide from non-admins (empty to clear)")
+	adminEditRepoCmd.Flags().Int64("lfs-quota", 0,
+		"Maximum size of LFS objects in bytes (0 for unlimited)")
+	adminEditRepoCmd.Flags().String("default-branch", "",
+		"Branch HEAD points to")
+	adminEditRepoCmd.Flags().StringArray("symref", nil,
+		"Set a symref as name=target (empty target to remove), can be repeated")
+	adminEditRepoCmd.Flags().String("protect-branch", "",
+		"Add or replace the protection rule for a branch name or ref pattern")
+	adminEditRepoCmd.Flags().Bool("deny-non-fast-forward", false,
+		"Refuse non-fast-forward updates of the protected branches")
+	adminEditRepoCmd.Flags().Bool("deny-deletion", false,
+		"Refuse deletion of the protected branches")
+	adminEditRepoCmd.Flags().StringSlice("allowed-pushers", nil,
+		"Comma-separated usernames that can push to the protected branches (empty for anyone)")
+	adminEditRepoCmd.Flags().String("unprotect-branch", "",
+		"Remove the protection rule with this branch name or ref pattern")
+	adminEditRepoCmd.Flags().String("creatable-refs", "",
+		"Comma-separated ref patterns that can be created (empty for any)")
+	adminEditRepoCmd.Flags().Bool("consistent-reads", false,
+		"Make fetches wait until the node caught up with the latest pushes")
+	adminEditRepoCmd.Flags().String("regions", "",
+		"Comma-separated other regions to replicate the repository to (empty for none)")
 	adminEditRepoCmd.Flags().String("hook-pre-receive", "",
 		"Set a pre-receive hook")
 	adminEditRepoCmd.Flags().String("hook-update", "",
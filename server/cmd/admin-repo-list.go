@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 	"repospanner.org/repospanner/server/datastructures"
@@ -30,6 +31,10 @@ func runAdminListRepos(cmd *cobra.Command, args []string) {
 	for name, repo := range resp.Repos {
 		fmt.Printf("Repo %s\n", name)
 		fmt.Printf("\tPublic: %t\n", repo.Public)
+		fmt.Printf("\tHidden refs:\n")
+		for _, hideref := range repo.HideRefs {
+			fmt.Printf("\t\t%s\n", hideref)
+		}
 		fmt.Printf("\tRefs:\n")
 		for refname, refval := range repo.Refs {
 			fmt.Printf("\t\t%s -> %s\n", refname, refval)
@@ -38,11 +43,20 @@ func runAdminListRepos(cmd *cobra.Command, args []string) {
 		for refname, refval := range repo.Symrefs {
 			fmt.Printf("\t\t%s -> %s\n", refname, refval)
 		}
+		var lfsusage int64
+		for _, obj := range repo.LFSObjects {
+			lfsusage += obj.Size
+		}
+		fmt.Printf("\tLFS: %d objects, %d bytes (quota: %d)\n", len(repo.LFSObjects), lfsusage, repo.LFSQuota)
 		fmt.Printf("\tHooks:\n")
 		fmt.Printf("\t\tPre-Receive: \t%s\n", repo.Hooks.PreReceive)
 		fmt.Printf("\t\tUpdate: \t%s\n", repo.Hooks.Update)
 		fmt.Printf("\t\tPost-Receive: \t%s\n", repo.Hooks.PostReceive)
 	}
+	for name, repo := range resp.DeletedRepos {
+		fmt.Printf("Deleted repo %s\n", name)
+		fmt.Printf("\tDeleted at: %s\n", time.Unix(repo.DeletedAt, 0))
+	}
 }
 
 func init() {
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminReflogRepoCmd = &cobra.Command{
+	Use:   "reflog",
+	Short: "Repo ref update history",
+	Long: `Show the recent ref updates of a repository, newest first.
+If a ref is given, only the updates of that ref are shown. Names not starting with refs/ are branch names.`,
+	Run:  runAdminReflogRepo,
+	Args: cobra.RangeArgs(1, 2),
+}
+
+func runAdminReflogRepo(cmd *cobra.Command, args []string) {
+	req := datastructures.RepoReflogRequest{
+		Reponame: args[0],
+	}
+	if len(args) == 2 {
+		req.Ref = args[1]
+		if !strings.HasPrefix(req.Ref, "refs/") {
+			req.Ref = "refs/heads/" + req.Ref
+		}
+	}
+
+	clnt := getAdminClient()
+	var resp datastructures.RepoReflog
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/reflog",
+		req,
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error getting reflog: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	for i := len(resp.Entries) - 1; i >= 0; i-- {
+		entry := resp.Entries[i]
+		forced := ""
+		if entry.Forced {
+			forced = " (forced)"
+		}
+		fmt.Printf("%s [%d] %s %s -> %s%s\n",
+			time.Unix(0, entry.PushTime).Format(time.RFC3339),
+			entry.RaftIndex,
+			entry.Ref,
+			entry.OldValue,
+			entry.NewValue,
+			forced,
+		)
+		fmt.Printf("\tby %s from %s via node %d\n", entry.Pusher, entry.PusherAddress, entry.PushNode)
+	}
+}
+
+func init() {
+	adminRepoCmd.AddCommand(adminReflogRepoCmd)
+}
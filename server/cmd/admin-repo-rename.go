@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminRenameRepoCmd = &cobra.Command{
+	Use:   "rename",
+	Short: "Repo renaming",
+	Long: `Rename a repository.
+Requests for the old name are redirected to the new name until the rename redirect period ends.`,
+	Run:  runAdminRenameRepo,
+	Args: cobra.ExactArgs(2),
+}
+
+func runAdminRenameRepo(cmd *cobra.Command, args []string) {
+	req := datastructures.RepoRenameRequest{
+		Reponame: args[0],
+		NewName:  args[1],
+	}
+
+	clnt := getAdminClient()
+	var resp datastructures.CommandResponse
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/renamerepo",
+		req,
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error renaming repository: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Println("Repo renamed successfully")
+	fmt.Fprintf(os.Stderr,
+		"Warning: access is granted by matching certificate --repo patterns against the repo name.\n"+
+			"Check which certificates match %s, as they may differ from the ones that matched %s.\n",
+		args[1], args[0])
+}
+
+func init() {
+	adminRepoCmd.AddCommand(adminRenameRepoCmd)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+var adminRestoreRepoCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Repo ref restore",
+	Long: `Restore the refs of a repository to their state at an earlier point.
+The point is either a raft index as shown by "admin repo reflog", or an RFC3339 timestamp.
+Restores are not checked by the hooks or branch protection rules.`,
+	Run:  runAdminRestoreRepo,
+	Args: cobra.ExactArgs(1),
+}
+
+func runAdminRestoreRepo(cmd *cobra.Command, args []string) {
+	at, _ := cmd.Flags().GetString("at")
+	refs, _ := cmd.Flags().GetStringArray("ref")
+
+	req := datastructures.RepoRestoreRequest{
+		Reponame: args[0],
+	}
+	if index, err := strconv.ParseUint(at, 10, 64); err == nil && index != 0 {
+		req.RaftIndex = index
+	} else if attime, err := time.Parse(time.RFC3339, at); err == nil {
+		req.Time = attime.UnixNano()
+	} else {
+		fmt.Fprintf(os.Stderr, "Invalid --at value %s, expected a raft index or RFC3339 timestamp\n", at)
+		os.Exit(1)
+	}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref, "refs/") {
+			ref = "refs/heads/" + ref
+		}
+		req.Refs = append(req.Refs, ref)
+	}
+
+	clnt := getAdminClient()
+	var resp datastructures.CommandResponse
+
+	shouldExit := clnt.PerformWithRequest(
+		"admin/restorerepo",
+		req,
+		&resp,
+	)
+	if shouldExit {
+		return
+	}
+
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error restoring repository: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Println(resp.Info)
+	fmt.Println("Repo restored successfully")
+}
+
+func init() {
+	adminRepoCmd.AddCommand(adminRestoreRepoCmd)
+
+	adminRestoreRepoCmd.Flags().String("at", "",
+		"Raft index or RFC3339 timestamp to restore the refs to")
+	adminRestoreRepoCmd.MarkFlagRequired("at")
+	adminRestoreRepoCmd.Flags().StringArray("ref", nil,
+		"Only restore this ref or branch, can be repeated")
+}
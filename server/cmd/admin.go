@@ -121,6 +121,26 @@ func (c *adminClient) PerformWithRequest(url string, in interface{}, out interfa
 	return c.PerformUpload(url, body.Len(), body, out)
 }
 
+// PerformDownload posts a request and returns the response, for responses that are not JSON
+func (c *adminClient) PerformDownload(url string, in interface{}) *http.Response {
+	req, err := json.Marshal(in)
+	if err != nil {
+		panic(err)
+	}
+	resp, err := c.httpClient.Post(
+		c.getURL(url),
+		"application/json",
+		bytes.NewBuffer(req),
+	)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != 200 {
+		panic("Server error occured")
+	}
+	return resp
+}
+
 func getAdminClient() *adminClient {
 	baseurl := viper.GetString("admin.url")
 	if baseurl == "" {
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup>",
+	Short: "Restore a backup",
+	Long: `Spawn a new region from a backup made with "admin backup", with this node as
+its only member. Incremental backups need the backups they build on in the same
+directory. Further nodes can join the region with "serve --joinnode" afterwards.`,
+	Run:  runRestore,
+	Args: cobra.ExactArgs(1),
+}
+
+func runRestore(cmd *cobra.Command, args []string) {
+	debug, _ := cmd.Flags().GetBool("debug")
+	spawning, _ := cmd.Flags().GetBool("spawn")
+	if !spawning {
+		panic("Backups can only be restored into a new region, use --spawn")
+	}
+
+	cfg := getServiceConfig(debug)
+	cfg.RestoreBackup = args[0]
+
+	runService(cfg, true, "")
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().Bool("debug", false, "Enable development logging")
+	restoreCmd.Flags().Bool("spawn", false, "Spawn a new region from the backup")
+}
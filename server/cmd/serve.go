@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"bufio"
 	"crypto/tls"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -23,7 +27,57 @@ func runServer(cmd *cobra.Command, args []string) {
 	debug, _ := cmd.Flags().GetBool("debug")
 	spawning, _ := cmd.Flags().GetBool("spawn")
 	joinnode, _ := cmd.Flags().GetString("joinnode")
+	replacenode, _ := cmd.Flags().GetUint64("replace")
+	if replacenode != 0 && joinnode == "" {
+		panic("--replace can only be used with --joinnode")
+	}
+	learner, _ := cmd.Flags().GetBool("learner")
+	if learner && joinnode == "" {
+		panic("--learner can only be used with --joinnode")
+	}
+
+	forcenew, _ := cmd.Flags().GetBool("force-new-cluster")
+	if forcenew && (spawning || joinnode != "") {
+		panic("--force-new-cluster can not be used with --spawn or --joinnode")
+	}
+	if forcenew && !confirmForceNewCluster() {
+		fmt.Fprintln(os.Stderr, "Aborted")
+		os.Exit(1)
+	}
+
+	cfg := getServiceConfig(debug)
+	cfg.JoinReplaceNodeID = replacenode
+	cfg.JoinAsLearner = learner
+	cfg.ForceNewCluster = forcenew
+
+	runService(cfg, spawning, joinnode)
+}
+
+const forceNewClusterConfirmation = "force new cluster"
 
+// confirmForceNewCluster makes sure the operator knows what forcing a new cluster does
+func confirmForceNewCluster() bool {
+	fmt.Fprintf(os.Stderr, `%[1]s
+WARNING: --force-new-cluster removes ALL OTHER NODES from the region.
+
+Only use this when a majority of the nodes is lost for good and the region
+can not make progress anymore. Changes that were not committed on this node
+are lost, and the removed nodes can never rejoin with their current state.
+Once this node stopped, start it with "repospanner serve", and add new nodes
+with "repospanner serve --joinnode".
+%[1]s
+
+Type "%[2]s" to continue: `, strings.Repeat("!", 78), forceNewClusterConfirmation)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(answer) == forceNewClusterConfirmation
+}
+
+// getServiceConfig creates the service from the configuration file
+func getServiceConfig(debug bool) *service.Service {
 	cfg := &service.Service{
 		ClientCaCertFile: viper.GetString("certificates.ca"),
 		ServerCerts:      make(map[string]tls.Certificate),
@@ -65,6 +119,11 @@ func runServer(cmd *cobra.Command, args []string) {
 		cfg.ServerCerts[servername] = cert
 	}
 
+	return cfg
+}
+
+// runService runs the service until it is stopped by a signal
+func runService(cfg *service.Service, spawning bool, joinnode string) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
@@ -84,4 +143,7 @@ func init() {
 	serveCmd.Flags().Bool("debug", false, "Enable development logging")
 	serveCmd.Flags().Bool("spawn", false, "Spawn a new region")
 	serveCmd.Flags().String("joinnode", "", "Enter node of an existing region to join")
+	serveCmd.Flags().Uint64("replace", 0, "ID of a dead node to remove when joining, to take over its place")
+	serveCmd.Flags().Bool("learner", false, "Join as a learner, which serves reads but doesn't count towards quorum")
+	serveCmd.Flags().Bool("force-new-cluster", false, "Remove all other nodes from the region, after losing a majority")
 }
@@ -2,4 +2,11 @@ package constants
 
 const (
 	HooksRepoName = "admin/hooks"
+	// LFS objects for repo X are stored in the storage project LFSRepoPrefix + X
+	LFSRepoPrefix = "admin/lfs/"
+	// Objects of purged repos are moved to storage projects under PurgedRepoPrefix until they are
+	// removed. Repo names can't have parts ending in .git, so these never clash with a repo.
+	PurgedRepoPrefix = "admin/purged.git/"
+	// BackupManifestName is the name of the last entry of a backup, which describes it
+	BackupManifestName = "manifest.json"
 )
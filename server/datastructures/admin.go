@@ -3,6 +3,8 @@ package datastructures
 type RepoRequestInfo struct {
 	Reponame string
 	Public   bool
+	// DefaultBranch is the branch HEAD points to, the configured default is used if empty
+	DefaultBranch string
 }
 
 type CommandResponse struct {
@@ -17,12 +19,65 @@ type RepoHookInfo struct {
 	PostReceive string
 }
 
+type LFSObjectInfo struct {
+	ObjectID string
+	Size     int64
+}
+
+// BranchProtection restricts the updates to the refs matching Pattern
+type BranchProtection struct {
+	Pattern            string
+	DenyNonFastForward bool
+	DenyDeletion       bool
+	// AllowedPushers are the usernames that can update the refs, anyone with write access if empty
+	AllowedPushers []string
+}
+
+// ReflogEntry records an update of a ref
+type ReflogEntry struct {
+	Ref      string
+	OldValue string
+	NewValue string
+	// Forced is set for updates that were not fast-forwards
+	Forced        bool
+	Pusher        string
+	PusherAddress string
+	PushNode      uint64
+	// PushTime is the Unix time in nanoseconds at which the push was received
+	PushTime int64
+	// RaftIndex is the index of the raft log entry that applied the update
+	RaftIndex uint64
+}
+
 type RepoInfo struct {
 	Symrefs      map[string]string
 	Refs         map[string]string
+	Peeled       map[string]string
 	Hooks        RepoHookInfo
 	LastPushNode uint64
 	Public       bool
+	HideRefs     []string
+	LFSObjects   map[string]LFSObjectInfo
+	LFSQuota     int64
+	// BranchProtections are checked for every push, in addition to the hooks
+	BranchProtections []BranchProtection
+	// CreatableRefs are patterns of refs that can be created, any ref can be created if empty
+	CreatableRefs []string
+	// Reflog contains the most recent ref updates, oldest first
+	Reflog []ReflogEntry
+	// DeletedAt is the Unix time at which the repo was deleted, or 0 if it is not
+	DeletedAt int64
+	// RenamedTo is set if the repo was renamed, and this entry only exists to redirect to the new name
+	RenamedTo string
+	RenamedAt int64
+	// Regions are the other regions this repo is replicated to
+	Regions []string
+	// ReplicaOf is the region this repo is replicated from. Replicas are read-only.
+	ReplicaOf string
+	// ConsistentReads makes ref advertisements wait until the node caught up with the leader
+	ConsistentReads bool
+	// ChangedIndex is the raft index of the last change applied to the repo, or 0 if unknown
+	ChangedIndex uint64
 }
 
 type RepoUpdateField string
@@ -32,6 +87,18 @@ const (
 	RepoUpdateHookPreReceive  RepoUpdateField = "hook-prereceive"
 	RepoUpdateHookUpdate      RepoUpdateField = "hook-update"
 	RepoUpdateHookPostReceive RepoUpdateField = "hook-postreceive"
+	RepoUpdateHideRefs        RepoUpdateField = "hiderefs"
+	RepoUpdateLFSQuota        RepoUpdateField = "lfs-quota"
+	RepoUpdateDefaultBranch   RepoUpdateField = "default-branch"
+	// RepoUpdateSymrefs is a comma-separated list of name=target symrefs to set, or to remove if target is empty
+	RepoUpdateSymrefs RepoUpdateField = "symrefs"
+	// RepoUpdateProtectBranch is a JSON encoded BranchProtection, replacing any rule with the same pattern
+	RepoUpdateProtectBranch   RepoUpdateField = "protect-branch"
+	RepoUpdateUnprotectBranch RepoUpdateField = "unprotect-branch"
+	RepoUpdateCreatableRefs   RepoUpdateField = "creatable-refs"
+	// RepoUpdateRegions is a comma-separated list of other regions to replicate the repo to
+	RepoUpdateRegions         RepoUpdateField = "regions"
+	RepoUpdateConsistentReads RepoUpdateField = "consistent-reads"
 )
 
 type RepoUpdateRequest struct {
@@ -40,7 +107,42 @@ type RepoUpdateRequest struct {
 }
 
 type RepoList struct {
-	Repos map[string]RepoInfo
+	Repos        map[string]RepoInfo
+	DeletedRepos map[string]RepoInfo
+}
+
+type RepoRenameRequest struct {
+	Reponame string
+	NewName  string
+}
+
+type RepoReflogRequest struct {
+	Reponame string
+	// Ref limits the entries to those of a single ref if set
+	Ref string
+}
+
+type RepoReflog struct {
+	Success bool
+	Error   string
+	Entries []ReflogEntry
+}
+
+// RepoRestoreRequest requests the refs of a repo to be restored to their state after the push
+// applied at RaftIndex, or if that is 0, at Time
+type RepoRestoreRequest struct {
+	Reponame  string
+	RaftIndex uint64
+	// Time is a Unix time in nanoseconds
+	Time int64
+	// Refs limits the restore to these refs if set
+	Refs []string
+}
+
+type RepoDeleteRequest struct {
+	Reponame string
+	// Purge deletes the repo immediately, without a grace period for undeleting
+	Purge bool
 }
 
 type NodeInfo struct {
@@ -50,6 +152,104 @@ type NodeInfo struct {
 	ClusterName string
 	Version     string
 	Peers       map[uint64]string
+	// Learners are the peers that receive all changes, but don't vote
+	Learners map[uint64]bool
+}
+
+// NodeStatus is the state of a node, as seen by that node
+type NodeStatus struct {
+	NodeID   uint64
+	NodeName string
+	Version  string
+	Learner  bool
+	// Error is set if the status could not be retrieved from the node
+	Error string
+
+	Leader        uint64
+	Term          uint64
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	SnapshotIndex uint64
+	// WALSize is the size of the raft write-ahead log in bytes
+	WALSize int64
+	// MatchIndexes are the last log index known to be replicated to each peer, only reported by the leader
+	MatchIndexes map[uint64]uint64
+	// Unreachable contains the peers that raft reported unreachable recently, with the Unix time
+	// of the last report
+	Unreachable map[uint64]int64
+	// OutqueueBacklog is the number of pushes waiting to be synced to each peer
+	OutqueueBacklog map[uint64]int
+	// Replication is the state of the replication from each other region, only reported by the
+	// node performing the replication
+	Replication map[string]ReplicationStatus
+}
+
+// ReplicationStatus is the progress of replicating repos from another region
+type ReplicationStatus struct {
+	// SourceIndex is the applied index of the source region when it was last contacted
+	SourceIndex uint64
+	// SyncedIndex is the applied index of the source region that all replicas are up to date with
+	SyncedIndex uint64
+	// LastContact and LastSync are the Unix times of the last contact and completed sync
+	LastContact int64
+	LastSync    int64
+	Repos       int
+	Error       string
+}
+
+// ReplicatedRepo is the state of a repo, as sent to the regions it is replicated to
+type ReplicatedRepo struct {
+	Public   bool
+	Deleted  bool
+	Symrefs  map[string]string
+	Refs     map[string]string
+	HideRefs []string
+}
+
+// ReplicationState contains the repos a region replicates to another region that changed
+// after the Since index, or all of them if Since is 0
+type ReplicationState struct {
+	Region       string
+	AppliedIndex uint64
+	Since        uint64
+	Repos        map[string]ReplicatedRepo
+	// Replicated are the names of all repos replicated to the region, changed or not
+	Replicated []string
+}
+
+type ClusterStatus struct {
+	Leader uint64
+	Term   uint64
+	Nodes  map[uint64]NodeStatus
+}
+
+type NodeRemoveRequest struct {
+	NodeID uint64
+}
+
+// BackupRequest asks a node for a backup, optionally incremental against an earlier one
+type BackupRequest struct {
+	// Base is the file name of the backup this one is incremental against
+	Base string
+	// BaseWants are the Wants of the base backup, everything reachable from them is left out
+	BaseWants map[string][]string
+}
+
+// BackupManifest describes a backup, and is its last entry
+type BackupManifest struct {
+	Version     int
+	ClusterName string
+	RegionName  string
+	NodeName    string
+	NodeID      uint64
+	// Index is the raft index the state snapshot was taken at
+	Index     uint64
+	CreatedAt int64
+	// Base is the file name of the backup this one is incremental against, in the same directory
+	Base string
+	// Wants are the objects referenced by the state, by storage project
+	Wants      map[string][]string
+	NumObjects int
 }
 
 type HookRunRequest struct {
@@ -0,0 +1,143 @@
+package datastructures
+
+import "time"
+
+type APISignature struct {
+	Name  string
+	Email string
+	Date  time.Time
+}
+
+type APICommit struct {
+	ID        string
+	Tree      string
+	Parents   []string
+	Author    APISignature
+	Committer APISignature
+	Message   string
+}
+
+type APITreeEntry struct {
+	Name string
+	Path string
+	// Type is one of "tree", "blob", "symlink" or "submodule"
+	Type string
+	Mode string
+	ID   string
+}
+
+type APITree struct {
+	ID      string
+	Commit  string
+	Path    string
+	Entries []APITreeEntry
+}
+
+type APILog struct {
+	Commits []APICommit
+	// NextPage is the page number to request for more commits, or 0 if there are none
+	NextPage int
+}
+
+type APIDiffHunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Header is the "@@ -a,b +c,d @@" line
+	Header string
+	// Lines are prefixed with " ", "+" or "-", like in a unified diff
+	Lines []string
+}
+
+type APIFileDiff struct {
+	Path string
+	// Status is one of "added", "removed" or "modified"
+	Status  string
+	OldID   string
+	NewID   string
+	OldMode string
+	NewMode string
+	Binary  bool
+	// TooLarge is set if the file was too large to compute a diff
+	TooLarge bool
+	Hunks    []APIDiffHunk
+}
+
+type APICompare struct {
+	Base      string
+	Head      string
+	MergeBase string
+	// Commits are reachable from head but not base, newest first.
+	// Only the newest are included if there are more than the server's limit.
+	Commits []APICommit
+	// TotalCommits is the number of commits reachable from head but not base
+	TotalCommits int
+	// Files are the changes between the merge base and head
+	Files []APIFileDiff
+}
+
+type APIFileChange struct {
+	Path string
+	// Action is one of "add", "modify" or "delete"
+	Action string
+	// Content is the new file content, base64 encoded if Encoding is "base64"
+	Content    string
+	Encoding   string
+	Executable bool
+}
+
+type APICommitRequest struct {
+	// Branch is the branch to commit to, either as short name or full ref name
+	Branch string
+	// Parent is the commit the branch is expected to point to. If empty, the current branch head is used,
+	// and if the branch does not exist yet it is created with a root commit.
+	Parent string
+	// Author is required. Committer defaults to the author. Dates default to the current time.
+	Author    APISignature
+	Committer APISignature
+	Message   string
+	Files     []APIFileChange
+}
+
+type APICommitResult struct {
+	Commit APICommit
+	// HookOutput contains the output of the hooks run for the push
+	HookOutput string
+}
+
+type APIMergeRequest struct {
+	// Branch is the branch to merge into, either as short name or full ref name
+	Branch string
+	// Head is the revision to merge into Branch
+	Head string
+	// Parent, if set, is the commit Branch is expected to point to
+	Parent string
+	// Message defaults to "Merge <Head> into <Branch>"
+	Message string
+	// Committer is required for merge commits. Author defaults to the committer.
+	Author    APISignature
+	Committer APISignature
+	// FastForwardOnly refuses to create a merge commit, NoFastForward always creates one
+	FastForwardOnly bool
+	NoFastForward   bool
+}
+
+type APIMergeConflict struct {
+	Path string
+	// Type is one of "content", "add/add", "modify/delete" or "type"
+	Type     string
+	BaseID   string
+	OursID   string
+	TheirsID string
+}
+
+type APIMergeResult struct {
+	// Merged is false if Branch already contained Head, or the merge had conflicts
+	Merged      bool
+	FastForward bool
+	// Commit is the new commit Branch points to
+	Commit     APICommit
+	Conflicts  []APIMergeConflict
+	HookOutput string
+}
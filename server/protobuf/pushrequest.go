@@ -24,6 +24,10 @@ func (m *UpdateRequest) ToObject() storage.ObjectID {
 	return storage.ObjectID(m.GetTo())
 }
 
+func (m *UpdateRequest) PeeledObject() storage.ObjectID {
+	return storage.ObjectID(m.GetPeeled())
+}
+
 func NewPushRequest(pushnode uint64, reponame string) *PushRequest {
 	timestamp := time.Now().UTC().UnixNano()
 	pushid := rand.Int63()
@@ -37,6 +41,11 @@ func NewPushRequest(pushnode uint64, reponame string) *PushRequest {
 	}
 }
 
+func (p *PushRequest) SetPusher(username, address string) {
+	p.Pusher = &username
+	p.Pusheraddress = &address
+}
+
 func (p *PushRequest) UUID() string {
 	return fmt.Sprintf("push-%d-%d-%d",
 		p.GetPushnode(),
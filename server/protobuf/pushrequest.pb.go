@@ -21,21 +21,32 @@ const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 type ChangeRequest_ChangeRequestType int32
 
 const (
-	ChangeRequest_NEWREPO  ChangeRequest_ChangeRequestType = 1
-	ChangeRequest_EDITREPO ChangeRequest_ChangeRequestType = 2
-	// DELETEREPO = 3;  // This is not yet implemented
-	ChangeRequest_PUSHREQUEST ChangeRequest_ChangeRequestType = 4
+	ChangeRequest_NEWREPO      ChangeRequest_ChangeRequestType = 1
+	ChangeRequest_EDITREPO     ChangeRequest_ChangeRequestType = 2
+	ChangeRequest_DELETEREPO   ChangeRequest_ChangeRequestType = 3
+	ChangeRequest_PUSHREQUEST  ChangeRequest_ChangeRequestType = 4
+	ChangeRequest_LFSOBJECT    ChangeRequest_ChangeRequestType = 5
+	ChangeRequest_UNDELETEREPO ChangeRequest_ChangeRequestType = 6
+	ChangeRequest_RENAMEREPO   ChangeRequest_ChangeRequestType = 7
 )
 
 var ChangeRequest_ChangeRequestType_name = map[int32]string{
 	1: "NEWREPO",
 	2: "EDITREPO",
+	3: "DELETEREPO",
 	4: "PUSHREQUEST",
+	5: "LFSOBJECT",
+	6: "UNDELETEREPO",
+	7: "RENAMEREPO",
 }
 var ChangeRequest_ChangeRequestType_value = map[string]int32{
-	"NEWREPO":     1,
-	"EDITREPO":    2,
-	"PUSHREQUEST": 4,
+	"NEWREPO":      1,
+	"EDITREPO":     2,
+	"DELETEREPO":   3,
+	"PUSHREQUEST":  4,
+	"LFSOBJECT":    5,
+	"UNDELETEREPO": 6,
+	"RENAMEREPO":   7,
 }
 
 func (x ChangeRequest_ChangeRequestType) Enum() *ChangeRequest_ChangeRequestType {
@@ -55,13 +66,17 @@ func (x *ChangeRequest_ChangeRequestType) UnmarshalJSON(data []byte) error {
 	return nil
 }
 func (ChangeRequest_ChangeRequestType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{5, 0}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{8, 0}
 }
 
 type UpdateRequest struct {
-	Ref                  *string  `protobuf:"bytes,1,req,name=ref" json:"ref,omitempty"`
-	From                 *string  `protobuf:"bytes,2,req,name=from" json:"from,omitempty"`
-	To                   *string  `protobuf:"bytes,3,req,name=to" json:"to,omitempty"`
+	Ref  *string `protobuf:"bytes,1,req,name=ref" json:"ref,omitempty"`
+	From *string `protobuf:"bytes,2,req,name=from" json:"from,omitempty"`
+	To   *string `protobuf:"bytes,3,req,name=to" json:"to,omitempty"`
+	// The object an annotated tag in "to" eventually points at
+	Peeled *string `protobuf:"bytes,4,opt,name=peeled" json:"peeled,omitempty"`
+	// Whether "to" descends from "from", set once the objects are available
+	Fastforward          *bool    `protobuf:"varint,5,opt,name=fastforward" json:"fastforward,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -71,7 +86,7 @@ func (m *UpdateRequest) Reset()         { *m = UpdateRequest{} }
 func (m *UpdateRequest) String() string { return proto.CompactTextString(m) }
 func (*UpdateRequest) ProtoMessage()    {}
 func (*UpdateRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{0}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{0}
 }
 func (m *UpdateRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_UpdateRequest.Unmarshal(m, b)
@@ -112,22 +127,44 @@ func (m *UpdateRequest) GetTo() string {
 	return ""
 }
 
+func (m *UpdateRequest) GetPeeled() string {
+	if m != nil && m.Peeled != nil {
+		return *m.Peeled
+	}
+	return ""
+}
+
+func (m *UpdateRequest) GetFastforward() bool {
+	if m != nil && m.Fastforward != nil {
+		return *m.Fastforward
+	}
+	return false
+}
+
 type PushRequest struct {
-	Pushnode             *uint64          `protobuf:"varint,1,req,name=pushnode" json:"pushnode,omitempty"`
-	Pushtime             *int64           `protobuf:"varint,2,req,name=pushtime" json:"pushtime,omitempty"`
-	Pushid               *int64           `protobuf:"varint,3,req,name=pushid" json:"pushid,omitempty"`
-	Reponame             *string          `protobuf:"bytes,4,req,name=reponame" json:"reponame,omitempty"`
-	Requests             []*UpdateRequest `protobuf:"bytes,5,rep,name=requests" json:"requests,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
-	XXX_unrecognized     []byte           `json:"-"`
-	XXX_sizecache        int32            `json:"-"`
+	Pushnode *uint64          `protobuf:"varint,1,req,name=pushnode" json:"pushnode,omitempty"`
+	Pushtime *int64           `protobuf:"varint,2,req,name=pushtime" json:"pushtime,omitempty"`
+	Pushid   *int64           `protobuf:"varint,3,req,name=pushid" json:"pushid,omitempty"`
+	Reponame *string          `protobuf:"bytes,4,req,name=reponame" json:"reponame,omitempty"`
+	Requests []*UpdateRequest `protobuf:"bytes,5,rep,name=requests" json:"requests,omitempty"`
+	// The username of the client that performed the push
+	Pusher *string `protobuf:"bytes,6,opt,name=pusher" json:"pusher,omitempty"`
+	// The address of the client that performed the push
+	Pusheraddress *string `protobuf:"bytes,7,opt,name=pusheraddress" json:"pusheraddress,omitempty"`
+	// Set for pushes that restore earlier ref values on request of an admin
+	Restore *bool `protobuf:"varint,8,opt,name=restore" json:"restore,omitempty"`
+	// Set for pushes that replay the refs of a repo replicated from another region
+	Replicated           *bool    `protobuf:"varint,9,opt,name=replicated" json:"replicated,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *PushRequest) Reset()         { *m = PushRequest{} }
 func (m *PushRequest) String() string { return proto.CompactTextString(m) }
 func (*PushRequest) ProtoMessage()    {}
 func (*PushRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{1}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{1}
 }
 func (m *PushRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_PushRequest.Unmarshal(m, b)
@@ -182,9 +219,40 @@ func (m *PushRequest) GetRequests() []*UpdateRequest {
 	return nil
 }
 
+func (m *PushRequest) GetPusher() string {
+	if m != nil && m.Pusher != nil {
+		return *m.Pusher
+	}
+	return ""
+}
+
+func (m *PushRequest) GetPusheraddress() string {
+	if m != nil && m.Pusheraddress != nil {
+		return *m.Pusheraddress
+	}
+	return ""
+}
+
+func (m *PushRequest) GetRestore() bool {
+	if m != nil && m.Restore != nil {
+		return *m.Restore
+	}
+	return false
+}
+
+func (m *PushRequest) GetReplicated() bool {
+	if m != nil && m.Replicated != nil {
+		return *m.Replicated
+	}
+	return false
+}
+
 type NewRepoRequest struct {
-	Reponame             *string  `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
-	Public               *bool    `protobuf:"varint,2,req,name=public" json:"public,omitempty"`
+	Reponame      *string `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	Public        *bool   `protobuf:"varint,2,req,name=public" json:"public,omitempty"`
+	Defaultbranch *string `protobuf:"bytes,3,opt,name=defaultbranch" json:"defaultbranch,omitempty"`
+	// The region the repo is replicated from, which makes it read-only in this region
+	Replicaof            *string  `protobuf:"bytes,4,opt,name=replicaof" json:"replicaof,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -194,7 +262,7 @@ func (m *NewRepoRequest) Reset()         { *m = NewRepoRequest{} }
 func (m *NewRepoRequest) String() string { return proto.CompactTextString(m) }
 func (*NewRepoRequest) ProtoMessage()    {}
 func (*NewRepoRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{2}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{2}
 }
 func (m *NewRepoRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_NewRepoRequest.Unmarshal(m, b)
@@ -228,6 +296,20 @@ func (m *NewRepoRequest) GetPublic() bool {
 	return false
 }
 
+func (m *NewRepoRequest) GetDefaultbranch() string {
+	if m != nil && m.Defaultbranch != nil {
+		return *m.Defaultbranch
+	}
+	return ""
+}
+
+func (m *NewRepoRequest) GetReplicaof() string {
+	if m != nil && m.Replicaof != nil {
+		return *m.Replicaof
+	}
+	return ""
+}
+
 type EditRepoRequest struct {
 	Reponame             *string  `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
 	Updaterequest        []byte   `protobuf:"bytes,2,req,name=updaterequest" json:"updaterequest,omitempty"`
@@ -240,7 +322,7 @@ func (m *EditRepoRequest) Reset()         { *m = EditRepoRequest{} }
 func (m *EditRepoRequest) String() string { return proto.CompactTextString(m) }
 func (*EditRepoRequest) ProtoMessage()    {}
 func (*EditRepoRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{3}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{3}
 }
 func (m *EditRepoRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_EditRepoRequest.Unmarshal(m, b)
@@ -275,7 +357,11 @@ func (m *EditRepoRequest) GetUpdaterequest() []byte {
 }
 
 type DeleteRepoRequest struct {
-	Reponame             *string  `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	Reponame *string `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	// Unix timestamp of the deletion, the grace period for undeleting starts here
+	Deletetime *int64 `protobuf:"varint,2,opt,name=deletetime" json:"deletetime,omitempty"`
+	// Remove the repo and its objects permanently, instead of marking it deleted
+	Purge                *bool    `protobuf:"varint,3,opt,name=purge" json:"purge,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -285,7 +371,7 @@ func (m *DeleteRepoRequest) Reset()         { *m = DeleteRepoRequest{} }
 func (m *DeleteRepoRequest) String() string { return proto.CompactTextString(m) }
 func (*DeleteRepoRequest) ProtoMessage()    {}
 func (*DeleteRepoRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{4}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{4}
 }
 func (m *DeleteRepoRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_DeleteRepoRequest.Unmarshal(m, b)
@@ -312,12 +398,184 @@ func (m *DeleteRepoRequest) GetReponame() string {
 	return ""
 }
 
+func (m *DeleteRepoRequest) GetDeletetime() int64 {
+	if m != nil && m.Deletetime != nil {
+		return *m.Deletetime
+	}
+	return 0
+}
+
+func (m *DeleteRepoRequest) GetPurge() bool {
+	if m != nil && m.Purge != nil {
+		return *m.Purge
+	}
+	return false
+}
+
+type UndeleteRepoRequest struct {
+	Reponame             *string  `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UndeleteRepoRequest) Reset()         { *m = UndeleteRepoRequest{} }
+func (m *UndeleteRepoRequest) String() string { return proto.CompactTextString(m) }
+func (*UndeleteRepoRequest) ProtoMessage()    {}
+func (*UndeleteRepoRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{5}
+}
+func (m *UndeleteRepoRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UndeleteRepoRequest.Unmarshal(m, b)
+}
+func (m *UndeleteRepoRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UndeleteRepoRequest.Marshal(b, m, deterministic)
+}
+func (dst *UndeleteRepoRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UndeleteRepoRequest.Merge(dst, src)
+}
+func (m *UndeleteRepoRequest) XXX_Size() int {
+	return xxx_messageInfo_UndeleteRepoRequest.Size(m)
+}
+func (m *UndeleteRepoRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UndeleteRepoRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UndeleteRepoRequest proto.InternalMessageInfo
+
+func (m *UndeleteRepoRequest) GetReponame() string {
+	if m != nil && m.Reponame != nil {
+		return *m.Reponame
+	}
+	return ""
+}
+
+type RenameRepoRequest struct {
+	Reponame *string `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	Newname  *string `protobuf:"bytes,2,req,name=newname" json:"newname,omitempty"`
+	// Unix timestamp of the rename, the old name redirects to the new one for a while after this
+	Renametime           *int64   `protobuf:"varint,3,req,name=renametime" json:"renametime,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenameRepoRequest) Reset()         { *m = RenameRepoRequest{} }
+func (m *RenameRepoRequest) String() string { return proto.CompactTextString(m) }
+func (*RenameRepoRequest) ProtoMessage()    {}
+func (*RenameRepoRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{6}
+}
+func (m *RenameRepoRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenameRepoRequest.Unmarshal(m, b)
+}
+func (m *RenameRepoRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenameRepoRequest.Marshal(b, m, deterministic)
+}
+func (dst *RenameRepoRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenameRepoRequest.Merge(dst, src)
+}
+func (m *RenameRepoRequest) XXX_Size() int {
+	return xxx_messageInfo_RenameRepoRequest.Size(m)
+}
+func (m *RenameRepoRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenameRepoRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenameRepoRequest proto.InternalMessageInfo
+
+func (m *RenameRepoRequest) GetReponame() string {
+	if m != nil && m.Reponame != nil {
+		return *m.Reponame
+	}
+	return ""
+}
+
+func (m *RenameRepoRequest) GetNewname() string {
+	if m != nil && m.Newname != nil {
+		return *m.Newname
+	}
+	return ""
+}
+
+func (m *RenameRepoRequest) GetRenametime() int64 {
+	if m != nil && m.Renametime != nil {
+		return *m.Renametime
+	}
+	return 0
+}
+
+type LFSObjectRequest struct {
+	Reponame             *string  `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	Oid                  *string  `protobuf:"bytes,2,req,name=oid" json:"oid,omitempty"`
+	Objectid             *string  `protobuf:"bytes,3,req,name=objectid" json:"objectid,omitempty"`
+	Size                 *int64   `protobuf:"varint,4,req,name=size" json:"size,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LFSObjectRequest) Reset()         { *m = LFSObjectRequest{} }
+func (m *LFSObjectRequest) String() string { return proto.CompactTextString(m) }
+func (*LFSObjectRequest) ProtoMessage()    {}
+func (*LFSObjectRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{7}
+}
+func (m *LFSObjectRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LFSObjectRequest.Unmarshal(m, b)
+}
+func (m *LFSObjectRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LFSObjectRequest.Marshal(b, m, deterministic)
+}
+func (dst *LFSObjectRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LFSObjectRequest.Merge(dst, src)
+}
+func (m *LFSObjectRequest) XXX_Size() int {
+	return xxx_messageInfo_LFSObjectRequest.Size(m)
+}
+func (m *LFSObjectRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LFSObjectRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LFSObjectRequest proto.InternalMessageInfo
+
+func (m *LFSObjectRequest) GetReponame() string {
+	if m != nil && m.Reponame != nil {
+		return *m.Reponame
+	}
+	return ""
+}
+
+func (m *LFSObjectRequest) GetOid() string {
+	if m != nil && m.Oid != nil {
+		return *m.Oid
+	}
+	return ""
+}
+
+func (m *LFSObjectRequest) GetObjectid() string {
+	if m != nil && m.Objectid != nil {
+		return *m.Objectid
+	}
+	return ""
+}
+
+func (m *LFSObjectRequest) GetSize() int64 {
+	if m != nil && m.Size != nil {
+		return *m.Size
+	}
+	return 0
+}
+
 type ChangeRequest struct {
 	Ctype                *ChangeRequest_ChangeRequestType `protobuf:"varint,1,req,name=ctype,enum=protobuf.ChangeRequest_ChangeRequestType" json:"ctype,omitempty"`
 	Newreporeq           *NewRepoRequest                  `protobuf:"bytes,2,opt,name=newreporeq" json:"newreporeq,omitempty"`
 	Editreporeq          *EditRepoRequest                 `protobuf:"bytes,3,opt,name=editreporeq" json:"editreporeq,omitempty"`
 	Deletereporeq        *DeleteRepoRequest               `protobuf:"bytes,4,opt,name=deletereporeq" json:"deletereporeq,omitempty"`
 	Pushreq              *PushRequest                     `protobuf:"bytes,5,opt,name=pushreq" json:"pushreq,omitempty"`
+	Lfsobjectreq         *LFSObjectRequest                `protobuf:"bytes,6,opt,name=lfsobjectreq" json:"lfsobjectreq,omitempty"`
+	Undeletereporeq      *UndeleteRepoRequest             `protobuf:"bytes,7,opt,name=undeletereporeq" json:"undeletereporeq,omitempty"`
+	Renamereporeq        *RenameRepoRequest               `protobuf:"bytes,8,opt,name=renamereporeq" json:"renamereporeq,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}                         `json:"-"`
 	XXX_unrecognized     []byte                           `json:"-"`
 	XXX_sizecache        int32                            `json:"-"`
@@ -327,7 +585,7 @@ func (m *ChangeRequest) Reset()         { *m = ChangeRequest{} }
 func (m *ChangeRequest) String() string { return proto.CompactTextString(m) }
 func (*ChangeRequest) ProtoMessage()    {}
 func (*ChangeRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pushrequest_dce14a1b2cc4ddd1, []int{5}
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{8}
 }
 func (m *ChangeRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ChangeRequest.Unmarshal(m, b)
@@ -382,42 +640,635 @@ func (m *ChangeRequest) GetPushreq() *PushRequest {
 	return nil
 }
 
+func (m *ChangeRequest) GetLfsobjectreq() *LFSObjectRequest {
+	if m != nil {
+		return m.Lfsobjectreq
+	}
+	return nil
+}
+
+func (m *ChangeRequest) GetUndeletereporeq() *UndeleteRepoRequest {
+	if m != nil {
+		return m.Undeletereporeq
+	}
+	return nil
+}
+
+func (m *ChangeRequest) GetRenamereporeq() *RenameRepoRequest {
+	if m != nil {
+		return m.Renamereporeq
+	}
+	return nil
+}
+
+type RepoHooksState struct {
+	Prereceive           *string  `protobuf:"bytes,1,opt,name=prereceive" json:"prereceive,omitempty"`
+	Update               *string  `protobuf:"bytes,2,opt,name=update" json:"update,omitempty"`
+	Postreceive          *string  `protobuf:"bytes,3,opt,name=postreceive" json:"postreceive,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RepoHooksState) Reset()         { *m = RepoHooksState{} }
+func (m *RepoHooksState) String() string { return proto.CompactTextString(m) }
+func (*RepoHooksState) ProtoMessage()    {}
+func (*RepoHooksState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{9}
+}
+func (m *RepoHooksState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RepoHooksState.Unmarshal(m, b)
+}
+func (m *RepoHooksState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RepoHooksState.Marshal(b, m, deterministic)
+}
+func (dst *RepoHooksState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RepoHooksState.Merge(dst, src)
+}
+func (m *RepoHooksState) XXX_Size() int {
+	return xxx_messageInfo_RepoHooksState.Size(m)
+}
+func (m *RepoHooksState) XXX_DiscardUnknown() {
+	xxx_messageInfo_RepoHooksState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RepoHooksState proto.InternalMessageInfo
+
+func (m *RepoHooksState) GetPrereceive() string {
+	if m != nil && m.Prereceive != nil {
+		return *m.Prereceive
+	}
+	return ""
+}
+
+func (m *RepoHooksState) GetUpdate() string {
+	if m != nil && m.Update != nil {
+		return *m.Update
+	}
+	return ""
+}
+
+func (m *RepoHooksState) GetPostreceive() string {
+	if m != nil && m.Postreceive != nil {
+		return *m.Postreceive
+	}
+	return ""
+}
+
+type LFSObjectState struct {
+	Objectid             *string  `protobuf:"bytes,1,req,name=objectid" json:"objectid,omitempty"`
+	Size                 *int64   `protobuf:"varint,2,req,name=size" json:"size,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LFSObjectState) Reset()         { *m = LFSObjectState{} }
+func (m *LFSObjectState) String() string { return proto.CompactTextString(m) }
+func (*LFSObjectState) ProtoMessage()    {}
+func (*LFSObjectState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{10}
+}
+func (m *LFSObjectState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LFSObjectState.Unmarshal(m, b)
+}
+func (m *LFSObjectState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LFSObjectState.Marshal(b, m, deterministic)
+}
+func (dst *LFSObjectState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LFSObjectState.Merge(dst, src)
+}
+func (m *LFSObjectState) XXX_Size() int {
+	return xxx_messageInfo_LFSObjectState.Size(m)
+}
+func (m *LFSObjectState) XXX_DiscardUnknown() {
+	xxx_messageInfo_LFSObjectState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LFSObjectState proto.InternalMessageInfo
+
+func (m *LFSObjectState) GetObjectid() string {
+	if m != nil && m.Objectid != nil {
+		return *m.Objectid
+	}
+	return ""
+}
+
+func (m *LFSObjectState) GetSize() int64 {
+	if m != nil && m.Size != nil {
+		return *m.Size
+	}
+	return 0
+}
+
+type BranchProtectionState struct {
+	Pattern              *string  `protobuf:"bytes,1,req,name=pattern" json:"pattern,omitempty"`
+	Denynonfastforward   *bool    `protobuf:"varint,2,opt,name=denynonfastforward" json:"denynonfastforward,omitempty"`
+	Denydeletion         *bool    `protobuf:"varint,3,opt,name=denydeletion" json:"denydeletion,omitempty"`
+	Allowedpushers       []string `protobuf:"bytes,4,rep,name=allowedpushers" json:"allowedpushers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BranchProtectionState) Reset()         { *m = BranchProtectionState{} }
+func (m *BranchProtectionState) String() string { return proto.CompactTextString(m) }
+func (*BranchProtectionState) ProtoMessage()    {}
+func (*BranchProtectionState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{11}
+}
+func (m *BranchProtectionState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BranchProtectionState.Unmarshal(m, b)
+}
+func (m *BranchProtectionState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BranchProtectionState.Marshal(b, m, deterministic)
+}
+func (dst *BranchProtectionState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BranchProtectionState.Merge(dst, src)
+}
+func (m *BranchProtectionState) XXX_Size() int {
+	return xxx_messageInfo_BranchProtectionState.Size(m)
+}
+func (m *BranchProtectionState) XXX_DiscardUnknown() {
+	xxx_messageInfo_BranchProtectionState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BranchProtectionState proto.InternalMessageInfo
+
+func (m *BranchProtectionState) GetPattern() string {
+	if m != nil && m.Pattern != nil {
+		return *m.Pattern
+	}
+	return ""
+}
+
+func (m *BranchProtectionState) GetDenynonfastforward() bool {
+	if m != nil && m.Denynonfastforward != nil {
+		return *m.Denynonfastforward
+	}
+	return false
+}
+
+func (m *BranchProtectionState) GetDenydeletion() bool {
+	if m != nil && m.Denydeletion != nil {
+		return *m.Denydeletion
+	}
+	return false
+}
+
+func (m *BranchProtectionState) GetAllowedpushers() []string {
+	if m != nil {
+		return m.Allowedpushers
+	}
+	return nil
+}
+
+type ReflogEntryState struct {
+	Ref                  *string  `protobuf:"bytes,1,req,name=ref" json:"ref,omitempty"`
+	Oldvalue             *string  `protobuf:"bytes,2,req,name=oldvalue" json:"oldvalue,omitempty"`
+	Newvalue             *string  `protobuf:"bytes,3,req,name=newvalue" json:"newvalue,omitempty"`
+	Forced               *bool    `protobuf:"varint,4,opt,name=forced" json:"forced,omitempty"`
+	Pusher               *string  `protobuf:"bytes,5,opt,name=pusher" json:"pusher,omitempty"`
+	Pusheraddress        *string  `protobuf:"bytes,6,opt,name=pusheraddress" json:"pusheraddress,omitempty"`
+	Pushnode             *uint64  `protobuf:"varint,7,opt,name=pushnode" json:"pushnode,omitempty"`
+	Pushtime             *int64   `protobuf:"varint,8,opt,name=pushtime" json:"pushtime,omitempty"`
+	Raftindex            *uint64  `protobuf:"varint,9,opt,name=raftindex" json:"raftindex,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReflogEntryState) Reset()         { *m = ReflogEntryState{} }
+func (m *ReflogEntryState) String() string { return proto.CompactTextString(m) }
+func (*ReflogEntryState) ProtoMessage()    {}
+func (*ReflogEntryState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{12}
+}
+func (m *ReflogEntryState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReflogEntryState.Unmarshal(m, b)
+}
+func (m *ReflogEntryState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReflogEntryState.Marshal(b, m, deterministic)
+}
+func (dst *ReflogEntryState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReflogEntryState.Merge(dst, src)
+}
+func (m *ReflogEntryState) XXX_Size() int {
+	return xxx_messageInfo_ReflogEntryState.Size(m)
+}
+func (m *ReflogEntryState) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReflogEntryState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReflogEntryState proto.InternalMessageInfo
+
+func (m *ReflogEntryState) GetRef() string {
+	if m != nil && m.Ref != nil {
+		return *m.Ref
+	}
+	return ""
+}
+
+func (m *ReflogEntryState) GetOldvalue() string {
+	if m != nil && m.Oldvalue != nil {
+		return *m.Oldvalue
+	}
+	return ""
+}
+
+func (m *ReflogEntryState) GetNewvalue() string {
+	if m != nil && m.Newvalue != nil {
+		return *m.Newvalue
+	}
+	return ""
+}
+
+func (m *ReflogEntryState) GetForced() bool {
+	if m != nil && m.Forced != nil {
+		return *m.Forced
+	}
+	return false
+}
+
+func (m *ReflogEntryState) GetPusher() string {
+	if m != nil && m.Pusher != nil {
+		return *m.Pusher
+	}
+	return ""
+}
+
+func (m *ReflogEntryState) GetPusheraddress() string {
+	if m != nil && m.Pusheraddress != nil {
+		return *m.Pusheraddress
+	}
+	return ""
+}
+
+func (m *ReflogEntryState) GetPushnode() uint64 {
+	if m != nil && m.Pushnode != nil {
+		return *m.Pushnode
+	}
+	return 0
+}
+
+func (m *ReflogEntryState) GetPushtime() int64 {
+	if m != nil && m.Pushtime != nil {
+		return *m.Pushtime
+	}
+	return 0
+}
+
+func (m *ReflogEntryState) GetRaftindex() uint64 {
+	if m != nil && m.Raftindex != nil {
+		return *m.Raftindex
+	}
+	return 0
+}
+
+type RepoState struct {
+	Reponame     *string           `protobuf:"bytes,1,req,name=reponame" json:"reponame,omitempty"`
+	Symrefs      map[string]string `protobuf:"bytes,2,rep,name=symrefs" json:"symrefs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Refs         map[string]string `protobuf:"bytes,3,rep,name=refs" json:"refs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Peeled       map[string]string `protobuf:"bytes,4,rep,name=peeled" json:"peeled,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Hooks        *RepoHooksState   `protobuf:"bytes,5,opt,name=hooks" json:"hooks,omitempty"`
+	Lastpushnode *uint64           `protobuf:"varint,6,opt,name=lastpushnode" json:"lastpushnode,omitempty"`
+	Public       *bool             `protobuf:"varint,7,opt,name=public" json:"public,omitempty"`
+	Hiderefs     []string          `protobuf:"bytes,8,rep,name=hiderefs" json:"hiderefs,omitempty"`
+	// LFS objects by oid
+	Lfsobjects           map[string]*LFSObjectState `protobuf:"bytes,9,rep,name=lfsobjects" json:"lfsobjects,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Lfsquota             *int64                     `protobuf:"varint,10,opt,name=lfsquota" json:"lfsquota,omitempty"`
+	Branchprotections    []*BranchProtectionState   `protobuf:"bytes,11,rep,name=branchprotections" json:"branchprotections,omitempty"`
+	Creatablerefs        []string                   `protobuf:"bytes,12,rep,name=creatablerefs" json:"creatablerefs,omitempty"`
+	Reflog               []*ReflogEntryState        `protobuf:"bytes,13,rep,name=reflog" json:"reflog,omitempty"`
+	Deletedat            *int64                     `protobuf:"varint,14,opt,name=deletedat" json:"deletedat,omitempty"`
+	Renamedto            *string                    `protobuf:"bytes,15,opt,name=renamedto" json:"renamedto,omitempty"`
+	Renamedat            *int64                     `protobuf:"varint,16,opt,name=renamedat" json:"renamedat,omitempty"`
+	Regions              []string                   `protobuf:"bytes,17,rep,name=regions" json:"regions,omitempty"`
+	Replicaof            *string                    `protobuf:"bytes,18,opt,name=replicaof" json:"replicaof,omitempty"`
+	Consistentreads      *bool                      `protobuf:"varint,19,opt,name=consistentreads" json:"consistentreads,omitempty"`
+	Changedindex         *uint64                    `protobuf:"varint,20,opt,name=changedindex" json:"changedindex,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                   `json:"-"`
+	XXX_unrecognized     []byte                     `json:"-"`
+	XXX_sizecache        int32                      `json:"-"`
+}
+
+func (m *RepoState) Reset()         { *m = RepoState{} }
+func (m *RepoState) String() string { return proto.CompactTextString(m) }
+func (*RepoState) ProtoMessage()    {}
+func (*RepoState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{13}
+}
+func (m *RepoState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RepoState.Unmarshal(m, b)
+}
+func (m *RepoState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RepoState.Marshal(b, m, deterministic)
+}
+func (dst *RepoState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RepoState.Merge(dst, src)
+}
+func (m *RepoState) XXX_Size() int {
+	return xxx_messageInfo_RepoState.Size(m)
+}
+func (m *RepoState) XXX_DiscardUnknown() {
+	xxx_messageInfo_RepoState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RepoState proto.InternalMessageInfo
+
+func (m *RepoState) GetReponame() string {
+	if m != nil && m.Reponame != nil {
+		return *m.Reponame
+	}
+	return ""
+}
+
+func (m *RepoState) GetSymrefs() map[string]string {
+	if m != nil {
+		return m.Symrefs
+	}
+	return nil
+}
+
+func (m *RepoState) GetRefs() map[string]string {
+	if m != nil {
+		return m.Refs
+	}
+	return nil
+}
+
+func (m *RepoState) GetPeeled() map[string]string {
+	if m != nil {
+		return m.Peeled
+	}
+	return nil
+}
+
+func (m *RepoState) GetHooks() *RepoHooksState {
+	if m != nil {
+		return m.Hooks
+	}
+	return nil
+}
+
+func (m *RepoState) GetLastpushnode() uint64 {
+	if m != nil && m.Lastpushnode != nil {
+		return *m.Lastpushnode
+	}
+	return 0
+}
+
+func (m *RepoState) GetPublic() bool {
+	if m != nil && m.Public != nil {
+		return *m.Public
+	}
+	return false
+}
+
+func (m *RepoState) GetHiderefs() []string {
+	if m != nil {
+		return m.Hiderefs
+	}
+	return nil
+}
+
+func (m *RepoState) GetLfsobjects() map[string]*LFSObjectState {
+	if m != nil {
+		return m.Lfsobjects
+	}
+	return nil
+}
+
+func (m *RepoState) GetLfsquota() int64 {
+	if m != nil && m.Lfsquota != nil {
+		return *m.Lfsquota
+	}
+	return 0
+}
+
+func (m *RepoState) GetBranchprotections() []*BranchProtectionState {
+	if m != nil {
+		return m.Branchprotections
+	}
+	return nil
+}
+
+func (m *RepoState) GetCreatablerefs() []string {
+	if m != nil {
+		return m.Creatablerefs
+	}
+	return nil
+}
+
+func (m *RepoState) GetReflog() []*ReflogEntryState {
+	if m != nil {
+		return m.Reflog
+	}
+	return nil
+}
+
+func (m *RepoState) GetDeletedat() int64 {
+	if m != nil && m.Deletedat != nil {
+		return *m.Deletedat
+	}
+	return 0
+}
+
+func (m *RepoState) GetRenamedto() string {
+	if m != nil && m.Renamedto != nil {
+		return *m.Renamedto
+	}
+	return ""
+}
+
+func (m *RepoState) GetRenamedat() int64 {
+	if m != nil && m.Renamedat != nil {
+		return *m.Renamedat
+	}
+	return 0
+}
+
+func (m *RepoState) GetRegions() []string {
+	if m != nil {
+		return m.Regions
+	}
+	return nil
+}
+
+func (m *RepoState) GetReplicaof() string {
+	if m != nil && m.Replicaof != nil {
+		return *m.Replicaof
+	}
+	return ""
+}
+
+func (m *RepoState) GetConsistentreads() bool {
+	if m != nil && m.Consistentreads != nil {
+		return *m.Consistentreads
+	}
+	return false
+}
+
+func (m *RepoState) GetChangedindex() uint64 {
+	if m != nil && m.Changedindex != nil {
+		return *m.Changedindex
+	}
+	return 0
+}
+
+// StateSnapshot contains the full replicated state, as of the raft index it was taken at
+type StateSnapshot struct {
+	// Format version, nodes refuse to load snapshots from newer versions
+	Version              *uint32      `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Index                *uint64      `protobuf:"varint,2,req,name=index" json:"index,omitempty"`
+	Repos                []*RepoState `protobuf:"bytes,3,rep,name=repos" json:"repos,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *StateSnapshot) Reset()         { *m = StateSnapshot{} }
+func (m *StateSnapshot) String() string { return proto.CompactTextString(m) }
+func (*StateSnapshot) ProtoMessage()    {}
+func (*StateSnapshot) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pushrequest_a494ec06a1b788fc, []int{14}
+}
+func (m *StateSnapshot) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StateSnapshot.Unmarshal(m, b)
+}
+func (m *StateSnapshot) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StateSnapshot.Marshal(b, m, deterministic)
+}
+func (dst *StateSnapshot) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StateSnapshot.Merge(dst, src)
+}
+func (m *StateSnapshot) XXX_Size() int {
+	return xxx_messageInfo_StateSnapshot.Size(m)
+}
+func (m *StateSnapshot) XXX_DiscardUnknown() {
+	xxx_messageInfo_StateSnapshot.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StateSnapshot proto.InternalMessageInfo
+
+func (m *StateSnapshot) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+func (m *StateSnapshot) GetIndex() uint64 {
+	if m != nil && m.Index != nil {
+		return *m.Index
+	}
+	return 0
+}
+
+func (m *StateSnapshot) GetRepos() []*RepoState {
+	if m != nil {
+		return m.Repos
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*UpdateRequest)(nil), "protobuf.UpdateRequest")
 	proto.RegisterType((*PushRequest)(nil), "protobuf.PushRequest")
 	proto.RegisterType((*NewRepoRequest)(nil), "protobuf.NewRepoRequest")
 	proto.RegisterType((*EditRepoRequest)(nil), "protobuf.EditRepoRequest")
 	proto.RegisterType((*DeleteRepoRequest)(nil), "protobuf.DeleteRepoRequest")
+	proto.RegisterType((*UndeleteRepoRequest)(nil), "protobuf.UndeleteRepoRequest")
+	proto.RegisterType((*RenameRepoRequest)(nil), "protobuf.RenameRepoRequest")
+	proto.RegisterType((*LFSObjectRequest)(nil), "protobuf.LFSObjectRequest")
 	proto.RegisterType((*ChangeRequest)(nil), "protobuf.ChangeRequest")
+	proto.RegisterType((*RepoHooksState)(nil), "protobuf.RepoHooksState")
+	proto.RegisterType((*LFSObjectState)(nil), "protobuf.LFSObjectState")
+	proto.RegisterType((*BranchProtectionState)(nil), "protobuf.BranchProtectionState")
+	proto.RegisterType((*ReflogEntryState)(nil), "protobuf.ReflogEntryState")
+	proto.RegisterType((*RepoState)(nil), "protobuf.RepoState")
+	proto.RegisterMapType((map[string]*LFSObjectState)(nil), "protobuf.RepoState.LfsobjectsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "protobuf.RepoState.PeeledEntry")
+	proto.RegisterMapType((map[string]string)(nil), "protobuf.RepoState.RefsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "protobuf.RepoState.SymrefsEntry")
+	proto.RegisterType((*StateSnapshot)(nil), "protobuf.StateSnapshot")
 	proto.RegisterEnum("protobuf.ChangeRequest_ChangeRequestType", ChangeRequest_ChangeRequestType_name, ChangeRequest_ChangeRequestType_value)
 }
 
-func init() { proto.RegisterFile("pushrequest.proto", fileDescriptor_pushrequest_dce14a1b2cc4ddd1) }
-
-var fileDescriptor_pushrequest_dce14a1b2cc4ddd1 = []byte{
-	// 369 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x92, 0x4f, 0x6b, 0xea, 0x50,
-	0x10, 0xc5, 0x31, 0x89, 0xcf, 0xbc, 0x89, 0xf1, 0x4f, 0x40, 0xde, 0x7d, 0x74, 0x23, 0x81, 0x16,
-	0x85, 0x92, 0x45, 0x16, 0xa5, 0x74, 0xd3, 0x45, 0x0d, 0xb4, 0x1b, 0x6b, 0xa3, 0xd2, 0xb5, 0x9a,
-	0xb1, 0x06, 0x34, 0xb9, 0x26, 0x37, 0x88, 0x9b, 0x7e, 0xba, 0x7e, 0xb0, 0x72, 0x47, 0x63, 0xfc,
-	0x03, 0xae, 0x74, 0x86, 0xf3, 0x9b, 0x73, 0x4e, 0xb8, 0xd0, 0xe4, 0x59, 0xba, 0x48, 0x70, 0x9d,
-	0x61, 0x2a, 0x1c, 0x9e, 0xc4, 0x22, 0xb6, 0x74, 0xfa, 0x99, 0x66, 0x73, 0xfb, 0x01, 0xcc, 0x31,
-	0x0f, 0x26, 0x02, 0xfd, 0x9d, 0xc0, 0x32, 0x40, 0x4d, 0x70, 0xce, 0x4a, 0x6d, 0xa5, 0xf3, 0xd7,
-	0xaa, 0x82, 0x36, 0x4f, 0xe2, 0x15, 0x53, 0x68, 0x02, 0x50, 0x44, 0xcc, 0x54, 0xf9, 0xdf, 0xfe,
-	0x06, 0x63, 0x90, 0xa5, 0x8b, 0x9c, 0x6a, 0x80, 0x2e, 0x5d, 0xa2, 0x38, 0x40, 0x42, 0xb5, 0x7c,
-	0x23, 0xc2, 0x15, 0x12, 0xae, 0x5a, 0x35, 0xf8, 0x23, 0x37, 0x61, 0x40, 0x27, 0x54, 0xa9, 0x48,
-	0x90, 0xc7, 0xd1, 0x64, 0x85, 0x4c, 0x23, 0x83, 0xae, 0xdc, 0xd0, 0xc1, 0x94, 0x95, 0xdb, 0x6a,
-	0xc7, 0x70, 0xff, 0x39, 0x79, 0x52, 0xe7, 0x24, 0xa6, 0xed, 0x42, 0xad, 0x8f, 0x1b, 0x1f, 0x79,
-	0x7c, 0x14, 0xe1, 0x70, 0x6e, 0x97, 0x9e, 0x0c, 0xa7, 0xcb, 0x70, 0x46, 0x01, 0x74, 0xfb, 0x09,
-	0xea, 0x5e, 0x10, 0x8a, 0xeb, 0x50, 0x0b, 0xcc, 0x8c, 0x9c, 0xf6, 0x49, 0x88, 0xad, 0xda, 0xb7,
-	0xd0, 0xec, 0xe1, 0x12, 0x65, 0x80, 0x2b, 0xb4, 0xfd, 0xa3, 0x80, 0xf9, 0xb2, 0x98, 0x44, 0x5f,
-	0x87, 0xef, 0xf9, 0x08, 0xe5, 0x99, 0xd8, 0xf2, 0x9d, 0xa0, 0xe6, 0x76, 0x8b, 0x42, 0x27, 0xba,
-	0xd3, 0x69, 0xb4, 0xe5, 0x68, 0xdd, 0x03, 0x44, 0xb8, 0x91, 0x06, 0x09, 0xae, 0x99, 0xd2, 0x2e,
-	0x75, 0x0c, 0x97, 0x15, 0xf8, 0x59, 0x7d, 0x07, 0x0c, 0x0c, 0x42, 0x91, 0xcb, 0x55, 0x92, 0xff,
-	0x2f, 0xe4, 0xe7, 0xcd, 0x5d, 0x30, 0x03, 0x2a, 0x94, 0x13, 0x1a, 0x11, 0x37, 0x05, 0x71, 0xd9,
-	0xf7, 0x0e, 0x2a, 0xfb, 0xb7, 0xc4, 0xca, 0xa4, 0x6e, 0x15, 0xea, 0xa3, 0xd7, 0x60, 0x3f, 0x43,
-	0xf3, 0xb2, 0x8e, 0x01, 0x95, 0xbe, 0xf7, 0xe9, 0x7b, 0x83, 0xf7, 0x46, 0xc9, 0xaa, 0x82, 0xee,
-	0xf5, 0xde, 0x46, 0x34, 0x29, 0x56, 0x1d, 0x8c, 0xc1, 0x78, 0xf8, 0xea, 0x7b, 0x1f, 0x63, 0x6f,
-	0x38, 0x6a, 0x68, 0xbf, 0x01, 0x00, 0x00, 0xff, 0xff, 0x1d, 0xc9, 0x19, 0xb9, 0xb3, 0x02, 0x00,
-	0x00,
+func init() { proto.RegisterFile("pushrequest.proto", fileDescriptor_pushrequest_a494ec06a1b788fc) }
+
+var fileDescriptor_pushrequest_a494ec06a1b788fc = []byte{
+	// 1138 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x56, 0x4d, 0x93, 0xdb, 0x44,
+	0x10, 0x2d, 0x59, 0xfe, 0x90, 0x5b, 0x96, 0x3f, 0xb4, 0x59, 0x32, 0x2c, 0x45, 0xe1, 0x12, 0x55,
+	0xc4, 0x21, 0xb0, 0x84, 0x3d, 0x40, 0x2a, 0x37, 0x92, 0x98, 0x4a, 0x42, 0xd8, 0x6c, 0xec, 0xdd,
+	0xe2, 0x00, 0x17, 0xad, 0xd5, 0x5a, 0x8b, 0x68, 0x35, 0xca, 0xcc, 0x38, 0xcb, 0x72, 0xe2, 0xc7,
+	0x70, 0xe0, 0xca, 0xdf, 0xe0, 0x57, 0x51, 0xd3, 0x63, 0xc9, 0x92, 0xd7, 0x24, 0x9c, 0xec, 0x19,
+	0xbf, 0xee, 0x79, 0xdd, 0xf3, 0xfa, 0x8d, 0x61, 0x94, 0xaf, 0xe4, 0x52, 0xe0, 0x9b, 0x15, 0x4a,
+	0x75, 0x98, 0x0b, 0xae, 0xb8, 0xef, 0xd0, 0xc7, 0xf9, 0x2a, 0x0e, 0x7e, 0x06, 0xef, 0x2c, 0x8f,
+	0x42, 0x85, 0x33, 0x03, 0xf0, 0x5d, 0xb0, 0x05, 0xc6, 0xcc, 0x1a, 0x37, 0x26, 0x5d, 0xbf, 0x07,
+	0xcd, 0x58, 0xf0, 0x4b, 0xd6, 0xa0, 0x15, 0x40, 0x43, 0x71, 0x66, 0xd3, 0xf7, 0x3e, 0xb4, 0x73,
+	0xc4, 0x14, 0x23, 0xd6, 0x1c, 0x5b, 0x93, 0xae, 0xbf, 0x07, 0x6e, 0x1c, 0x4a, 0x15, 0x73, 0x71,
+	0x15, 0x8a, 0x88, 0xb5, 0xc6, 0xd6, 0xc4, 0x09, 0xfe, 0xb1, 0xc0, 0x3d, 0x59, 0xc9, 0x65, 0x91,
+	0x7b, 0x08, 0x8e, 0xe6, 0x92, 0xf1, 0x08, 0xe9, 0x80, 0x66, 0xb1, 0xa3, 0x92, 0x4b, 0xa4, 0x43,
+	0x6c, 0x4a, 0xbc, 0x92, 0xcb, 0x24, 0xa2, 0x83, 0x6c, 0x8d, 0x10, 0x98, 0xf3, 0x2c, 0xbc, 0x44,
+	0xd6, 0xa4, 0xa3, 0xef, 0xea, 0x1d, 0x4a, 0x28, 0x59, 0x6b, 0x6c, 0x4f, 0xdc, 0xa3, 0xdb, 0x87,
+	0x45, 0x3d, 0x87, 0xf5, 0x62, 0xd6, 0xc9, 0x50, 0xb0, 0x36, 0xb1, 0xdc, 0x07, 0xcf, 0xac, 0xc3,
+	0x28, 0x12, 0x28, 0x25, 0xeb, 0xd0, 0xf6, 0x00, 0x3a, 0x02, 0xa5, 0xe2, 0x02, 0x99, 0xa3, 0x89,
+	0xfb, 0x3e, 0x80, 0xc0, 0x3c, 0x4d, 0x16, 0xa1, 0xc2, 0x88, 0x75, 0xa9, 0x98, 0x5f, 0xa0, 0x7f,
+	0x8c, 0x57, 0x33, 0xcc, 0x79, 0xa5, 0x9c, 0x92, 0x9a, 0x55, 0x76, 0x65, 0x75, 0x9e, 0x26, 0x0b,
+	0x2a, 0xc6, 0xd1, 0xe7, 0x45, 0x18, 0x87, 0xab, 0x54, 0x9d, 0x8b, 0x30, 0x5b, 0x2c, 0x99, 0x4d,
+	0xe7, 0x8d, 0xa0, 0xbb, 0x4e, 0xcf, 0x63, 0xd3, 0xbf, 0xe0, 0x21, 0x0c, 0xa6, 0x51, 0xa2, 0xde,
+	0x9d, 0x7e, 0x1f, 0xbc, 0x15, 0xd5, 0xb7, 0xae, 0x9f, 0x4e, 0xe9, 0x05, 0x4f, 0x61, 0xf4, 0x04,
+	0x53, 0x54, 0xf8, 0xee, 0x68, 0x1f, 0x20, 0x22, 0xd8, 0xba, 0xdb, 0xd6, 0xc4, 0xf6, 0x3d, 0x68,
+	0xe5, 0x2b, 0x71, 0x81, 0x44, 0xcc, 0x09, 0xee, 0xc0, 0xde, 0x59, 0x16, 0xbd, 0x3f, 0x57, 0xf0,
+	0x1c, 0x46, 0x33, 0xd4, 0xeb, 0x77, 0x1f, 0x39, 0x80, 0x4e, 0x86, 0x57, 0xb4, 0xd1, 0x28, 0x38,
+	0x08, 0x8a, 0x23, 0x0e, 0x74, 0xc3, 0xc1, 0x2b, 0x18, 0xbe, 0xf8, 0x7e, 0xfe, 0xf2, 0xfc, 0x57,
+	0x5c, 0xa8, 0xff, 0x4e, 0xe5, 0x82, 0xcd, 0x93, 0x68, 0x9d, 0x66, 0x08, 0x0e, 0x27, 0xfc, 0x5a,
+	0x26, 0xa4, 0x54, 0x99, 0xfc, 0x6e, 0x24, 0x62, 0x07, 0x7f, 0x36, 0xc1, 0x7b, 0xbc, 0x0c, 0xb3,
+	0x8b, 0x52, 0x09, 0x0f, 0xa0, 0xb5, 0x50, 0xd7, 0xb9, 0xc9, 0xd6, 0x3f, 0xba, 0xbb, 0x51, 0x4c,
+	0x0d, 0x57, 0x5f, 0x9d, 0x5e, 0xe7, 0xe8, 0x7f, 0x01, 0x90, 0xe1, 0x95, 0x66, 0x23, 0xf0, 0x0d,
+	0xb5, 0xcd, 0x3d, 0x62, 0x9b, 0xf0, 0x2d, 0x4d, 0x1c, 0x82, 0x8b, 0x51, 0xa2, 0x0a, 0xb8, 0x4d,
+	0xf0, 0x0f, 0x37, 0xf0, 0xed, 0x4b, 0x3e, 0x02, 0xcf, 0xf4, 0xbb, 0x88, 0x68, 0x52, 0xc4, 0x47,
+	0x9b, 0x88, 0x9b, 0x57, 0xfb, 0x19, 0x74, 0xd6, 0x23, 0x4d, 0x73, 0xe6, 0x1e, 0xed, 0x6f, 0xd0,
+	0xd5, 0x71, 0xbb, 0x0f, 0xbd, 0x34, 0x96, 0xa6, 0x51, 0x1a, 0xdc, 0x26, 0xf0, 0xc1, 0x06, 0x7c,
+	0xa3, 0xed, 0xdf, 0xc0, 0x60, 0x95, 0xd5, 0xf9, 0x74, 0x28, 0xe8, 0xe3, 0xca, 0x84, 0xed, 0x10,
+	0xc8, 0x11, 0x78, 0xe6, 0x5a, 0x8b, 0x28, 0x67, 0xbb, 0x8a, 0x1b, 0x6a, 0x09, 0xfe, 0xb0, 0x60,
+	0x74, 0xb3, 0xdb, 0x2e, 0x74, 0x8e, 0xa7, 0x3f, 0xcd, 0xa6, 0x27, 0x2f, 0x87, 0x96, 0xdf, 0x03,
+	0x67, 0xfa, 0xe4, 0xd9, 0x29, 0xad, 0x1a, 0x7e, 0x1f, 0xe0, 0xc9, 0xf4, 0xc5, 0xf4, 0x74, 0x4a,
+	0x6b, 0xdb, 0x1f, 0x80, 0x7b, 0x72, 0x36, 0x7f, 0x3a, 0x9b, 0xbe, 0x3a, 0x9b, 0xce, 0x4f, 0x87,
+	0x4d, 0xdf, 0x83, 0xae, 0xae, 0xe8, 0xd1, 0xf3, 0xe9, 0xe3, 0xd3, 0x61, 0xcb, 0x1f, 0x42, 0xef,
+	0xec, 0xb8, 0x12, 0xd1, 0xd6, 0x19, 0x66, 0xd3, 0xe3, 0xef, 0x7e, 0x34, 0xeb, 0x4e, 0xf0, 0x0c,
+	0xfa, 0x9a, 0xd1, 0x53, 0xce, 0x5f, 0xcb, 0xb9, 0x0a, 0x15, 0x6a, 0x7d, 0xe6, 0x02, 0x05, 0x2e,
+	0x30, 0x79, 0xab, 0xb5, 0x62, 0x99, 0xa1, 0x36, 0x53, 0xc7, 0x1a, 0x85, 0xd5, 0xe5, 0x5c, 0xaa,
+	0x02, 0x44, 0x23, 0x1d, 0xdc, 0x87, 0x7e, 0xd9, 0x4d, 0x93, 0xaa, 0xaa, 0x51, 0xab, 0xa6, 0x51,
+	0x32, 0xba, 0x40, 0xc0, 0xfe, 0x23, 0x32, 0x85, 0x13, 0xc1, 0x95, 0x86, 0xf1, 0xcc, 0x04, 0x0e,
+	0xa0, 0x93, 0x87, 0x4a, 0xa1, 0xc8, 0xd6, 0x71, 0x07, 0xe0, 0x47, 0x98, 0x5d, 0x67, 0x3c, 0xab,
+	0x5a, 0x6c, 0x83, 0x9c, 0xea, 0x16, 0xf4, 0xf4, 0x6f, 0x74, 0x25, 0x09, 0xcf, 0xcc, 0x1c, 0xfb,
+	0x1f, 0x40, 0x3f, 0x4c, 0x53, 0x7e, 0x85, 0x91, 0xb1, 0x3b, 0xc9, 0x9a, 0x63, 0x7b, 0xd2, 0x0d,
+	0xfe, 0xb6, 0x60, 0x38, 0xc3, 0x38, 0xe5, 0x17, 0xd3, 0x4c, 0x89, 0x6b, 0x73, 0x5e, 0xcd, 0xf1,
+	0x35, 0xeb, 0x34, 0x7a, 0x1b, 0xa6, 0x2b, 0xdc, 0xcc, 0x5a, 0x86, 0x57, 0x66, 0xa7, 0xf4, 0xfe,
+	0x98, 0x8b, 0xc5, 0xda, 0xfb, 0x9d, 0x8a, 0xcb, 0xb6, 0x76, 0xbb, 0xac, 0x31, 0xdf, 0xaa, 0xfb,
+	0x6b, 0x55, 0xd5, 0xdd, 0xdf, 0x21, 0x3f, 0xd2, 0xce, 0x18, 0xc6, 0x2a, 0xc9, 0x22, 0xfc, 0x8d,
+	0x7c, 0xb7, 0x19, 0xfc, 0xd5, 0x86, 0xae, 0xbe, 0xa5, 0xb2, 0xab, 0x5b, 0xc6, 0xf0, 0x35, 0x74,
+	0xe4, 0xf5, 0xa5, 0xc0, 0x58, 0xb2, 0x06, 0xbd, 0x06, 0xe3, 0xaa, 0xea, 0xd6, 0x71, 0x87, 0x73,
+	0x03, 0xa1, 0xb2, 0xfd, 0x7b, 0xd0, 0x24, 0xbc, 0x3d, 0xb6, 0xeb, 0xda, 0xde, 0xe0, 0x67, 0x25,
+	0xf8, 0xab, 0xca, 0x4b, 0xa7, 0xe1, 0x9f, 0xec, 0x82, 0x9f, 0x10, 0xc2, 0x04, 0xdc, 0x81, 0xd6,
+	0x52, 0x2b, 0x8a, 0xb5, 0xb6, 0xbd, 0x62, 0x4b, 0x6c, 0xb7, 0xa0, 0x97, 0x86, 0x52, 0x95, 0x4d,
+	0x69, 0x53, 0x53, 0x36, 0x6f, 0x48, 0x87, 0xba, 0x3b, 0x04, 0x67, 0x99, 0x44, 0x48, 0x84, 0x1d,
+	0x7d, 0x8b, 0xfe, 0xb7, 0x00, 0xe5, 0x5c, 0x4b, 0xd6, 0x25, 0x56, 0x9f, 0xee, 0x62, 0xf5, 0xa2,
+	0x44, 0x19, 0x66, 0x43, 0x70, 0xd2, 0x58, 0xbe, 0x59, 0x71, 0x15, 0x32, 0xa0, 0x7e, 0x3f, 0x84,
+	0x91, 0x79, 0x99, 0xf2, 0x52, 0x84, 0x92, 0xb9, 0xdb, 0x75, 0xee, 0xd6, 0xe9, 0x3e, 0x78, 0x0b,
+	0x81, 0xa1, 0x0a, 0xcf, 0x53, 0xc3, 0xae, 0x47, 0xec, 0x3e, 0x87, 0xb6, 0x20, 0x89, 0x31, 0x6f,
+	0x6c, 0xd7, 0xfd, 0xe6, 0x86, 0xf4, 0x46, 0xd0, 0x35, 0x66, 0x12, 0x85, 0x8a, 0xf5, 0x4b, 0x05,
+	0x90, 0x57, 0x44, 0x8a, 0xb3, 0xc1, 0xe6, 0xb9, 0xa4, 0xad, 0x50, 0xb1, 0x21, 0xa1, 0xe8, 0xc5,
+	0xbe, 0x20, 0xb6, 0xa3, 0xb1, 0x5d, 0x60, 0x8a, 0x27, 0xd5, 0xa7, 0xb0, 0xdb, 0x30, 0x58, 0xf0,
+	0x4c, 0x26, 0x52, 0x61, 0xa6, 0x04, 0x86, 0x91, 0x64, 0x7b, 0xba, 0xa3, 0x07, 0x87, 0xd0, 0xab,
+	0xc9, 0xc1, 0x05, 0xfb, 0x35, 0x5e, 0xaf, 0xa7, 0xdd, 0x83, 0x56, 0xa1, 0x7e, 0x6b, 0xd2, 0x7d,
+	0xd8, 0x78, 0x60, 0x1d, 0xdc, 0xd3, 0x02, 0xfc, 0xbf, 0xe0, 0x2f, 0xc1, 0xad, 0x8a, 0xe1, 0x7d,
+	0xf0, 0x1f, 0x60, 0xb0, 0x7d, 0x4b, 0xb5, 0x90, 0x3b, 0xd5, 0x90, 0x9a, 0x98, 0xea, 0x76, 0xa3,
+	0x93, 0x05, 0x73, 0xf0, 0x68, 0x31, 0xcf, 0xc2, 0x5c, 0x2e, 0xb9, 0xd2, 0x6d, 0x7a, 0x8b, 0x42,
+	0x6a, 0x63, 0xd0, 0xc3, 0xe2, 0x69, 0x06, 0x66, 0xb6, 0x1a, 0xf4, 0xf7, 0x2b, 0x80, 0x96, 0x9e,
+	0xa6, 0x62, 0x12, 0xf6, 0x76, 0x88, 0xe8, 0xdf, 0x01, 0x00, 0x5a, 0x66, 0xce, 0x75, 0x3f, 0x0a,
+	0x00, 0x00,
 }
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+var errUnknownArchiveFormat = errors.New("Unknown archive format")
+
+// archiveFormats maps the supported formats to their content types
+var archiveFormats = map[string]string{
+	"tar":    "application/x-tar",
+	"tar.gz": "application/gzip",
+	"tgz":    "application/gzip",
+	"zip":    "application/zip",
+}
+
+type archiveWriter interface {
+	addDirectory(name string) error
+	addFile(name string, executable bool, size uint, r io.Reader) error
+	addSymlink(name string, target string) error
+	Close() error
+}
+
+type tarArchiveWriter struct {
+	gz    *gzip.Writer
+	tw    *tar.Writer
+	mtime time.Time
+}
+
+func (a *tarArchiveWriter) addDirectory(name string) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0775,
+		ModTime:  a.mtime,
+	})
+}
+
+func (a *tarArchiveWriter) addFile(name string, executable bool, size uint, r io.Reader) error {
+	var mode int64 = 0664
+	if executable {
+		mode = 0775
+	}
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(size),
+		ModTime:  a.mtime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarArchiveWriter) addSymlink(name string, target string) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  a.mtime,
+	})
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+type zipArchiveWriter struct {
+	zw    *zip.Writer
+	mtime time.Time
+}
+
+func (a *zipArchiveWriter) create(name string, mode os.FileMode, method uint16) (io.Writer, error) {
+	hdr := &zip.FileHeader{
+		Name:   name,
+		Method: method,
+	}
+	hdr.SetModTime(a.mtime)
+	hdr.SetMode(mode)
+	return a.zw.CreateHeader(hdr)
+}
+
+func (a *zipArchiveWriter) addDirectory(name string) error {
+	_, err := a.create(name+"/", os.ModeDir|0775, zip.Store)
+	return err
+}
+
+func (a *zipArchiveWriter) addFile(name string, executable bool, size uint, r io.Reader) error {
+	var mode os.FileMode = 0664
+	if executable {
+		mode = 0775
+	}
+	w, err := a.create(name, mode, zip.Deflate)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipArchiveWriter) addSymlink(name string, target string) error {
+	w, err := a.create(name, os.ModeSymlink|0777, zip.Store)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(target))
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+func newArchiveWriter(w io.Writer, format string, mtime time.Time) (archiveWriter, error) {
+	switch format {
+	case "tar":
+		return &tarArchiveWriter{tw: tar.NewWriter(w), mtime: mtime}, nil
+	case "tar.gz", "tgz":
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{gz: gz, tw: tar.NewWriter(gz), mtime: mtime}, nil
+	case "zip":
+		return &zipArchiveWriter{zw: zip.NewWriter(w), mtime: mtime}, nil
+	default:
+		return nil, errUnknownArchiveFormat
+	}
+}
+
+// writeArchive writes an archive of the tree of commitid to w.
+// If paths are provided, only those paths (files or directories) of the tree are included.
+// prefix is a directory that all files are put under in the archive.
+func writeArchive(w io.Writer, p storage.ProjectStorageDriver, commitid storage.ObjectID, format string, paths []string, prefix string) error {
+	_, commit, err := peelToCommit(p, commitid)
+	if err != nil {
+		return err
+	}
+	mtime := time.Now()
+	if committer, err := parseSignature(commit.committer); err == nil {
+		mtime = committer.when
+	}
+
+	// Look up all paths before starting to write, so we can error out cleanly
+	var entries []treeEntry
+	var names []string
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+	for _, entrypath := range paths {
+		entrypath = strings.Trim(entrypath, "/")
+		entry, err := findTreeEntry(p, commit.tree, entrypath)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		names = append(names, path.Join(prefix, entrypath))
+	}
+
+	archiver, err := newArchiveWriter(w, format, mtime)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if err := writeArchiveEntry(archiver, p, entry, names[i]); err != nil {
+			return err
+		}
+	}
+	return archiver.Close()
+}
+
+func writeArchiveEntry(archiver archiveWriter, p storage.ProjectStorageDriver, entry treeEntry, name string) error {
+	if entry.isSubmodule() {
+		// We don't have the submodule contents, just like git archive
+		return archiver.addDirectory(name)
+	}
+	if entry.isTree() {
+		if name != "" {
+			if err := archiver.addDirectory(name); err != nil {
+				return err
+			}
+		}
+		tree, err := readTreeObject(p, entry.objectid)
+		if err != nil {
+			return err
+		}
+		for _, subentry := range tree.entries {
+			subname := subentry.name
+			if name != "" {
+				subname = name + "/" + subentry.name
+			}
+			if err := writeArchiveEntry(archiver, p, subentry, subname); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	objtype, objsize, r, err := p.ReadObject(entry.objectid)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if objtype != storage.ObjectTypeBlob {
+		return errors.New("Non-blob object found as file")
+	}
+	if entry.isSymlink() {
+		target, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return archiver.addSymlink(name, string(target))
+	}
+	return archiver.addFile(name, entry.isExecutable(), objsize, r)
+}
@@ -0,0 +1,481 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// backupFormatVersion is the version of the backup format written by this node
+const backupFormatVersion = 1
+
+// A backup is a tar file, with the state snapshot, the objects as uncompressed git loose
+// objects, and finally the manifest. Backups without manifest are incomplete.
+const (
+	backupStateName  = "state.snap"
+	backupObjectsDir = "objects/"
+)
+
+// getBackupState snapshots the state, and returns the snapshot with the index it was taken at
+func (store *stateStore) getBackupState() (uint64, []byte, map[string]datastructures.RepoInfo, error) {
+	if res := store.raftnode.forceSnapshot(); res.err != nil {
+		return 0, nil, nil, errors.Wrap(res.err, "Error creating snapshot")
+	}
+	snapshot, err := store.snapshotter.Load()
+	if err == snap.ErrNoSnapshot {
+		return 0, nil, nil, errors.New("No state to back up")
+	} else if err != nil {
+		return 0, nil, nil, errors.Wrap(err, "Error loading snapshot")
+	}
+
+	repoinfos, err := decodeStateSnapshot(snapshot.Data)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	// The last snapshot might be in an older format
+	data, err := encodeStateSnapshot(snapshot.Metadata.Index, repoinfos)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return snapshot.Metadata.Index, data, repoinfos, nil
+}
+
+// getBackupWants returns the objects referenced by the state, by storage project
+func getBackupWants(repoinfos map[string]datastructures.RepoInfo) map[string][]string {
+	wantsets := make(map[string]map[string]bool)
+	add := func(project, objid string) {
+		if objid == "" || objid == string(storage.ZeroID) {
+			return
+		}
+		if _, exists := wantsets[project]; !exists {
+			wantsets[project] = make(map[string]bool)
+		}
+		wantsets[project][objid] = true
+	}
+
+	for reponame, info := range repoinfos {
+		for _, objid := range info.Refs {
+			add(reponame, objid)
+		}
+		add(constants.HooksRepoName, info.Hooks.PreReceive)
+		add(constants.HooksRepoName, info.Hooks.Update)
+		add(constants.HooksRepoName, info.Hooks.PostReceive)
+		for _, object := range info.LFSObjects {
+			add(getLFSProjectName(reponame), object.ObjectID)
+		}
+	}
+
+	wants := make(map[string][]string)
+	for project, wantset := range wantsets {
+		for objid := range wantset {
+			wants[project] = append(wants[project], objid)
+		}
+		sort.Strings(wants[project])
+	}
+	return wants
+}
+
+// walkObjects calls visit for all objects reachable from wants that are not in seen yet, and
+// adds them to seen. Missing objects are an error, unless skipMissing is set.
+func walkObjects(p storage.ProjectStorageDriver, wants []string, seen map[storage.ObjectID]bool, skipMissing bool, visit func(storage.ObjectID, storage.ObjectType, uint, io.Reader) error) error {
+	var stack []storage.ObjectID
+	for _, want := range wants {
+		stack = append(stack, storage.ObjectID(want))
+	}
+
+	for len(stack) != 0 {
+		objid := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[objid] {
+			continue
+		}
+		seen[objid] = true
+
+		objtype, objsize, reader, err := p.ReadObject(objid)
+		if err == storage.ErrObjectNotFound && skipMissing {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "Error reading object %s", objid)
+		}
+
+		if objtype == storage.ObjectTypeBlob {
+			err = visit(objid, objtype, objsize, reader)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return errors.Wrapf(err, "Error reading object %s", objid)
+		}
+		children, err := getObjectChildren(objid, objtype, content)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, children...)
+		if err := visit(objid, objtype, uint(len(content)), bytes.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *Service) serveAdminBackup(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger) {
+	var backuprequest datastructures.BackupRequest
+	if cont := cfg.parseJSONRequest(w, r, &backuprequest); !cont {
+		return
+	}
+
+	index, state, repoinfos, err := cfg.statestore.getBackupState()
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Everything reachable from the base backup is in one of the backups it builds on
+	seen := make(map[string]map[storage.ObjectID]bool)
+	for project, basewants := range backuprequest.BaseWants {
+		seen[project] = make(map[storage.ObjectID]bool)
+		err := walkObjects(
+			cfg.gitstore.GetProjectStorage(project),
+			basewants,
+			seen[project],
+			true,
+			func(storage.ObjectID, storage.ObjectType, uint, io.Reader) error { return nil },
+		)
+		if err != nil {
+			cfg.respondJSONResponse(w, datastructures.CommandResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	manifest := datastructures.BackupManifest{
+		Version:     backupFormatVersion,
+		ClusterName: cfg.cluster,
+		RegionName:  cfg.region,
+		NodeName:    cfg.nodename,
+		NodeID:      cfg.nodeid,
+		Index:       index,
+		CreatedAt:   time.Now().Unix(),
+		Base:        backuprequest.Base,
+		Wants:       getBackupWants(repoinfos),
+	}
+	reqlogger.Infow("Writing backup",
+		"index", index,
+		"base", backuprequest.Base,
+	)
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(200)
+	if err := cfg.writeBackup(w, state, manifest, seen); err != nil {
+		// Leaving out the manifest tells the client the backup is incomplete
+		reqlogger.Infow("Error writing backup",
+			"error", err,
+		)
+		return
+	}
+	reqlogger.Infow("Backup written",
+		"index", index,
+	)
+}
+
+func (cfg *Service) writeBackup(w io.Writer, state []byte, manifest datastructures.BackupManifest, seen map[string]map[storage.ObjectID]bool) error {
+	tw := tar.NewWriter(w)
+	mtime := time.Unix(manifest.CreatedAt, 0)
+
+	writeFile := func(name string, size int64, r io.Reader) error {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			ModTime:  mtime,
+		})
+		if err != nil {
+			return err
+		}
+		written, err := io.Copy(tw, r)
+		if err != nil {
+			return err
+		}
+		if written != size {
+			return errors.Errorf("Wrote %d bytes of %s instead of %d", written, name, size)
+		}
+		return nil
+	}
+
+	if err := writeFile(backupStateName, int64(len(state)), bytes.NewReader(state)); err != nil {
+		return err
+	}
+
+	projects := make([]string, 0, len(manifest.Wants))
+	for project := range manifest.Wants {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	for _, project := range projects {
+		if _, exists := seen[project]; !exists {
+			seen[project] = make(map[storage.ObjectID]bool)
+		}
+		err := walkObjects(
+			cfg.gitstore.GetProjectStorage(project),
+			manifest.Wants[project],
+			seen[project],
+			false,
+			func(objid storage.ObjectID, objtype storage.ObjectType, objsize uint, r io.Reader) error {
+				hdr := fmt.Sprintf("%s %d\x00", objtype.HdrName(), objsize)
+				manifest.NumObjects++
+				return writeFile(
+					backupObjectsDir+project+"/"+string(objid),
+					int64(len(hdr))+int64(objsize),
+					io.MultiReader(strings.NewReader(hdr), r),
+				)
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	cts, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(constants.BackupManifestName, int64(len(cts)), bytes.NewReader(cts)); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// restoreBackup initializes the state and objects of a spawned node from a backup, and the
+// backups it is incremental against
+func (store *stateStore) restoreBackup(src string) error {
+	gitstore := store.cfg.gitstore
+	if clustered, isclustered := gitstore.(*clusterStorageDriverInstance); isclustered {
+		// There are no peers to sync with yet
+		gitstore = clustered.inner
+	}
+
+	manifest, state, err := restoreBackupFile(gitstore, src)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return errors.Errorf("Backup %s contains no state", src)
+	}
+	store.cfg.log.Infow("Restored backup",
+		"backup", src,
+		"index", manifest.Index,
+		"objects", manifest.NumObjects,
+	)
+
+	// Incremental backups only contain the objects that are new since their base
+	restored := map[string]bool{filepath.Base(src): true}
+	for base := manifest.Base; base != ""; {
+		if restored[base] {
+			return errors.Errorf("Backup %s is incremental against itself", base)
+		}
+		restored[base] = true
+		basemanifest, _, err := restoreBackupFile(gitstore, filepath.Join(filepath.Dir(src), base))
+		if err != nil {
+			return err
+		}
+		store.cfg.log.Infow("Restored base backup",
+			"backup", base,
+			"index", basemanifest.Index,
+			"objects", basemanifest.NumObjects,
+		)
+		base = basemanifest.Base
+	}
+
+	return store.writeRestoredState(manifest.Index, state)
+}
+
+// restoreBackupFile writes the objects of a backup to storage, and returns its manifest and state
+func restoreBackupFile(gitstore storage.StorageDriver, src string) (manifest datastructures.BackupManifest, state []byte, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return manifest, nil, errors.Wrap(err, "Error opening backup")
+	}
+	defer f.Close()
+
+	pushers := make(map[string]storage.ProjectStoragePushDriver)
+	tr := tar.NewReader(f)
+	var hasmanifest bool
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, nil, errors.Wrapf(err, "Error reading backup %s", src)
+		}
+		if hasmanifest {
+			return manifest, nil, errors.Errorf("Backup %s has entries after its manifest", src)
+		}
+
+		switch {
+		case hdr.Name == backupStateName:
+			state, err = ioutil.ReadAll(tr)
+		case hdr.Name == constants.BackupManifestName:
+			var cts []byte
+			cts, err = ioutil.ReadAll(tr)
+			if err == nil {
+				err = json.Unmarshal(cts, &manifest)
+			}
+			hasmanifest = true
+		case strings.HasPrefix(hdr.Name, backupObjectsDir):
+			project, objid := path.Split(strings.TrimPrefix(hdr.Name, backupObjectsDir))
+			project = strings.TrimSuffix(project, "/")
+			if !isValidBackupProject(project) || !isValidRef(objid) || storage.ObjectID(objid) == storage.ZeroID {
+				err = errors.Errorf("Invalid object entry %s", hdr.Name)
+				break
+			}
+			pusher, exists := pushers[project]
+			if !exists {
+				pusher = gitstore.GetProjectStorage(project).GetPusher("restore")
+				pushers[project] = pusher
+			}
+			err = restoreBackupObject(pusher, storage.ObjectID(objid), tr)
+		default:
+			err = errors.Errorf("Unexpected entry %s", hdr.Name)
+		}
+		if err != nil {
+			return manifest, nil, errors.Wrapf(err, "Error restoring backup %s", src)
+		}
+	}
+
+	for project, pusher := range pushers {
+		pusher.Done()
+		if err = <-pusher.GetPushResultChannel(); err != nil {
+			return manifest, nil, errors.Wrapf(err, "Error storing objects of %s", project)
+		}
+	}
+	if !hasmanifest {
+		return manifest, nil, errors.Errorf("Backup %s is incomplete", src)
+	}
+	if manifest.Version > backupFormatVersion {
+		return manifest, nil, errors.Errorf("Backup version %d is newer than supported version %d",
+			manifest.Version,
+			backupFormatVersion,
+		)
+	}
+	return manifest, state, nil
+}
+
+// isValidBackupProject returns whether a project name from a backup stays within the storage directory
+func isValidBackupProject(project string) bool {
+	if project == "" || path.IsAbs(project) {
+		return false
+	}
+	for _, part := range strings.Split(project, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func restoreBackupObject(pusher storage.ProjectStoragePushDriver, objid storage.ObjectID, r io.Reader) error {
+	br := bufio.NewReader(r)
+	hdr, err := br.ReadString(0)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading header of object %s", objid)
+	}
+	hdrparts := strings.SplitN(strings.TrimSuffix(hdr, "\x00"), " ", 2)
+	if len(hdrparts) != 2 {
+		return errors.Errorf("Object %s has an invalid header", objid)
+	}
+	switch hdrparts[0] {
+	case "commit", "tree", "blob", "tag":
+	default:
+		return errors.Errorf("Object %s has an invalid type", objid)
+	}
+	objtype := storage.ObjectTypeFromHdrName(hdrparts[0])
+	objsize, err := strconv.ParseUint(hdrparts[1], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "Object %s has an invalid size", objid)
+	}
+
+	staged, err := pusher.StageObject(objtype, uint(objsize))
+	if err != nil {
+		return err
+	}
+	defer staged.Close()
+	if _, err := io.Copy(staged, br); err != nil {
+		return err
+	}
+	_, err = staged.Finalize(objid)
+	return err
+}
+
+// writeRestoredState makes the state snapshot the start of the raft log of the new region,
+// with this node as its only member
+func (store *stateStore) writeRestoredState(index uint64, state []byte) error {
+	snapdir := fmt.Sprintf("%s/snap", store.directory)
+	waldir := fmt.Sprintf("%s/wal", store.directory)
+	if err := os.Mkdir(snapdir, 0750); err != nil {
+		return err
+	}
+
+	snapshot := raftpb.Snapshot{
+		Data: state,
+		Metadata: raftpb.SnapshotMetadata{
+			Index: index,
+			Term:  1,
+			ConfState: raftpb.ConfState{
+				Nodes: []uint64{store.cfg.nodeid},
+			},
+		},
+	}
+	if err := snap.New(snapdir).SaveSnap(snapshot); err != nil {
+		return errors.Wrap(err, "Error saving restored snapshot")
+	}
+
+	w, err := wal.Create(waldir, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error creating WAL")
+	}
+	defer w.Close()
+	err = w.SaveSnapshot(walpb.Snapshot{
+		Index: index,
+		Term:  1,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error saving restored snapshot to WAL")
+	}
+	return w.Save(raftpb.HardState{Term: 1, Commit: index}, nil)
+}
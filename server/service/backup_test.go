@@ -0,0 +1,110 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+func newTestStorage(t *testing.T) (storage.StorageDriver, string, func()) {
+	dir, err := ioutil.TempDir("", "repospanner_test_")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+	driver, err := storage.InitializeStorageDriver(map[string]string{
+		"type":      "tree",
+		"directory": dir,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Error initializing storage: %s", err)
+	}
+	return driver, dir, func() { os.RemoveAll(dir) }
+}
+
+func writeTestBackupFile(t *testing.T, dir string, content []byte) string {
+	name := path.Join(dir, "backup.tar")
+	if err := ioutil.WriteFile(name, content, 0644); err != nil {
+		t.Fatalf("Error writing backup: %s", err)
+	}
+	return name
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	src, _, srccleanup := newTestStorage(t)
+	defer srccleanup()
+	pusher := src.GetProjectStorage("project").GetPusher("")
+	blob := writeTestBlob(t, pusher, "file\n")
+	tree := writeTestTree(t, pusher, fileEntry("file", blob))
+	commit := writeTestCommit(t, pusher, tree, "Commit")
+
+	manifest := datastructures.BackupManifest{
+		Version: backupFormatVersion,
+		Index:   42,
+		Wants:   map[string][]string{"project": {string(commit)}},
+	}
+	var buf bytes.Buffer
+	cfg := &Service{gitstore: src}
+	if err := cfg.writeBackup(&buf, []byte("state"), manifest, make(map[string]map[storage.ObjectID]bool)); err != nil {
+		t.Fatalf("Error writing backup: %s", err)
+	}
+
+	dest, destdir, destcleanup := newTestStorage(t)
+	defer destcleanup()
+	restored, state, err := restoreBackupFile(dest, writeTestBackupFile(t, destdir, buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Error restoring backup: %s", err)
+	}
+	if string(state) != "state" {
+		t.Errorf("Restored state is %q", state)
+	}
+	if restored.Index != 42 || restored.NumObjects != 3 {
+		t.Errorf("Restored manifest has index %d and %d objects", restored.Index, restored.NumObjects)
+	}
+	for _, objid := range []storage.ObjectID{commit, tree, blob} {
+		_, _, r, err := dest.GetProjectStorage("project").ReadObject(objid)
+		if err != nil {
+			t.Fatalf("Object %s was not restored: %s", objid, err)
+		}
+		r.Close()
+	}
+}
+
+func TestRestoreBackupInvalidNames(t *testing.T) {
+	dest, destdir, destcleanup := newTestStorage(t)
+	defer destcleanup()
+
+	content := "blob 5\x00file\n"
+	objid := "f73f3093ff865c514c6c51f867e35f693487d0d3"
+	for _, name := range []string{
+		"objects/../escaped/" + objid,
+		"objects//absolute/" + objid,
+		"objects/" + objid,
+		"objects/project/../../" + objid,
+		"objects/project/short",
+		"objects/project/" + string(storage.ZeroID),
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatalf("Error writing tar header: %s", err)
+		}
+		tw.Write([]byte(content))
+		tw.Close()
+
+		_, _, err := restoreBackupFile(dest, writeTestBackupFile(t, destdir, buf.Bytes()))
+		if err == nil || !strings.Contains(err.Error(), "Invalid object entry") {
+			t.Errorf("Restoring entry %s returned %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path.Join(path.Dir(destdir), "escaped")); !os.IsNotExist(err) {
+		t.Errorf("Object was written outside of the storage directory: %v", err)
+	}
+}
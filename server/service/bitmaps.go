@@ -0,0 +1,399 @@
+package service
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+// maxBitmapsPerRepo is the number of reachability bitmaps kept per repo.
+// The bitmaps for the current refs are always kept, older ones are dropped oldest first.
+const maxBitmapsPerRepo = 128
+
+const bitmapFileMagic = "RSBM"
+const bitmapFileVersion = 1
+
+// bitmap is a set of object positions in a reachabilityIndex
+type bitmap []uint64
+
+func (b *bitmap) set(pos uint32) {
+	word := int(pos / 64)
+	for len(*b) <= word {
+		*b = append(*b, 0)
+	}
+	(*b)[word] |= 1 << (pos % 64)
+}
+
+func (b bitmap) get(pos uint32) bool {
+	word := int(pos / 64)
+	return word < len(b) && b[word]&(1<<(pos%64)) != 0
+}
+
+func (b *bitmap) or(o bitmap) {
+	for len(*b) < len(o) {
+		*b = append(*b, 0)
+	}
+	for i, word := range o {
+		(*b)[i] |= word
+	}
+}
+
+// andNot returns the positions in b that are not in o
+func (b bitmap) andNot(o bitmap) bitmap {
+	result := make(bitmap, len(b))
+	for i, word := range b {
+		if i < len(o) {
+			word &^= o[i]
+		}
+		result[i] = word
+	}
+	return result
+}
+
+// positions returns all positions in the bitmap, in increasing order
+func (b bitmap) positions() []uint32 {
+	var positions []uint32
+	for i, word := range b {
+		for bit := uint32(0); word != 0; bit++ {
+			if word&1 != 0 {
+				positions = append(positions, uint32(i)*64+bit)
+			}
+			word >>= 1
+		}
+	}
+	return positions
+}
+
+// reachabilityIndex assigns a position to every object of a repo, and keeps bitmaps of all objects
+// reachable from recent ref values, so that the objects to send for a fetch can be determined as a
+// set difference instead of walking the full object graph.
+// An index is not modified once it is in use: updates build a new index that replaces it, so that
+// fetches can use it without any locking.
+type reachabilityIndex struct {
+	objects   []storage.ObjectID
+	positions map[storage.ObjectID]uint32
+	bitmaps   map[storage.ObjectID]bitmap
+	// order contains the objects with bitmaps, oldest first
+	order []storage.ObjectID
+}
+
+func newReachabilityIndex() *reachabilityIndex {
+	return &reachabilityIndex{
+		positions: make(map[storage.ObjectID]uint32),
+		bitmaps:   make(map[storage.ObjectID]bitmap),
+	}
+}
+
+// addObject assigns the next position to objid. Only used while building an index.
+func (idx *reachabilityIndex) addObject(objid storage.ObjectID) {
+	if _, known := idx.positions[objid]; !known {
+		idx.positions[objid] = uint32(len(idx.objects))
+		idx.objects = append(idx.objects, objid)
+	}
+}
+
+// reachabilityWalk determines reachability using an index. Objects that are not in the index get
+// positions after those of the index, and new bitmaps are kept in the walk, leaving the index as is.
+type reachabilityWalk struct {
+	idx          *reachabilityIndex
+	newobjects   []storage.ObjectID
+	newpositions map[storage.ObjectID]uint32
+	newbitmaps   map[storage.ObjectID]bitmap
+}
+
+func (idx *reachabilityIndex) newWalk() *reachabilityWalk {
+	return &reachabilityWalk{
+		idx:          idx,
+		newpositions: make(map[storage.ObjectID]uint32),
+		newbitmaps:   make(map[storage.ObjectID]bitmap),
+	}
+}
+
+func (w *reachabilityWalk) lookup(objid storage.ObjectID) (uint32, bool) {
+	if pos, known := w.idx.positions[objid]; known {
+		return pos, true
+	}
+	pos, known := w.newpositions[objid]
+	return pos, known
+}
+
+func (w *reachabilityWalk) position(objid storage.ObjectID) uint32 {
+	pos, known := w.lookup(objid)
+	if !known {
+		pos = uint32(len(w.idx.objects) + len(w.newobjects))
+		w.newobjects = append(w.newobjects, objid)
+		w.newpositions[objid] = pos
+	}
+	return pos
+}
+
+func (w *reachabilityWalk) object(pos uint32) storage.ObjectID {
+	if int(pos) < len(w.idx.objects) {
+		return w.idx.objects[pos]
+	}
+	return w.newobjects[int(pos)-len(w.idx.objects)]
+}
+
+func (w *reachabilityWalk) getBitmap(objid storage.ObjectID) (bitmap, bool) {
+	if bm, hasbitmap := w.idx.bitmaps[objid]; hasbitmap {
+		return bm, true
+	}
+	bm, hasbitmap := w.newbitmaps[objid]
+	return bm, hasbitmap
+}
+
+type reachabilityEntry struct {
+	objid  storage.ObjectID
+	isblob bool
+}
+
+// reachable returns a bitmap of all objects reachable from start, using stored bitmaps where possible
+func (w *reachabilityWalk) reachable(p storage.ProjectStorageDriver, start []storage.ObjectID) (bitmap, error) {
+	var result bitmap
+	stack := make([]reachabilityEntry, 0, len(start))
+	for _, objid := range start {
+		stack = append(stack, reachabilityEntry{objid: objid})
+	}
+
+	for len(stack) != 0 {
+		entry := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if bm, hasbitmap := w.getBitmap(entry.objid); hasbitmap {
+			result.or(bm)
+			continue
+		}
+		pos := w.position(entry.objid)
+		if result.get(pos) {
+			continue
+		}
+		result.set(pos)
+		if entry.isblob {
+			// We know from the tree it's a blob, no need to read it
+			continue
+		}
+
+		objtype, _, r, err := p.ReadObject(entry.objid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading object %s", entry.objid)
+		}
+		switch objtype {
+		case storage.ObjectTypeCommit:
+			commit, err := readCommit(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, reachabilityEntry{objid: commit.tree})
+			for _, parent := range commit.parents {
+				stack = append(stack, reachabilityEntry{objid: parent})
+			}
+		case storage.ObjectTypeTag:
+			tag, err := readTag(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, reachabilityEntry{objid: tag.object})
+		case storage.ObjectTypeTree:
+			tree, err := readTree(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			for _, treeentry := range tree.entries {
+				if treeentry.isSubmodule() {
+					// Submodule commits are not part of this repo
+					continue
+				}
+				stack = append(stack, reachabilityEntry{
+					objid:  treeentry.objectid,
+					isblob: !treeentry.isTree(),
+				})
+			}
+		default:
+			r.Close()
+		}
+	}
+	return result, nil
+}
+
+// withBitmaps returns a new index with bitmaps for each of the objects in tips that doesn't have one yet
+func (idx *reachabilityIndex) withBitmaps(p storage.ProjectStorageDriver, tips []storage.ObjectID) (*reachabilityIndex, error) {
+	walk := idx.newWalk()
+	keep := make(map[storage.ObjectID]bool)
+	order := append([]storage.ObjectID{}, idx.order...)
+	for _, tip := range tips {
+		keep[tip] = true
+		if _, hasbitmap := walk.getBitmap(tip); hasbitmap {
+			continue
+		}
+		bm, err := walk.reachable(p, []storage.ObjectID{tip})
+		if err != nil {
+			return nil, err
+		}
+		walk.newbitmaps[tip] = bm
+		order = append(order, tip)
+	}
+
+	newidx := &reachabilityIndex{
+		objects:   make([]storage.ObjectID, 0, len(idx.objects)+len(walk.newobjects)),
+		positions: make(map[storage.ObjectID]uint32, len(idx.objects)+len(walk.newobjects)),
+		bitmaps:   make(map[storage.ObjectID]bitmap),
+	}
+	newidx.objects = append(newidx.objects, idx.objects...)
+	newidx.objects = append(newidx.objects, walk.newobjects...)
+	for pos, objid := range newidx.objects {
+		newidx.positions[objid] = uint32(pos)
+	}
+
+	// Drop the oldest bitmaps that are not for current tips
+	toremove := len(order) - maxBitmapsPerRepo
+	for _, objid := range order {
+		if toremove > 0 && !keep[objid] {
+			toremove--
+			continue
+		}
+		newidx.bitmaps[objid], _ = walk.getBitmap(objid)
+		newidx.order = append(newidx.order, objid)
+	}
+	return newidx, nil
+}
+
+// objectsToSend returns the objects reachable from wants but not from haves
+func (idx *reachabilityIndex) objectsToSend(p storage.ProjectStorageDriver, wants, haves []storage.ObjectID, tags map[storage.ObjectID]storage.ObjectID) ([]storage.ObjectID, error) {
+	walk := idx.newWalk()
+	wantbm, err := walk.reachable(p, wants)
+	if err != nil {
+		return nil, err
+	}
+	havebm, err := walk.reachable(p, haves)
+	if err != nil {
+		return nil, err
+	}
+	tosend := wantbm.andNot(havebm)
+
+	// Include annotated tags pointing at objects we are going to send
+	for tag, peeled := range tags {
+		peeledpos, known := walk.lookup(peeled)
+		if !known || !tosend.get(peeledpos) {
+			continue
+		}
+		tagbm, err := walk.reachable(p, []storage.ObjectID{tag})
+		if err != nil {
+			return nil, err
+		}
+		tosend.or(tagbm.andNot(havebm))
+	}
+
+	positions := tosend.positions()
+	objects := make([]storage.ObjectID, len(positions))
+	for i, pos := range positions {
+		objects[i] = walk.object(pos)
+	}
+	return objects, nil
+}
+
+// writeTo serializes the index
+func (idx *reachabilityIndex) writeTo(w io.Writer) error {
+	if _, err := w.Write([]byte(bitmapFileMagic)); err != nil {
+		return err
+	}
+	if err := writeIndexUint32(w, bitmapFileVersion); err != nil {
+		return err
+	}
+	if err := writeIndexUint32(w, uint32(len(idx.objects))); err != nil {
+		return err
+	}
+	for _, objid := range idx.objects {
+		if err := writeIndexObjectID(w, objid); err != nil {
+			return err
+		}
+	}
+	if err := writeIndexUint32(w, uint32(len(idx.order))); err != nil {
+		return err
+	}
+	for _, objid := range idx.order {
+		bm := idx.bitmaps[objid]
+		if err := writeIndexObjectID(w, objid); err != nil {
+			return err
+		}
+		if err := writeIndexUint32(w, uint32(len(bm))); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, []uint64(bm)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readReachabilityIndex(r io.Reader) (*reachabilityIndex, error) {
+	idx := newReachabilityIndex()
+	magic := make([]byte, len(bitmapFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != bitmapFileMagic {
+		return nil, errors.New("Invalid bitmap file magic")
+	}
+	var version, numobjects, numbitmaps uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != bitmapFileVersion {
+		return nil, errors.Errorf("Unsupported bitmap file version %d", version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &numobjects); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < numobjects; i++ {
+		objid, err := readIndexObjectID(r)
+		if err != nil {
+			return nil, err
+		}
+		idx.addObject(objid)
+	}
+	if err := binary.Read(r, binary.BigEndian, &numbitmaps); err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < numbitmaps; i++ {
+		objid, err := readIndexObjectID(r)
+		if err != nil {
+			return nil, err
+		}
+		var numwords uint32
+		if err := binary.Read(r, binary.BigEndian, &numwords); err != nil {
+			return nil, err
+		}
+		if numwords > (numobjects+63)/64 {
+			return nil, errors.New("Bitmap larger than number of objects")
+		}
+		bm := make(bitmap, numwords)
+		if err := binary.Read(r, binary.BigEndian, []uint64(bm)); err != nil {
+			return nil, err
+		}
+		idx.bitmaps[objid] = bm
+		idx.order = append(idx.order, objid)
+	}
+	return idx, nil
+}
+
+// writeBitmapPackFile writes a packfile with all objects reachable from wants that are not reachable from
+// haves, determined using the reachability index of the repo.
+func (cfg *Service) writeBitmapPackFile(p storage.ProjectStorageDriver, reponame string, wants, haves []storage.ObjectID, tags map[storage.ObjectID]storage.ObjectID) (*os.File, uint32, error) {
+	idx := cfg.indexer.getBitmaps(reponame)
+	if len(idx.bitmaps) == 0 {
+		// This repo has not been indexed yet, make sure the next fetch is faster
+		cfg.indexer.queueUpdate(reponame)
+	}
+	objects, err := idx.objectsToSend(p, wants, haves, tags)
+	if err != nil {
+		return nil, 0, err
+	}
+	return writeObjectsToTemporaryPackFile(p, objects)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+func writeTestCommit(t *testing.T, pusher storage.ProjectStoragePushDriver, tree storage.ObjectID, message string, parents ...storage.ObjectID) storage.ObjectID {
+	sig := signature{name: "Tester", email: "tester@example.com", when: time.Unix(1500000000, 0).UTC()}
+	objid, err := writeCommitObject(pusher, tree, parents, sig, sig, message)
+	if err != nil {
+		t.Fatalf("Error writing commit: %s", err)
+	}
+	return objid
+}
+
+func writeTestTag(t *testing.T, pusher storage.ProjectStoragePushDriver, target storage.ObjectID, name string) storage.ObjectID {
+	content := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger Tester <tester@example.com> 1500000000 +0000\n\nTag\n", target, name)
+	objid, err := writeObject(pusher, storage.ObjectTypeTag, []byte(content))
+	if err != nil {
+		t.Fatalf("Error writing tag: %s", err)
+	}
+	return objid
+}
+
+func checkObjectsToSend(t *testing.T, idx *reachabilityIndex, p storage.ProjectStorageDriver, wants, haves []storage.ObjectID, tags map[storage.ObjectID]storage.ObjectID, expected ...storage.ObjectID) {
+	objects, err := idx.objectsToSend(p, wants, haves, tags)
+	if err != nil {
+		t.Fatalf("Error determining objects to send: %s", err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i] < objects[j] })
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	if fmt.Sprint(objects) != fmt.Sprint(expected) {
+		t.Errorf("Objects to send are %v, expected %v", objects, expected)
+	}
+}
+
+func TestObjectsToSend(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	blob1 := writeTestBlob(t, pusher, "one\n")
+	blob2 := writeTestBlob(t, pusher, "two\n")
+	tree1 := writeTestTree(t, pusher, fileEntry("one", blob1))
+	tree2 := writeTestTree(t, pusher, fileEntry("one", blob1), fileEntry("two", blob2))
+	commit1 := writeTestCommit(t, pusher, tree1, "First")
+	commit2 := writeTestCommit(t, pusher, tree2, "Second", commit1)
+	tag := writeTestTag(t, pusher, commit2, "v2")
+	tags := map[storage.ObjectID]storage.ObjectID{tag: commit2}
+
+	idx := newReachabilityIndex()
+	checkObjectsToSend(t, idx, p, []storage.ObjectID{commit2}, nil, nil,
+		commit1, commit2, tree1, tree2, blob1, blob2)
+	checkObjectsToSend(t, idx, p, []storage.ObjectID{commit2}, []storage.ObjectID{commit1}, tags,
+		commit2, tree2, blob2, tag)
+	checkObjectsToSend(t, idx, p, []storage.ObjectID{commit1}, nil, tags,
+		commit1, tree1, blob1)
+	if len(idx.objects) != 0 || len(idx.bitmaps) != 0 {
+		t.Fatal("Determining objects to send changed the index")
+	}
+
+	withbitmaps, err := idx.withBitmaps(p, []storage.ObjectID{commit1})
+	if err != nil {
+		t.Fatalf("Error adding bitmaps: %s", err)
+	}
+	if len(idx.objects) != 0 || len(idx.bitmaps) != 0 {
+		t.Fatal("Adding bitmaps changed the old index")
+	}
+	if len(withbitmaps.bitmaps) != 1 || len(withbitmaps.objects) != 3 {
+		t.Fatalf("Index has %d bitmaps and %d objects", len(withbitmaps.bitmaps), len(withbitmaps.objects))
+	}
+	checkObjectsToSend(t, withbitmaps, p, []storage.ObjectID{commit2}, []storage.ObjectID{commit1}, tags,
+		commit2, tree2, blob2, tag)
+
+	var buf bytes.Buffer
+	if err := withbitmaps.writeTo(&buf); err != nil {
+		t.Fatalf("Error writing index: %s", err)
+	}
+	loaded, err := readReachabilityIndex(&buf)
+	if err != nil {
+		t.Fatalf("Error reading index: %s", err)
+	}
+	if fmt.Sprint(loaded.objects) != fmt.Sprint(withbitmaps.objects) || fmt.Sprint(loaded.bitmaps) != fmt.Sprint(withbitmaps.bitmaps) {
+		t.Error("Index changed when writing and reading it")
+	}
+	checkObjectsToSend(t, loaded, p, []storage.ObjectID{commit2}, nil, nil,
+		commit1, commit2, tree1, tree2, blob1, blob2)
+}
+
+func TestWithBitmapsDropsOldest(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	tree := writeTestTree(t, pusher, fileEntry("file", writeTestBlob(t, pusher, "file\n")))
+	idx := newReachabilityIndex()
+	var commits []storage.ObjectID
+	for i := 0; i < maxBitmapsPerRepo+2; i++ {
+		commit := writeTestCommit(t, pusher, tree, fmt.Sprintf("Commit %d", i))
+		commits = append(commits, commit)
+		var err error
+		// Keep the first commit as a current tip all the time
+		idx, err = idx.withBitmaps(p, []storage.ObjectID{commits[0], commit})
+		if err != nil {
+			t.Fatalf("Error adding bitmaps: %s", err)
+		}
+	}
+	if len(idx.bitmaps) != maxBitmapsPerRepo || len(idx.order) != maxBitmapsPerRepo {
+		t.Fatalf("Index has %d bitmaps, expected %d", len(idx.bitmaps), maxBitmapsPerRepo)
+	}
+	for i, commit := range commits {
+		_, hasbitmap := idx.bitmaps[commit]
+		if expected := i == 0 || i > 2; hasbitmap != expected {
+			t.Errorf("Commit %d has bitmap: %t, expected %t", i, hasbitmap, expected)
+		}
+	}
+}
+
+func TestRepoIndexerEviction(t *testing.T) {
+	i := (&Service{}).createRepoIndexer()
+	for n := 0; n < maxCachedRepoIndexes+10; n++ {
+		reponame := fmt.Sprintf("repo%d", n)
+		i.mux.Lock()
+		i.markUsed(reponame)
+		i.graphs[reponame] = newCommitGraph()
+		i.bitmaps[reponame] = newReachabilityIndex()
+		i.mux.Unlock()
+
+		if n == 5 {
+			continue
+		}
+		// Keep using repo5
+		i.mux.Lock()
+		i.markUsed("repo5")
+		i.mux.Unlock()
+	}
+	if len(i.graphs) != maxCachedRepoIndexes || len(i.bitmaps) != maxCachedRepoIndexes {
+		t.Fatalf("%d graphs and %d bitmaps loaded, expected %d", len(i.graphs), len(i.bitmaps), maxCachedRepoIndexes)
+	}
+	for _, reponame := range []string{"repo0", "repo10"} {
+		if _, loaded := i.graphs[reponame]; loaded {
+			t.Errorf("Least recently used %s was not dropped", reponame)
+		}
+	}
+	for _, reponame := range []string{"repo5", "repo11", fmt.Sprintf("repo%d", maxCachedRepoIndexes+9)} {
+		if _, loaded := i.bitmaps[reponame]; !loaded {
+			t.Errorf("Recently used %s was dropped", reponame)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// parseBranchProtection parses a branch protection rule, as passed in repo update requests
+func parseBranchProtection(val string) (rule datastructures.BranchProtection, err error) {
+	if err := json.Unmarshal([]byte(val), &rule); err != nil {
+		return rule, errors.Wrap(err, "Invalid branch protection rule")
+	}
+	if rule.Pattern == "" {
+		return rule, errors.New("Branch protection rule without pattern")
+	}
+	rule.Pattern = getBranchRefName(rule.Pattern)
+	return rule, nil
+}
+
+// validateBranchProtectionUpdates verifies the branch protection fields of an update request,
+// so that they can be applied unconditionally once they are committed
+func validateBranchProtectionUpdates(request datastructures.RepoUpdateRequest) error {
+	if val, hasrule := request.UpdateRequest[datastructures.RepoUpdateProtectBranch]; hasrule {
+		if _, err := parseBranchProtection(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBranchProtection adds a rule, replacing the rule with the same pattern if there is one
+func setBranchProtection(rules []datastructures.BranchProtection, rule datastructures.BranchProtection) []datastructures.BranchProtection {
+	newrules := removeBranchProtection(rules, rule.Pattern)
+	return append(newrules, rule)
+}
+
+// removeBranchProtection removes the rule with pattern
+func removeBranchProtection(rules []datastructures.BranchProtection, pattern string) []datastructures.BranchProtection {
+	pattern = getBranchRefName(pattern)
+	var newrules []datastructures.BranchProtection
+	for _, rule := range rules {
+		if rule.Pattern != pattern {
+			newrules = append(newrules, rule)
+		}
+	}
+	return newrules
+}
+
+// refPatternMatches returns whether refname matches a ref pattern, in which "*" (or "**") matches
+// any sequence of characters, including slashes. All other characters only match themselves.
+func refPatternMatches(pattern, refname string) bool {
+	p, n := 0, 0
+	// Where to continue if the part after the last star doesn't match
+	starp, starn := -1, 0
+	for n < len(refname) {
+		if p < len(pattern) && pattern[p] == '*' {
+			starp, starn = p, n
+			p++
+		} else if p < len(pattern) && pattern[p] == refname[n] {
+			p++
+			n++
+		} else if starp != -1 {
+			// Let the last star match one more character
+			starn++
+			p, n = starp+1, starn
+		} else {
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func isAllowedPusher(rule datastructures.BranchProtection, pusher string) bool {
+	if len(rule.AllowedPushers) == 0 {
+		return true
+	}
+	for _, allowed := range rule.AllowedPushers {
+		if allowed == pusher {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRefPolicies checks an update of a ref in req against the branch protection rules of the repo.
+// It returns the branch result and an error if the update is denied.
+// Fast-forwards are only checked if checkfastforward is set, which requires addFastForwardInfo to
+// have been called: updates without fast-forward information are treated as non-fast-forward.
+func checkRefPolicies(info datastructures.RepoInfo, req *pb.PushRequest, request *pb.UpdateRequest, checkfastforward bool) (string, error) {
+	refname := request.GetRef()
+	pusher := req.GetPusher()
+
+	if req.GetRestore() || req.GetReplicated() {
+		// Restores undo earlier updates, which might not have been possible under the current rules,
+		// and replicated pushes were already checked in their own region
+		return "OK", nil
+	}
+
+	if request.FromObject() == storage.ZeroID && len(info.CreatableRefs) != 0 {
+		creatable := false
+		for _, pattern := range info.CreatableRefs {
+			if refPatternMatches(pattern, refname) {
+				creatable = true
+				break
+			}
+		}
+		if !creatable {
+			return "creation-denied", fmt.Errorf("Ref %s can not be created", refname)
+		}
+	}
+
+	for _, rule := range info.BranchProtections {
+		if !refPatternMatches(rule.Pattern, refname) {
+			continue
+		}
+		if !isAllowedPusher(rule, pusher) {
+			return "pusher-denied", fmt.Errorf("Ref %s is protected, %s can not push to it", refname, pusher)
+		}
+		if rule.DenyDeletion && request.ToObject() == storage.ZeroID {
+			return "deletion-denied", fmt.Errorf("Ref %s is protected against deletion", refname)
+		}
+		isupdate := request.FromObject() != storage.ZeroID && request.ToObject() != storage.ZeroID
+		if rule.DenyNonFastForward && checkfastforward && isupdate && !request.GetFastforward() {
+			return "non-fast-forward", fmt.Errorf("Ref %s is protected against non-fast-forward updates", refname)
+		}
+	}
+
+	return "OK", nil
+}
+
+// addFastForwardInfo records for every updated ref whether the update is a fast-forward, so that
+// every node can check the branch protection rules without needing the objects.
+func addFastForwardInfo(p storage.ProjectStorageDriver, graph *commitGraph, toupdate *pb.PushRequest) error {
+	for _, updinfo := range toupdate.Requests {
+		from, to := updinfo.FromObject(), updinfo.ToObject()
+		if from == storage.ZeroID || to == storage.ZeroID {
+			// Creations and deletions are not fast-forwards or the opposite
+			continue
+		}
+		fromtype, err := peelTags(p, &from)
+		if err != nil {
+			return err
+		}
+		totype, err := peelTags(p, &to)
+		if err != nil {
+			return err
+		}
+		fastforward := false
+		if fromtype == storage.ObjectTypeCommit && totype == storage.ObjectTypeCommit {
+			fastforward, err = graph.IsAncestor(p, from, to)
+			if err != nil {
+				return err
+			}
+		}
+		updinfo.Fastforward = &fastforward
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+)
+
+func TestRefPatternMatches(t *testing.T) {
+	cases := []struct {
+		pattern, refname string
+		expected         bool
+	}{
+		{"refs/heads/main", "refs/heads/main", true},
+		{"refs/heads/main", "refs/heads/main2", false},
+		{"refs/heads/*", "refs/heads/main", true},
+		{"refs/heads/*", "refs/heads/feature/a/b", true},
+		{"refs/heads/*", "refs/tags/v1", false},
+		{"refs/heads/**", "refs/heads/feature/a", true},
+		{"refs/*/release", "refs/heads/stable/release", true},
+		{"refs/*/release", "refs/heads/release/1", false},
+		{"refs/heads/*-rc*", "refs/heads/1.0-rc/2", true},
+		{"*", "refs/heads/main", true},
+		{"refs/heads/*", "refs/heads/", true},
+	}
+	for _, c := range cases {
+		if matches := refPatternMatches(c.pattern, c.refname); matches != c.expected {
+			t.Errorf("%s matches %s: %t, expected %t", c.pattern, c.refname, matches, c.expected)
+		}
+	}
+}
+
+func TestCheckRefPolicies(t *testing.T) {
+	const (
+		oldcommit = "1111111111111111111111111111111111111111"
+		newcommit = "2222222222222222222222222222222222222222"
+		zero      = "0000000000000000000000000000000000000000"
+	)
+	info := datastructures.RepoInfo{
+		CreatableRefs: []string{"refs/heads/*", "refs/tags/v*"},
+		BranchProtections: []datastructures.BranchProtection{
+			{Pattern: getBranchRefName("main"), DenyNonFastForward: true, DenyDeletion: true},
+			{Pattern: getBranchRefName("release/*"), AllowedPushers: []string{"releaser"}},
+		},
+	}
+	fastforward := true
+	notfastforward := false
+
+	cases := []struct {
+		name             string
+		ref, from, to    string
+		pusher           string
+		fastforward      *bool
+		checkfastforward bool
+		expected         string
+	}{
+		{"fast-forward", "refs/heads/main", oldcommit, newcommit, "user", &fastforward, true, "OK"},
+		{"non-fast-forward", "refs/heads/main", oldcommit, newcommit, "user", &notfastforward, true, "non-fast-forward"},
+		{"unknown fast-forward", "refs/heads/main", oldcommit, newcommit, "user", nil, true, "non-fast-forward"},
+		{"precheck", "refs/heads/main", oldcommit, newcommit, "user", nil, false, "OK"},
+		{"unprotected", "refs/heads/feature/main", oldcommit, newcommit, "user", &notfastforward, true, "OK"},
+		{"creation", "refs/heads/main", zero, newcommit, "user", nil, true, "OK"},
+		{"deletion", "refs/heads/main", oldcommit, zero, "user", nil, true, "deletion-denied"},
+		{"nested pattern", "refs/heads/release/1.0/hotfix", oldcommit, newcommit, "user", nil, true, "pusher-denied"},
+		{"allowed pusher", "refs/heads/release/1.0/hotfix", oldcommit, newcommit, "releaser", nil, true, "OK"},
+		{"creatable", "refs/tags/v1.0", zero, newcommit, "user", nil, true, "OK"},
+		{"not creatable", "refs/tags/other", zero, newcommit, "user", nil, true, "creation-denied"},
+		{"existing not creatable", "refs/tags/other", oldcommit, newcommit, "user", nil, true, "OK"},
+	}
+	for _, c := range cases {
+		req := pb.NewPushRequest(1, "repo")
+		req.SetPusher(c.pusher, "")
+		request := pb.NewUpdateRequest(c.ref, c.from, c.to)
+		request.Fastforward = c.fastforward
+		req.AddRequest(request)
+
+		result, err := checkRefPolicies(info, req, request, c.checkfastforward)
+		if result != c.expected {
+			t.Errorf("%s: result %s (error %v), expected %s", c.name, result, err, c.expected)
+		}
+		if (err == nil) != (c.expected == "OK") {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+	}
+
+	restore := true
+	req := pb.NewPushRequest(1, "repo")
+	req.SetPusher("user", "")
+	req.Restore = &restore
+	request := pb.NewUpdateRequest("refs/heads/main", oldcommit, zero)
+	req.AddRequest(request)
+	if result, err := checkRefPolicies(info, req, request, true); err != nil {
+		t.Errorf("Restore was denied: %s (%s)", result, err)
+	}
+}
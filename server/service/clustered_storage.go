@@ -30,6 +30,14 @@ func (d *clusterStorageDriverInstance) GetProjectStorage(project string) storage
 	}
 }
 
+func (d *clusterStorageDriverInstance) DeleteProject(project string) error {
+	return d.inner.DeleteProject(project)
+}
+
+func (d *clusterStorageDriverInstance) RenameProject(project, newproject string) error {
+	return d.inner.RenameProject(project, newproject)
+}
+
 type clusterStorageProjectDriverInstance struct {
 	d       *clusterStorageDriverInstance
 	project string
@@ -51,6 +59,7 @@ type clusterStorageProjectPushDriverInstance struct {
 
 	objectSyncMux          *sync.Mutex
 	objectSyncPeers        []uint64
+	objectSyncLearners     map[uint64]bool
 	objectSyncDBPath       string
 	objectSyncDB           *sql.DB
 	objectSyncAllSubmitted bool
@@ -199,7 +208,7 @@ func (d *clusterStorageProjectDriverInstance) ReadObject(objectid storage.Object
 		}
 
 		// If that didn't have the object (or crashed), fall back
-		for k := range d.d.cfg.statestore.Peers {
+		for k := range d.d.cfg.statestore.getPeers() {
 			objtype, objsize, reader, err := d.tryObjectFromNode(objectid, k)
 			if err == nil {
 				return objtype, objsize, reader, err
@@ -225,6 +234,11 @@ func (d *clusterStorageProjectDriverInstance) ReadObject(objectid storage.Object
 	return objtype, objsize, reader, err
 }
 
+// DeleteObject only removes the local copy of an object, copies synced to peers are left alone
+func (d *clusterStorageProjectDriverInstance) DeleteObject(objectid storage.ObjectID) error {
+	return d.inner.DeleteObject(objectid)
+}
+
 func (d *clusterStorageProjectPushDriverInstance) pushSingleObject(peerid uint64, objid storage.ObjectID) error {
 	return d.d.d.cfg.syncSingleObject(
 		peerid,
@@ -386,20 +400,33 @@ func (d *clusterStorageProjectPushDriverInstance) dbPeerColumn(peerid uint64) st
 	return "node_" + strconv.Itoa(int(peerid)) + "_queue"
 }
 
-func (d *clusterStorageProjectPushDriverInstance) dbAddPeer(peerid uint64) error {
+func (d *clusterStorageProjectPushDriverInstance) dbAddPeer(peerid uint64, learner bool) error {
 	_, err := d.objectSyncDB.Exec(
 		`CREATE TABLE ` + d.dbPeerColumn(peerid) + ` (objectid TEXT PRIMARY KEY NOT NULL)`,
 	)
 	d.objectSyncPeers = append(d.objectSyncPeers, peerid)
+	if learner {
+		d.objectSyncLearners[peerid] = true
+	}
 	return err
 }
 
+// numVoterPeers returns the number of peers that objects get synced to which count towards quorum
+func (d *clusterStorageProjectPushDriverInstance) numVoterPeers() int {
+	return len(d.objectSyncPeers) - len(d.objectSyncLearners)
+}
+
 func (d *clusterStorageProjectPushDriverInstance) dbAddObject(objid storage.ObjectID) error {
 	d.objectSyncMux.Lock()
 	defer d.objectSyncMux.Unlock()
 
-	numpeers := len(d.objectSyncPeers)
+	// Learners get the objects as well, but we don't wait for them
+	numpeers := d.numVoterPeers()
 	neededpeers := int(math.Floor(float64(numpeers+1) / 2.0))
+	if d.d.d.cfg.statestore.isLearner(d.d.d.cfg.nodeid) {
+		// Our own copy doesn't count towards quorum, so we need a majority of the voters
+		neededpeers = numpeers/2 + 1
+	}
 
 	tx, err := d.objectSyncDB.Begin()
 	if err != nil {
@@ -496,6 +523,11 @@ func (d *clusterStorageProjectPushDriverInstance) dbReportObject(objid storage.O
 	defer d.objectSyncMux.Unlock()
 	d.d.d.cfg.log.Debug("Got locks report")
 
+	if d.objectSyncLearners[nodeid] {
+		// Learners don't count towards the push result
+		return nil
+	}
+
 	tx, err := d.objectSyncDB.Begin()
 	if err != nil {
 		return err
@@ -595,6 +627,7 @@ func (d *clusterStorageProjectDriverInstance) GetPusher(pushuuid string) storage
 
 		objectSyncAllSubmitted: false,
 		objectSyncPeers:        make([]uint64, 0),
+		objectSyncLearners:     make(map[uint64]bool),
 		objectSyncMux:          new(sync.Mutex),
 		objectSyncDBPath:       dbpath,
 		objectSyncDB:           db,
@@ -609,10 +642,10 @@ func (d *clusterStorageProjectDriverInstance) GetPusher(pushuuid string) storage
 
 	syncwg := new(sync.WaitGroup)
 
-	for peerid := range d.d.cfg.statestore.Peers {
+	for peerid := range d.d.cfg.statestore.getPeers() {
 		if peerid != d.d.cfg.statestore.NodeID {
 			syncwg.Add(1)
-			inst.dbAddPeer(peerid)
+			inst.dbAddPeer(peerid, d.d.cfg.statestore.isLearner(peerid))
 
 			go func(peerid uint64) {
 				syncwg.Done()
@@ -688,7 +721,9 @@ func (d *clusterStorageProjectPushDriverInstance) Done() {
 
 func (d *clusterStorageProjectPushDriverInstance) startObjectSync(objid storage.ObjectID) {
 	if len(d.objectSyncPeers) != 0 {
-		d.outstandingobjects.Add(1)
+		if d.numVoterPeers() != 0 {
+			d.outstandingobjects.Add(1)
+		}
 		err := d.dbAddObject(objid)
 		if err != nil {
 			panic(err)
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+// nodeStatusTimeout is how long we wait for a peer to report its status
+const nodeStatusTimeout = 5 * time.Second
+
+// getWALSize returns the total size of the raft write-ahead log files
+func (rc *stateRaftNode) getWALSize() (int64, error) {
+	dirent, err := ioutil.ReadDir(rc.waldir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, file := range dirent {
+		if !file.IsDir() {
+			size += file.Size()
+		}
+	}
+	return size, nil
+}
+
+// getLocalNodeStatus returns the raft and syncer state of this node
+func (cfg *Service) getLocalNodeStatus() datastructures.NodeStatus {
+	status := datastructures.NodeStatus{
+		NodeID:          cfg.nodeid,
+		NodeName:        cfg.nodename,
+		Version:         constants.VersionString(),
+		Learner:         cfg.statestore.isLearner(cfg.nodeid),
+		Unreachable:     make(map[uint64]int64),
+		OutqueueBacklog: make(map[uint64]int),
+	}
+	rc := cfg.statestore.raftnode
+
+	raftstatus := rc.node.Status()
+	status.Leader = raftstatus.Lead
+	status.Term = raftstatus.Term
+	status.CommitIndex = raftstatus.Commit
+	status.AppliedIndex = raftstatus.Applied
+	if len(raftstatus.Progress) != 0 {
+		status.MatchIndexes = make(map[uint64]uint64)
+		for peer, progress := range raftstatus.Progress {
+			status.MatchIndexes[peer] = progress.Match
+		}
+	}
+
+	snap, err := rc.raftStorage.Snapshot()
+	if err == nil {
+		status.SnapshotIndex = snap.Metadata.Index
+	}
+	walsize, err := rc.getWALSize()
+	if err != nil {
+		cfg.log.Infow("Error determining WAL size", "error", err)
+	}
+	status.WALSize = walsize
+
+	if replication := cfg.replicator.getStatus(); len(replication) != 0 {
+		status.Replication = replication
+	}
+
+	for peer, reported := range rc.getUnreachablePeers() {
+		status.Unreachable[peer] = reported.Unix()
+	}
+	for peer := range cfg.statestore.getPeers() {
+		if peer == cfg.nodeid {
+			continue
+		}
+		backlog, err := cfg.sync.getPeerBacklog(peer)
+		if err != nil {
+			cfg.log.Infow("Error determining outqueue backlog",
+				"peer", peer,
+				"error", err,
+			)
+			continue
+		}
+		status.OutqueueBacklog[peer] = backlog
+	}
+
+	return status
+}
+
+// getPeerNodeStatus asks a peer for its status
+func (cfg *Service) getPeerNodeStatus(peer uint64) (status datastructures.NodeStatus, err error) {
+	resp, err := cfg.rpcClient.Get(cfg.GetPeerURL(peer, "/rpc/nodestatus"))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = errors.Errorf("Error returned by peer: %s", resp.Status)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return
+}
+
+// getClusterStatus collects the status of all nodes in the region.
+// Nodes that do not respond in time are reported with an error.
+func (cfg *Service) getClusterStatus() datastructures.ClusterStatus {
+	local := cfg.getLocalNodeStatus()
+	clusterstatus := datastructures.ClusterStatus{
+		Leader: local.Leader,
+		Term:   local.Term,
+		Nodes: map[uint64]datastructures.NodeStatus{
+			cfg.nodeid: local,
+		},
+	}
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	for peer := range cfg.statestore.getPeers() {
+		if peer == cfg.nodeid {
+			continue
+		}
+		wg.Add(1)
+		go func(peer uint64) {
+			defer wg.Done()
+			statusC := make(chan datastructures.NodeStatus, 1)
+			go func() {
+				status, err := cfg.getPeerNodeStatus(peer)
+				if err != nil {
+					status = datastructures.NodeStatus{NodeID: peer, Error: err.Error()}
+				}
+				statusC <- status
+			}()
+
+			var status datastructures.NodeStatus
+			select {
+			case status = <-statusC:
+			case <-time.After(nodeStatusTimeout):
+				status = datastructures.NodeStatus{NodeID: peer, Error: "Timed out waiting for status"}
+			}
+
+			mux.Lock()
+			defer mux.Unlock()
+			clusterstatus.Nodes[peer] = status
+		}(peer)
+	}
+	wg.Wait()
+
+	// A node that is partitioned away might not know about the latest election yet
+	for _, status := range clusterstatus.Nodes {
+		if status.Error == "" && status.Term > clusterstatus.Term {
+			clusterstatus.Leader = status.Leader
+			clusterstatus.Term = status.Term
+		}
+	}
+
+	return clusterstatus
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// fileChange is a change to a single file, with a path relative to the tree it is applied to
+type fileChange struct {
+	path         string
+	delete       bool
+	mustexist    bool
+	mustnotexist bool
+	content      []byte
+	executable   bool
+}
+
+// changeError is returned when the requested changes can not be applied
+type changeError string
+
+func (e changeError) Error() string {
+	return string(e)
+}
+
+// writeObject stores a fully formed object and returns its ID
+func writeObject(pusher storage.ProjectStoragePushDriver, objtype storage.ObjectType, content []byte) (storage.ObjectID, error) {
+	stg, err := pusher.StageObject(objtype, uint(len(content)))
+	if err != nil {
+		return storage.ZeroID, err
+	}
+	defer stg.Close()
+	if _, err := stg.Write(content); err != nil {
+		return storage.ZeroID, err
+	}
+	return stg.Finalize(storage.ZeroID)
+}
+
+// treeSortName is the name git uses to sort tree entries: subtrees sort as if they had a trailing slash
+func treeSortName(entry treeEntry) string {
+	if entry.isTree() {
+		return entry.name + "/"
+	}
+	return entry.name
+}
+
+// formatTree serializes tree entries in the git tree object format
+func formatTree(entries map[string]treeEntry) ([]byte, error) {
+	sorted := make([]treeEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return treeSortName(sorted[i]) < treeSortName(sorted[j])
+	})
+
+	var buf bytes.Buffer
+	for _, entry := range sorted {
+		binid, err := hex.DecodeString(string(entry.objectid))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s %s\x00", strings.TrimPrefix(entry.gitMode(), "0"), entry.name)
+		buf.Write(binid)
+	}
+	return buf.Bytes(), nil
+}
+
+// applyTreeChanges writes a new tree that is treeid with changes applied.
+// treeid can be storage.ZeroID to start with an empty tree.
+// If the resulting tree is empty, storage.ZeroID is returned.
+func applyTreeChanges(p storage.ProjectStorageDriver, pusher storage.ProjectStoragePushDriver, treeid storage.ObjectID, changes []fileChange) (storage.ObjectID, error) {
+	entries, err := readTreeEntries(p, treeid)
+	if err != nil {
+		return storage.ZeroID, err
+	}
+
+	subchanges := make(map[string][]fileChange)
+	var subnames []string
+	for _, change := range changes {
+		split := strings.SplitN(change.path, "/", 2)
+		if len(split) == 2 {
+			if _, seen := subchanges[split[0]]; !seen {
+				subnames = append(subnames, split[0])
+			}
+			change.path = split[1]
+			subchanges[split[0]] = append(subchanges[split[0]], change)
+			continue
+		}
+
+		existing, exists := entries[change.path]
+		if exists && (existing.isTree() || existing.isSubmodule()) {
+			return storage.ZeroID, changeError(fmt.Sprintf("%s is not a file", change.path))
+		}
+		if change.mustexist && !exists {
+			return storage.ZeroID, changeError(fmt.Sprintf("%s does not exist", change.path))
+		}
+		if change.mustnotexist && exists {
+			return storage.ZeroID, changeError(fmt.Sprintf("%s already exists", change.path))
+		}
+		if change.delete {
+			delete(entries, change.path)
+			continue
+		}
+
+		blobid, err := writeObject(pusher, storage.ObjectTypeBlob, change.content)
+		if err != nil {
+			return storage.ZeroID, err
+		}
+		var mode os.FileMode = 0644
+		if change.executable {
+			mode = 0755
+		}
+		entries[change.path] = treeEntry{
+			mode:     mode,
+			name:     change.path,
+			objectid: blobid,
+		}
+	}
+
+	for _, name := range subnames {
+		subtree := storage.ZeroID
+		if existing, exists := entries[name]; exists {
+			if !existing.isTree() {
+				return storage.ZeroID, changeError(fmt.Sprintf("%s is not a directory", name))
+			}
+			subtree = existing.objectid
+		}
+		newsubtree, err := applyTreeChanges(p, pusher, subtree, subchanges[name])
+		if err != nil {
+			return storage.ZeroID, err
+		}
+		if newsubtree == storage.ZeroID {
+			// Git doesn't store empty directories
+			delete(entries, name)
+		} else {
+			entries[name] = treeEntry{
+				mode:     os.ModeDir | 0755,
+				name:     name,
+				objectid: newsubtree,
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		return storage.ZeroID, nil
+	}
+	tree, err := formatTree(entries)
+	if err != nil {
+		return storage.ZeroID, err
+	}
+	return writeObject(pusher, storage.ObjectTypeTree, tree)
+}
+
+// formatSignature returns a signature line as used in commit and tag headers
+func formatSignature(sig signature) string {
+	return fmt.Sprintf("%s <%s> %d %s", sig.name, sig.email, sig.when.Unix(), sig.when.Format("-0700"))
+}
+
+func isValidSignaturePart(part string) bool {
+	return part != "" && !strings.ContainsAny(part, "<>\n")
+}
+
+// formatCommit serializes a commit in the git commit object format
+func formatCommit(tree storage.ObjectID, parents []storage.ObjectID, author, committer signature, message string) ([]byte, error) {
+	for _, sig := range []signature{author, committer} {
+		if !isValidSignaturePart(sig.name) || !isValidSignaturePart(sig.email) {
+			return nil, changeError("Invalid name or email")
+		}
+	}
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\n", formatSignature(author))
+	fmt.Fprintf(&buf, "committer %s\n", formatSignature(committer))
+	buf.WriteString("\n")
+	buf.WriteString(message)
+	return buf.Bytes(), nil
+}
+
+// writeCommit writes a tree with changes applied to the tree of parent (if any), and a commit
+// object for it. It returns the ID of the new commit.
+func writeCommit(p storage.ProjectStorageDriver, pusher storage.ProjectStoragePushDriver, parents []storage.ObjectID, changes []fileChange, author, committer signature, message string) (storage.ObjectID, error) {
+	basetree := storage.ZeroID
+	if len(parents) != 0 {
+		_, parentcommit, err := peelToCommit(p, parents[0])
+		if err != nil {
+			return storage.ZeroID, err
+		}
+		basetree = parentcommit.tree
+	}
+	tree, err := applyTreeChanges(p, pusher, basetree, changes)
+	if err != nil {
+		return storage.ZeroID, err
+	}
+	return writeCommitObject(pusher, tree, parents, author, committer, message)
+}
+
+// writeCommitObject writes a commit object for tree, which can be storage.ZeroID for an empty tree
+func writeCommitObject(pusher storage.ProjectStoragePushDriver, tree storage.ObjectID, parents []storage.ObjectID, author, committer signature, message string) (storage.ObjectID, error) {
+	var err error
+	if tree == storage.ZeroID {
+		tree, err = writeObject(pusher, storage.ObjectTypeTree, []byte{})
+		if err != nil {
+			return storage.ZeroID, err
+		}
+	}
+	commit, err := formatCommit(tree, parents, author, committer, message)
+	if err != nil {
+		return storage.ZeroID, err
+	}
+	return writeObject(pusher, storage.ObjectTypeCommit, commit)
+}
+
+// getNewSignature returns the signature for a new commit, defaulting to the current time
+func getNewSignature(sig datastructures.APISignature) signature {
+	when := sig.Date
+	if when.IsZero() {
+		when = time.Now()
+	}
+	return signature{
+		name:  sig.Name,
+		email: sig.Email,
+		when:  when,
+	}
+}
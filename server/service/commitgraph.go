@@ -0,0 +1,421 @@
+package service
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+const commitGraphFileMagic = "RSCG"
+const commitGraphFileVersion = 1
+
+// commitGraphEntry is the information about a commit needed for ancestry queries.
+// The generation of a commit without parents is 1, and otherwise one more than
+// the highest generation of its parents: any ancestor has a lower generation.
+type commitGraphEntry struct {
+	parents    []storage.ObjectID
+	generation uint32
+	date       int64
+}
+
+// commitGraph is a cache of the parents, generation numbers and commit dates of the commits in a repo.
+// Commits are added on demand, and after pushes for the new ref values.
+type commitGraph struct {
+	mux sync.Mutex
+
+	commits map[storage.ObjectID]commitGraphEntry
+}
+
+func newCommitGraph() *commitGraph {
+	return &commitGraph{
+		commits: make(map[storage.ObjectID]commitGraphEntry),
+	}
+}
+
+// get returns the entry for a commit, adding it and any of its ancestors missing from the graph.
+// The graph must be locked.
+func (g *commitGraph) get(p storage.ProjectStorageDriver, commitid storage.ObjectID) (commitGraphEntry, error) {
+	if entry, known := g.commits[commitid]; known {
+		return entry, nil
+	}
+
+	read := make(map[storage.ObjectID]commitGraphEntry)
+	stack := []storage.ObjectID{commitid}
+	for len(stack) != 0 {
+		current := stack[len(stack)-1]
+		if _, known := g.commits[current]; known {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		entry, isread := read[current]
+		if !isread {
+			objtype, _, r, err := p.ReadObject(current)
+			if err != nil {
+				return commitGraphEntry{}, errors.Wrapf(err, "Error reading commit %s", current)
+			}
+			if objtype != storage.ObjectTypeCommit {
+				r.Close()
+				return commitGraphEntry{}, errors.Errorf("Object %s is not a commit", current)
+			}
+			commit, err := readCommit(r)
+			r.Close()
+			if err != nil {
+				return commitGraphEntry{}, err
+			}
+			entry.parents = commit.parents
+			if committer, err := parseSignature(commit.committer); err == nil {
+				entry.date = committer.when.Unix()
+			}
+			read[current] = entry
+		}
+
+		// Parents need to be added first to determine the generation
+		missingparents := false
+		entry.generation = 1
+		for _, parent := range entry.parents {
+			parententry, known := g.commits[parent]
+			if !known {
+				stack = append(stack, parent)
+				missingparents = true
+			} else if parententry.generation >= entry.generation {
+				entry.generation = parententry.generation + 1
+			}
+		}
+		if !missingparents {
+			g.commits[current] = entry
+			delete(read, current)
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return g.commits[commitid], nil
+}
+
+// addTips adds the commits that the objects in tips peel to. Tips not pointing to a commit are ignored.
+// The graph must be locked.
+func (g *commitGraph) addTips(p storage.ProjectStorageDriver, tips []storage.ObjectID) error {
+	for _, tip := range tips {
+		objtype, err := peelTags(p, &tip)
+		if err != nil {
+			return err
+		}
+		if objtype != storage.ObjectTypeCommit {
+			continue
+		}
+		if _, err := g.get(p, tip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// peelTags follows annotated tags until objid is something else, and returns its type
+func peelTags(p storage.ProjectStorageDriver, objid *storage.ObjectID) (storage.ObjectType, error) {
+	for {
+		objtype, _, r, err := p.ReadObject(*objid)
+		if err != nil {
+			return objtype, err
+		}
+		if objtype != storage.ObjectTypeTag {
+			r.Close()
+			return objtype, nil
+		}
+		tag, err := readTag(r)
+		r.Close()
+		if err != nil {
+			return objtype, err
+		}
+		*objid = tag.object
+	}
+}
+
+// IsAncestor returns whether a is an ancestor of, or the same commit as, b
+func (g *commitGraph) IsAncestor(p storage.ProjectStorageDriver, a, b storage.ObjectID) (bool, error) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	aentry, err := g.get(p, a)
+	if err != nil {
+		return false, err
+	}
+	if _, err := g.get(p, b); err != nil {
+		return false, err
+	}
+
+	seen := map[storage.ObjectID]bool{b: true}
+	stack := []storage.ObjectID{b}
+	for len(stack) != 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if current == a {
+			return true, nil
+		}
+		for _, parent := range g.commits[current].parents {
+			// Commits with a generation not above that of a can't have it as ancestor
+			if seen[parent] || (parent != a && g.commits[parent].generation <= aentry.generation) {
+				continue
+			}
+			seen[parent] = true
+			stack = append(stack, parent)
+		}
+	}
+	return false, nil
+}
+
+const (
+	mergeBaseParent1 = 1 << iota
+	mergeBaseParent2
+	mergeBaseStale
+)
+
+// commitGraphQueue is a heap of commits that returns the highest generation first
+type commitGraphQueue struct {
+	g   *commitGraph
+	ids []storage.ObjectID
+}
+
+func (q *commitGraphQueue) Len() int {
+	return len(q.ids)
+}
+
+func (q *commitGraphQueue) Less(i, j int) bool {
+	return q.g.commits[q.ids[i]].generation > q.g.commits[q.ids[j]].generation
+}
+
+func (q *commitGraphQueue) Swap(i, j int) {
+	q.ids[i], q.ids[j] = q.ids[j], q.ids[i]
+}
+
+func (q *commitGraphQueue) Push(x interface{}) {
+	q.ids = append(q.ids, x.(storage.ObjectID))
+}
+
+func (q *commitGraphQueue) Pop() interface{} {
+	last := q.ids[len(q.ids)-1]
+	q.ids = q.ids[:len(q.ids)-1]
+	return last
+}
+
+// isNewer returns whether commit a has a more recent commit date than b, using the IDs to break ties
+func (g *commitGraph) isNewer(a, b storage.ObjectID) bool {
+	adate, bdate := g.commits[a].date, g.commits[b].date
+	if adate != bdate {
+		return adate > bdate
+	}
+	return a < b
+}
+
+// MergeBase returns the best common ancestor of a and b, or storage.ZeroID if they have no common history.
+// If there are multiple best common ancestors, the one with the most recent commit date is returned.
+func (g *commitGraph) MergeBase(p storage.ProjectStorageDriver, a, b storage.ObjectID) (storage.ObjectID, error) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if _, err := g.get(p, a); err != nil {
+		return storage.ZeroID, err
+	}
+	if _, err := g.get(p, b); err != nil {
+		return storage.ZeroID, err
+	}
+	if a == b {
+		return a, nil
+	}
+
+	// Walk down from both commits, highest generation first, so that every commit is visited after
+	// all its descendants. The first commits reached from both sides are the best common ancestors,
+	// and everything below them is marked stale.
+	flags := map[storage.ObjectID]int{a: mergeBaseParent1, b: mergeBaseParent2}
+	queue := &commitGraphQueue{g: g, ids: []storage.ObjectID{a, b}}
+	heap.Init(queue)
+	nonstale := 2
+	mergebase := storage.ZeroID
+	for nonstale != 0 {
+		current := heap.Pop(queue).(storage.ObjectID)
+		currentflags := flags[current]
+		if currentflags&mergeBaseStale == 0 {
+			nonstale--
+			if currentflags&(mergeBaseParent1|mergeBaseParent2) == mergeBaseParent1|mergeBaseParent2 {
+				if mergebase == storage.ZeroID || g.isNewer(current, mergebase) {
+					mergebase = current
+				}
+				currentflags |= mergeBaseStale
+			}
+		}
+		for _, parent := range g.commits[current].parents {
+			oldflags, queued := flags[parent]
+			newflags := oldflags | currentflags
+			if newflags == oldflags {
+				continue
+			}
+			flags[parent] = newflags
+			if !queued {
+				heap.Push(queue, parent)
+				if newflags&mergeBaseStale == 0 {
+					nonstale++
+				}
+			} else if oldflags&mergeBaseStale == 0 && newflags&mergeBaseStale != 0 {
+				nonstale--
+			}
+		}
+	}
+	return mergebase, nil
+}
+
+const (
+	onlyInIncluded = 1 << iota
+	onlyInExcluded
+)
+
+// OnlyIn returns the commits reachable from include but not from exclude, newest first.
+// Only the commits between the two and their common ancestors are visited.
+func (g *commitGraph) OnlyIn(p storage.ProjectStorageDriver, include, exclude storage.ObjectID) ([]storage.ObjectID, error) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if _, err := g.get(p, include); err != nil {
+		return nil, err
+	}
+	if _, err := g.get(p, exclude); err != nil {
+		return nil, err
+	}
+
+	// As with MergeBase, every commit is visited after all its descendants, so its flags are final
+	// when it is popped. Once every queued commit is reachable from exclude, so are all the rest.
+	flags := map[storage.ObjectID]int{include: onlyInIncluded}
+	flags[exclude] |= onlyInExcluded
+	queue := &commitGraphQueue{g: g, ids: []storage.ObjectID{include}}
+	if exclude != include {
+		queue.ids = append(queue.ids, exclude)
+	}
+	heap.Init(queue)
+	interesting := 0
+	if flags[include] == onlyInIncluded {
+		interesting = 1
+	}
+	var result []storage.ObjectID
+	for interesting != 0 {
+		current := heap.Pop(queue).(storage.ObjectID)
+		currentflags := flags[current]
+		if currentflags == onlyInIncluded {
+			interesting--
+			result = append(result, current)
+		}
+		for _, parent := range g.commits[current].parents {
+			oldflags, queued := flags[parent]
+			newflags := oldflags | currentflags
+			if newflags == oldflags {
+				continue
+			}
+			flags[parent] = newflags
+			if !queued {
+				heap.Push(queue, parent)
+				if newflags == onlyInIncluded {
+					interesting++
+				}
+			} else if oldflags == onlyInIncluded {
+				interesting--
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return g.isNewer(result[i], result[j])
+	})
+	return result, nil
+}
+
+// writeTo serializes the graph. The graph must be locked.
+func (g *commitGraph) writeTo(w io.Writer) error {
+	if _, err := w.Write([]byte(commitGraphFileMagic)); err != nil {
+		return err
+	}
+	if err := writeIndexUint32(w, commitGraphFileVersion); err != nil {
+		return err
+	}
+	if err := writeIndexUint32(w, uint32(len(g.commits))); err != nil {
+		return err
+	}
+	for commitid, entry := range g.commits {
+		if err := writeIndexObjectID(w, commitid); err != nil {
+			return err
+		}
+		if err := writeIndexUint32(w, entry.generation); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, entry.date); err != nil {
+			return err
+		}
+		if err := writeIndexUint32(w, uint32(len(entry.parents))); err != nil {
+			return err
+		}
+		for _, parent := range entry.parents {
+			if err := writeIndexObjectID(w, parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readFrom loads a serialized graph into an empty graph
+func (g *commitGraph) readFrom(r io.Reader) error {
+	magic := make([]byte, len(commitGraphFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if string(magic) != commitGraphFileMagic {
+		return errors.New("Invalid commit-graph file magic")
+	}
+	var version, numcommits uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version != commitGraphFileVersion {
+		return errors.Errorf("Unsupported commit-graph file version %d", version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &numcommits); err != nil {
+		return err
+	}
+	commits := make(map[storage.ObjectID]commitGraphEntry, numcommits)
+	for i := uint32(0); i < numcommits; i++ {
+		commitid, err := readIndexObjectID(r)
+		if err != nil {
+			return err
+		}
+		var entry commitGraphEntry
+		var numparents uint32
+		if err := binary.Read(r, binary.BigEndian, &entry.generation); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &entry.date); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &numparents); err != nil {
+			return err
+		}
+		for j := uint32(0); j < numparents; j++ {
+			parent, err := readIndexObjectID(r)
+			if err != nil {
+				return err
+			}
+			entry.parents = append(entry.parents, parent)
+		}
+		commits[commitid] = entry
+	}
+
+	// Only use the graph if it is complete, so that all ancestors of known commits are known
+	for _, entry := range commits {
+		for _, parent := range entry.parents {
+			if _, known := commits[parent]; !known {
+				return errors.New("Commit-graph is missing parents")
+			}
+		}
+	}
+	g.commits = commits
+	return nil
+}
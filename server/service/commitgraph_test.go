@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+func TestCommitGraph(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	tree := writeTestTree(t, pusher, fileEntry("file", writeTestBlob(t, pusher, "file\n")))
+	c1 := writeTestCommit(t, pusher, tree, "c1")
+	c2 := writeTestCommit(t, pusher, tree, "c2", c1)
+	c3 := writeTestCommit(t, pusher, tree, "c3", c2)
+	c4 := writeTestCommit(t, pusher, tree, "c4", c2)
+	c5 := writeTestCommit(t, pusher, tree, "c5", c3, c4)
+	unrelated := writeTestCommit(t, pusher, tree, "unrelated")
+	// A criss-cross merge has two best common ancestors
+	cross1 := writeTestCommit(t, pusher, tree, "cross1", c3, c4)
+	cross2 := writeTestCommit(t, pusher, tree, "cross2", c4, c3)
+	names := map[storage.ObjectID]string{
+		c1: "c1", c2: "c2", c3: "c3", c4: "c4", c5: "c5", unrelated: "unrelated",
+		cross1: "cross1", cross2: "cross2", storage.ZeroID: "none",
+	}
+
+	graph := newCommitGraph()
+	ancestorcases := []struct {
+		a, b     storage.ObjectID
+		expected bool
+	}{
+		{c1, c3, true},
+		{c3, c3, true},
+		{c4, c5, true},
+		{c1, c5, true},
+		{c3, c4, false},
+		{c5, c1, false},
+		{unrelated, c5, false},
+		{c5, unrelated, false},
+	}
+	for _, c := range ancestorcases {
+		isancestor, err := graph.IsAncestor(p, c.a, c.b)
+		if err != nil {
+			t.Fatalf("Error checking ancestry: %s", err)
+		}
+		if isancestor != c.expected {
+			t.Errorf("%s ancestor of %s: %t, expected %t", names[c.a], names[c.b], isancestor, c.expected)
+		}
+	}
+
+	crossbase := c3
+	if c4 < c3 {
+		// With equal commit dates, the lowest ID is used
+		crossbase = c4
+	}
+	mergebasecases := []struct {
+		a, b     storage.ObjectID
+		expected storage.ObjectID
+	}{
+		{c3, c4, c2},
+		{c4, c3, c2},
+		{c5, c4, c4},
+		{c1, c1, c1},
+		{c5, c1, c1},
+		{c3, unrelated, storage.ZeroID},
+		{cross1, cross2, crossbase},
+	}
+	for _, c := range mergebasecases {
+		mergebase, err := graph.MergeBase(p, c.a, c.b)
+		if err != nil {
+			t.Fatalf("Error determining merge base: %s", err)
+		}
+		if mergebase != c.expected {
+			t.Errorf("Merge base of %s and %s: %s, expected %s", names[c.a], names[c.b], names[mergebase], names[c.expected])
+		}
+	}
+
+	if _, err := graph.IsAncestor(p, c1, storage.ObjectID("0123456789012345678901234567890123456789")); err == nil {
+		t.Error("Checking ancestry of a missing commit succeeded")
+	}
+
+	var buf bytes.Buffer
+	if err := graph.writeTo(&buf); err != nil {
+		t.Fatalf("Error writing graph: %s", err)
+	}
+	loaded := newCommitGraph()
+	if err := loaded.readFrom(&buf); err != nil {
+		t.Fatalf("Error reading graph: %s", err)
+	}
+	if len(loaded.commits) != len(graph.commits) {
+		t.Fatalf("Loaded graph has %d commits, expected %d", len(loaded.commits), len(graph.commits))
+	}
+	mergebase, err := loaded.MergeBase(p, c3, c4)
+	if err != nil || mergebase != c2 {
+		t.Errorf("Merge base in loaded graph is %s (error %v), expected c2", names[mergebase], err)
+	}
+}
+
+func TestCommitGraphOnlyIn(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	tree := writeTestTree(t, pusher, fileEntry("file", writeTestBlob(t, pusher, "file\n")))
+	c1 := writeTestCommit(t, pusher, tree, "c1")
+	c2 := writeTestCommit(t, pusher, tree, "c2", c1)
+	c3 := writeTestCommit(t, pusher, tree, "c3", c2)
+	c4 := writeTestCommit(t, pusher, tree, "c4", c2)
+	c5 := writeTestCommit(t, pusher, tree, "c5", c3, c4)
+	c6 := writeTestCommit(t, pusher, tree, "c6", c5)
+	unrelated := writeTestCommit(t, pusher, tree, "unrelated")
+
+	graph := newCommitGraph()
+	cases := []struct {
+		include, exclude storage.ObjectID
+		expected         []storage.ObjectID
+	}{
+		{c6, c3, []storage.ObjectID{c4, c5, c6}},
+		{c6, c4, []storage.ObjectID{c3, c5, c6}},
+		{c3, c4, []storage.ObjectID{c3}},
+		{c3, c6, nil},
+		{c3, c3, nil},
+		{c2, unrelated, []storage.ObjectID{c1, c2}},
+	}
+	for i, c := range cases {
+		commits, err := graph.OnlyIn(p, c.include, c.exclude)
+		if err != nil {
+			t.Fatalf("Error listing commits: %s", err)
+		}
+		// All commits have the same date, so they are ordered by ID
+		sort.Slice(c.expected, func(i, j int) bool { return c.expected[i] < c.expected[j] })
+		if fmt.Sprint(commits) != fmt.Sprint(c.expected) {
+			t.Errorf("Case %d: got commits %v, expected %v", i, commits, c.expected)
+		}
+	}
+}
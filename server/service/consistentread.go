@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// defaultConsistentReadTimeout is how long a consistent read waits for this node to catch up,
+// unless configured otherwise
+const defaultConsistentReadTimeout = 5 * time.Second
+
+func getConsistentReadTimeout() time.Duration {
+	if viper.IsSet("transfer.consistent_read_timeout") {
+		return viper.GetDuration("transfer.consistent_read_timeout")
+	}
+	return defaultConsistentReadTimeout
+}
+
+type appliedWaiter struct {
+	index uint64
+	c     chan struct{}
+}
+
+// setAppliedIndex records that the state store applied all entries up to index
+func (store *stateStore) setAppliedIndex(index uint64) {
+	store.appliedMux.Lock()
+	defer store.appliedMux.Unlock()
+
+	store.appliedIndex = index
+	var waiting []appliedWaiter
+	for _, waiter := range store.appliedWaiters {
+		if waiter.index <= index {
+			close(waiter.c)
+		} else {
+			waiting = append(waiting, waiter)
+		}
+	}
+	store.appliedWaiters = waiting
+}
+
+// getAppliedIndex returns the index up to which the state store applied all entries
+func (store *stateStore) getAppliedIndex() uint64 {
+	store.appliedMux.Lock()
+	defer store.appliedMux.Unlock()
+
+	return store.appliedIndex
+}
+
+// waitApplied waits until the state store applied all entries up to index
+func (store *stateStore) waitApplied(ctx context.Context, index uint64) error {
+	store.appliedMux.Lock()
+	if store.appliedIndex >= index {
+		store.appliedMux.Unlock()
+		return nil
+	}
+	waiter := appliedWaiter{index: index, c: make(chan struct{})}
+	store.appliedWaiters = append(store.appliedWaiters, waiter)
+	store.appliedMux.Unlock()
+
+	select {
+	case <-waiter.c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitConsistentRead waits until this node applied everything the leader committed when it
+// was called, so that the state read afterwards includes all changes completed before
+func (store *stateStore) waitConsistentRead() error {
+	ctx, cancel := context.WithTimeout(context.Background(), getConsistentReadTimeout())
+	defer cancel()
+
+	index, err := store.raftnode.readIndex(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Error determining read index")
+	}
+	if err := store.waitApplied(ctx, index); err != nil {
+		return errors.Wrap(err, "Error waiting for read index to be applied")
+	}
+	return nil
+}
+
+// wantsConsistentRead returns whether a read of a repo has to be linearizable, because the
+// repo or the client asks for it
+func (store *stateStore) wantsConsistentRead(r *http.Request, reponame string) bool {
+	if r.Header.Get("X-RepoSpanner-Consistent-Read") == "true" {
+		return true
+	}
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	return store.repoinfos[reponame].ConsistentReads
+}
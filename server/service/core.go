@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/pkg/transport"
@@ -112,6 +113,14 @@ type Service struct {
 	StateStorageDir   string
 	GitStorageConfig  map[string]string
 	Debug             bool
+	// JoinReplaceNodeID is the ID of a dead node that a joining node takes over from
+	JoinReplaceNodeID uint64
+	// JoinAsLearner makes a joining node a learner, which gets all changes but doesn't vote
+	JoinAsLearner bool
+	// RestoreBackup is the path of a backup to initialize a spawned region from
+	RestoreBackup string
+	// ForceNewCluster removes all other nodes from the region, for when a majority is lost
+	ForceNewCluster bool
 
 	initialized bool
 
@@ -133,6 +142,12 @@ type Service struct {
 	statestore *stateStore
 	gitstore   storage.StorageDriver
 	sync       *syncer
+	indexer    *repoIndexer
+	replicator *replicator
+
+	// peeledTags caches the targets of annotated tags pushed before they got recorded
+	peeledTagsMux sync.Mutex
+	peeledTags    map[storage.ObjectID]storage.ObjectID
 
 	isrunning bool
 }
@@ -285,6 +300,8 @@ func (cfg *Service) Initialize() error {
 		return err
 	}
 	cfg.sync = syncer
+	cfg.indexer = cfg.createRepoIndexer()
+	cfg.replicator = cfg.createReplicator()
 
 	cfg.log.Debug("Initialization finished")
 
@@ -299,6 +316,7 @@ func (cfg *Service) Shutdown() {
 	}
 
 	cfg.sync.Stop()
+	cfg.indexer.Stop()
 	cfg.httpServer.Shutdown(context.Background())
 	var closer struct{}
 	cfg.statestore.raftnode.stopc <- closer
@@ -326,7 +344,8 @@ func (cfg *Service) verifyNodeCert(rawCerts [][]byte, verifiedChains [][]*x509.C
 
 	for _, ext := range peer.Extensions {
 		if ext.Id.Equal(constants.OIDRegionName) {
-			if string(ext.Value) == cfg.region {
+			if string(ext.Value) == cfg.region || isReplicationRegion(string(ext.Value)) {
+				// Nodes of other regions can only access replication, see filterRPCRegions
 				correctregion = true
 				continue
 			}
@@ -384,7 +403,7 @@ func (cfg *Service) openGitStorage() error {
 }
 
 func (cfg *Service) GetPeerURL(peer uint64, relativeurl string) string {
-	peerbase := cfg.statestore.Peers[peer]
+	peerbase, _ := cfg.statestore.getPeerURL(peer)
 	return peerbase + relativeurl
 }
 
@@ -466,6 +485,7 @@ func (cfg *Service) runServer(spawning bool, joinnode string) error {
 
 	errchan := make(chan error)
 	go cfg.sync.Run(errchan)
+	go cfg.indexer.Run()
 	go cfg.statestore.RunStateStore(errchan, raftstarted)
 	go cfg.runHTTP(errchan)
 
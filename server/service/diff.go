@@ -0,0 +1,431 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+const (
+	diffContextLines = 3
+	// maxDiffBlobSize is the largest blob we will compute line diffs for
+	maxDiffBlobSize = 1024 * 1024
+	// maxDiffEdits is the largest number of changed lines we will compute line diffs for,
+	// as computing the diff takes time quadratic in it
+	maxDiffEdits = 10000
+	// binaryCheckSize is how much of a blob is checked for NUL bytes, like git does
+	binaryCheckSize = 8000
+)
+
+type treeChange struct {
+	path   string
+	old    treeEntry
+	new    treeEntry
+	hasold bool
+	hasnew bool
+}
+
+func readTreeEntries(p storage.ProjectStorageDriver, treeid storage.ObjectID) (map[string]treeEntry, error) {
+	entries := make(map[string]treeEntry)
+	if treeid == storage.ZeroID {
+		return entries, nil
+	}
+	tree, err := readTreeObject(p, treeid)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range tree.entries {
+		entries[entry.name] = entry
+	}
+	return entries, nil
+}
+
+// diffTrees returns the non-tree entries that differ between oldtree and newtree.
+// Either tree can be storage.ZeroID to indicate an empty tree.
+func diffTrees(p storage.ProjectStorageDriver, oldtree, newtree storage.ObjectID, prefix string) ([]treeChange, error) {
+	oldentries, err := readTreeEntries(p, oldtree)
+	if err != nil {
+		return nil, err
+	}
+	newentries, err := readTreeEntries(p, newtree)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range oldentries {
+		names = append(names, name)
+	}
+	for name := range newentries {
+		if _, inold := oldentries[name]; !inold {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []treeChange
+	for _, name := range names {
+		oldentry, hasold := oldentries[name]
+		newentry, hasnew := newentries[name]
+		if hasold && hasnew && oldentry.objectid == newentry.objectid && oldentry.mode == newentry.mode {
+			continue
+		}
+		entrypath := path.Join(prefix, name)
+
+		oldsub, newsub := storage.ZeroID, storage.ZeroID
+		if hasold && oldentry.isTree() {
+			oldsub = oldentry.objectid
+			hasold = false
+		}
+		if hasnew && newentry.isTree() {
+			newsub = newentry.objectid
+			hasnew = false
+		}
+		if oldsub != storage.ZeroID || newsub != storage.ZeroID {
+			subchanges, err := diffTrees(p, oldsub, newsub, entrypath)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, subchanges...)
+		}
+		if hasold || hasnew {
+			changes = append(changes, treeChange{
+				path:   entrypath,
+				old:    oldentry,
+				new:    newentry,
+				hasold: hasold,
+				hasnew: hasnew,
+			})
+		}
+	}
+	return changes, nil
+}
+
+type diffOpType int
+
+const (
+	diffEqual diffOpType = iota
+	diffDelete
+	diffInsert
+)
+
+type diffOp struct {
+	kind diffOpType
+	line string
+}
+
+// diffLines computes the shortest edit script from a to b, using the linear space variant of
+// Myers' algorithm. It fails if more than maxDiffEdits lines would have to change.
+func diffLines(a, b []string) ([]diffOp, bool) {
+	// The furthest a middle snake search gets
+	maxh := (len(a)+len(b)+1)/2 + 1
+	if maxh > maxDiffEdits/2+1 {
+		maxh = maxDiffEdits/2 + 1
+	}
+	df := &lineDiffer{
+		a:   a,
+		b:   b,
+		off: maxh + 1,
+		vf:  make([]int, 2*maxh+3),
+		vb:  make([]int, 2*maxh+3),
+	}
+	if !df.diff(0, len(a), 0, len(b)) {
+		return nil, false
+	}
+
+	// Within each block of changes, show the deleted lines first, like git does
+	ops := df.ops
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+		end := i
+		for end < len(ops) && ops[end].kind != diffEqual {
+			end++
+		}
+		sort.SliceStable(ops[i:end], func(x, y int) bool {
+			return ops[i+x].kind == diffDelete && ops[i+y].kind == diffInsert
+		})
+		i = end
+	}
+	return ops, true
+}
+
+// lineDiffer holds the state of diffLines. vf and vb are shared by all middle snake searches,
+// which only read the diagonals they wrote themselves.
+type lineDiffer struct {
+	a, b   []string
+	off    int
+	vf, vb []int
+	ops    []diffOp
+}
+
+func (df *lineDiffer) emit(kind diffOpType, lines []string) {
+	for _, line := range lines {
+		df.ops = append(df.ops, diffOp{kind: kind, line: line})
+	}
+}
+
+// diff appends the edit script from a[alo:ahi] to b[blo:bhi]
+func (df *lineDiffer) diff(alo, ahi, blo, bhi int) bool {
+	prefix := alo
+	for alo < ahi && blo < bhi && df.a[alo] == df.b[blo] {
+		alo++
+		blo++
+	}
+	df.emit(diffEqual, df.a[prefix:alo])
+	suffix := ahi
+	for alo < ahi && blo < bhi && df.a[ahi-1] == df.b[bhi-1] {
+		ahi--
+		bhi--
+	}
+
+	if alo == ahi {
+		df.emit(diffInsert, df.b[blo:bhi])
+	} else if blo == bhi {
+		df.emit(diffDelete, df.a[alo:ahi])
+	} else {
+		// Both sides start and end with different lines, so the edit distance is at least
+		// two, and the middle snake splits this into two smaller problems
+		x, y, u, v, ok := df.middleSnake(alo, ahi, blo, bhi)
+		if !ok {
+			return false
+		}
+		if !df.diff(alo, x, blo, y) {
+			return false
+		}
+		df.emit(diffEqual, df.a[x:u])
+		if !df.diff(u, ahi, v, bhi) {
+			return false
+		}
+	}
+	df.emit(diffEqual, df.a[ahi:suffix])
+	return true
+}
+
+// middleSnake finds the snake from (x, y) to (u, v) in the middle of a shortest edit script
+// from a[alo:ahi] to b[blo:bhi], by searching from both ends at once
+func (df *lineDiffer) middleSnake(alo, ahi, blo, bhi int) (x, y, u, v int, ok bool) {
+	a, b := df.a, df.b
+	n, m := ahi-alo, bhi-blo
+	delta := n - m
+	odd := delta%2 != 0
+	vf, vb, off := df.vf, df.vb, df.off
+	vf[off+1] = 0
+	vb[off+1] = 0
+
+	for h := 0; off+h+1 < len(vf); h++ {
+		// Forward paths with h edits, on diagonal k = x - y
+		for k := -h; k <= h; k += 2 {
+			var fx int
+			if k == -h || (k != h && vf[off+k-1] < vf[off+k+1]) {
+				fx = vf[off+k+1]
+			} else {
+				fx = vf[off+k-1] + 1
+			}
+			fy := fx - k
+			sx, sy := fx, fy
+			for fx < n && fy < m && a[alo+fx] == b[blo+fy] {
+				fx++
+				fy++
+			}
+			vf[off+k] = fx
+			// The reverse paths with h-1 edits are on diagonals delta - k
+			if odd && k >= delta-(h-1) && k <= delta+(h-1) && fx+vb[off+delta-k] >= n {
+				return alo + sx, blo + sy, alo + fx, blo + fy, true
+			}
+		}
+		// Reverse paths with h edits, counting from the ends
+		for k := -h; k <= h; k += 2 {
+			var rx int
+			if k == -h || (k != h && vb[off+k-1] < vb[off+k+1]) {
+				rx = vb[off+k+1]
+			} else {
+				rx = vb[off+k-1] + 1
+			}
+			ry := rx - k
+			sx, sy := rx, ry
+			for rx < n && ry < m && a[ahi-1-rx] == b[bhi-1-ry] {
+				rx++
+				ry++
+			}
+			vb[off+k] = rx
+			if !odd && k >= delta-h && k <= delta+h && rx+vf[off+delta-k] >= n {
+				return ahi - rx, bhi - ry, ahi - sx, bhi - sy, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// splitLines splits content into lines, keeping the line endings
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func formatHunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// makeHunks groups the edits in ops into unified diff hunks with context lines around them
+func makeHunks(ops []diffOp, context int) []datastructures.APIDiffHunk {
+	var hunks []datastructures.APIDiffHunk
+
+	// oldpos and newpos are the number of lines before each op
+	oldpos := make([]int, len(ops)+1)
+	newpos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldpos[i+1] = oldpos[i]
+		newpos[i+1] = newpos[i]
+		if op.kind != diffInsert {
+			oldpos[i+1]++
+		}
+		if op.kind != diffDelete {
+			newpos[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while the next change is close enough to share context
+		end := i
+		for j := i; j < len(ops) && j <= end+2*context+1; j++ {
+			if ops[j].kind != diffEqual {
+				end = j
+			}
+		}
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		hunk := datastructures.APIDiffHunk{
+			OldStart: oldpos[start] + 1,
+			OldLines: oldpos[stop] - oldpos[start],
+			NewStart: newpos[start] + 1,
+			NewLines: newpos[stop] - newpos[start],
+		}
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		hunk.Header = fmt.Sprintf("@@ -%s +%s @@",
+			formatHunkRange(hunk.OldStart, hunk.OldLines),
+			formatHunkRange(hunk.NewStart, hunk.NewLines),
+		)
+		for _, op := range ops[start:stop] {
+			prefix := " "
+			if op.kind == diffDelete {
+				prefix = "-"
+			} else if op.kind == diffInsert {
+				prefix = "+"
+			}
+			hunk.Lines = append(hunk.Lines, prefix+strings.TrimSuffix(op.line, "\n"))
+			if !strings.HasSuffix(op.line, "\n") {
+				hunk.Lines = append(hunk.Lines, "\\ No newline at end of file")
+			}
+		}
+		hunks = append(hunks, hunk)
+		i = stop
+	}
+	return hunks
+}
+
+// readBlobForDiff returns the contents of a blob, unless it is too large to diff
+func readBlobForDiff(p storage.ProjectStorageDriver, objid storage.ObjectID) (content []byte, toolarge bool, err error) {
+	_, objsize, r, err := p.ReadObject(objid)
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	if objsize > maxDiffBlobSize {
+		return nil, true, nil
+	}
+	content, err = ioutil.ReadAll(r)
+	return content, false, err
+}
+
+func isBinaryContent(content []byte) bool {
+	if len(content) > binaryCheckSize {
+		content = content[:binaryCheckSize]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// getFileDiff computes the diff for a single changed file
+func getFileDiff(p storage.ProjectStorageDriver, change treeChange) (datastructures.APIFileDiff, error) {
+	filediff := datastructures.APIFileDiff{
+		Path:   change.path,
+		Status: "modified",
+	}
+	var oldlines, newlines []string
+	if change.hasold {
+		filediff.OldID = string(change.old.objectid)
+		filediff.OldMode = change.old.gitMode()
+	} else {
+		filediff.Status = "added"
+	}
+	if change.hasnew {
+		filediff.NewID = string(change.new.objectid)
+		filediff.NewMode = change.new.gitMode()
+	} else {
+		filediff.Status = "removed"
+	}
+
+	for _, side := range []struct {
+		has   bool
+		entry treeEntry
+		lines *[]string
+	}{
+		{change.hasold, change.old, &oldlines},
+		{change.hasnew, change.new, &newlines},
+	} {
+		if !side.has || side.entry.isSubmodule() {
+			continue
+		}
+		content, toolarge, err := readBlobForDiff(p, side.entry.objectid)
+		if err != nil {
+			return filediff, err
+		}
+		if toolarge {
+			filediff.TooLarge = true
+			return filediff, nil
+		}
+		if isBinaryContent(content) {
+			filediff.Binary = true
+			return filediff, nil
+		}
+		*side.lines = splitLines(content)
+	}
+
+	ops, ok := diffLines(oldlines, newlines)
+	if !ok {
+		filediff.TooLarge = true
+		return filediff, nil
+	}
+	filediff.Hunks = makeHunks(ops, diffContextLines)
+	return filediff, nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcsLength returns the length of the longest common subsequence of a and b
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] > cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func checkDiff(t *testing.T, a, b []string) {
+	ops, ok := diffLines(a, b)
+	if !ok {
+		t.Fatalf("Diff of %v and %v failed", a, b)
+	}
+	var olda, newb []string
+	edits := 0
+	for _, op := range ops {
+		if op.kind != diffInsert {
+			olda = append(olda, op.line)
+		}
+		if op.kind != diffDelete {
+			newb = append(newb, op.line)
+		}
+		if op.kind != diffEqual {
+			edits++
+		}
+	}
+	if strings.Join(olda, "") != strings.Join(a, "") || strings.Join(newb, "") != strings.Join(b, "") {
+		t.Fatalf("Diff of %v and %v does not reproduce them: %v", a, b, ops)
+	}
+	if expected := len(a) + len(b) - 2*lcsLength(a, b); edits != expected {
+		t.Fatalf("Diff of %v and %v has %d edits instead of %d", a, b, edits, expected)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	checkDiff(t, nil, nil)
+	checkDiff(t, []string{"a\n"}, nil)
+	checkDiff(t, nil, []string{"a\n"})
+	checkDiff(t, []string{"a\n", "b\n", "c\n"}, []string{"a\n", "x\n", "c\n"})
+
+	rnd := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rnd.Intn(20))
+		for i := range lines {
+			lines[i] = string('a'+rune(rnd.Intn(4))) + "\n"
+		}
+		return lines
+	}
+	for i := 0; i < 5000; i++ {
+		checkDiff(t, randomLines(), randomLines())
+	}
+}
+
+func TestDiffLinesTooLarge(t *testing.T) {
+	a := make([]string, maxDiffEdits)
+	b := make([]string, maxDiffEdits)
+	for i := range a {
+		a[i] = "a\n"
+		b[i] = "b\n"
+	}
+	if _, ok := diffLines(a, b); ok {
+		t.Fatal("Diff with too many edits succeeded")
+	}
+	if _, ok := diffLines(a, a[1:]); !ok {
+		t.Fatal("Diff of long similar files failed")
+	}
+}
+
+func TestMakeHunks(t *testing.T) {
+	var a []string
+	for i := 1; i <= 20; i++ {
+		a = append(a, strings.Repeat("x", i)+"\n")
+	}
+	b := append([]string(nil), a...)
+	b[1] = "changed\n"
+	b[3] = "changed too\n"
+	b = append(b[:15], b[16:]...)
+	b[len(b)-1] = "no newline"
+
+	ops, ok := diffLines(a, b)
+	if !ok {
+		t.Fatal("Diff failed")
+	}
+	hunks := makeHunks(ops, 3)
+	if len(hunks) != 2 {
+		t.Fatalf("Expected 2 hunks, got %d: %v", len(hunks), hunks)
+	}
+	if hunks[0].Header != "@@ -1,7 +1,7 @@" {
+		t.Errorf("Unexpected first hunk header %s", hunks[0].Header)
+	}
+	if hunks[1].Header != "@@ -13,8 +13,7 @@" {
+		t.Errorf("Unexpected second hunk header %s", hunks[1].Header)
+	}
+	last := hunks[1].Lines[len(hunks[1].Lines)-2:]
+	if last[0] != "+no newline" || last[1] != "\\ No newline at end of file" {
+		t.Errorf("Unexpected end of hunk: %v", last)
+	}
+	if len(makeHunks(ops[:1], 3)) != 0 {
+		t.Error("Hunks for unchanged lines")
+	}
+}
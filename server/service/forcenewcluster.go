@@ -0,0 +1,121 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal"
+	"github.com/pkg/errors"
+)
+
+// getRaftMembers returns the voters and learners of the region after the snapshot and entries
+func getRaftMembers(snapshot *raftpb.Snapshot, ents []raftpb.Entry) (voters, learners map[uint64]bool) {
+	voters = make(map[uint64]bool)
+	learners = make(map[uint64]bool)
+	if snapshot != nil {
+		for _, id := range snapshot.Metadata.ConfState.Nodes {
+			voters[id] = true
+		}
+		for _, id := range snapshot.Metadata.ConfState.Learners {
+			learners[id] = true
+		}
+	}
+
+	for _, ent := range ents {
+		if ent.Type != raftpb.EntryConfChange {
+			continue
+		}
+		var cc raftpb.ConfChange
+		cc.Unmarshal(ent.Data)
+		switch cc.Type {
+		case raftpb.ConfChangeAddNode:
+			voters[cc.NodeID] = true
+			delete(learners, cc.NodeID)
+		case raftpb.ConfChangeAddLearnerNode:
+			learners[cc.NodeID] = true
+		case raftpb.ConfChangeRemoveNode:
+			delete(voters, cc.NodeID)
+			delete(learners, cc.NodeID)
+		}
+	}
+	return
+}
+
+// forceNewCluster discards the entries that were not committed, and commits the removal of
+// all other members, so that this node forms a region of its own. It returns the new hard
+// state and entries.
+func (rc *stateRaftNode) forceNewCluster(w *wal.WAL, snapshot *raftpb.Snapshot, st raftpb.HardState, ents []raftpb.Entry) (raftpb.HardState, []raftpb.Entry, error) {
+	for i, ent := range ents {
+		if ent.Index > st.Commit {
+			ents = ents[:i]
+			break
+		}
+	}
+
+	nodeid := rc.store.cfg.nodeid
+	voters, learners := getRaftMembers(snapshot, ents)
+	var remove []uint64
+	for id := range voters {
+		if id != nodeid {
+			remove = append(remove, id)
+		}
+	}
+	for id := range learners {
+		if id != nodeid {
+			remove = append(remove, id)
+		}
+	}
+	sort.Slice(remove, func(i, j int) bool { return remove[i] < remove[j] })
+
+	index := st.Commit
+	if snapshot != nil && snapshot.Metadata.Index > index {
+		index = snapshot.Metadata.Index
+	}
+	var forced []raftpb.Entry
+	appendConfChange := func(cc raftpb.ConfChange) error {
+		data, err := cc.Marshal()
+		if err != nil {
+			return err
+		}
+		index++
+		forced = append(forced, raftpb.Entry{
+			Type:  raftpb.EntryConfChange,
+			Term:  st.Term,
+			Index: index,
+			Data:  data,
+		})
+		return nil
+	}
+
+	for _, id := range remove {
+		err := appendConfChange(raftpb.ConfChange{
+			Type:   raftpb.ConfChangeRemoveNode,
+			NodeID: id,
+		})
+		if err != nil {
+			return st, nil, err
+		}
+	}
+	if !voters[nodeid] {
+		// A learner has to become a voter to form a region
+		err := appendConfChange(raftpb.ConfChange{
+			Type:    raftpb.ConfChangeAddNode,
+			NodeID:  nodeid,
+			Context: []byte(rc.store.Peers[nodeid]),
+		})
+		if err != nil {
+			return st, nil, err
+		}
+	}
+
+	// Commit the changes right away, the other members are not around to agree to them
+	st.Commit = index
+	if err := w.Save(st, forced); err != nil {
+		return st, nil, errors.Wrap(err, "Error saving forced membership changes")
+	}
+	rc.store.cfg.log.Warnw("Forced new cluster",
+		"removed", remove,
+		"index", index,
+	)
+	return st, append(ents, forced...), nil
+}
@@ -178,6 +178,49 @@ func (s sideBandSender) Write(packet []byte) (int, error) {
 	return len(packet), sendSideBandPacket(s.w, s.sbstatus, s.sb, packet)
 }
 
+// sideBandReader reads the data of a side-band-64k response until the flush packet,
+// skipping progress messages
+type sideBandReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (s *sideBandReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		rawlen := make([]byte, 4)
+		if _, err := io.ReadFull(s.r, rawlen); err != nil {
+			return 0, err
+		}
+		pktlen, err := strconv.ParseUint(string(rawlen), 16, 16)
+		if err != nil {
+			return 0, err
+		}
+		if pktlen == 0 {
+			return 0, io.EOF
+		}
+		if pktlen <= 5 {
+			return 0, fmt.Errorf("Invalid side-band packet length %d", pktlen)
+		}
+		pkt := make([]byte, pktlen-4)
+		if _, err := io.ReadFull(s.r, pkt); err != nil {
+			return 0, err
+		}
+		switch sideBand(pkt[0]) {
+		case sideBandData:
+			s.buf = pkt[1:]
+		case sideBandProgress:
+			continue
+		case sideBandFatal:
+			return 0, fmt.Errorf("Remote error: %s", strings.TrimSpace(string(pkt[1:])))
+		default:
+			return 0, fmt.Errorf("Invalid side-band %d", pkt[0])
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
 var extensions = []string{
 	"delete-refs",
 	"no-thin",
@@ -189,6 +232,7 @@ var extensions = []string{
 	"multi_ack",
 	"multi_ack_detailed",
 	"allow-reachable-sha1-in-want",
+	"include-tag",
 	"agent=repoSpanner/" + constants.PublicVersionString(),
 }
 
@@ -1142,6 +1186,50 @@ func validateObjects(p storage.ProjectStorageDriver, toupdate *pb.PushRequest, r
 	return nil
 }
 
+// addPeeledTargets records the peeled object for every annotated tag that gets pushed,
+// so that it can be advertised without having to read the tag objects.
+func addPeeledTargets(p storage.ProjectStorageDriver, toupdate *pb.PushRequest) error {
+	for _, updinfo := range toupdate.Requests {
+		if updinfo.ToObject() == storage.ZeroID {
+			continue
+		}
+		peeled, istag, err := peelTag(p, updinfo.ToObject())
+		if err != nil {
+			return err
+		}
+		if istag {
+			peeledS := string(peeled)
+			updinfo.Peeled = &peeledS
+		}
+	}
+	return nil
+}
+
+// peelTag follows a chain of tag objects until it finds a non-tag object.
+func peelTag(p storage.ProjectStorageDriver, objid storage.ObjectID) (peeled storage.ObjectID, istag bool, err error) {
+	peeled = objid
+	for {
+		objtype, _, r, err := p.ReadObject(peeled)
+		if err != nil {
+			return storage.ZeroID, false, err
+		}
+		if objtype != storage.ObjectTypeTag {
+			r.Close()
+			return peeled, istag, nil
+		}
+		taginf, err := readTag(r)
+		r.Close()
+		if err != nil {
+			return storage.ZeroID, false, err
+		}
+		if taginf.object == "" {
+			return storage.ZeroID, false, errors.New("Tag without object")
+		}
+		istag = true
+		peeled = taginf.object
+	}
+}
+
 func validateCommitOrTag(p storage.ProjectStorageDriver, commitstart storage.ObjectID, commitend storage.ObjectID, allowtag bool, recursive bool) error {
 	if commitstart == commitend {
 		// We assume that the refto is already in our database.
@@ -1261,22 +1349,31 @@ func validateTree(p storage.ProjectStorageDriver, treeid storage.ObjectID, seent
 	return nil
 }
 
-// TODO: Replace objectIDSearch with a binary search tree
 type objectIDSearcher interface {
 	Add(o storage.ObjectID)
 	Contains(o storage.ObjectID) bool
 	List() []storage.ObjectID
 }
+
+// objectIDSearch is a set of object IDs that remembers the order in which they were added
 type objectIDSearch struct {
 	s []storage.ObjectID
+	m map[storage.ObjectID]struct{}
 }
 
 func newObjectIDSearch() objectIDSearcher {
-	return &objectIDSearch{[]storage.ObjectID{}}
+	return &objectIDSearch{
+		s: []storage.ObjectID{},
+		m: make(map[storage.ObjectID]struct{}),
+	}
 }
 
 func newObjectIDSearchFromSlice(o []storage.ObjectID) objectIDSearcher {
-	return &objectIDSearch{o}
+	search := newObjectIDSearch()
+	for _, oid := range o {
+		search.Add(oid)
+	}
+	return search
 }
 
 func (t *objectIDSearch) Add(o storage.ObjectID) {
@@ -1284,15 +1381,12 @@ func (t *objectIDSearch) Add(o storage.ObjectID) {
 		return
 	}
 	t.s = append(t.s, o)
+	t.m[o] = struct{}{}
 }
 
 func (t *objectIDSearch) Contains(o storage.ObjectID) bool {
-	for _, oid := range t.s {
-		if oid == o {
-			return true
-		}
-	}
-	return false
+	_, contains := t.m[o]
+	return contains
 }
 
 func (t *objectIDSearch) List() []storage.ObjectID {
@@ -1470,7 +1564,10 @@ func buildPackHeader(numo uint32) (buf []byte) {
 	return
 }
 
-func writeTemporaryPackFile(p storage.ProjectStorageDriver, commits []storage.ObjectID, commonobjects objectIDSearcher, recursive bool) (packfile *os.File, numobjects uint32, err error) {
+// writeTemporaryPackFile writes a packfile with all objects required for commits.
+// tags maps annotated tags to their peeled objects: any tag pointing at an object that
+// ended up in the pack is included as well (for the include-tag capability).
+func writeTemporaryPackFile(p storage.ProjectStorageDriver, commits []storage.ObjectID, commonobjects objectIDSearcher, recursive bool, tags map[storage.ObjectID]storage.ObjectID) (packfile *os.File, numobjects uint32, err error) {
 	packfile, err = ioutil.TempFile("", "repospanner_pack_")
 	if err != nil {
 		return
@@ -1504,6 +1601,18 @@ func writeTemporaryPackFile(p storage.ProjectStorageDriver, commits []storage.Ob
 		numobjects += numObjectsInCommit
 	}
 
+	for tag, peeled := range tags {
+		if !written.Contains(peeled) {
+			continue
+		}
+		var numObjectsInTag uint32
+		numObjectsInTag, err = writeCommitOrTagToPack(packfile, p, tag, written, commonobjects, false)
+		if err != nil {
+			return
+		}
+		numobjects += numObjectsInTag
+	}
+
 	if len(written.List()) != int(numobjects) {
 		return nil, 0, fmt.Errorf("written != numwritten: %d != %d", len(written.List()), numobjects)
 	}
@@ -1511,6 +1620,59 @@ func writeTemporaryPackFile(p storage.ProjectStorageDriver, commits []storage.Ob
 	return
 }
 
+// writeObjectsToTemporaryPackFile writes a packfile with exactly the objects listed, undeltified
+func writeObjectsToTemporaryPackFile(p storage.ProjectStorageDriver, objects []storage.ObjectID) (packfile *os.File, numobjects uint32, err error) {
+	packfile, err = ioutil.TempFile("", "repospanner_pack_")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			// We are going to return an error, clean up the file
+			packfile.Close()
+		} else {
+			// Make sure to seek to the front of the file
+			var off int64
+			off, err = packfile.Seek(0, 0)
+			if off != 0 {
+				err = errors.New("Seek() did not go to the start of the file")
+			}
+		}
+	}()
+	// We want to unlink it as soon as possible, so that if something goes wrong, we don't keep it around
+	err = os.Remove(packfile.Name())
+	if err != nil {
+		return
+	}
+
+	for _, objid := range objects {
+		err = writeObjectToPack(packfile, p, objid)
+		if err != nil {
+			return
+		}
+		numobjects++
+	}
+	return
+}
+
+func writeObjectToPack(w io.Writer, p storage.ProjectStorageDriver, objid storage.ObjectID) error {
+	objtype, objsize, r, err := p.ReadObject(objid)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	err = writeObjectHeader(w, objtype, objsize)
+	if err != nil {
+		return err
+	}
+	zwriter := zlib.NewWriter(w)
+	_, err = flushToFrom(zwriter, r, objsize)
+	if err != nil {
+		return err
+	}
+	return zwriter.Close()
+}
+
 func flushToFrom(w io.Writer, r io.Reader, expected uint) (int, error) {
 	totalsent := 0
 	for {
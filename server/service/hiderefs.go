@@ -0,0 +1,256 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// parseHideRefs parses a comma-separated list of hiderefs patterns, as passed in
+// repo update requests.
+func parseHideRefs(val string) []string {
+	var rules []string
+	for _, rule := range strings.Split(val, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// hideRefPatternMatches follows the transfer.hideRefs semantics of git: a pattern
+// matches a ref if it is the full refname or a prefix of it up to a slash.
+// Additionally, glob patterns (e.g. refs/pipelines/*) are accepted.
+func hideRefPatternMatches(pattern, refname string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(refname, pattern)
+	}
+	if refname == pattern || strings.HasPrefix(refname, pattern+"/") {
+		return true
+	}
+	return lineMatches(pattern, refname)
+}
+
+// isRefHidden determines whether refname is hidden by the rules.
+// Rules prefixed with "!" unhide refs, and the last matching rule wins.
+func isRefHidden(rules []string, refname string) bool {
+	hidden := false
+	for _, rule := range rules {
+		negated := strings.HasPrefix(rule, "!")
+		if negated {
+			rule = rule[1:]
+		}
+		if hideRefPatternMatches(rule, refname) {
+			hidden = !negated
+		}
+	}
+	return hidden
+}
+
+// getHideRefs returns the hiderefs rules for a repo: the cluster-wide rules, followed
+// by the per-repo rules, so that the latter can override the former.
+func (cfg *Service) getHideRefs(reponame string) []string {
+	rules := viper.GetStringSlice("transfer.hiderefs")
+	return append(rules, cfg.statestore.GetRepoHideRefs(reponame)...)
+}
+
+func (cfg *Service) canSeeHiddenRefs(perminfo permissionInfo, reponame string) bool {
+	return cfg.checkAccess(perminfo, reponame, constants.CertPermissionAdmin)
+}
+
+// getAdvertisedRefs returns the refs and symrefs that should be advertised to the
+// requester, with any hidden refs filtered out.
+func (cfg *Service) getAdvertisedRefs(perminfo permissionInfo, reponame string, fakerefs bool) (map[string]string, map[string]string) {
+	refs := cfg.statestore.getGitRefs(reponame)
+	symrefs := cfg.statestore.getSymRefs(reponame)
+
+	rules := cfg.getHideRefs(reponame)
+	if len(rules) != 0 && !cfg.canSeeHiddenRefs(perminfo, reponame) {
+		visiblerefs := make(map[string]string)
+		for refname, refval := range refs {
+			if !isRefHidden(rules, refname) {
+				visiblerefs[refname] = refval
+			}
+		}
+		visiblesymrefs := make(map[string]string)
+		for symref, target := range symrefs {
+			if !isRefHidden(rules, target) {
+				visiblesymrefs[symref] = target
+			}
+		}
+		refs = visiblerefs
+		symrefs = visiblesymrefs
+	}
+
+	if fakerefs {
+		// The fake refs are only ever requested by the hook runner over RPC, which
+		// needs them to retrieve the objects of the push under consideration.
+		frefs, hasfrefs := cfg.statestore.fakerefs[reponame]
+		if hasfrefs {
+			realrefs := refs
+			refs = make(map[string]string)
+			for refname, refval := range realrefs {
+				refs[refname] = refval
+			}
+			for refname, refval := range frefs {
+				refs[refname] = refval
+			}
+		}
+	}
+
+	// Symrefs can point at branches that don't exist (yet), like HEAD in an empty repo
+	existingsymrefs := make(map[string]string)
+	for symref, target := range symrefs {
+		if _, exists := refs[target]; exists {
+			existingsymrefs[symref] = target
+		}
+	}
+	symrefs = existingsymrefs
+
+	return refs, symrefs
+}
+
+// getAdvertisedTags returns the annotated tags advertised to the requester, mapped to
+// the objects they peel to.
+func (cfg *Service) getAdvertisedTags(perminfo permissionInfo, reponame string, fakerefs bool) map[storage.ObjectID]storage.ObjectID {
+	refs, _ := cfg.getAdvertisedRefs(perminfo, reponame, fakerefs)
+	peeled := cfg.peelAdvertisedRefs(cfg.gitstore.GetProjectStorage(reponame), reponame, refs)
+
+	tags := make(map[storage.ObjectID]storage.ObjectID)
+	for refname, peeledval := range peeled {
+		refval, advertised := refs[refname]
+		if advertised {
+			tags[storage.ObjectID(refval)] = storage.ObjectID(peeledval)
+		}
+	}
+	return tags
+}
+
+// peelAdvertisedRefs returns the objects that the annotated tags among refs peel to.
+// Tags pushed before peeled objects got recorded in the state are peeled from storage.
+func (cfg *Service) peelAdvertisedRefs(p storage.ProjectStorageDriver, reponame string, refs map[string]string) map[string]string {
+	peeled := cfg.statestore.getPeeledRefs(reponame)
+	for refname, refval := range refs {
+		if _, recorded := peeled[refname]; recorded || !strings.HasPrefix(refname, "refs/tags/") {
+			continue
+		}
+		target, err := cfg.peelUnrecordedTag(p, storage.ObjectID(refval))
+		if err != nil {
+			cfg.log.Infow("Error peeling tag",
+				"repo", reponame,
+				"ref", refname,
+				"error", err,
+			)
+			continue
+		}
+		if target != storage.ObjectID(refval) {
+			peeled[refname] = string(target)
+		}
+	}
+	return peeled
+}
+
+// peelUnrecordedTag peels objid, returning objid itself if it is not a tag.
+// Objects are immutable, so the result is cached for subsequent requests.
+func (cfg *Service) peelUnrecordedTag(p storage.ProjectStorageDriver, objid storage.ObjectID) (storage.ObjectID, error) {
+	cfg.peeledTagsMux.Lock()
+	target, cached := cfg.peeledTags[objid]
+	cfg.peeledTagsMux.Unlock()
+	if cached {
+		return target, nil
+	}
+
+	target, _, err := peelTag(p, objid)
+	if err != nil {
+		return storage.ZeroID, err
+	}
+
+	cfg.peeledTagsMux.Lock()
+	defer cfg.peeledTagsMux.Unlock()
+	if cfg.peeledTags == nil {
+		cfg.peeledTags = make(map[storage.ObjectID]storage.ObjectID)
+	}
+	cfg.peeledTags[objid] = target
+	return target, nil
+}
+
+// checkWantsVisible verifies that all wants are reachable from the advertised refs,
+// so that hidden refs can't be fetched by object ID.
+// It returns the first want that is not, or storage.ZeroID if all wants are fine.
+func (cfg *Service) checkWantsVisible(p storage.ProjectStorageDriver, perminfo permissionInfo, reponame string, fakerefs bool, wants []storage.ObjectID) (storage.ObjectID, error) {
+	if len(cfg.getHideRefs(reponame)) == 0 || cfg.canSeeHiddenRefs(perminfo, reponame) {
+		// Nothing is hidden from this requester
+		return storage.ZeroID, nil
+	}
+
+	refs, _ := cfg.getAdvertisedRefs(perminfo, reponame, fakerefs)
+	tips := newObjectIDSearch()
+	for _, refval := range refs {
+		tips.Add(storage.ObjectID(refval))
+	}
+
+	var reachable objectIDSearcher
+	for _, want := range wants {
+		if tips.Contains(want) {
+			continue
+		}
+		if reachable == nil {
+			// Only walk the history if there was a want that isn't an advertised tip
+			reachable = newObjectIDSearch()
+			for _, tip := range tips.List() {
+				if err := addReachableCommits(p, tip, reachable); err != nil {
+					return storage.ZeroID, err
+				}
+			}
+		}
+		if !reachable.Contains(want) {
+			return want, nil
+		}
+	}
+	return storage.ZeroID, nil
+}
+
+// addReachableCommits adds all tags and commits reachable from objid to reachable.
+func addReachableCommits(p storage.ProjectStorageDriver, objid storage.ObjectID, reachable objectIDSearcher) error {
+	tovisit := []storage.ObjectID{objid}
+	for len(tovisit) != 0 {
+		objid = tovisit[len(tovisit)-1]
+		tovisit = tovisit[:len(tovisit)-1]
+		if reachable.Contains(objid) {
+			continue
+		}
+		reachable.Add(objid)
+
+		objtype, _, r, err := p.ReadObject(objid)
+		if err != nil {
+			return err
+		}
+		if objtype == storage.ObjectTypeTag {
+			tag, err := readTag(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			if tag.object != "" {
+				tovisit = append(tovisit, tag.object)
+			}
+		} else if objtype == storage.ObjectTypeCommit {
+			commit, err := readCommit(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			tovisit = append(tovisit, commit.parents...)
+		} else {
+			r.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+func TestIsRefHidden(t *testing.T) {
+	rules := []string{"refs/pipelines", "refs/changes/*", "!refs/changes/public"}
+
+	cases := map[string]bool{
+		"refs/heads/master":         false,
+		"refs/pipelines":            true,
+		"refs/pipelines/1":          true,
+		"refs/pipelinesfoo":         false,
+		"refs/changes/1/2":          true,
+		"refs/changes/public":       false,
+		"refs/changes/public/thing": false,
+	}
+
+	for refname, expected := range cases {
+		if isRefHidden(rules, refname) != expected {
+			t.Errorf("Ref %s: expected hidden=%t", refname, expected)
+		}
+	}
+
+	if isRefHidden(nil, "refs/heads/master") {
+		t.Error("Ref hidden without rules")
+	}
+}
+
+func TestParseHideRefs(t *testing.T) {
+	rules := parseHideRefs(" refs/a, ,!refs/b ")
+	if len(rules) != 2 || rules[0] != "refs/a" || rules[1] != "!refs/b" {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+	if len(parseHideRefs("")) != 0 {
+		t.Fatal("Empty string should clear rules")
+	}
+}
+
+func TestPeelAdvertisedRefs(t *testing.T) {
+	driver, _, cleanup := newTestStorage(t)
+	defer cleanup()
+	p := driver.GetProjectStorage("repo")
+	pusher := p.GetPusher("")
+	tree := writeTestTree(t, pusher, fileEntry("file", writeTestBlob(t, pusher, "file\n")))
+	commit := writeTestCommit(t, pusher, tree, "Commit")
+	tag := writeTestTag(t, pusher, commit, "v1")
+	newtag := writeTestTag(t, pusher, commit, "v2")
+
+	refs := map[string]string{
+		"refs/heads/master": string(commit),
+		"refs/tags/light":   string(commit),
+		"refs/tags/v1":      string(tag),
+		"refs/tags/v2":      string(newtag),
+	}
+	info := datastructures.RepoInfo{
+		Refs:   refs,
+		Peeled: map[string]string{"refs/tags/v2": string(commit)},
+	}
+	cfg := &Service{
+		log:        zap.NewNop().Sugar(),
+		gitstore:   driver,
+		statestore: &stateStore{repoinfos: map[string]datastructures.RepoInfo{"repo": info}},
+	}
+
+	for i := 0; i < 2; i++ {
+		peeled := cfg.peelAdvertisedRefs(p, "repo", refs)
+		if len(peeled) != 2 || peeled["refs/tags/v1"] != string(commit) || peeled["refs/tags/v2"] != string(commit) {
+			t.Errorf("Peeled refs are %v", peeled)
+		}
+	}
+	if len(cfg.peeledTags) != 2 || cfg.peeledTags[tag] != commit || cfg.peeledTags[commit] != commit {
+		t.Errorf("Peeled tag cache is %v", cfg.peeledTags)
+	}
+	if _, recorded := cfg.statestore.repoinfos["repo"].Peeled["refs/tags/v1"]; recorded {
+		t.Error("Lazily peeled tag got recorded in the state")
+	}
+}
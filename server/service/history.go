@@ -0,0 +1,133 @@
+package service
+
+import (
+	"container/heap"
+	"time"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+type historyEntry struct {
+	id      storage.ObjectID
+	commit  commitInfo
+	message string
+	when    time.Time
+}
+
+// historyQueue is a priority queue returning the most recent commit first
+type historyQueue []*historyEntry
+
+func (q historyQueue) Len() int            { return len(q) }
+func (q historyQueue) Less(i, j int) bool  { return q[i].when.After(q[j].when) }
+func (q historyQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *historyQueue) Push(x interface{}) { *q = append(*q, x.(*historyEntry)) }
+func (q *historyQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+func readHistoryEntry(p storage.ProjectStorageDriver, id storage.ObjectID) (*historyEntry, error) {
+	_, _, r, err := p.ReadObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	commit, message, err := readCommitWithMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	entry := &historyEntry{
+		id:      id,
+		commit:  commit,
+		message: message,
+	}
+	if committer, err := parseSignature(commit.committer); err == nil {
+		entry.when = committer.when
+	}
+	return entry, nil
+}
+
+// getPathObject returns the object ID at path in tree, or storage.ZeroID if it doesn't exist
+func getPathObject(p storage.ProjectStorageDriver, tree storage.ObjectID, path string) (storage.ObjectID, error) {
+	entry, err := findTreeEntry(p, tree, path)
+	if err == errPathNotFound {
+		return storage.ZeroID, nil
+	}
+	return entry.objectid, err
+}
+
+// walkHistory visits the commits reachable from start, most recent first, until visit returns false.
+// Commits in hidden (and their ancestors) are not visited.
+// If path is not empty, only commits changing that path are visited, following git's default
+// history simplification: merges that didn't change the path only follow the unchanged parent.
+func walkHistory(p storage.ProjectStorageDriver, start []storage.ObjectID, hidden objectIDSearcher, path string, visit func(*historyEntry) (bool, error)) error {
+	seen := newObjectIDSearch()
+	queue := &historyQueue{}
+	add := func(id storage.ObjectID) error {
+		if seen.Contains(id) || (hidden != nil && hidden.Contains(id)) {
+			return nil
+		}
+		seen.Add(id)
+		entry, err := readHistoryEntry(p, id)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, entry)
+		return nil
+	}
+	for _, id := range start {
+		if err := add(id); err != nil {
+			return err
+		}
+	}
+
+	for queue.Len() != 0 {
+		entry := heap.Pop(queue).(*historyEntry)
+		interesting := true
+		follow := entry.commit.parents
+
+		if path != "" {
+			pathobj, err := getPathObject(p, entry.commit.tree, path)
+			if err != nil {
+				return err
+			}
+			if len(entry.commit.parents) == 0 {
+				interesting = pathobj != storage.ZeroID
+			}
+			for _, parent := range entry.commit.parents {
+				_, parentcommit, err := peelToCommit(p, parent)
+				if err != nil {
+					return err
+				}
+				parentobj, err := getPathObject(p, parentcommit.tree, path)
+				if err != nil {
+					return err
+				}
+				if parentobj == pathobj {
+					// This parent is the same for our path: only follow this one
+					interesting = false
+					follow = []storage.ObjectID{parent}
+					break
+				}
+			}
+		}
+
+		if interesting {
+			cont, err := visit(entry)
+			if err != nil {
+				return err
+			}
+			if !cont {
+				return nil
+			}
+		}
+		for _, parent := range follow {
+			if err := add(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"strings"
 	"time"
 
+	"go.uber.org/zap"
+
 	"repospanner.org/repospanner/server/constants"
 	"repospanner.org/repospanner/server/datastructures"
 	"repospanner.org/repospanner/server/storage"
@@ -21,7 +24,8 @@ func (cfg *Service) getNodeInfo() datastructures.NodeInfo {
 		RegionName:  cfg.region,
 		ClusterName: cfg.cluster,
 		Version:     constants.VersionString(),
-		Peers:       cfg.statestore.Peers,
+		Peers:       cfg.statestore.getPeers(),
+		Learners:    cfg.statestore.getLearners(),
 	}
 }
 
@@ -64,6 +68,11 @@ func (cfg *Service) serveAdminNodeInfo(w http.ResponseWriter, r *http.Request) {
 	cfg.respondJSONResponse(w, info)
 }
 
+func (cfg *Service) serveAdminClusterStatus(w http.ResponseWriter, r *http.Request) {
+	status := cfg.getClusterStatus()
+	cfg.respondJSONResponse(w, status)
+}
+
 func (cfg *Service) serveAdminCreateRepo(w http.ResponseWriter, r *http.Request) {
 	var createreporequest datastructures.RepoRequestInfo
 	if cont := cfg.parseJSONRequest(w, r, &createreporequest); !cont {
@@ -73,6 +82,7 @@ func (cfg *Service) serveAdminCreateRepo(w http.ResponseWriter, r *http.Request)
 	err := cfg.statestore.createRepo(
 		createreporequest.Reponame,
 		createreporequest.Public,
+		createreporequest.DefaultBranch,
 	)
 	if err != nil {
 		w.WriteHeader(200)
@@ -96,6 +106,28 @@ func (cfg *Service) serveAdminEditRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := cfg.statestore.checkWritable(editreporequest.Reponame); err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	for _, validate := range []func(datastructures.RepoUpdateRequest) error{
+		validateSymrefUpdates,
+		validateBranchProtectionUpdates,
+	} {
+		if err := validate(editreporequest); err != nil {
+			w.WriteHeader(200)
+			cfg.respondJSONResponse(w, datastructures.CommandResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
 	remarshal, err := json.Marshal(editreporequest)
 	if err != nil {
 		w.WriteHeader(200)
@@ -126,10 +158,173 @@ func (cfg *Service) serveAdminEditRepo(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (cfg *Service) serveAdminDeleteRepo(w http.ResponseWriter, r *http.Request) {
+	var deletereporequest datastructures.RepoDeleteRequest
+	if cont := cfg.parseJSONRequest(w, r, &deletereporequest); !cont {
+		return
+	}
+
+	err := cfg.statestore.checkWritable(deletereporequest.Reponame)
+	if err == nil {
+		err = cfg.statestore.deleteRepo(
+			deletereporequest.Reponame,
+			deletereporequest.Purge,
+		)
+	}
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: true,
+	})
+}
+
+func (cfg *Service) serveAdminUndeleteRepo(w http.ResponseWriter, r *http.Request) {
+	var undeletereporequest datastructures.RepoRequestInfo
+	if cont := cfg.parseJSONRequest(w, r, &undeletereporequest); !cont {
+		return
+	}
+
+	err := cfg.statestore.checkWritable(undeletereporequest.Reponame)
+	if err == nil {
+		err = cfg.statestore.undeleteRepo(undeletereporequest.Reponame)
+	}
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: true,
+	})
+}
+
+func (cfg *Service) serveAdminRenameRepo(w http.ResponseWriter, r *http.Request) {
+	var renamereporequest datastructures.RepoRenameRequest
+	if cont := cfg.parseJSONRequest(w, r, &renamereporequest); !cont {
+		return
+	}
+
+	err := cfg.statestore.checkWritable(renamereporequest.Reponame)
+	if err == nil {
+		err = cfg.statestore.renameRepo(
+			renamereporequest.Reponame,
+			renamereporequest.NewName,
+		)
+	}
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: true,
+	})
+}
+
+func (cfg *Service) serveAdminReflog(w http.ResponseWriter, r *http.Request) {
+	var reflogrequest datastructures.RepoReflogRequest
+	if cont := cfg.parseJSONRequest(w, r, &reflogrequest); !cont {
+		return
+	}
+
+	entries, err := cfg.statestore.getReflog(reflogrequest.Reponame, reflogrequest.Ref)
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.RepoReflog{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.RepoReflog{
+		Success: true,
+		Entries: entries,
+	})
+}
+
+func (cfg *Service) serveAdminRestoreRepo(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger) {
+	var restorerequest datastructures.RepoRestoreRequest
+	if cont := cfg.parseJSONRequest(w, r, &restorerequest); !cont {
+		return
+	}
+
+	summary, err := cfg.restoreRefs(reqlogger, restorerequest, perminfo.Username, r.RemoteAddr)
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: true,
+		Info:    summary,
+	})
+}
+
+func (cfg *Service) serveAdminRemoveNode(w http.ResponseWriter, r *http.Request) {
+	var removenoderequest datastructures.NodeRemoveRequest
+	if cont := cfg.parseJSONRequest(w, r, &removenoderequest); !cont {
+		return
+	}
+
+	err := cfg.statestore.removeNode(removenoderequest.NodeID)
+	if err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: true,
+	})
+}
+
+func (cfg *Service) serveAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	var snapshotrequest struct{}
+	if cont := cfg.parseJSONRequest(w, r, &snapshotrequest); !cont {
+		return
+	}
+
+	res := cfg.statestore.raftnode.forceSnapshot()
+	if res.err != nil {
+		cfg.respondJSONResponse(w, datastructures.CommandResponse{
+			Success: false,
+			Error:   res.err.Error(),
+		})
+		return
+	}
+
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: true,
+		Info: fmt.Sprintf("Snapshot at index %d, removed %d WAL and %d snapshot files",
+			res.index,
+			res.removedWALs,
+			res.removedSnaps,
+		),
+	})
+}
+
 func (cfg *Service) serveAdminListRepos(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	cfg.respondJSONResponse(w, datastructures.RepoList{
-		Repos: cfg.statestore.GetRepos(),
+		Repos:        cfg.statestore.GetRepos(),
+		DeletedRepos: cfg.statestore.GetDeletedRepos(),
 	})
 	return
 }
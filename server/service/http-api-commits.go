@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// serverPushError is an error in a push created by the server itself, with the HTTP status to return
+type serverPushError struct {
+	status  int
+	message string
+}
+
+func (e serverPushError) Error() string {
+	return e.message
+}
+
+// getBranchRefName expands a short branch name to a full ref name
+func getBranchRefName(branch string) string {
+	if strings.HasPrefix(branch, "refs/") {
+		return branch
+	}
+	return "refs/heads/" + branch
+}
+
+// cleanFilePath validates a path in a commit request, and returns it without leading or trailing slashes
+func cleanFilePath(filepath string) (string, error) {
+	filepath = strings.Trim(filepath, "/")
+	for _, c := range filepath {
+		// Tree entries end in a NUL byte, and control characters are never meant in a path
+		if unicode.IsControl(c) {
+			return "", errors.New("Invalid character in path " + strconv.Quote(filepath))
+		}
+	}
+	for _, component := range strings.Split(filepath, "/") {
+		// .GIT is .git on case-insensitive checkouts
+		if component == "" || component == "." || component == ".." || strings.EqualFold(component, ".git") {
+			return "", errors.New("Invalid path " + filepath)
+		}
+	}
+	return filepath, nil
+}
+
+// getFileChanges converts the file changes of a commit request
+func getFileChanges(files []datastructures.APIFileChange) ([]fileChange, error) {
+	changes := make([]fileChange, 0, len(files))
+	seen := make(map[string]bool)
+	for _, file := range files {
+		filepath, err := cleanFilePath(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		if seen[filepath] {
+			return nil, errors.New("Multiple changes for " + filepath)
+		}
+		seen[filepath] = true
+
+		change := fileChange{
+			path:       filepath,
+			executable: file.Executable,
+		}
+		switch file.Action {
+		case "add":
+			change.mustnotexist = true
+		case "modify":
+			change.mustexist = true
+		case "delete":
+			change.mustexist = true
+			change.delete = true
+		default:
+			return nil, errors.New("Invalid action for " + filepath)
+		}
+		if !change.delete {
+			if file.Encoding == "base64" {
+				change.content, err = base64.StdEncoding.DecodeString(file.Content)
+				if err != nil {
+					return nil, errors.New("Invalid base64 content for " + filepath)
+				}
+			} else if file.Encoding == "" {
+				change.content = []byte(file.Content)
+			} else {
+				return nil, errors.New("Invalid encoding for " + filepath)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// finishServerPush validates and syncs the objects staged in pusher, runs the hooks and performs the push.
+// This is the equivalent of the last steps of serveGitReceivePack for pushes created by the server.
+func (cfg *Service) finishServerPush(reqlogger *zap.SugaredLogger, p storage.ProjectStorageDriver, pusher storage.ProjectStoragePushDriver, reponame string, toupdate *pb.PushRequest, hookout io.Writer) error {
+	if err := validateObjects(p, toupdate, false); err != nil {
+		reqlogger.Infow("Object validation failure", "err", err)
+		return serverPushError{500, "Object validation failed"}
+	}
+	if err := addPeeledTargets(p, toupdate); err != nil {
+		reqlogger.Infow("Error peeling tags", "err", err)
+		return serverPushError{500, "Object validation failed"}
+	}
+	if err := addFastForwardInfo(p, cfg.indexer.getCommitGraph(reponame), toupdate); err != nil {
+		reqlogger.Infow("Error determining fast-forwards", "err", err)
+		return serverPushError{500, "Object validation failed"}
+	}
+
+	pusher.Done()
+	if syncerr := <-pusher.GetPushResultChannel(); syncerr != nil {
+		reqlogger.Infow("Error syncing object out to enough nodes", "err", syncerr)
+		return serverPushError{500, "Object sync failed"}
+	}
+
+	cfg.statestore.AddFakeRefs(reponame, toupdate)
+
+	if err := cfg.runHook(hookTypePreReceive, hookout, hookout, reponame, toupdate); err != nil {
+		reqlogger.Infow("Pre-receive hook refused push", "error", err)
+		return serverPushError{403, "Pre-receive hook refused push"}
+	}
+	if err := cfg.runHook(hookTypeUpdate, hookout, hookout, reponame, toupdate); err != nil {
+		reqlogger.Infow("Update hook refused push", "error", err)
+		return serverPushError{403, "Update hook refused push"}
+	}
+
+	pushresult := cfg.statestore.performPush(toupdate)
+	if !pushresult.success {
+		reqlogger.Infow("Push failed", "error", pushresult.logerror)
+		return serverPushError{409, pushresult.clienterror.Error()}
+	}
+
+	if err := cfg.runHook(hookTypePostReceive, hookout, hookout, reponame, toupdate); err != nil {
+		reqlogger.Infow("Post-receive hook failed", "error", err)
+	}
+	return nil
+}
+
+func (cfg *Service) respondServerPushError(w http.ResponseWriter, err error, hookout *bytes.Buffer) {
+	status := 500
+	if pusherr, ok := err.(serverPushError); ok {
+		status = pusherr.status
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.WriteHeader(status)
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: false,
+		Error:   err.Error(),
+		Info:    hookout.String(),
+	})
+}
+
+func (cfg *Service) serveAPICreateCommit(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, perminfo permissionInfo, p storage.ProjectStorageDriver, reponame string) {
+	var request datastructures.APICommitRequest
+	if !cfg.parseJSONRequest(w, r, &request) {
+		return
+	}
+	refname := getBranchRefName(request.Branch)
+	reqlogger = reqlogger.With(
+		"ref", refname,
+		"parent", request.Parent,
+		"numfiles", len(request.Files),
+	)
+	if !isValidRefName(refname) || refname == "HEAD" {
+		cfg.respondAPIError(w, 400, "Invalid branch name")
+		return
+	}
+	if strings.TrimSpace(request.Message) == "" {
+		cfg.respondAPIError(w, 400, "Commit message required")
+		return
+	}
+	if request.Committer.Name == "" && request.Committer.Email == "" {
+		request.Committer = request.Author
+	}
+	author := getNewSignature(request.Author)
+	committer := getNewSignature(request.Committer)
+	changes, err := getFileChanges(request.Files)
+	if err != nil {
+		cfg.respondAPIError(w, 400, err.Error())
+		return
+	}
+
+	from := storage.ZeroID
+	if current, exists := cfg.statestore.getGitRefs(reponame)[refname]; exists {
+		from = storage.ObjectID(current)
+	}
+	var parents []storage.ObjectID
+	if request.Parent != "" {
+		parent, err := cfg.resolveCommit(p, perminfo, reponame, request.Parent)
+		if err != nil {
+			reqlogger.Debugw("Unable to resolve parent", "error", err)
+			cfg.respondAPIError(w, 404, "Parent not found")
+			return
+		}
+		if from != storage.ZeroID && from != parent {
+			cfg.respondAPIError(w, 409, "Branch does not point to parent")
+			return
+		}
+		parents = []storage.ObjectID{parent}
+	} else if from != storage.ZeroID {
+		parents = []storage.ObjectID{from}
+	}
+
+	toupdate := pb.NewPushRequest(cfg.nodeid, reponame)
+	toupdate.SetPusher(perminfo.Username, r.RemoteAddr)
+	pusher := p.GetPusher(toupdate.UUID())
+	commitid, err := writeCommit(p, pusher, parents, changes, author, committer, request.Message)
+	if err != nil {
+		reqlogger.Infow("Error creating commit", "error", err)
+		pusher.Done()
+		if _, ok := err.(changeError); ok {
+			cfg.respondAPIError(w, 400, err.Error())
+		} else {
+			cfg.respondAPIError(w, 500, "Error creating commit")
+		}
+		return
+	}
+	toupdate.AddRequest(pb.NewUpdateRequest(refname, string(from), string(commitid)))
+	reqlogger = reqlogger.With("commit", commitid)
+
+	if precheck := cfg.statestore.getPushPrecheckResult(toupdate); !precheck.success {
+		reqlogger.Infow("Push pre-check failed", "error", precheck.logerror)
+		pusher.Done()
+		cfg.respondAPIError(w, 409, precheck.clienterror.Error())
+		return
+	}
+
+	var hookout bytes.Buffer
+	if err := cfg.finishServerPush(reqlogger, p, pusher, reponame, toupdate, &hookout); err != nil {
+		cfg.respondServerPushError(w, err, &hookout)
+		return
+	}
+
+	entry, err := readHistoryEntry(p, commitid)
+	if err != nil {
+		reqlogger.Infow("Error reading created commit", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading commit")
+		return
+	}
+	reqlogger.Debug("Commit created")
+	w.Header()["Content-Type"] = []string{"application/json"}
+	cfg.respondJSONResponse(w, datastructures.APICommitResult{
+		Commit:     getAPICommit(entry),
+		HookOutput: hookout.String(),
+	})
+}
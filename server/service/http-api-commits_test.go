@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestCleanFilePath(t *testing.T) {
+	valid := map[string]string{
+		"a":         "a",
+		"/a/b/":     "a/b",
+		"dir/.gitx": "dir/.gitx",
+		"ünïcode":   "ünïcode",
+	}
+	for in, expected := range valid {
+		out, err := cleanFilePath(in)
+		if err != nil || out != expected {
+			t.Errorf("Path %q: expected %q, got %q (%v)", in, expected, out, err)
+		}
+	}
+
+	for _, in := range []string{"", "a//b", "./a", "a/../b", ".git/config", "a/.GIT/b", "a\x00b", "a\nb", "a\tb"} {
+		if _, err := cleanFilePath(in); err == nil {
+			t.Errorf("Path %q was accepted", in)
+		}
+	}
+}
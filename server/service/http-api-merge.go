@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// resolveCommit resolves a revision to the commit it points to
+func (cfg *Service) resolveCommit(p storage.ProjectStorageDriver, perminfo permissionInfo, reponame, rev string) (storage.ObjectID, error) {
+	objid, err := cfg.resolveRevision(p, perminfo, reponame, rev)
+	if err != nil {
+		return storage.ZeroID, err
+	}
+	objid, _, err = peelToCommit(p, objid)
+	return objid, err
+}
+
+func (cfg *Service) serveAPIMerge(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, perminfo permissionInfo, p storage.ProjectStorageDriver, reponame string) {
+	var request datastructures.APIMergeRequest
+	if !cfg.parseJSONRequest(w, r, &request) {
+		return
+	}
+	refname := getBranchRefName(request.Branch)
+	reqlogger = reqlogger.With(
+		"ref", refname,
+		"head", request.Head,
+		"parent", request.Parent,
+	)
+	if !isValidRefName(refname) || refname == "HEAD" {
+		cfg.respondAPIError(w, 400, "Invalid branch name")
+		return
+	}
+	if request.FastForwardOnly && request.NoFastForward {
+		cfg.respondAPIError(w, 400, "FastForwardOnly and NoFastForward are mutually exclusive")
+		return
+	}
+
+	current, exists := cfg.statestore.getGitRefs(reponame)[refname]
+	if !exists {
+		cfg.respondAPIError(w, 404, "Branch not found")
+		return
+	}
+	ours := storage.ObjectID(current)
+	if request.Parent != "" {
+		parent, err := cfg.resolveCommit(p, perminfo, reponame, request.Parent)
+		if err != nil {
+			reqlogger.Debugw("Unable to resolve parent", "error", err)
+			cfg.respondAPIError(w, 404, "Parent not found")
+			return
+		}
+		if parent != ours {
+			cfg.respondAPIError(w, 409, "Branch does not point to parent")
+			return
+		}
+	}
+	theirs, err := cfg.resolveCommit(p, perminfo, reponame, request.Head)
+	if err != nil {
+		reqlogger.Debugw("Unable to resolve head", "error", err)
+		cfg.respondAPIError(w, 404, "Head not found")
+		return
+	}
+
+	mergebase, err := cfg.indexer.getCommitGraph(reponame).MergeBase(p, ours, theirs)
+	if err != nil {
+		reqlogger.Infow("Error finding merge base", "error", err)
+		cfg.respondAPIError(w, 500, "Error finding merge base")
+		return
+	}
+	reqlogger = reqlogger.With(
+		"ours", ours,
+		"theirs", theirs,
+		"mergebase", mergebase,
+	)
+
+	result := datastructures.APIMergeResult{
+		Conflicts: []datastructures.APIMergeConflict{},
+	}
+	if mergebase == theirs {
+		// Nothing to merge
+		entry, err := readHistoryEntry(p, ours)
+		if err != nil {
+			reqlogger.Infow("Error reading commit", "error", err)
+			cfg.respondAPIError(w, 500, "Error reading commit")
+			return
+		}
+		result.Commit = getAPICommit(entry)
+		w.Header()["Content-Type"] = []string{"application/json"}
+		cfg.respondJSONResponse(w, result)
+		return
+	}
+
+	toupdate := pb.NewPushRequest(cfg.nodeid, reponame)
+	toupdate.SetPusher(perminfo.Username, r.RemoteAddr)
+	pusher := p.GetPusher(toupdate.UUID())
+	var newcommit storage.ObjectID
+	if mergebase == ours && !request.NoFastForward {
+		result.FastForward = true
+		newcommit = theirs
+	} else if request.FastForwardOnly {
+		pusher.Done()
+		cfg.respondAPIError(w, 409, "Fast-forward not possible")
+		return
+	} else {
+		newcommit, result.Conflicts, err = cfg.writeMergeCommit(p, pusher, request, mergebase, ours, theirs)
+		if err != nil {
+			reqlogger.Infow("Error creating merge commit", "error", err)
+			pusher.Done()
+			if _, ok := err.(changeError); ok {
+				cfg.respondAPIError(w, 400, err.Error())
+			} else {
+				cfg.respondAPIError(w, 500, "Error creating merge commit")
+			}
+			return
+		}
+		if len(result.Conflicts) != 0 {
+			reqlogger.Debugw("Merge has conflicts", "conflicts", len(result.Conflicts))
+			pusher.Done()
+			w.Header()["Content-Type"] = []string{"application/json"}
+			w.WriteHeader(409)
+			cfg.respondJSONResponse(w, result)
+			return
+		}
+	}
+	toupdate.AddRequest(pb.NewUpdateRequest(refname, string(ours), string(newcommit)))
+	reqlogger = reqlogger.With(
+		"commit", newcommit,
+		"fastforward", result.FastForward,
+	)
+
+	if precheck := cfg.statestore.getPushPrecheckResult(toupdate); !precheck.success {
+		reqlogger.Infow("Push pre-check failed", "error", precheck.logerror)
+		pusher.Done()
+		cfg.respondAPIError(w, 409, precheck.clienterror.Error())
+		return
+	}
+
+	var hookout bytes.Buffer
+	if err := cfg.finishServerPush(reqlogger, p, pusher, reponame, toupdate, &hookout); err != nil {
+		cfg.respondServerPushError(w, err, &hookout)
+		return
+	}
+
+	entry, err := readHistoryEntry(p, newcommit)
+	if err != nil {
+		reqlogger.Infow("Error reading merged commit", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading commit")
+		return
+	}
+	reqlogger.Debug("Merge performed")
+	result.Merged = true
+	result.Commit = getAPICommit(entry)
+	result.HookOutput = hookout.String()
+	w.Header()["Content-Type"] = []string{"application/json"}
+	cfg.respondJSONResponse(w, result)
+}
+
+// writeMergeCommit performs a three-way merge of ours and theirs, and writes a merge commit for the result.
+// If there are any conflicts, they are returned and no objects are written.
+func (cfg *Service) writeMergeCommit(p storage.ProjectStorageDriver, pusher storage.ProjectStoragePushDriver, request datastructures.APIMergeRequest, mergebase, ours, theirs storage.ObjectID) (storage.ObjectID, []datastructures.APIMergeConflict, error) {
+	trees := [3]storage.ObjectID{storage.ZeroID, storage.ZeroID, storage.ZeroID}
+	for i, commitid := range []storage.ObjectID{mergebase, ours, theirs} {
+		if commitid == storage.ZeroID {
+			// Unrelated histories, merge as if both sides were added to an empty tree
+			continue
+		}
+		_, commit, err := peelToCommit(p, commitid)
+		if err != nil {
+			return storage.ZeroID, nil, err
+		}
+		trees[i] = commit.tree
+	}
+
+	// First determine whether there are conflicts, so we don't write any objects if there are
+	_, conflicts, err := mergeTrees(p, nil, trees[0], trees[1], trees[2], "")
+	if err != nil || len(conflicts) != 0 {
+		return storage.ZeroID, conflicts, err
+	}
+	tree, _, err := mergeTrees(p, pusher, trees[0], trees[1], trees[2], "")
+	if err != nil {
+		return storage.ZeroID, nil, err
+	}
+
+	if request.Author.Name == "" && request.Author.Email == "" {
+		request.Author = request.Committer
+	}
+	message := request.Message
+	if strings.TrimSpace(message) == "" {
+		message = fmt.Sprintf("Merge %s into %s", request.Head, strings.TrimPrefix(request.Branch, "refs/heads/"))
+	}
+	commitid, err := writeCommitObject(
+		pusher,
+		tree,
+		[]storage.ObjectID{ours, theirs},
+		getNewSignature(request.Author),
+		getNewSignature(request.Committer),
+		message,
+	)
+	return commitid, nil, err
+}
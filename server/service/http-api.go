@@ -0,0 +1,415 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+const (
+	apiDefaultPerPage = 30
+	apiMaxPerPage     = 100
+	// apiMaxCompareCommits is the maximum number of commits returned by a comparison
+	apiMaxCompareCommits = 250
+)
+
+var apiCommands = map[string]bool{
+	"tree":    true,
+	"blob":    true,
+	"commit":  true,
+	"log":     true,
+	"compare": true,
+	"commits": true,
+	"merge":   true,
+}
+
+func (cfg *Service) respondAPIError(w http.ResponseWriter, code int, message string) {
+	w.Header()["Content-Type"] = []string{"application/json"}
+	w.WriteHeader(code)
+	cfg.respondJSONResponse(w, datastructures.CommandResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// findAPIRepoAndCommand splits the parts of an /api/repo/ URL into the repo name, command and arguments.
+// Since repo names can contain slashes, the shortest existing repo followed by a command is used.
+func (cfg *Service) findAPIRepoAndCommand(parts []string) (string, string, []string) {
+	for i := 1; i < len(parts); i++ {
+		if !apiCommands[parts[i]] {
+			continue
+		}
+		reponame := strings.Join(parts[:i], "/")
+		if cfg.statestore.hasRepo(reponame) {
+			return reponame, parts[i], parts[i+1:]
+		}
+	}
+	return "", "", nil
+}
+
+// splitRevisionAndPath finds the shortest prefix of args that is a valid revision, and returns the remainder as path
+func (cfg *Service) splitRevisionAndPath(p storage.ProjectStorageDriver, perminfo permissionInfo, reponame string, args []string) (storage.ObjectID, string, error) {
+	for i := 1; i <= len(args); i++ {
+		objid, err := cfg.resolveRevision(p, perminfo, reponame, strings.Join(args[:i], "/"))
+		if err == errRevisionNotFound {
+			continue
+		} else if err != nil {
+			return storage.ZeroID, "", err
+		}
+		return objid, strings.Trim(strings.Join(args[i:], "/"), "/"), nil
+	}
+	return storage.ZeroID, "", errRevisionNotFound
+}
+
+// serveAPI handles all requests under /api/.
+func (cfg *Service) serveAPI(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, pathparts []string) {
+	if len(pathparts) < 2 || pathparts[0] != "repo" {
+		reqlogger.Debug("Unknown API page requested")
+		cfg.respondAPIError(w, 404, "Not found")
+		return
+	}
+	reponame, command, args := cfg.findAPIRepoAndCommand(pathparts[1:])
+	if reponame == "" {
+		reqlogger.Debug("Non-existing repo or command requested")
+		cfg.respondAPIError(w, 404, "Not found")
+		return
+	}
+	reqlogger = reqlogger.With(
+		"reponame", reponame,
+		"apicommand", command,
+	)
+
+	if !cfg.checkAccess(perminfo, reponame, constants.CertPermissionRead) {
+		// If we don't have read access, we have no access to this repo
+		reqlogger.Info("Unauthorized request")
+		cfg.respondAPIError(w, 404, "Not found")
+		return
+	}
+
+	projectstore := cfg.gitstore.GetProjectStorage(reponame)
+	if command == "commits" || command == "merge" {
+		if len(args) != 0 {
+			cfg.respondAPIError(w, 404, "Not found")
+			return
+		}
+		if r.Method != "POST" {
+			cfg.respondAPIError(w, 405, "POST required")
+			return
+		}
+		if !cfg.checkAccess(perminfo, reponame, constants.CertPermissionWrite) {
+			reqlogger.Info("Unauthorized request")
+			cfg.respondAPIError(w, 403, "Write access denied")
+			return
+		}
+		if command == "commits" {
+			cfg.serveAPICreateCommit(w, r, reqlogger, perminfo, projectstore, reponame)
+		} else {
+			cfg.serveAPIMerge(w, r, reqlogger, perminfo, projectstore, reponame)
+		}
+		return
+	}
+	if r.Method != "GET" {
+		cfg.respondAPIError(w, 405, "GET required")
+		return
+	}
+	if command == "compare" {
+		cfg.serveAPICompare(w, reqlogger, perminfo, projectstore, reponame, strings.Join(args, "/"))
+		return
+	}
+
+	var objid storage.ObjectID
+	var entrypath string
+	var err error
+	if command == "commit" {
+		objid, err = cfg.resolveRevision(projectstore, perminfo, reponame, strings.Join(args, "/"))
+	} else {
+		objid, entrypath, err = cfg.splitRevisionAndPath(projectstore, perminfo, reponame, args)
+	}
+	if err != nil {
+		reqlogger.Debugw("Unable to resolve revision", "error", err)
+		cfg.respondAPIError(w, 404, "Revision not found")
+		return
+	}
+	commitid, commit, err := peelToCommit(projectstore, objid)
+	if err != nil {
+		reqlogger.Debugw("Unable to find commit", "error", err)
+		cfg.respondAPIError(w, 404, "Revision does not point to a commit")
+		return
+	}
+	reqlogger = reqlogger.With(
+		"commit", commitid,
+		"path", entrypath,
+	)
+
+	switch command {
+	case "tree":
+		cfg.serveAPITree(w, reqlogger, projectstore, commitid, commit, entrypath)
+	case "blob":
+		cfg.serveAPIBlob(w, reqlogger, projectstore, commit, entrypath)
+	case "commit":
+		cfg.serveAPICommit(w, reqlogger, projectstore, commitid)
+	case "log":
+		cfg.serveAPILog(w, r, reqlogger, projectstore, commitid, entrypath)
+	}
+}
+
+func (cfg *Service) serveAPITree(w http.ResponseWriter, reqlogger *zap.SugaredLogger, p storage.ProjectStorageDriver, commitid storage.ObjectID, commit commitInfo, entrypath string) {
+	entry, err := findTreeEntry(p, commit.tree, entrypath)
+	if err == errPathNotFound || (err == nil && !entry.isTree()) {
+		cfg.respondAPIError(w, 404, "Tree not found")
+		return
+	} else if err != nil {
+		reqlogger.Infow("Error looking up tree", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading tree")
+		return
+	}
+	tree, err := readTreeObject(p, entry.objectid)
+	if err != nil {
+		reqlogger.Infow("Error reading tree", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading tree")
+		return
+	}
+
+	resp := datastructures.APITree{
+		ID:      string(entry.objectid),
+		Commit:  string(commitid),
+		Path:    entrypath,
+		Entries: make([]datastructures.APITreeEntry, 0, len(tree.entries)),
+	}
+	for _, subentry := range tree.entries {
+		resp.Entries = append(resp.Entries, datastructures.APITreeEntry{
+			Name: subentry.name,
+			Path: path.Join(entrypath, subentry.name),
+			Type: subentry.typeName(),
+			Mode: subentry.gitMode(),
+			ID:   string(subentry.objectid),
+		})
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	cfg.respondJSONResponse(w, resp)
+}
+
+func (cfg *Service) serveAPIBlob(w http.ResponseWriter, reqlogger *zap.SugaredLogger, p storage.ProjectStorageDriver, commit commitInfo, entrypath string) {
+	entry, err := findTreeEntry(p, commit.tree, entrypath)
+	if err == errPathNotFound || (err == nil && (entry.isTree() || entry.isSubmodule())) {
+		cfg.respondAPIError(w, 404, "Blob not found")
+		return
+	} else if err != nil {
+		reqlogger.Infow("Error looking up blob", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading blob")
+		return
+	}
+	objtype, objsize, reader, err := p.ReadObject(entry.objectid)
+	if err != nil {
+		reqlogger.Infow("Error reading blob", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading blob")
+		return
+	}
+	defer reader.Close()
+	if objtype != storage.ObjectTypeBlob {
+		cfg.respondAPIError(w, 404, "Blob not found")
+		return
+	}
+
+	w.Header()["Content-Type"] = []string{"application/octet-stream"}
+	w.Header()["Content-Length"] = []string{strconv.FormatUint(uint64(objsize), 10)}
+	w.WriteHeader(200)
+	if _, err := io.Copy(w, reader); err != nil {
+		reqlogger.Infow("Error sending blob", "error", err)
+	}
+}
+
+func getAPISignature(line string) datastructures.APISignature {
+	sig, err := parseSignature(line)
+	if err != nil {
+		return datastructures.APISignature{}
+	}
+	return datastructures.APISignature{
+		Name:  sig.name,
+		Email: sig.email,
+		Date:  sig.when,
+	}
+}
+
+func getAPICommit(entry *historyEntry) datastructures.APICommit {
+	parents := make([]string, len(entry.commit.parents))
+	for i, parent := range entry.commit.parents {
+		parents[i] = string(parent)
+	}
+	return datastructures.APICommit{
+		ID:        string(entry.id),
+		Tree:      string(entry.commit.tree),
+		Parents:   parents,
+		Author:    getAPISignature(entry.commit.author),
+		Committer: getAPISignature(entry.commit.committer),
+		Message:   entry.message,
+	}
+}
+
+func (cfg *Service) serveAPICommit(w http.ResponseWriter, reqlogger *zap.SugaredLogger, p storage.ProjectStorageDriver, commitid storage.ObjectID) {
+	entry, err := readHistoryEntry(p, commitid)
+	if err != nil {
+		reqlogger.Infow("Error reading commit", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading commit")
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	cfg.respondJSONResponse(w, getAPICommit(entry))
+}
+
+// getPageParameters returns the page and per_page query arguments, with defaults applied
+func getPageParameters(r *http.Request) (page int, perpage int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perpage, err = strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perpage < 1 {
+		perpage = apiDefaultPerPage
+	} else if perpage > apiMaxPerPage {
+		perpage = apiMaxPerPage
+	}
+	return
+}
+
+func (cfg *Service) serveAPILog(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, p storage.ProjectStorageDriver, commitid storage.ObjectID, entrypath string) {
+	if querypath := r.URL.Query().Get("path"); querypath != "" {
+		entrypath = strings.Trim(querypath, "/")
+	}
+	page, perpage := getPageParameters(r)
+
+	resp := datastructures.APILog{
+		Commits: make([]datastructures.APICommit, 0, perpage),
+	}
+	skip := (page - 1) * perpage
+	err := walkHistory(p, []storage.ObjectID{commitid}, nil, entrypath, func(entry *historyEntry) (bool, error) {
+		if skip > 0 {
+			skip--
+			return true, nil
+		}
+		if len(resp.Commits) == perpage {
+			// There is at least one more commit
+			resp.NextPage = page + 1
+			return false, nil
+		}
+		resp.Commits = append(resp.Commits, getAPICommit(entry))
+		return true, nil
+	})
+	if err != nil {
+		reqlogger.Infow("Error walking history", "error", err)
+		cfg.respondAPIError(w, 500, "Error reading history")
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	cfg.respondJSONResponse(w, resp)
+}
+
+func (cfg *Service) serveAPICompare(w http.ResponseWriter, reqlogger *zap.SugaredLogger, perminfo permissionInfo, p storage.ProjectStorageDriver, reponame, revrange string) {
+	revs := strings.SplitN(revrange, "...", 2)
+	if len(revs) != 2 || revs[0] == "" || revs[1] == "" {
+		cfg.respondAPIError(w, 400, "Comparison must be <base>...<head>")
+		return
+	}
+	reqlogger = reqlogger.With(
+		"base", revs[0],
+		"head", revs[1],
+	)
+
+	var commits [2]storage.ObjectID
+	for i, rev := range revs {
+		objid, err := cfg.resolveRevision(p, perminfo, reponame, rev)
+		if err != nil {
+			reqlogger.Debugw("Unable to resolve revision",
+				"revision", rev,
+				"error", err,
+			)
+			cfg.respondAPIError(w, 404, "Revision "+rev+" not found")
+			return
+		}
+		commits[i], _, err = peelToCommit(p, objid)
+		if err != nil {
+			reqlogger.Debugw("Unable to find commit",
+				"revision", rev,
+				"error", err,
+			)
+			cfg.respondAPIError(w, 404, "Revision "+rev+" does not point to a commit")
+			return
+		}
+	}
+	base, head := commits[0], commits[1]
+
+	resp, err := compareCommits(p, cfg.indexer.getCommitGraph(reponame), base, head)
+	if err != nil {
+		reqlogger.Infow("Error comparing commits", "error", err)
+		cfg.respondAPIError(w, 500, "Error comparing commits")
+		return
+	}
+	w.Header()["Content-Type"] = []string{"application/json"}
+	cfg.respondJSONResponse(w, resp)
+}
+
+// compareCommits returns the commits in head that are not in base, at most apiMaxCompareCommits
+// of them, and the changes made in head since the merge base of the two
+func compareCommits(p storage.ProjectStorageDriver, graph *commitGraph, base, head storage.ObjectID) (datastructures.APICompare, error) {
+	resp := datastructures.APICompare{
+		Base:    string(base),
+		Head:    string(head),
+		Commits: []datastructures.APICommit{},
+		Files:   []datastructures.APIFileDiff{},
+	}
+
+	commitids, err := graph.OnlyIn(p, head, base)
+	if err != nil {
+		return resp, err
+	}
+	resp.TotalCommits = len(commitids)
+	if len(commitids) > apiMaxCompareCommits {
+		commitids = commitids[:apiMaxCompareCommits]
+	}
+	for _, commitid := range commitids {
+		entry, err := readHistoryEntry(p, commitid)
+		if err != nil {
+			return resp, err
+		}
+		resp.Commits = append(resp.Commits, getAPICommit(entry))
+	}
+
+	mergebase, err := graph.MergeBase(p, base, head)
+	if err != nil {
+		return resp, err
+	}
+	oldtree := storage.ZeroID
+	if mergebase != storage.ZeroID {
+		resp.MergeBase = string(mergebase)
+		_, basecommit, err := peelToCommit(p, mergebase)
+		if err != nil {
+			return resp, err
+		}
+		oldtree = basecommit.tree
+	}
+	_, headcommit, err := peelToCommit(p, head)
+	if err != nil {
+		return resp, err
+	}
+
+	changes, err := diffTrees(p, oldtree, headcommit.tree, "")
+	if err != nil {
+		return resp, err
+	}
+	for _, change := range changes {
+		filediff, err := getFileDiff(p, change)
+		if err != nil {
+			return resp, err
+		}
+		resp.Files = append(resp.Files, filediff)
+	}
+	return resp, nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// splitArchiveName splits "<ref>.<format>" into the ref and archive format.
+func splitArchiveName(name string) (string, string) {
+	for format := range archiveFormats {
+		if strings.HasSuffix(name, "."+format) {
+			return name[:len(name)-len(format)-1], format
+		}
+	}
+	return "", ""
+}
+
+func (cfg *Service) serveGitArchive(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, reponame, command string) {
+	rev, format := splitArchiveName(command[len("archive/"):])
+	if rev == "" || r.Method != "GET" {
+		reqlogger.Debug("Invalid archive requested")
+		http.NotFound(w, r)
+		return
+	}
+	paths := r.URL.Query()["path"]
+	prefix := r.URL.Query().Get("prefix")
+	reqlogger = reqlogger.With(
+		"revision", rev,
+		"format", format,
+		"paths", paths,
+	)
+
+	projectstore := cfg.gitstore.GetProjectStorage(reponame)
+	objid, err := cfg.resolveRevision(projectstore, perminfo, reponame, rev)
+	if err != nil {
+		reqlogger.Debugw("Unable to resolve revision", "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	_, commit, err := peelToCommit(projectstore, objid)
+	if err != nil {
+		reqlogger.Debugw("Unable to find commit", "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	for _, entrypath := range paths {
+		// Make sure the paths exist, so we can return an error before we start streaming
+		if _, err := findTreeEntry(projectstore, commit.tree, strings.Trim(entrypath, "/")); err != nil {
+			reqlogger.Debugw("Unable to find archive path",
+				"path", entrypath,
+				"error", err,
+			)
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", path.Base(reponame), strings.Replace(rev, "/", "-", -1), format)
+	w.Header()["Content-Type"] = []string{archiveFormats[format]}
+	w.Header()["Content-Disposition"] = []string{"attachment; filename=\"" + filename + "\""}
+	w.WriteHeader(200)
+
+	if err := writeArchive(w, projectstore, objid, format, paths, prefix); err != nil {
+		// We have already started sending, so all we can do is log and abort
+		reqlogger.Infow("Error writing archive", "error", err)
+		return
+	}
+	reqlogger.Debug("Archive sent")
+}
+
+// sideBandChunker splits writes so that each fits in a single large side-band packet
+type sideBandChunker struct {
+	w io.Writer
+}
+
+const maxSideBandPayload = 65515
+
+func (c sideBandChunker) Write(buf []byte) (int, error) {
+	written := 0
+	for written < len(buf) {
+		end := written + maxSideBandPayload
+		if end > len(buf) {
+			end = len(buf)
+		}
+		n, err := c.w.Write(buf[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// serveGitUploadArchive implements the git-upload-archive service, as used by git archive --remote.
+// Git only supports this service over ssh and git:// remotes, so it is reached through repoclient.
+func (cfg *Service) serveGitUploadArchive(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, reponame string) {
+	bodyreader := bufio.NewReader(r.Body)
+	rw := newWrappedResponseWriter(w)
+	w.Header()["Content-Type"] = []string{"application/x-git-upload-archive-result"}
+
+	var args []string
+	for {
+		pkt, err := readPacket(bodyreader)
+		if err != nil {
+			reqlogger.Infow("Error reading upload-archive request", "error", err)
+			return
+		}
+		if len(pkt) == 0 {
+			break
+		}
+		arg := strings.TrimSuffix(string(pkt), "\n")
+		if !strings.HasPrefix(arg, "argument ") {
+			sendPacket(rw, []byte("NACK expected argument\n"))
+			sendFlushPacket(rw)
+			return
+		}
+		args = append(args, arg[len("argument "):])
+	}
+	reqlogger = reqlogger.With("arguments", args)
+	rw.isfullyread = true
+
+	format := "tar"
+	prefix := ""
+	rev := ""
+	var paths []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--format=") {
+			format = arg[len("--format="):]
+		} else if strings.HasPrefix(arg, "--prefix=") {
+			prefix = arg[len("--prefix="):]
+		} else if len(arg) == 2 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9' {
+			// Compression level, ignored
+		} else if strings.HasPrefix(arg, "-") {
+			sendPacket(rw, []byte("NACK unsupported option "+arg+"\n"))
+			sendFlushPacket(rw)
+			return
+		} else if rev == "" {
+			rev = arg
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	if _, known := archiveFormats[format]; !known {
+		sendPacket(rw, []byte("NACK unknown archive format '"+format+"'\n"))
+		sendFlushPacket(rw)
+		return
+	}
+
+	projectstore := cfg.gitstore.GetProjectStorage(reponame)
+	objid, err := cfg.resolveRevision(projectstore, perminfo, reponame, rev)
+	if err != nil {
+		reqlogger.Debugw("Unable to resolve revision", "error", err)
+		sendPacket(rw, []byte("NACK not a valid object name: "+rev+"\n"))
+		sendFlushPacket(rw)
+		return
+	}
+
+	sendPacket(rw, []byte("ACK\n"))
+	sendFlushPacket(rw)
+
+	sbsender := sideBandSender{
+		w:        rw,
+		sbstatus: sideBandStatusLarge,
+		sb:       sideBandData,
+	}
+	err = writeArchive(sideBandChunker{w: sbsender}, projectstore, objid, format, paths, prefix)
+	if err != nil {
+		reqlogger.Infow("Error writing archive", "error", err)
+		sendSideBandPacket(rw, sideBandStatusLarge, sideBandFatal, []byte(err.Error()+"\n"))
+	}
+	sendFlushPacket(rw)
+	reqlogger.Debug("Archive sent")
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var errUnsupportedContentEncoding = errors.New("Unsupported Content-Encoding")
+
+// gzipRequestBody decompresses a request body, and closes the original body when closed
+type gzipRequestBody struct {
+	*gzip.Reader
+	inner io.ReadCloser
+}
+
+func (b *gzipRequestBody) Close() error {
+	b.Reader.Close()
+	return b.inner.Close()
+}
+
+// decodeRequestBody replaces the body of requests sent with Content-Encoding gzip
+// (as git does for large upload-pack negotiations) with the decompressed body
+func decodeRequestBody(r *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+	default:
+		return errUnsupportedContentEncoding
+	}
+
+	gzreader, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = &gzipRequestBody{Reader: gzreader, inner: r.Body}
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return nil
+}
+
+// decodeRequestBodyOrRespond decodes the request body, and responds with an error if that fails.
+// It returns whether the request can be handled.
+// This is only used for git and API requests: other routes, like LFS uploads, need the Content-Length.
+func decodeRequestBodyOrRespond(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger) bool {
+	err := decodeRequestBody(r)
+	if err == nil {
+		return true
+	}
+	reqlogger.Infow("Unable to decode request body", "error", err)
+	if err == errUnsupportedContentEncoding {
+		w.WriteHeader(415)
+	} else {
+		w.WriteHeader(400)
+	}
+	w.Write([]byte("Invalid request body encoding\n"))
+	return false
+}
+
+// acceptsGzip returns whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header[http.CanonicalHeaderKey("Accept-Encoding")] {
+		for _, coding := range strings.Split(header, ",") {
+			params := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name != "gzip" && name != "x-gzip" {
+				continue
+			}
+			accepted := true
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					accepted = err == nil && q > 0
+				}
+			}
+			return accepted
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses everything written to the response
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzwriter *gzip.Writer
+}
+
+func (w gzipResponseWriter) Write(p []byte) (int, error) {
+	return w.gzwriter.Write(p)
+}
+
+func (w gzipResponseWriter) Flush() {
+	w.gzwriter.Flush()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// compressResponse returns a writer that gzip compresses the response if the client accepts that.
+// This must be called before the headers are written, and the returned function must be called
+// once the full response is written.
+func compressResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return w, func() {}
+	}
+	w.Header()["Content-Encoding"] = []string{"gzip"}
+	gzwriter := gzip.NewWriter(w)
+	return gzipResponseWriter{ResponseWriter: w, gzwriter: gzwriter}, func() {
+		gzwriter.Close()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"repospanner.org/repospanner/server/constants"
 	"repospanner.org/repospanner/server/storage"
@@ -35,6 +36,19 @@ func (cfg *Service) serveGitDiscovery(w http.ResponseWriter, r *http.Request, pe
 			return
 		}
 
+		if read && !fakerefs && cfg.statestore.wantsConsistentRead(r, reponame) {
+			if err := cfg.statestore.waitConsistentRead(); err != nil {
+				reqlogger.Infow("Consistent read failed", "error", err)
+				http.Error(w, "Unable to perform consistent read", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		if viper.GetBool("transfer.gzip_discovery") {
+			var finish func()
+			w, finish = compressResponse(w, r)
+			defer finish()
+		}
 		w.WriteHeader(200)
 
 		if !isrepoclient {
@@ -50,27 +64,13 @@ func (cfg *Service) serveGitDiscovery(w http.ResponseWriter, r *http.Request, pe
 			}
 		}
 
-		refs := cfg.statestore.getGitRefs(reponame)
-		symrefs := cfg.statestore.getSymRefs(reponame)
-
-		if fakerefs {
-			frefs, hasfrefs := cfg.statestore.fakerefs[reponame]
-			reqlogger.Debugw(
-				"Embedding fake refs",
-				"frefs", frefs,
-				"has", hasfrefs,
-			)
-			if hasfrefs {
-				realrefs := refs
-				refs = make(map[string]string)
-				for refname, refval := range realrefs {
-					refs[refname] = refval
-				}
-				for refname, refval := range frefs {
-					refs[refname] = refval
-				}
-			}
-		}
+		refs, symrefs := cfg.getAdvertisedRefs(perminfo, reponame, fakerefs)
+		peeled := cfg.peelAdvertisedRefs(cfg.gitstore.GetProjectStorage(reponame), reponame, refs)
+		reqlogger.Debugw(
+			"Advertising refs",
+			"fakerefs", fakerefs,
+			"numrefs", len(refs),
+		)
 
 		if len(refs) == 0 {
 			// Empty repo
@@ -98,6 +98,11 @@ func (cfg *Service) serveGitDiscovery(w http.ResponseWriter, r *http.Request, pe
 			} else {
 				err = sendPacket(w, pkt)
 			}
+			if err == nil {
+				if peeledval, ispeeled := peeled[refname]; ispeeled {
+					err = sendPacket(w, []byte(fmt.Sprintf("%s %s^{}", peeledval, refname)))
+				}
+			}
 			if err != nil {
 				reqlogger.Errorw("Error sending packet", "error", err)
 				http.NotFound(w, r)
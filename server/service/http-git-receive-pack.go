@@ -13,7 +13,7 @@ import (
 	"repospanner.org/repospanner/server/storage"
 )
 
-func (cfg *Service) serveGitReceivePack(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, reponame string) {
+func (cfg *Service) serveGitReceivePack(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, reponame string) {
 	bodyreader := bufio.NewReader(r.Body)
 	rw := newWrappedResponseWriter(w)
 
@@ -28,6 +28,7 @@ func (cfg *Service) serveGitReceivePack(w http.ResponseWriter, r *http.Request,
 		sendPacket(rw, []byte("ERR Invalid request"))
 		return
 	}
+	toupdate.SetPusher(perminfo.Username, r.RemoteAddr)
 	reqlogger = reqlogger.With(
 		"capabs", capabs,
 		// zap does weird things to updateinfoEntry, so let's stringize ourselves
@@ -60,7 +61,7 @@ func (cfg *Service) serveGitReceivePack(w http.ResponseWriter, r *http.Request,
 
 	// Perform a pre-check to determine whether there's any chance of success after we parse all the objects
 	cfg.debugPacket(rw, sbstatus, "Performing pre-check...")
-	precheckresult := cfg.statestore.getPushResult(toupdate)
+	precheckresult := cfg.statestore.getPushPrecheckResult(toupdate)
 	cfg.debugPacket(rw, sbstatus, "Pre-check results in")
 	reqlogger.Debugw("Pre-check results computed",
 		"success", precheckresult.success,
@@ -323,6 +324,22 @@ func (cfg *Service) serveGitReceivePack(w http.ResponseWriter, r *http.Request,
 		sendUnpackFail(rw, hasStatus, sbstatus, toupdate)
 		return
 	}
+	if err := addPeeledTargets(projectstore, toupdate); err != nil {
+		reqlogger.Infow("Error peeling tags",
+			"err", err,
+		)
+		sendSideBandPacket(rw, sbstatus, sideBandProgress, []byte("ERR Object validation failed\n"))
+		sendUnpackFail(rw, hasStatus, sbstatus, toupdate)
+		return
+	}
+	if err := addFastForwardInfo(projectstore, cfg.indexer.getCommitGraph(reponame), toupdate); err != nil {
+		reqlogger.Infow("Error determining fast-forwards",
+			"err", err,
+		)
+		sendSideBandPacket(rw, sbstatus, sideBandProgress, []byte("ERR Object validation failed\n"))
+		sendUnpackFail(rw, hasStatus, sbstatus, toupdate)
+		return
+	}
 	cfg.debugPacket(rw, sbstatus, "Objects validated")
 	reqlogger.Debug("Objects in request are sufficient")
 
@@ -10,7 +10,7 @@ import (
 	"repospanner.org/repospanner/server/storage"
 )
 
-func (cfg *Service) serveGitUploadPack(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, reponame string) {
+func (cfg *Service) serveGitUploadPack(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, reponame string, fakerefs bool) {
 	reqlogger.Debug("Read requested")
 	bodyreader := bufio.NewReader(r.Body)
 	rw := newWrappedResponseWriter(w)
@@ -28,6 +28,19 @@ func (cfg *Service) serveGitUploadPack(w http.ResponseWriter, r *http.Request, r
 
 	reqlogger.Debug("Got request wants")
 
+	hiddenwant, err := cfg.checkWantsVisible(projectstore, perminfo, reponame, fakerefs, wants)
+	if err != nil {
+		panic(err)
+	}
+	if hiddenwant != storage.ZeroID {
+		reqlogger.Infow(
+			"Hidden object requested",
+			"want", hiddenwant,
+		)
+		sendPacket(rw, []byte("ERR upload-pack: not our ref "+string(hiddenwant)))
+		return
+	}
+
 	sbstatus, err := getSideBandStatus(capabs)
 	if err != nil {
 		panic(err)
@@ -127,7 +140,16 @@ func (cfg *Service) serveGitUploadPack(w http.ResponseWriter, r *http.Request, r
 		commitList = commitsToSend.List()
 	}
 
-	packfile, numobjects, err := writeTemporaryPackFile(projectstore, commitList, commonObjects, recursive)
+	var tags map[storage.ObjectID]storage.ObjectID
+	if hasCapab(capabs, "include-tag") {
+		tags = cfg.getAdvertisedTags(perminfo, reponame, fakerefs)
+	}
+
+	packfile, numobjects, err := cfg.writeBitmapPackFile(projectstore, reponame, wants, commonObjects.List(), tags)
+	if err != nil {
+		reqlogger.Infow("Error building packfile from bitmaps, walking objects", "error", err)
+		packfile, numobjects, err = writeTemporaryPackFile(projectstore, commitList, commonObjects, recursive, tags)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/storage"
+)
+
+const lfsContentType = "application/vnd.git-lfs+json"
+
+type lfsObject struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers,omitempty"`
+	Objects   []lfsObject `json:"objects"`
+}
+
+type lfsAction struct {
+	Href string `json:"href"`
+}
+
+type lfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsResponseObject struct {
+	Oid           string               `json:"oid"`
+	Size          int64                `json:"size"`
+	Authenticated bool                 `json:"authenticated,omitempty"`
+	Actions       map[string]lfsAction `json:"actions,omitempty"`
+	Error         *lfsObjectError      `json:"error,omitempty"`
+}
+
+type lfsBatchResponse struct {
+	Transfer string              `json:"transfer"`
+	Objects  []lfsResponseObject `json:"objects"`
+}
+
+type lfsErrorResponse struct {
+	Message string `json:"message"`
+}
+
+func isValidLFSOid(oid string) bool {
+	if len(oid) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(oid)
+	return err == nil
+}
+
+func getLFSProjectName(reponame string) string {
+	return constants.LFSRepoPrefix + reponame
+}
+
+func (cfg *Service) respondLFSError(w http.ResponseWriter, code int, message string) {
+	w.Header()["Content-Type"] = []string{lfsContentType}
+	w.WriteHeader(code)
+	cfg.respondJSONResponse(w, lfsErrorResponse{Message: message})
+}
+
+// serveLFS handles all requests under /repo/<name>.git/info/lfs/.
+func (cfg *Service) serveLFS(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, reponame, command string) {
+	command = command[len("info/lfs/"):]
+
+	if command == "objects/batch" && r.Method == "POST" {
+		cfg.serveLFSBatch(w, r, perminfo, reqlogger, reponame)
+		return
+	} else if strings.HasPrefix(command, "objects/") {
+		oid := command[len("objects/"):]
+		if !isValidLFSOid(oid) {
+			reqlogger.Debugw("Invalid LFS oid requested", "oid", oid)
+			cfg.respondLFSError(w, 422, "Invalid object ID")
+			return
+		}
+		reqlogger = reqlogger.With("oid", oid)
+
+		if r.Method == "GET" {
+			cfg.serveLFSDownload(w, r, reqlogger, reponame, oid)
+			return
+		} else if r.Method == "PUT" {
+			if !cfg.checkAccess(perminfo, reponame, constants.CertPermissionWrite) {
+				reqlogger.Info("Unauthorized request")
+				cfg.respondLFSError(w, 403, "Write access denied")
+				return
+			}
+			cfg.serveLFSUpload(w, r, reqlogger, reponame, oid)
+			return
+		}
+	}
+
+	reqlogger.Debug("Unknown LFS command requested")
+	cfg.respondLFSError(w, 404, "Not found")
+}
+
+func (cfg *Service) getLFSObjectURL(r *http.Request, reponame, oid string) string {
+	return fmt.Sprintf("https://%s/repo/%s.git/info/lfs/objects/%s", r.Host, reponame, oid)
+}
+
+func (cfg *Service) serveLFSBatch(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, reponame string) {
+	var request lfsBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		reqlogger.Infow("Invalid LFS batch request", "error", err)
+		cfg.respondLFSError(w, 422, "Invalid request")
+		return
+	}
+	reqlogger = reqlogger.With(
+		"operation", request.Operation,
+		"numobjects", len(request.Objects),
+	)
+
+	if len(request.Transfers) != 0 && !hasCapab(request.Transfers, "basic") {
+		cfg.respondLFSError(w, 422, "Only basic transfers are supported")
+		return
+	}
+
+	isupload := request.Operation == "upload"
+	if !isupload && request.Operation != "download" {
+		cfg.respondLFSError(w, 422, "Invalid operation")
+		return
+	}
+	if isupload && !cfg.checkAccess(perminfo, reponame, constants.CertPermissionWrite) {
+		reqlogger.Info("Unauthorized request")
+		cfg.respondLFSError(w, 403, "Write access denied")
+		return
+	}
+
+	response := lfsBatchResponse{
+		Transfer: "basic",
+		Objects:  make([]lfsResponseObject, 0, len(request.Objects)),
+	}
+	var newsize int64
+	for _, obj := range request.Objects {
+		respobj := lfsResponseObject{
+			Oid:  obj.Oid,
+			Size: obj.Size,
+		}
+		if !isValidLFSOid(obj.Oid) || obj.Size < 0 {
+			respobj.Error = &lfsObjectError{Code: 422, Message: "Invalid object"}
+			response.Objects = append(response.Objects, respobj)
+			continue
+		}
+
+		info, exists := cfg.statestore.getLFSObject(reponame, obj.Oid)
+		if isupload {
+			if !exists {
+				newsize += obj.Size
+				respobj.Authenticated = true
+				respobj.Actions = map[string]lfsAction{
+					"upload": {Href: cfg.getLFSObjectURL(r, reponame, obj.Oid)},
+				}
+			}
+			// If the object already exists, no actions tells the client it's done
+		} else {
+			if exists {
+				respobj.Size = info.Size
+				respobj.Authenticated = true
+				respobj.Actions = map[string]lfsAction{
+					"download": {Href: cfg.getLFSObjectURL(r, reponame, obj.Oid)},
+				}
+			} else {
+				respobj.Error = &lfsObjectError{Code: 404, Message: "Object does not exist"}
+			}
+		}
+		response.Objects = append(response.Objects, respobj)
+	}
+
+	if isupload {
+		usage, quota := cfg.statestore.getLFSUsage(reponame)
+		if quota > 0 && usage+newsize > quota {
+			reqlogger.Infow("LFS upload would exceed quota",
+				"usage", usage,
+				"newsize", newsize,
+				"quota", quota,
+			)
+			cfg.respondLFSError(w, 507, "LFS quota exceeded")
+			return
+		}
+	}
+
+	w.Header()["Content-Type"] = []string{lfsContentType}
+	w.WriteHeader(200)
+	cfg.respondJSONResponse(w, response)
+}
+
+func (cfg *Service) serveLFSDownload(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, reponame, oid string) {
+	info, exists := cfg.statestore.getLFSObject(reponame, oid)
+	if !exists {
+		cfg.respondLFSError(w, 404, "Object does not exist")
+		return
+	}
+
+	projectstore := cfg.gitstore.GetProjectStorage(getLFSProjectName(reponame))
+	_, objsize, reader, err := projectstore.ReadObject(storage.ObjectID(info.ObjectID))
+	if err != nil {
+		reqlogger.Errorw("Error reading LFS object",
+			"objectid", info.ObjectID,
+			"error", err,
+		)
+		cfg.respondLFSError(w, 500, "Error reading object")
+		return
+	}
+	defer reader.Close()
+
+	w.Header()["Content-Type"] = []string{"application/octet-stream"}
+	w.Header()["Content-Length"] = []string{strconv.FormatUint(uint64(objsize), 10)}
+	w.WriteHeader(200)
+	if _, err := io.Copy(w, reader); err != nil {
+		reqlogger.Infow("Error sending LFS object", "error", err)
+	}
+}
+
+func (cfg *Service) serveLFSUpload(w http.ResponseWriter, r *http.Request, reqlogger *zap.SugaredLogger, reponame, oid string) {
+	if _, exists := cfg.statestore.getLFSObject(reponame, oid); exists {
+		reqlogger.Debug("LFS object already existed")
+		w.WriteHeader(200)
+		return
+	}
+
+	size := r.ContentLength
+	if size < 0 {
+		cfg.respondLFSError(w, 411, "Content-Length required")
+		return
+	}
+	usage, quota := cfg.statestore.getLFSUsage(reponame)
+	if quota > 0 && usage+size > quota {
+		reqlogger.Infow("LFS upload would exceed quota",
+			"usage", usage,
+			"size", size,
+			"quota", quota,
+		)
+		cfg.respondLFSError(w, 507, "LFS quota exceeded")
+		return
+	}
+
+	drv := cfg.gitstore.GetProjectStorage(getLFSProjectName(reponame))
+	psh := drv.GetPusher("lfs-" + oid + "-" + strconv.Itoa(int(time.Now().UTC().UnixNano())))
+	stg, err := psh.StageObject(storage.ObjectTypeBlob, uint(size))
+	if err != nil {
+		reqlogger.Infow("Error staging LFS object", "error", err)
+		cfg.respondLFSError(w, 500, "Error storing object")
+		return
+	}
+	defer stg.Close()
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(stg, hasher), r.Body)
+	if err != nil {
+		reqlogger.Infow("Error receiving LFS object", "error", err)
+		cfg.respondLFSError(w, 500, "Error storing object")
+		return
+	}
+	if written != size {
+		reqlogger.Infow("Not full LFS object written",
+			"size", size,
+			"written", written,
+		)
+		cfg.respondLFSError(w, 422, "Not full object written")
+		return
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != oid {
+		reqlogger.Info("LFS object contents do not match oid")
+		cfg.respondLFSError(w, 422, "Object contents do not match object ID")
+		return
+	}
+
+	objid, err := stg.Finalize(storage.ZeroID)
+	if err != nil {
+		reqlogger.Infow("Error finalizing LFS object", "error", err)
+		cfg.respondLFSError(w, 500, "Error storing object")
+		return
+	}
+	psh.Done()
+	if err := <-psh.GetPushResultChannel(); err != nil {
+		reqlogger.Infow("Error syncing LFS object out to enough nodes",
+			"objectid", objid,
+			"error", err,
+		)
+		cfg.respondLFSError(w, 500, "Object sync failed")
+		return
+	}
+
+	// Only record the object once it is stored, so the state never refers to a missing object
+	if err := cfg.statestore.addLFSObject(reponame, oid, objid, size); err == errLFSQuotaExceeded {
+		// Another upload used up the quota in the meantime, don't keep an unreferenced object.
+		// The object ID is determined by the contents, so no other record can refer to it.
+		reqlogger.Info("LFS object refused due to quota")
+		if err := drv.DeleteObject(objid); err != nil {
+			reqlogger.Infow("Error deleting refused LFS object",
+				"objectid", objid,
+				"error", err,
+			)
+		}
+		cfg.respondLFSError(w, 507, err.Error())
+		return
+	} else if err != nil {
+		reqlogger.Infow("Error recording LFS object",
+			"objectid", objid,
+			"error", err,
+		)
+		cfg.respondLFSError(w, 500, "Error recording object")
+		return
+	}
+
+	reqlogger.Debugw("LFS object stored", "objectid", objid)
+	w.WriteHeader(200)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"net/http"
+	"net/url"
 	"strings"
 
 	"go.uber.org/zap"
@@ -65,6 +66,30 @@ func findProjectAndOp(parts []string) (string, string) {
 	return reponame, command
 }
 
+// redirectRenamedRepo redirects a request for a repo that was renamed to its new name
+func (cfg *Service) redirectRenamedRepo(w http.ResponseWriter, r *http.Request, perminfo permissionInfo, reqlogger *zap.SugaredLogger, newname, command string) {
+	if !cfg.checkAccess(perminfo, newname, constants.CertPermissionRead) {
+		// Don't disclose the new name to clients that can't read the repo
+		reqlogger.Info("Unauthorized request")
+		http.NotFound(w, r)
+		return
+	}
+	reqlogger.Debugw("Renamed repo requested, redirecting",
+		"newname", newname,
+	)
+
+	target := url.URL{
+		Path:     "/repo/" + newname + ".git/" + command,
+		RawQuery: r.URL.RawQuery,
+	}
+	status := http.StatusMovedPermanently
+	if r.Method != "GET" && r.Method != "HEAD" {
+		// Make sure the client repeats the request with the same method and body
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, target.String(), status)
+}
+
 func (cfg *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqlogger, perminfo := cfg.prereq(w, r, "gitservice")
 
@@ -106,6 +131,10 @@ func (cfg *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		)
 
 		if !cfg.statestore.hasRepo(reponame) {
+			if newname := cfg.statestore.getRepoRedirect(reponame); newname != "" {
+				cfg.redirectRenamedRepo(w, r, perminfo, reqlogger, newname, command)
+				return
+			}
 			reqlogger.Debug("Non-existing repo requested")
 			http.NotFound(w, r)
 			return
@@ -118,11 +147,15 @@ func (cfg *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if strings.HasPrefix(command, "git-") && !decodeRequestBodyOrRespond(w, r, reqlogger) {
+			return
+		}
+
 		if command == "info/refs" {
 			cfg.serveGitDiscovery(w, r, perminfo, reqlogger, reponame, false)
 			return
 		} else if command == "git-upload-pack" {
-			cfg.serveGitUploadPack(w, r, reqlogger, reponame)
+			cfg.serveGitUploadPack(w, r, perminfo, reqlogger, reponame, false)
 			return
 		} else if command == "git-receive-pack" {
 			if !cfg.checkAccess(perminfo, reponame, constants.CertPermissionWrite) {
@@ -132,12 +165,27 @@ func (cfg *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			cfg.serveGitReceivePack(w, r, reqlogger, reponame)
+			cfg.serveGitReceivePack(w, r, perminfo, reqlogger, reponame)
+			return
+		} else if command == "git-upload-archive" {
+			cfg.serveGitUploadArchive(w, r, perminfo, reqlogger, reponame)
+			return
+		} else if strings.HasPrefix(command, "archive/") {
+			cfg.serveGitArchive(w, r, perminfo, reqlogger, reponame, command)
+			return
+		} else if strings.HasPrefix(command, "info/lfs/") {
+			cfg.serveLFS(w, r, perminfo, reqlogger, reponame, command)
 			return
 		}
 		reqlogger.Debug("Unknown command requested")
 		http.NotFound(w, r)
 		return
+	} else if pathparts[0] == "api" {
+		if !decodeRequestBodyOrRespond(w, r, reqlogger) {
+			return
+		}
+		cfg.serveAPI(w, r, perminfo, reqlogger, pathparts[1:])
+		return
 	} else if pathparts[0] == "admin" {
 		if !cfg.checkAccess(perminfo, "*", constants.CertPermissionAdmin) {
 			reqlogger.Info("Unauthorized admin request received")
@@ -149,12 +197,39 @@ func (cfg *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if pathparts[1] == "nodeinfo" {
 			cfg.serveAdminNodeInfo(w, r)
 			return
+		} else if pathparts[1] == "clusterstatus" {
+			cfg.serveAdminClusterStatus(w, r)
+			return
+		} else if pathparts[1] == "removenode" {
+			cfg.serveAdminRemoveNode(w, r)
+			return
+		} else if pathparts[1] == "snapshot" {
+			cfg.serveAdminSnapshot(w, r)
+			return
+		} else if pathparts[1] == "backup" {
+			cfg.serveAdminBackup(w, r, reqlogger)
+			return
 		} else if pathparts[1] == "createrepo" {
 			cfg.serveAdminCreateRepo(w, r)
 			return
 		} else if pathparts[1] == "editrepo" {
 			cfg.serveAdminEditRepo(w, r)
 			return
+		} else if pathparts[1] == "deleterepo" {
+			cfg.serveAdminDeleteRepo(w, r)
+			return
+		} else if pathparts[1] == "undeleterepo" {
+			cfg.serveAdminUndeleteRepo(w, r)
+			return
+		} else if pathparts[1] == "renamerepo" {
+			cfg.serveAdminRenameRepo(w, r)
+			return
+		} else if pathparts[1] == "reflog" {
+			cfg.serveAdminReflog(w, r)
+			return
+		} else if pathparts[1] == "restorerepo" {
+			cfg.serveAdminRestoreRepo(w, r, perminfo, reqlogger)
+			return
 		} else if pathparts[1] == "listrepos" {
 			cfg.serveAdminListRepos(w, r)
 			return
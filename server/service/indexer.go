@@ -0,0 +1,273 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+// maxCachedRepoIndexes is the number of repos whose indexes are kept in memory.
+// The indexes of the least recently used repos are dropped, and loaded from disk again when needed.
+const maxCachedRepoIndexes = 64
+
+// repoIndexer keeps the indexes (commit-graphs and reachability bitmaps) of all repos,
+// and updates them in the background after pushes.
+// The indexes are only caches: if one can't be loaded, it is rebuilt from the objects.
+type repoIndexer struct {
+	cfg *Service
+
+	mux     sync.Mutex
+	graphs  map[string]*commitGraph
+	bitmaps map[string]*reachabilityIndex
+	pending map[string]bool
+	// lastused contains the value of usecounter when the indexes of a repo were last used
+	lastused   map[string]uint64
+	usecounter uint64
+
+	notifyC chan struct{}
+	stopC   chan struct{}
+}
+
+func (cfg *Service) createRepoIndexer() *repoIndexer {
+	return &repoIndexer{
+		cfg:      cfg,
+		graphs:   make(map[string]*commitGraph),
+		bitmaps:  make(map[string]*reachabilityIndex),
+		pending:  make(map[string]bool),
+		lastused: make(map[string]uint64),
+		notifyC:  make(chan struct{}, 1),
+		stopC:    make(chan struct{}),
+	}
+}
+
+func writeIndexUint32(w io.Writer, val uint32) error {
+	return binary.Write(w, binary.BigEndian, val)
+}
+
+func writeIndexObjectID(w io.Writer, objid storage.ObjectID) error {
+	raw, err := hex.DecodeString(string(objid))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	return err
+}
+
+func readIndexObjectID(r io.Reader) (storage.ObjectID, error) {
+	raw := make([]byte, 20)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return storage.ZeroID, err
+	}
+	return storage.ObjectIDFromRaw(raw), nil
+}
+
+// indexPath returns the path of the index file of type kind for a repo
+func (i *repoIndexer) indexPath(kind, reponame string) string {
+	return path.Join(i.cfg.statestore.directory, kind, url.PathEscape(reponame)+"."+kind)
+}
+
+// loadIndex calls read with the contents of an index file, if it exists
+func (i *repoIndexer) loadIndex(kind, reponame string, read func(io.Reader) error) {
+	f, err := os.Open(i.indexPath(kind, reponame))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		defer f.Close()
+		err = read(bufio.NewReader(f))
+	}
+	if err != nil {
+		i.cfg.log.Infow("Error loading index, starting fresh",
+			"kind", kind,
+			"reponame", reponame,
+			"error", err,
+		)
+	}
+}
+
+// saveIndex atomically replaces an index file with what write writes
+func (i *repoIndexer) saveIndex(kind, reponame string, write func(io.Writer) error) error {
+	indexpath := i.indexPath(kind, reponame)
+	if err := os.MkdirAll(path.Dir(indexpath), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(path.Dir(indexpath), "tmp_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), indexpath)
+}
+
+// markUsed records that the indexes of reponame are used, and drops the indexes of the least
+// recently used repos if too many are loaded. The indexer must be locked.
+func (i *repoIndexer) markUsed(reponame string) {
+	i.usecounter++
+	i.lastused[reponame] = i.usecounter
+
+	for len(i.lastused) > maxCachedRepoIndexes {
+		var oldest string
+		for name, used := range i.lastused {
+			if oldest == "" || used < i.lastused[oldest] {
+				oldest = name
+			}
+		}
+		delete(i.graphs, oldest)
+		delete(i.bitmaps, oldest)
+		delete(i.lastused, oldest)
+	}
+}
+
+// getCommitGraph returns the commit-graph of a repo, loading it from disk if needed
+func (i *repoIndexer) getCommitGraph(reponame string) *commitGraph {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.markUsed(reponame)
+	graph, loaded := i.graphs[reponame]
+	if !loaded {
+		graph = newCommitGraph()
+		i.loadIndex("commitgraph", reponame, func(r io.Reader) error {
+			return graph.readFrom(r)
+		})
+		i.graphs[reponame] = graph
+	}
+	return graph
+}
+
+// getBitmaps returns the reachability index of a repo, loading it from disk if needed
+func (i *repoIndexer) getBitmaps(reponame string) *reachabilityIndex {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.markUsed(reponame)
+	idx, loaded := i.bitmaps[reponame]
+	if !loaded {
+		idx = newReachabilityIndex()
+		i.loadIndex("bitmap", reponame, func(r io.Reader) error {
+			loadedidx, err := readReachabilityIndex(r)
+			if err == nil {
+				idx = loadedidx
+			}
+			return err
+		})
+		i.bitmaps[reponame] = idx
+	}
+	return idx
+}
+
+// replaceBitmaps replaces the reachability index of a repo, unless it was dropped since old was retrieved
+func (i *repoIndexer) replaceBitmaps(reponame string, old, idx *reachabilityIndex) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	if i.bitmaps[reponame] == old {
+		i.bitmaps[reponame] = idx
+	}
+}
+
+// forget drops the indexes of a repo that was removed
+func (i *repoIndexer) forget(reponame string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	delete(i.graphs, reponame)
+	delete(i.bitmaps, reponame)
+	delete(i.pending, reponame)
+	delete(i.lastused, reponame)
+	os.Remove(i.indexPath("commitgraph", reponame))
+	os.Remove(i.indexPath("bitmap", reponame))
+}
+
+// queueUpdate schedules an update of the indexes of a repo. It never blocks.
+func (i *repoIndexer) queueUpdate(reponame string) {
+	i.mux.Lock()
+	i.pending[reponame] = true
+	i.mux.Unlock()
+
+	select {
+	case i.notifyC <- struct{}{}:
+	default:
+		// An update run is already pending
+	}
+}
+
+func (i *repoIndexer) Run() {
+	for {
+		select {
+		case <-i.stopC:
+			return
+		case <-i.notifyC:
+			i.mux.Lock()
+			pending := i.pending
+			i.pending = make(map[string]bool)
+			i.mux.Unlock()
+
+			for reponame := range pending {
+				if err := i.update(reponame); err != nil {
+					// The next push or fetch will try again
+					i.cfg.log.Infow("Error updating repo indexes",
+						"reponame", reponame,
+						"error", err,
+					)
+				}
+			}
+		}
+	}
+}
+
+func (i *repoIndexer) Stop() {
+	close(i.stopC)
+}
+
+// update adds the current refs of a repo to its indexes, and saves them
+func (i *repoIndexer) update(reponame string) error {
+	var tips []storage.ObjectID
+	for _, refval := range i.cfg.statestore.getGitRefs(reponame) {
+		tips = append(tips, storage.ObjectID(refval))
+	}
+	p := i.cfg.gitstore.GetProjectStorage(reponame)
+
+	graph := i.getCommitGraph(reponame)
+	graph.mux.Lock()
+	err := graph.addTips(p, tips)
+	if err == nil {
+		err = i.saveIndex("commitgraph", reponame, graph.writeTo)
+	}
+	graph.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Fetches keep using the current index while the new one is built
+	idx := i.getBitmaps(reponame)
+	newidx, err := idx.withBitmaps(p, tips)
+	if err != nil {
+		return err
+	}
+	if err := i.saveIndex("bitmap", reponame, newidx.writeTo); err != nil {
+		return err
+	}
+	i.replaceBitmaps(reponame, idx, newidx)
+	return nil
+}
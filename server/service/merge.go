@@ -0,0 +1,317 @@
+package service
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// lineEdit replaces the base lines [start, end) with lines
+type lineEdit struct {
+	start int
+	end   int
+	lines []string
+}
+
+// getLineEdits groups the changes in ops into edits relative to the old side
+func getLineEdits(ops []diffOp) []lineEdit {
+	var edits []lineEdit
+	var current *lineEdit
+	pos := 0
+	for _, op := range ops {
+		if op.kind == diffEqual {
+			if current != nil {
+				edits = append(edits, *current)
+				current = nil
+			}
+			pos++
+			continue
+		}
+		if current == nil {
+			current = &lineEdit{start: pos, end: pos}
+		}
+		if op.kind == diffDelete {
+			current.end++
+			pos++
+		} else {
+			current.lines = append(current.lines, op.line)
+		}
+	}
+	if current != nil {
+		edits = append(edits, *current)
+	}
+	return edits
+}
+
+// applyLineEdits returns base lines [start, end) with edits, which must all be inside that range, applied
+func applyLineEdits(base []string, start, end int, edits []lineEdit) []string {
+	var result []string
+	pos := start
+	for _, edit := range edits {
+		result = append(result, base[pos:edit.start]...)
+		result = append(result, edit.lines...)
+		pos = edit.end
+	}
+	return append(result, base[pos:end]...)
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeLines performs a three-way merge of ours and theirs, which both derive from base.
+// Changes that touch the same or adjacent lines conflict, unless they are identical.
+func mergeLines(base, ours, theirs []string) ([]string, bool) {
+	oursops, ok := diffLines(base, ours)
+	if !ok {
+		return nil, false
+	}
+	theirsops, ok := diffLines(base, theirs)
+	if !ok {
+		return nil, false
+	}
+	oursedits := getLineEdits(oursops)
+	theirsedits := getLineEdits(theirsops)
+
+	var result []string
+	pos := 0
+	i, j := 0, 0
+	for i < len(oursedits) || j < len(theirsedits) {
+		// Find the region of overlapping edits starting at the first remaining edit
+		var start int
+		if j >= len(theirsedits) || (i < len(oursedits) && oursedits[i].start <= theirsedits[j].start) {
+			start = oursedits[i].start
+		} else {
+			start = theirsedits[j].start
+		}
+		end := start
+		firsti, firstj := i, j
+		for {
+			if i < len(oursedits) && oursedits[i].start <= end {
+				if oursedits[i].end > end {
+					end = oursedits[i].end
+				}
+				i++
+			} else if j < len(theirsedits) && theirsedits[j].start <= end {
+				if theirsedits[j].end > end {
+					end = theirsedits[j].end
+				}
+				j++
+			} else {
+				break
+			}
+		}
+
+		result = append(result, base[pos:start]...)
+		oursregion := applyLineEdits(base, start, end, oursedits[firsti:i])
+		theirsregion := applyLineEdits(base, start, end, theirsedits[firstj:j])
+		if firstj == j {
+			result = append(result, oursregion...)
+		} else if firsti == i || equalLines(oursregion, theirsregion) {
+			result = append(result, theirsregion...)
+		} else {
+			return nil, false
+		}
+		pos = end
+	}
+	return append(result, base[pos:]...), true
+}
+
+// mergeSide is one version of a tree entry in a merge
+type mergeSide struct {
+	entry  treeEntry
+	exists bool
+}
+
+func (s mergeSide) equals(o mergeSide) bool {
+	if !s.exists || !o.exists {
+		return s.exists == o.exists
+	}
+	return s.entry.objectid == o.entry.objectid && s.entry.mode == o.entry.mode
+}
+
+func (s mergeSide) isTree() bool {
+	return s.exists && s.entry.isTree()
+}
+
+func (s mergeSide) isTreeOrMissing() bool {
+	return !s.exists || s.entry.isTree()
+}
+
+func (s mergeSide) isRegularFile() bool {
+	return s.exists && !s.entry.isTree() && !s.entry.isSubmodule() && !s.entry.isSymlink()
+}
+
+func (s mergeSide) treeID() storage.ObjectID {
+	if s.isTree() {
+		return s.entry.objectid
+	}
+	return storage.ZeroID
+}
+
+func (s mergeSide) objectID() string {
+	if !s.exists {
+		return ""
+	}
+	return string(s.entry.objectid)
+}
+
+func readBlobLines(p storage.ProjectStorageDriver, side mergeSide) ([]string, bool, error) {
+	if !side.exists {
+		return nil, true, nil
+	}
+	content, toolarge, err := readBlobForDiff(p, side.entry.objectid)
+	if err != nil || toolarge || isBinaryContent(content) {
+		return nil, false, err
+	}
+	return splitLines(content), true, nil
+}
+
+// mergeFile merges a regular file that was changed on both sides.
+// It returns whether the merge succeeded, and if pusher is not nil, the new entry.
+func mergeFile(p storage.ProjectStorageDriver, pusher storage.ProjectStoragePushDriver, base, ours, theirs mergeSide) (treeEntry, bool, error) {
+	mode := ours.entry.mode
+	if base.exists && ours.entry.mode == base.entry.mode {
+		mode = theirs.entry.mode
+	} else if theirs.entry.mode != ours.entry.mode && !(base.exists && theirs.entry.mode == base.entry.mode) {
+		// Both sides changed the mode differently
+		return treeEntry{}, false, nil
+	}
+
+	entry := treeEntry{mode: mode, name: ours.entry.name, objectid: ours.entry.objectid}
+	if ours.entry.objectid == theirs.entry.objectid {
+		return entry, true, nil
+	}
+	baselines, ok, err := readBlobLines(p, base)
+	if err != nil || !ok {
+		return treeEntry{}, false, err
+	}
+	ourslines, ok, err := readBlobLines(p, ours)
+	if err != nil || !ok {
+		return treeEntry{}, false, err
+	}
+	theirslines, ok, err := readBlobLines(p, theirs)
+	if err != nil || !ok {
+		return treeEntry{}, false, err
+	}
+	merged, ok := mergeLines(baselines, ourslines, theirslines)
+	if !ok || pusher == nil {
+		return entry, ok, nil
+	}
+	entry.objectid, err = writeObject(pusher, storage.ObjectTypeBlob, []byte(strings.Join(merged, "")))
+	return entry, true, err
+}
+
+// getConflictType describes why a path could not be merged
+func getConflictType(base, ours, theirs mergeSide) string {
+	if !ours.exists || !theirs.exists {
+		return "modify/delete"
+	}
+	if ours.isTree() != theirs.isTree() || ours.isRegularFile() != theirs.isRegularFile() {
+		return "type"
+	}
+	if !base.exists {
+		return "add/add"
+	}
+	return "content"
+}
+
+// mergeTrees performs a three-way merge of the trees ours and theirs, with common ancestor base.
+// Any of the trees can be storage.ZeroID for an empty tree.
+// If pusher is nil, no objects are written and only the conflicts are determined.
+// The merged tree is storage.ZeroID if it is empty or no objects were written.
+func mergeTrees(p storage.ProjectStorageDriver, pusher storage.ProjectStoragePushDriver, base, ours, theirs storage.ObjectID, prefix string) (storage.ObjectID, []datastructures.APIMergeConflict, error) {
+	var sides [3]map[string]treeEntry
+	for i, treeid := range []storage.ObjectID{base, ours, theirs} {
+		entries, err := readTreeEntries(p, treeid)
+		if err != nil {
+			return storage.ZeroID, nil, err
+		}
+		sides[i] = entries
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, entries := range sides {
+		for name := range entries {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	result := make(map[string]treeEntry)
+	var conflicts []datastructures.APIMergeConflict
+	for _, name := range names {
+		var basev, oursv, theirsv mergeSide
+		basev.entry, basev.exists = sides[0][name]
+		oursv.entry, oursv.exists = sides[1][name]
+		theirsv.entry, theirsv.exists = sides[2][name]
+		entrypath := path.Join(prefix, name)
+
+		var merged mergeSide
+		if oursv.equals(theirsv) || theirsv.equals(basev) {
+			merged = oursv
+		} else if oursv.equals(basev) {
+			merged = theirsv
+		} else if oursv.isTreeOrMissing() && theirsv.isTreeOrMissing() && basev.isTreeOrMissing() {
+			// Directories on both sides (or removed on one side): merge their contents
+			subtree, subconflicts, err := mergeTrees(p, pusher, basev.treeID(), oursv.treeID(), theirsv.treeID(), entrypath)
+			if err != nil {
+				return storage.ZeroID, nil, err
+			}
+			conflicts = append(conflicts, subconflicts...)
+			if subtree != storage.ZeroID {
+				merged = mergeSide{
+					entry:  treeEntry{mode: os.ModeDir | 0755, name: name, objectid: subtree},
+					exists: true,
+				}
+			}
+		} else {
+			ok := false
+			if oursv.isRegularFile() && theirsv.isRegularFile() && (!basev.exists || basev.isRegularFile()) {
+				var err error
+				merged.entry, ok, err = mergeFile(p, pusher, basev, oursv, theirsv)
+				if err != nil {
+					return storage.ZeroID, nil, err
+				}
+				merged.exists = ok
+			}
+			if !ok {
+				conflicts = append(conflicts, datastructures.APIMergeConflict{
+					Path:     entrypath,
+					Type:     getConflictType(basev, oursv, theirsv),
+					BaseID:   basev.objectID(),
+					OursID:   oursv.objectID(),
+					TheirsID: theirsv.objectID(),
+				})
+			}
+		}
+		if merged.exists {
+			result[name] = merged.entry
+		}
+	}
+
+	if pusher == nil || len(conflicts) != 0 || len(result) == 0 {
+		return storage.ZeroID, conflicts, nil
+	}
+	tree, err := formatTree(result)
+	if err != nil {
+		return storage.ZeroID, nil, err
+	}
+	treeid, err := writeObject(pusher, storage.ObjectTypeTree, tree)
+	return treeid, nil, err
+}
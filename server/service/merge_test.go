@@ -0,0 +1,213 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+// newTestProject returns the storage of a project in a temporary tree storage
+func newTestProject(t *testing.T) (storage.ProjectStorageDriver, storage.ProjectStoragePushDriver, func()) {
+	dir, err := ioutil.TempDir("", "repospanner_test_")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+	driver, err := storage.InitializeStorageDriver(map[string]string{
+		"type":      "tree",
+		"directory": dir,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Error initializing storage: %s", err)
+	}
+	p := driver.GetProjectStorage("project")
+	return p, p.GetPusher(""), func() { os.RemoveAll(dir) }
+}
+
+func writeTestBlob(t *testing.T, pusher storage.ProjectStoragePushDriver, content string) storage.ObjectID {
+	objid, err := writeObject(pusher, storage.ObjectTypeBlob, []byte(content))
+	if err != nil {
+		t.Fatalf("Error writing blob: %s", err)
+	}
+	return objid
+}
+
+func writeTestTree(t *testing.T, pusher storage.ProjectStoragePushDriver, entries ...treeEntry) storage.ObjectID {
+	byname := make(map[string]treeEntry)
+	for _, entry := range entries {
+		byname[entry.name] = entry
+	}
+	content, err := formatTree(byname)
+	if err != nil {
+		t.Fatalf("Error formatting tree: %s", err)
+	}
+	objid, err := writeObject(pusher, storage.ObjectTypeTree, content)
+	if err != nil {
+		t.Fatalf("Error writing tree: %s", err)
+	}
+	return objid
+}
+
+func fileEntry(name string, objid storage.ObjectID) treeEntry {
+	return treeEntry{mode: 0644, name: name, objectid: objid}
+}
+
+func dirEntry(name string, objid storage.ObjectID) treeEntry {
+	return treeEntry{mode: os.ModeDir | 0755, name: name, objectid: objid}
+}
+
+func TestMergeLines(t *testing.T) {
+	base := splitLines([]byte("a\nb\nc\nd\ne\n"))
+	cases := []struct {
+		name     string
+		ours     string
+		theirs   string
+		expected string
+		ok       bool
+	}{
+		{"unchanged", "a\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\n", true},
+		{"ours only", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", true},
+		{"theirs only", "a\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "a\nb\nc\nd\nE\n", true},
+		{"separate changes", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", true},
+		{"insert and delete", "a\nb\nx\nc\nd\ne\n", "a\nb\nc\nd\n", "a\nb\nx\nc\nd\n", true},
+		{"identical changes", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", true},
+		{"conflicting changes", "a\nB\nc\nd\ne\n", "a\nX\nc\nd\ne\n", "", false},
+		{"adjacent changes", "a\nB\nc\nd\ne\n", "a\nb\nC\nd\ne\n", "", false},
+		{"both append", "a\nb\nc\nd\ne\nf\n", "a\nb\nc\nd\ne\ng\n", "", false},
+	}
+	for _, c := range cases {
+		merged, ok := mergeLines(base, splitLines([]byte(c.ours)), splitLines([]byte(c.theirs)))
+		if ok != c.ok {
+			t.Errorf("%s: merge returned %t, expected %t", c.name, ok, c.ok)
+			continue
+		}
+		if ok && strings.Join(merged, "") != c.expected {
+			t.Errorf("%s: merged to %q, expected %q", c.name, strings.Join(merged, ""), c.expected)
+		}
+	}
+}
+
+func TestMergeLinesTooLarge(t *testing.T) {
+	var base, ours []string
+	for i := 0; i < maxDiffEdits+1; i++ {
+		base = append(base, "a\n")
+		ours = append(ours, "b\n")
+	}
+	if _, ok := mergeLines(base, ours, base); ok {
+		t.Error("Merge with too many changes succeeded")
+	}
+}
+
+func TestMergeTrees(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	base1 := writeTestBlob(t, pusher, "a\nb\nc\nd\ne\n")
+	ours1 := writeTestBlob(t, pusher, "A\nb\nc\nd\ne\n")
+	theirs1 := writeTestBlob(t, pusher, "a\nb\nc\nd\nE\n")
+	merged1 := writeTestBlob(t, pusher, "A\nb\nc\nd\nE\n")
+	keep := writeTestBlob(t, pusher, "keep\n")
+	added := writeTestBlob(t, pusher, "added\n")
+
+	basesub := writeTestTree(t, pusher, fileEntry("keep", keep))
+	base := writeTestTree(t, pusher,
+		fileEntry("file", base1),
+		fileEntry("removed", keep),
+		dirEntry("sub", basesub),
+	)
+	ours := writeTestTree(t, pusher,
+		fileEntry("file", ours1),
+		dirEntry("sub", writeTestTree(t, pusher, fileEntry("keep", keep), fileEntry("ours", added))),
+	)
+	theirs := writeTestTree(t, pusher,
+		fileEntry("file", theirs1),
+		fileEntry("removed", keep),
+		fileEntry("new", added),
+		dirEntry("sub", writeTestTree(t, pusher, fileEntry("keep", keep), fileEntry("theirs", added))),
+	)
+	expected := writeTestTree(t, pusher,
+		fileEntry("file", merged1),
+		fileEntry("new", added),
+		dirEntry("sub", writeTestTree(t, pusher,
+			fileEntry("keep", keep),
+			fileEntry("ours", added),
+			fileEntry("theirs", added),
+		)),
+	)
+
+	result, conflicts, err := mergeTrees(p, pusher, base, ours, theirs, "")
+	if err != nil {
+		t.Fatalf("Error merging trees: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("Unexpected conflicts: %v", conflicts)
+	}
+	if result != expected {
+		t.Errorf("Merged tree is %s, expected %s", result, expected)
+	}
+
+	// Without a pusher, only the conflicts are determined
+	result, conflicts, err = mergeTrees(p, nil, base, ours, theirs, "")
+	if err != nil {
+		t.Fatalf("Error merging trees without pusher: %s", err)
+	}
+	if len(conflicts) != 0 || result != storage.ZeroID {
+		t.Errorf("Merge without pusher returned %s with conflicts %v", result, conflicts)
+	}
+}
+
+func TestMergeTreesConflicts(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	base1 := writeTestBlob(t, pusher, "a\nb\nc\n")
+	ours1 := writeTestBlob(t, pusher, "a\nB\nc\n")
+	theirs1 := writeTestBlob(t, pusher, "a\nX\nc\n")
+	other := writeTestBlob(t, pusher, "other\n")
+
+	base := writeTestTree(t, pusher,
+		fileEntry("content", base1),
+		fileEntry("modifydelete", base1),
+		fileEntry("type", base1),
+	)
+	ours := writeTestTree(t, pusher,
+		fileEntry("added", ours1),
+		fileEntry("content", ours1),
+		fileEntry("type", ours1),
+	)
+	theirs := writeTestTree(t, pusher,
+		fileEntry("added", theirs1),
+		fileEntry("content", theirs1),
+		fileEntry("modifydelete", other),
+		dirEntry("type", writeTestTree(t, pusher, fileEntry("file", other))),
+	)
+
+	_, conflicts, err := mergeTrees(p, pusher, base, ours, theirs, "dir")
+	if err != nil {
+		t.Fatalf("Error merging trees: %s", err)
+	}
+	expected := map[string]string{
+		"dir/added":        "add/add",
+		"dir/content":      "content",
+		"dir/modifydelete": "modify/delete",
+		"dir/type":         "type",
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Got conflicts %v, expected %v", conflicts, expected)
+	}
+	for _, conflict := range conflicts {
+		if expected[conflict.Path] != conflict.Type {
+			t.Errorf("Conflict %s has type %s, expected %s", conflict.Path, conflict.Type, expected[conflict.Path])
+		}
+	}
+	for _, conflict := range conflicts {
+		if conflict.Path == "dir/modifydelete" {
+			if conflict.BaseID != string(base1) || conflict.OursID != "" || conflict.TheirsID != string(other) {
+				t.Errorf("Unexpected object IDs in conflict: %v", conflict)
+			}
+		}
+	}
+}
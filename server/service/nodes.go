@@ -0,0 +1,42 @@
+package service
+
+import (
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/pkg/errors"
+)
+
+// proposeConfChange proposes a raft configuration change, and waits for it to be applied
+func (store *stateStore) proposeConfChange(cc raftpb.ConfChange) error {
+	ccC := store.subscribeConfChange()
+	defer store.unsubscribeConfChange(ccC)
+	store.confChangeC <- cc
+	// TODO: Add a resend timer
+	for msg := range ccC {
+		if msg.NodeID != cc.NodeID {
+			continue
+		}
+		if msg.Type != cc.Type {
+			return errors.Errorf("Unexpected config change for node %d: %s", msg.NodeID, msg.Type)
+		}
+		return nil
+	}
+	return errors.New("State store stopped")
+}
+
+// removeNode removes a node from the region, for example because its machine died.
+// The node can not rejoin with the same node ID.
+func (store *stateStore) removeNode(nodeid uint64) error {
+	if nodeid == store.cfg.nodeid {
+		return errors.New("A node can not remove itself, send the request to another node")
+	}
+	if _, exists := store.getPeerURL(nodeid); !exists {
+		return errors.Errorf("Node %d is not in the region", nodeid)
+	}
+	store.cfg.log.Infow("Node removal requested",
+		"nodeid", nodeid,
+	)
+	return store.proposeConfChange(raftpb.ConfChange{
+		Type:   raftpb.ConfChangeRemoveNode,
+		NodeID: nodeid,
+	})
+}
@@ -1,20 +1,31 @@
 package service
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"repospanner.org/repospanner/server/storage"
 )
 
 type commitInfo struct {
-	parents []storage.ObjectID
-	tree    storage.ObjectID
+	parents   []storage.ObjectID
+	tree      storage.ObjectID
+	author    string
+	committer string
+}
+
+type signature struct {
+	name  string
+	email string
+	when  time.Time
 }
 
 type treeEntry struct {
@@ -23,6 +34,49 @@ type treeEntry struct {
 	objectid storage.ObjectID
 }
 
+// isTree returns whether the entry is a subtree.
+// Note that readTree converts the git directory bit to os.ModeDir, which gitlinks also carry.
+func (e treeEntry) isTree() bool {
+	return e.mode&os.ModeDir != 0 && !e.isSubmodule()
+}
+
+func (e treeEntry) isSubmodule() bool {
+	return e.mode&os.ModeDir != 0 && e.mode&0120000 == 0120000
+}
+
+func (e treeEntry) isSymlink() bool {
+	return e.mode&os.ModeDir == 0 && e.mode&0170000 == 0120000
+}
+
+func (e treeEntry) isExecutable() bool {
+	return e.mode&os.ModeDir == 0 && e.mode&0111 != 0
+}
+
+// gitMode returns the mode as git would show it
+func (e treeEntry) gitMode() string {
+	if e.isSubmodule() {
+		return "160000"
+	} else if e.isTree() {
+		return "040000"
+	} else if e.isSymlink() {
+		return "120000"
+	} else if e.isExecutable() {
+		return "100755"
+	}
+	return "100644"
+}
+
+func (e treeEntry) typeName() string {
+	if e.isSubmodule() {
+		return "submodule"
+	} else if e.isTree() {
+		return "tree"
+	} else if e.isSymlink() {
+		return "symlink"
+	}
+	return "blob"
+}
+
 type treeInfo struct {
 	entries []treeEntry
 }
@@ -171,9 +225,55 @@ func parseCommitInfo(headers map[string][]string) commitInfo {
 			info.parents = append(info.parents, storage.ObjectID(parent))
 		}
 	}
+	author, hasauthor := headers["author"]
+	if hasauthor {
+		info.author = author[0]
+	}
+	committer, hascommitter := headers["committer"]
+	if hascommitter {
+		info.committer = committer[0]
+	}
 	return info
 }
 
+// parseSignature parses an author or committer line ("Name <email> 1234567890 +0100").
+func parseSignature(line string) (sig signature, err error) {
+	emailstart := strings.Index(line, "<")
+	emailend := strings.LastIndex(line, ">")
+	if emailstart == -1 || emailend < emailstart {
+		return sig, fmt.Errorf("Invalid signature %s", line)
+	}
+	sig.name = strings.TrimSpace(line[:emailstart])
+	sig.email = line[emailstart+1 : emailend]
+
+	split := strings.Fields(line[emailend+1:])
+	if len(split) != 2 {
+		return sig, fmt.Errorf("Invalid signature date in %s", line)
+	}
+	timestamp, err := strconv.ParseInt(split[0], 10, 64)
+	if err != nil {
+		return sig, err
+	}
+	tz := split[1]
+	if len(tz) != 5 {
+		return sig, fmt.Errorf("Invalid signature timezone in %s", line)
+	}
+	tzhours, err := strconv.Atoi(tz[1:3])
+	if err != nil {
+		return sig, err
+	}
+	tzminutes, err := strconv.Atoi(tz[3:5])
+	if err != nil {
+		return sig, err
+	}
+	offset := tzhours*3600 + tzminutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	sig.when = time.Unix(timestamp, 0).In(time.FixedZone(tz, offset))
+	return sig, nil
+}
+
 func readCommit(r io.Reader) (info commitInfo, err error) {
 	reader := headerObjectReader{r: r}
 	err = reader.ParseFullHeader()
@@ -181,6 +281,20 @@ func readCommit(r io.Reader) (info commitInfo, err error) {
 	return
 }
 
+// readCommitWithMessage parses a commit, and also returns the commit message
+func readCommitWithMessage(r io.Reader) (info commitInfo, message string, err error) {
+	cts, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	info, err = readCommit(bytes.NewReader(cts))
+	split := bytes.SplitN(cts, []byte("\n\n"), 2)
+	if len(split) == 2 {
+		message = string(split[1])
+	}
+	return
+}
+
 func readTag(r io.Reader) (info tagInfo, err error) {
 	reader := headerObjectReader{r: r}
 	err = reader.ParseFullHeader()
@@ -318,6 +432,38 @@ func (t *treeReader) ParseFullTree() error {
 	}
 }
 
+// getObjectChildren returns the objects a commit, tree or tag refers to
+func getObjectChildren(objid storage.ObjectID, objtype storage.ObjectType, content []byte) ([]storage.ObjectID, error) {
+	switch objtype {
+	case storage.ObjectTypeCommit:
+		info, err := readCommit(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		return append([]storage.ObjectID{info.tree}, info.parents...), nil
+	case storage.ObjectTypeTree:
+		info, err := readTree(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		var children []storage.ObjectID
+		for _, entry := range info.entries {
+			if !entry.isSubmodule() {
+				children = append(children, entry.objectid)
+			}
+		}
+		return children, nil
+	case storage.ObjectTypeTag:
+		info, err := readTag(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		return []storage.ObjectID{info.object}, nil
+	default:
+		return nil, fmt.Errorf("Object %s has an invalid type", objid)
+	}
+}
+
 func readTree(r io.Reader) (info treeInfo, err error) {
 	treader := &treeReader{r: r}
 	err = treader.ParseFullTree()
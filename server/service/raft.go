@@ -6,13 +6,18 @@ import (
 	"hash/fnv"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/etcdserver/stats"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/wal/walpb"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
 
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
@@ -26,12 +31,12 @@ type stateRaftNode struct {
 
 	proposeC    <-chan []byte
 	confChangeC <-chan raftpb.ConfChange
-	commitC     chan<- *[]byte
+	commitC     chan<- *committedEntry
 	errorC      chan<- error
 
 	waldir      string
 	snapdir     string
-	getSnapshot func() ([]byte, error)
+	getSnapshot func(index uint64) ([]byte, error)
 	lastIndex   uint64
 
 	confState     raftpb.ConfState
@@ -45,8 +50,17 @@ type stateRaftNode struct {
 	snapshotter      *snap.Snapshotter
 	snapshotterReady chan *snap.Snapshotter
 
-	snapCount uint64
-	transport *rafthttp.Transport
+	snapCount      uint64
+	forceSnapshotC chan chan snapshotResult
+	transport      *rafthttp.Transport
+
+	unreachableMux sync.Mutex
+	unreachable    map[uint64]time.Time
+
+	readIndexMux     sync.Mutex
+	readIndexCounter uint64
+	readIndexWaiters map[string]chan uint64
+
 	stoppedc  chan struct{}
 	stopc     chan struct{}
 	httpstopc chan struct{}
@@ -61,8 +75,22 @@ func (cfg *Service) getRegionHash() uint64 {
 
 const defaultSnapCount uint64 = 10000
 
+// getSnapCount returns the number of applied entries after which the state gets snapshotted
+func getSnapCount() uint64 {
+	if viper.IsSet("storage.snapshot_count") {
+		return uint64(viper.GetInt64("storage.snapshot_count"))
+	}
+	return defaultSnapCount
+}
+
+// committedEntry is a committed raft log entry, to be applied by the state store
+type committedEntry struct {
+	index uint64
+	data  []byte
+}
+
 func (store *stateStore) createStateRaftNode() {
-	commitC := make(chan *[]byte)
+	commitC := make(chan *committedEntry)
 	errorC := make(chan error)
 	proposeC := make(chan []byte)
 	confChangeC := make(chan raftpb.ConfChange)
@@ -77,11 +105,15 @@ func (store *stateStore) createStateRaftNode() {
 		waldir:      fmt.Sprintf("%s/wal", store.directory),
 		snapdir:     fmt.Sprintf("%s/snap", store.directory),
 		getSnapshot: store.getSnapshot,
-		snapCount:   defaultSnapCount,
+		snapCount:   getSnapCount(),
 		stopc:       make(chan struct{}),
 		stoppedc:    make(chan struct{}),
 		httpstopc:   make(chan struct{}),
 		httpdonec:   make(chan struct{}),
+		unreachable: make(map[uint64]time.Time),
+
+		readIndexWaiters: make(map[string]chan uint64),
+		forceSnapshotC:   make(chan chan snapshotResult),
 
 		snapshotterReady: snapshotterReady,
 	}
@@ -126,13 +158,12 @@ func (rc *stateRaftNode) publishEntries(ents []raftpb.Entry) bool {
 	for i := range ents {
 		switch ents[i].Type {
 		case raftpb.EntryNormal:
-			if len(ents[i].Data) == 0 {
-				// ignore empty messages
-				break
+			entry := &committedEntry{
+				index: ents[i].Index,
+				data:  ents[i].Data,
 			}
-			s := ents[i].Data
 			select {
-			case rc.commitC <- &s:
+			case rc.commitC <- entry:
 			case <-rc.stopc:
 				return false
 			}
@@ -142,9 +173,9 @@ func (rc *stateRaftNode) publishEntries(ents []raftpb.Entry) bool {
 			cc.Unmarshal(ents[i].Data)
 			rc.confState = *rc.node.ApplyConfChange(cc)
 			switch cc.Type {
-			case raftpb.ConfChangeAddNode:
+			case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
 				if len(cc.Context) > 0 {
-					rc.store.Peers[cc.NodeID] = string(cc.Context)
+					rc.store.setPeer(cc.NodeID, string(cc.Context), cc.Type == raftpb.ConfChangeAddLearnerNode)
 					rc.store.Save()
 					rc.transport.AddPeer(types.ID(cc.NodeID), []string{string(cc.Context)})
 					rc.store.cfg.sync.AddPeer(cc.NodeID)
@@ -154,12 +185,22 @@ func (rc *stateRaftNode) publishEntries(ents []raftpb.Entry) bool {
 					rc.store.cfg.log.Info("Removed from cluster, shutting down")
 					return false
 				}
-				delete(rc.store.Peers, cc.NodeID)
-				rc.store.Save()
-				rc.transport.RemovePeer(types.ID(cc.NodeID))
+				// When the log is replayed, the peer might already be gone from the saved state
+				if rc.store.removePeer(cc.NodeID) {
+					rc.store.Save()
+					rc.transport.RemovePeer(types.ID(cc.NodeID))
+				}
+				rc.store.cfg.sync.RemovePeer(cc.NodeID)
 			}
 
 			rc.store.announceConfChange(cc)
+
+			// Let the state store know that this index has been applied
+			select {
+			case rc.commitC <- &committedEntry{index: ents[i].Index}:
+			case <-rc.stopc:
+				return false
+			}
 		}
 
 		// after commit, update appliedIndex
@@ -223,6 +264,12 @@ func (rc *stateRaftNode) replayWAL() *wal.WAL {
 	if err != nil {
 		log.Fatalf("raftexample: failed to read WAL (%v)", err)
 	}
+	if rc.store.cfg.ForceNewCluster {
+		st, ents, err = rc.forceNewCluster(w, snapshot, st, ents)
+		if err != nil {
+			rc.store.cfg.log.Fatalw("Error forcing new cluster", "err", err)
+		}
+	}
 	rc.raftStorage = raft.NewMemoryStorage()
 	if snapshot != nil {
 		rc.raftStorage.ApplySnapshot(*snapshot)
@@ -260,11 +307,11 @@ func (rc *stateRaftNode) startRaft(startedC chan<- struct{}) {
 	oldwal := wal.Exist(rc.waldir)
 	rc.wal = rc.replayWAL()
 
-	rpeers := make([]raft.Peer, len(rc.store.Peers))
-	var i int
-	for nid := range rc.store.Peers {
-		rpeers[i] = raft.Peer{ID: nid}
-		i++
+	var rpeers []raft.Peer
+	for nid := range rc.store.getPeers() {
+		if !rc.store.isLearner(nid) {
+			rpeers = append(rpeers, raft.Peer{ID: nid})
+		}
 	}
 	c := &raft.Config{
 		ID:              rc.store.cfg.nodeid,
@@ -299,7 +346,7 @@ func (rc *stateRaftNode) startRaft(startedC chan<- struct{}) {
 	close(startedC)
 
 	rc.transport.Start()
-	for pid, purl := range rc.store.Peers {
+	for pid, purl := range rc.store.getPeers() {
 		rc.transport.AddPeer(types.ID(pid), []string{purl})
 	}
 
@@ -346,39 +393,116 @@ func (rc *stateRaftNode) publishSnapshot(snapshotToSave raftpb.Snapshot) {
 
 var snapshotCatchUpEntriesN uint64 = 10000
 
+// maxSnapFiles is the number of snapshot files that are kept on disk
+const maxSnapFiles = 5
+
+type snapshotResult struct {
+	index        uint64
+	removedWALs  int
+	removedSnaps int
+	err          error
+}
+
 func (rc *stateRaftNode) maybeTriggerSnapshot() {
 	if rc.appliedIndex-rc.snapshotIndex <= rc.snapCount {
 		return
 	}
 
-	rc.store.cfg.log.Debugw("Starting snapshot",
-		"applied-index", rc.appliedIndex,
-		"last-index", rc.snapshotIndex,
-	)
-	data, err := rc.getSnapshot()
-	if err != nil {
-		log.Panic(err)
+	if res := rc.triggerSnapshot(); res.err != nil {
+		log.Panic(res.err)
 	}
-	snap, err := rc.raftStorage.CreateSnapshot(rc.appliedIndex, &rc.confState, data)
-	if err != nil {
-		panic(err)
+}
+
+// triggerSnapshot snapshots the state as of the applied index, compacts the log, and removes
+// the WAL and snapshot files that are no longer needed
+func (rc *stateRaftNode) triggerSnapshot() (res snapshotResult) {
+	res.index = rc.snapshotIndex
+	if rc.appliedIndex > rc.snapshotIndex {
+		rc.store.cfg.log.Debugw("Starting snapshot",
+			"applied-index", rc.appliedIndex,
+			"last-index", rc.snapshotIndex,
+		)
+		data, err := rc.getSnapshot(rc.appliedIndex)
+		if err != nil {
+			res.err = err
+			return
+		}
+		snap, err := rc.raftStorage.CreateSnapshot(rc.appliedIndex, &rc.confState, data)
+		if err != nil {
+			res.err = err
+			return
+		}
+		if err := rc.saveSnap(snap); err != nil {
+			res.err = err
+			return
+		}
+
+		compactIndex := uint64(1)
+		if rc.appliedIndex > snapshotCatchUpEntriesN {
+			compactIndex = rc.appliedIndex - snapshotCatchUpEntriesN
+		}
+		if err := rc.raftStorage.Compact(compactIndex); err != nil {
+			res.err = err
+			return
+		}
+
+		rc.store.cfg.log.Debugw("Compacted log",
+			"index", compactIndex,
+		)
+		rc.snapshotIndex = rc.appliedIndex
+		res.index = rc.snapshotIndex
 	}
-	if err := rc.saveSnap(snap); err != nil {
-		panic(err)
+
+	// The WAL files before the snapshot got unlocked when it was saved
+	res.removedWALs, res.err = purgeFiles(rc.waldir, ".wal", 1)
+	if res.err != nil {
+		return
 	}
+	res.removedSnaps, res.err = purgeFiles(rc.snapdir, ".snap", maxSnapFiles)
+	return
+}
 
-	compactIndex := uint64(1)
-	if rc.appliedIndex > snapshotCatchUpEntriesN {
-		compactIndex = rc.appliedIndex - snapshotCatchUpEntriesN
+// forceSnapshot makes the raft node snapshot the state and compact the WAL now, regardless
+// of the number of entries applied since the last snapshot
+func (rc *stateRaftNode) forceSnapshot() snapshotResult {
+	resultC := make(chan snapshotResult, 1)
+	select {
+	case rc.forceSnapshotC <- resultC:
+	case <-rc.stopc:
+		return snapshotResult{err: errors.New("Raft node stopped")}
 	}
-	if err := rc.raftStorage.Compact(compactIndex); err != nil {
-		panic(err)
+	return <-resultC
+}
+
+// purgeFiles removes the oldest files in dir with the suffix, keeping the newest keep files
+// and any file that is still locked, as well as all files newer than that
+func purgeFiles(dir, suffix string, keep int) (int, error) {
+	names, err := fileutil.ReadDir(dir)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error listing files to purge")
+	}
+	var files []string
+	for _, name := range names {
+		if strings.HasSuffix(name, suffix) {
+			files = append(files, name)
+		}
 	}
 
-	rc.store.cfg.log.Debugw("Compacted log",
-		"index", compactIndex,
-	)
-	rc.snapshotIndex = rc.appliedIndex
+	removed := 0
+	for i := 0; i < len(files)-keep; i++ {
+		fpath := filepath.Join(dir, files[i])
+		lock, err := fileutil.TryLockFile(fpath, os.O_WRONLY, fileutil.PrivateFileMode)
+		if err != nil {
+			break
+		}
+		err = os.Remove(fpath)
+		lock.Close()
+		if err != nil {
+			return removed, errors.Wrap(err, "Error purging file")
+		}
+		removed++
+	}
+	return removed, nil
 }
 
 func (rc *stateRaftNode) serveChannels() {
@@ -439,6 +563,9 @@ func (rc *stateRaftNode) serveChannels() {
 			}
 			rc.raftStorage.Append(rd.Entries)
 			rc.transport.Send(rd.Messages)
+			for _, rs := range rd.ReadStates {
+				rc.publishReadState(rs)
+			}
 			if ok := rc.publishEntries(rc.entriesToApply(rd.CommittedEntries)); !ok {
 				rc.stop()
 				return
@@ -446,6 +573,9 @@ func (rc *stateRaftNode) serveChannels() {
 			rc.maybeTriggerSnapshot()
 			rc.node.Advance()
 
+		case resultC := <-rc.forceSnapshotC:
+			resultC <- rc.triggerSnapshot()
+
 		case err := <-rc.transport.ErrorC:
 			rc.writeError(err)
 			return
@@ -469,6 +599,64 @@ func (rc *stateRaftNode) ReportUnreachable(id uint64) {
 	/*rc.store.cfg.log.Debugw("Node found unreachable",
 		"nodeid", id,
 	)*/
+	rc.unreachableMux.Lock()
+	defer rc.unreachableMux.Unlock()
+	rc.unreachable[id] = time.Now()
+}
+
+// readIndex returns the commit index of the leader, which reads have to wait for to be linearizable
+func (rc *stateRaftNode) readIndex(ctx context.Context) (uint64, error) {
+	rc.readIndexMux.Lock()
+	rc.readIndexCounter++
+	reqid := fmt.Sprintf("%d-%d", rc.store.cfg.nodeid, rc.readIndexCounter)
+	waiter := make(chan uint64, 1)
+	rc.readIndexWaiters[reqid] = waiter
+	rc.readIndexMux.Unlock()
+
+	defer func() {
+		rc.readIndexMux.Lock()
+		defer rc.readIndexMux.Unlock()
+		delete(rc.readIndexWaiters, reqid)
+	}()
+
+	if err := rc.node.ReadIndex(ctx, []byte(reqid)); err != nil {
+		return 0, err
+	}
+	select {
+	case index := <-waiter:
+		return index, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (rc *stateRaftNode) publishReadState(rs raft.ReadState) {
+	rc.readIndexMux.Lock()
+	defer rc.readIndexMux.Unlock()
+
+	if waiter, exists := rc.readIndexWaiters[string(rs.RequestCtx)]; exists {
+		select {
+		case waiter <- rs.Index:
+		default:
+		}
+	}
+}
+
+// unreachableWindow is how long a peer counts as unreachable after raft reported it
+const unreachableWindow = time.Minute
+
+// getUnreachablePeers returns the peers that were reported unreachable recently
+func (rc *stateRaftNode) getUnreachablePeers() map[uint64]time.Time {
+	rc.unreachableMux.Lock()
+	defer rc.unreachableMux.Unlock()
+
+	peers := make(map[uint64]time.Time)
+	for id, reported := range rc.unreachable {
+		if time.Since(reported) < unreachableWindow {
+			peers[id] = reported
+		}
+	}
+	return peers
 }
 
 func (rc *stateRaftNode) ReportSnapshot(id uint64, status raft.SnapshotStatus) {
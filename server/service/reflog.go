@@ -0,0 +1,52 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+)
+
+// maxReflogEntries is how many ref updates are kept per repo
+const maxReflogEntries = 1000
+
+// appendReflog adds the ref updates of a push applied at raft index to a reflog, dropping the
+// oldest entries when it grows beyond maxReflogEntries
+func appendReflog(reflog []datastructures.ReflogEntry, req *pb.PushRequest, index uint64) []datastructures.ReflogEntry {
+	for _, request := range req.Requests {
+		reflog = append(reflog, datastructures.ReflogEntry{
+			Ref:           request.GetRef(),
+			OldValue:      request.GetFrom(),
+			NewValue:      request.GetTo(),
+			Forced:        request.Fastforward != nil && !request.GetFastforward(),
+			Pusher:        req.GetPusher(),
+			PusherAddress: req.GetPusheraddress(),
+			PushNode:      req.GetPushnode(),
+			PushTime:      req.GetPushtime(),
+			RaftIndex:     index,
+		})
+	}
+	if len(reflog) > maxReflogEntries {
+		trimmed := make([]datastructures.ReflogEntry, maxReflogEntries)
+		copy(trimmed, reflog[len(reflog)-maxReflogEntries:])
+		reflog = trimmed
+	}
+	return reflog
+}
+
+// getReflog returns the reflog of a repo, limited to the entries of ref if it is not empty
+func (store *stateStore) getReflog(repo, ref string) ([]datastructures.ReflogEntry, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	if !store.hasRepo(repo) {
+		return nil, errors.Errorf("Repo %s does not exists", repo)
+	}
+	var entries []datastructures.ReflogEntry
+	for _, entry := range store.repoinfos[repo].Reflog {
+		if ref == "" || entry.Ref == ref {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// isReflogEntryAfter returns whether a reflog entry was applied after the point in time of a
+// restore request, which is either a raft index or a time
+func isReflogEntryAfter(entry datastructures.ReflogEntry, req datastructures.RepoRestoreRequest) bool {
+	if req.RaftIndex != 0 {
+		return entry.RaftIndex > req.RaftIndex
+	}
+	return entry.PushTime > req.Time
+}
+
+// getRestoreUpdates determines the ref updates needed to bring the refs of a repo back to their
+// state at the point in time of a restore request, by undoing the reflog entries after it.
+func (store *stateStore) getRestoreUpdates(req datastructures.RepoRestoreRequest) ([]*pb.UpdateRequest, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	if !store.hasRepo(req.Reponame) {
+		return nil, errors.Errorf("Repo %s does not exists", req.Reponame)
+	}
+	info := store.repoinfos[req.Reponame]
+
+	target := make(map[string]string)
+	for refname, refval := range info.Refs {
+		target[refname] = refval
+	}
+	undone := 0
+	for i := len(info.Reflog) - 1; i >= 0; i-- {
+		entry := info.Reflog[i]
+		if !isReflogEntryAfter(entry, req) {
+			break
+		}
+		if storage.ObjectID(entry.OldValue) == storage.ZeroID {
+			delete(target, entry.Ref)
+		} else {
+			target[entry.Ref] = entry.OldValue
+		}
+		undone++
+	}
+	if undone == maxReflogEntries {
+		// Older entries were dropped, and some of them may be after the requested point as well
+		return nil, errors.New("The reflog does not go back far enough")
+	}
+
+	inscope := func(refname string) bool {
+		if len(req.Refs) == 0 {
+			return true
+		}
+		for _, ref := range req.Refs {
+			if ref == refname {
+				return true
+			}
+		}
+		return false
+	}
+	var refnames []string
+	for refname := range info.Refs {
+		if inscope(refname) && info.Refs[refname] != target[refname] {
+			refnames = append(refnames, refname)
+		}
+	}
+	for refname := range target {
+		if _, exists := info.Refs[refname]; !exists && inscope(refname) {
+			refnames = append(refnames, refname)
+		}
+	}
+	sort.Strings(refnames)
+
+	var updates []*pb.UpdateRequest
+	for _, refname := range refnames {
+		from, exists := info.Refs[refname]
+		if !exists {
+			from = string(storage.ZeroID)
+		}
+		to, exists := target[refname]
+		if !exists {
+			to = string(storage.ZeroID)
+		}
+		updates = append(updates, pb.NewUpdateRequest(refname, from, to))
+	}
+	return updates, nil
+}
+
+// restoreRefs pushes the refs of a repo back to their state at an earlier point in time.
+// The hooks are not run for restores, and the branch protection rules do not apply.
+// It returns a description of the updated refs.
+func (cfg *Service) restoreRefs(reqlogger *zap.SugaredLogger, req datastructures.RepoRestoreRequest, pusher, address string) (string, error) {
+	updates, err := cfg.statestore.getRestoreUpdates(req)
+	if err != nil {
+		return "", err
+	}
+	if len(updates) == 0 {
+		return "All refs are already in the requested state", nil
+	}
+
+	p := cfg.gitstore.GetProjectStorage(req.Reponame)
+	toupdate := pb.NewPushRequest(cfg.nodeid, req.Reponame)
+	toupdate.SetPusher(pusher, address)
+	restore := true
+	toupdate.Restore = &restore
+
+	var summary []string
+	for _, update := range updates {
+		if update.ToObject() != storage.ZeroID {
+			exists, err := hasObject(p, update.ToObject())
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				return "", errors.Errorf("Object %s of %s is no longer available", update.ToObject(), update.GetRef())
+			}
+		}
+		toupdate.AddRequest(update)
+		summary = append(summary, fmt.Sprintf("%s: %s -> %s", update.GetRef(), update.GetFrom(), update.GetTo()))
+	}
+
+	if err := validateObjects(p, toupdate, false); err != nil {
+		reqlogger.Infow("Object validation failure", "err", err)
+		return "", errors.New("Object validation failed")
+	}
+	if err := addPeeledTargets(p, toupdate); err != nil {
+		reqlogger.Infow("Error peeling tags", "err", err)
+		return "", errors.New("Object validation failed")
+	}
+	if err := addFastForwardInfo(p, cfg.indexer.getCommitGraph(req.Reponame), toupdate); err != nil {
+		reqlogger.Infow("Error determining fast-forwards", "err", err)
+		return "", errors.New("Object validation failed")
+	}
+
+	reqlogger.Infow("Restoring refs",
+		"reponame", req.Reponame,
+		"updates", summary,
+	)
+	pushresult := cfg.statestore.performPush(toupdate)
+	if !pushresult.success {
+		reqlogger.Infow("Restore push failed", "error", pushresult.logerror)
+		return "", pushresult.clienterror
+	}
+	return strings.Join(summary, "\n"), nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+func testCommitID(n int) string {
+	return fmt.Sprintf("%040x", n)
+}
+
+// pushTestReflog applies a push of a single ref update to info at raft index
+func pushTestReflog(info *datastructures.RepoInfo, index uint64, ref, from, to string) {
+	req := pb.NewPushRequest(1, "repo")
+	pushtime := int64(index) * 10
+	req.Pushtime = &pushtime
+	req.AddRequest(pb.NewUpdateRequest(ref, from, to))
+	info.Reflog = appendReflog(info.Reflog, req, index)
+	if storage.ObjectID(to) == storage.ZeroID {
+		delete(info.Refs, ref)
+	} else {
+		info.Refs[ref] = to
+	}
+}
+
+func checkRestoreUpdates(t *testing.T, store *stateStore, req datastructures.RepoRestoreRequest, expected ...string) {
+	updates, err := store.getRestoreUpdates(req)
+	if err != nil {
+		t.Fatalf("Error determining restore updates: %s", err)
+	}
+	var descriptions []string
+	for _, update := range updates {
+		descriptions = append(descriptions, fmt.Sprintf("%s:%s..%s", update.GetRef(), update.GetFrom()[39:], update.GetTo()[39:]))
+	}
+	if fmt.Sprint(descriptions) != fmt.Sprint(expected) {
+		t.Errorf("Restore updates for %+v are %v, expected %v", req, descriptions, expected)
+	}
+}
+
+func TestGetRestoreUpdates(t *testing.T) {
+	zero := string(storage.ZeroID)
+	info := datastructures.RepoInfo{Refs: make(map[string]string)}
+	pushTestReflog(&info, 1, "refs/heads/main", zero, testCommitID(1))
+	pushTestReflog(&info, 2, "refs/heads/main", testCommitID(1), testCommitID(2))
+	pushTestReflog(&info, 3, "refs/heads/feature", zero, testCommitID(3))
+	pushTestReflog(&info, 4, "refs/heads/old", zero, testCommitID(4))
+	pushTestReflog(&info, 5, "refs/heads/main", testCommitID(2), testCommitID(5))
+	pushTestReflog(&info, 6, "refs/heads/old", testCommitID(4), zero)
+	store := &stateStore{repoinfos: map[string]datastructures.RepoInfo{"repo": info}}
+
+	checkRestoreUpdates(t, store, datastructures.RepoRestoreRequest{Reponame: "repo", RaftIndex: 6})
+	checkRestoreUpdates(t, store, datastructures.RepoRestoreRequest{Reponame: "repo", RaftIndex: 4},
+		"refs/heads/main:5..2",
+		"refs/heads/old:0..4",
+	)
+	checkRestoreUpdates(t, store, datastructures.RepoRestoreRequest{Reponame: "repo", RaftIndex: 2},
+		"refs/heads/feature:3..0",
+		"refs/heads/main:5..2",
+	)
+	checkRestoreUpdates(t, store, datastructures.RepoRestoreRequest{Reponame: "repo", Time: 25, Refs: []string{"refs/heads/main"}},
+		"refs/heads/main:5..2",
+	)
+
+	if _, err := store.getRestoreUpdates(datastructures.RepoRestoreRequest{Reponame: "missing", RaftIndex: 1}); err == nil {
+		t.Error("Restoring a missing repo succeeded")
+	}
+}
+
+func TestGetRestoreUpdatesTruncatedReflog(t *testing.T) {
+	info := datastructures.RepoInfo{Refs: make(map[string]string)}
+	pushTestReflog(&info, 1, "refs/heads/main", string(storage.ZeroID), testCommitID(1))
+	for i := 2; i <= maxReflogEntries+1; i++ {
+		pushTestReflog(&info, uint64(i), "refs/heads/main", testCommitID(i-1), testCommitID(i))
+	}
+	if len(info.Reflog) != maxReflogEntries {
+		t.Fatalf("Reflog has %d entries, expected %d", len(info.Reflog), maxReflogEntries)
+	}
+	store := &stateStore{repoinfos: map[string]datastructures.RepoInfo{"repo": info}}
+
+	if _, err := store.getRestoreUpdates(datastructures.RepoRestoreRequest{Reponame: "repo", RaftIndex: 1}); err == nil {
+		t.Error("Restoring to before the oldest reflog entry succeeded")
+	}
+	// The oldest kept entry is the update at index 2, which can still be undone
+	updates, err := store.getRestoreUpdates(datastructures.RepoRestoreRequest{Reponame: "repo", RaftIndex: 2})
+	if err != nil {
+		t.Fatalf("Error restoring to the oldest reflog entry: %s", err)
+	}
+	if len(updates) != 1 || updates[0].GetTo() != testCommitID(2) {
+		t.Errorf("Restore updates are %v", updates)
+	}
+}
@@ -0,0 +1,627 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// defaultReplicationInterval is how often repos are replicated from other regions, unless
+// configured otherwise
+const defaultReplicationInterval = 30 * time.Second
+
+func getReplicationInterval() time.Duration {
+	if viper.IsSet("replication.interval") {
+		return viper.GetDuration("replication.interval")
+	}
+	return defaultReplicationInterval
+}
+
+// getReplicationMaxPackObjects returns the maximum number of objects accepted in a single pack
+// replicated from another region, or 0 if unlimited
+func getReplicationMaxPackObjects() uint32 {
+	return uint32(viper.GetInt("replication.max_pack_objects"))
+}
+
+// getReplicationRegions returns the other regions that repos are replicated with, and the RPC
+// URLs of their nodes
+func getReplicationRegions() map[string][]string {
+	return viper.GetStringMapStringSlice("replication.regions")
+}
+
+// isReplicationRegion returns whether nodes of another region can connect for replication
+func isReplicationRegion(region string) bool {
+	_, configured := getReplicationRegions()[strings.ToLower(region)]
+	return configured
+}
+
+// isReplicatedTo returns whether a repo is replicated to region
+func isReplicatedTo(info datastructures.RepoInfo, region string) bool {
+	for _, replregion := range info.Regions {
+		if replregion == region {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWritable returns an error if a repo is a read-only replica of a repo in another region
+func (store *stateStore) checkWritable(repo string) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	if replicaof := store.repoinfos[repo].ReplicaOf; replicaof != "" {
+		return errors.Errorf("Repo %s is a read-only replica from region %s", repo, replicaof)
+	}
+	return nil
+}
+
+// getReplicationState returns the state of the repos replicated to region that changed after the
+// since index, so that the other region only has to replay what changed since its last sync.
+// All replicated repos are returned if since is 0.
+func (store *stateStore) getReplicationState(region string, since uint64) datastructures.ReplicationState {
+	// Everything up to this index is applied, changes applied while collecting the repos below are
+	// included as well and sent again next time
+	applied := store.getAppliedIndex()
+	if since > applied {
+		// The state was reset, for example by restoring a backup, send everything
+		since = 0
+	}
+	state := datastructures.ReplicationState{
+		Region:       store.cfg.region,
+		AppliedIndex: applied,
+		Since:        since,
+		Repos:        make(map[string]datastructures.ReplicatedRepo),
+	}
+
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	for reponame, info := range store.repoinfos {
+		if info.RenamedTo != "" || !isReplicatedTo(info, region) {
+			continue
+		}
+		state.Replicated = append(state.Replicated, reponame)
+		if since != 0 && info.ChangedIndex != 0 && info.ChangedIndex <= since {
+			continue
+		}
+		repo := datastructures.ReplicatedRepo{
+			Public:   info.Public,
+			Deleted:  info.DeletedAt != 0,
+			Symrefs:  make(map[string]string),
+			Refs:     make(map[string]string),
+			HideRefs: info.HideRefs,
+		}
+		for name, target := range info.Symrefs {
+			repo.Symrefs[name] = target
+		}
+		for refname, refval := range info.Refs {
+			repo.Refs[refname] = refval
+		}
+		state.Repos[reponame] = repo
+	}
+	return state
+}
+
+// getReplicas returns the names of the repos that are replicated from region
+func (store *stateStore) getReplicas(region string) []string {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	var replicas []string
+	for reponame, info := range store.repoinfos {
+		if info.ReplicaOf == region && isActiveRepo(info) {
+			replicas = append(replicas, reponame)
+		}
+	}
+	return replicas
+}
+
+// createReplicaRepo creates a read-only repo that gets replicated from region
+func (store *stateStore) createReplicaRepo(repo, region string, public bool) error {
+	store.cfg.log.Infow("Replica creation requested",
+		"reponame", repo,
+		"region", region,
+	)
+	return store.proposeRepoChange(repo, &pb.ChangeRequest{
+		Ctype: pb.ChangeRequest_NEWREPO.Enum(),
+		Newreporeq: &pb.NewRepoRequest{
+			Reponame:  &repo,
+			Public:    &public,
+			Replicaof: &region,
+		},
+	})
+}
+
+// rpcReplicationHandler serves the repos that are replicated to the region of the requesting node
+func (cfg *Service) rpcReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	reqlogger, perminfo := cfg.prereq(w, r, "rpc")
+	if len(perminfo.regions) != 1 {
+		http.Error(w, "Node certificate required", 403)
+		return
+	}
+	region := perminfo.regions[0]
+
+	pathparts := strings.Split(r.URL.Path, "/")[3:]
+	if len(pathparts) == 1 && pathparts[0] == "state" {
+		var since uint64
+		if sinceS := r.URL.Query().Get("since"); sinceS != "" {
+			var err error
+			if since, err = strconv.ParseUint(sinceS, 10, 64); err != nil {
+				http.Error(w, "Invalid since index", 400)
+				return
+			}
+		}
+		cfg.respondJSONResponse(w, cfg.statestore.getReplicationState(region, since))
+		return
+	} else if reponame, command := findProjectAndOp(pathparts); command == "git-upload-pack" {
+		cfg.statestore.mux.Lock()
+		info, exists := cfg.statestore.repoinfos[reponame]
+		cfg.statestore.mux.Unlock()
+		if !exists || !isReplicatedTo(info, region) {
+			http.NotFound(w, r)
+			return
+		}
+		reqlogger = reqlogger.With(
+			"reponame", reponame,
+			"command", command,
+		)
+		cfg.serveGitUploadPack(w, r, cfg.getReplicationPermissions(perminfo, reponame), reqlogger, reponame, false)
+		return
+	}
+	reqlogger.Info("Invalid replication request")
+	http.NotFound(w, r)
+}
+
+// getReplicationPermissions returns the permissions of a node of another region on a repo that is
+// replicated to it: the replica gets all refs, including the hidden ones
+func (cfg *Service) getReplicationPermissions(perminfo permissionInfo, reponame string) permissionInfo {
+	return permissionInfo{
+		Authenticated: perminfo.Authenticated,
+		Username:      perminfo.Username,
+		regions:       []string{cfg.region},
+		repos:         []string{reponame},
+		permissions: []constants.CertPermission{
+			constants.CertPermissionAdmin,
+			constants.CertPermissionRead,
+		},
+	}
+}
+
+// filterRPCRegions only lets nodes from other regions access the replication RPCs
+func (cfg *Service) filterRPCRegions(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && !strings.HasPrefix(r.URL.Path, "/rpc/replication/") {
+			perminfo := cfg.getPermissionInfo(r.TLS)
+			if len(perminfo.regions) != 1 || perminfo.regions[0] != cfg.region {
+				http.Error(w, "Only replication is available to other regions", 403)
+				return
+			}
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+type replicator struct {
+	cfg *Service
+
+	statusMux sync.Mutex
+	status    map[string]datastructures.ReplicationStatus
+}
+
+func (cfg *Service) createReplicator() *replicator {
+	return &replicator{
+		cfg:    cfg,
+		status: make(map[string]datastructures.ReplicationStatus),
+	}
+}
+
+// getStatus returns the progress of the replication from each region
+func (r *replicator) getStatus() map[string]datastructures.ReplicationStatus {
+	r.statusMux.Lock()
+	defer r.statusMux.Unlock()
+
+	status := make(map[string]datastructures.ReplicationStatus)
+	for region, regionstatus := range r.status {
+		status[region] = regionstatus
+	}
+	return status
+}
+
+// Run periodically replicates the repos of other regions, when this node is the leader
+func (r *replicator) Run() {
+	ticker := time.NewTicker(getReplicationInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.cfg.statestore.raftnode.stoppedc:
+			return
+		case <-ticker.C:
+		}
+		if !r.cfg.statestore.isLeader() {
+			// Only one node needs to replicate, the changes reach the others through raft
+			continue
+		}
+
+		for region, urls := range getReplicationRegions() {
+			r.replicateRegion(region, urls)
+		}
+	}
+}
+
+// getSourceState retrieves the state of the replicated repos that changed after the since index
+// from the first node of region that responds, and returns the URL of that node
+func (r *replicator) getSourceState(urls []string, since uint64) (state datastructures.ReplicationState, url string, err error) {
+	err = errors.New("No nodes configured")
+	for _, url = range urls {
+		var resp *http.Response
+		resp, err = r.cfg.rpcClient.Get(url + "/rpc/replication/state?since=" + strconv.FormatUint(since, 10))
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			err = errors.Errorf("Error returned by %s: %s", url, resp.Status)
+			continue
+		}
+		err = json.NewDecoder(resp.Body).Decode(&state)
+		resp.Body.Close()
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *replicator) replicateRegion(region string, urls []string) {
+	r.statusMux.Lock()
+	status := r.status[region]
+	r.statusMux.Unlock()
+	defer func() {
+		r.statusMux.Lock()
+		defer r.statusMux.Unlock()
+		r.status[region] = status
+	}()
+
+	// Only the repos that changed since the last completed sync are sent. The synced index is not
+	// persisted, so a restarted node starts with a full sync.
+	contact := time.Now().Unix()
+	state, url, err := r.getSourceState(urls, status.SyncedIndex)
+	if err != nil {
+		r.cfg.log.Infow("Error retrieving replication state",
+			"region", region,
+			"error", err,
+		)
+		status.Error = err.Error()
+		return
+	}
+	status.LastContact = contact
+	status.SourceIndex = state.AppliedIndex
+	replicated := make(map[string]bool)
+	for _, reponame := range state.Replicated {
+		replicated[reponame] = true
+	}
+	for reponame := range state.Repos {
+		replicated[reponame] = true
+	}
+	status.Repos = len(replicated)
+
+	var reponames []string
+	for reponame := range state.Repos {
+		reponames = append(reponames, reponame)
+	}
+	sort.Strings(reponames)
+
+	var failed []string
+	for _, reponame := range reponames {
+		if err := r.replicateRepo(region, url, reponame, state.Repos[reponame]); err != nil {
+			r.cfg.log.Infow("Error replicating repo",
+				"region", region,
+				"reponame", reponame,
+				"error", err,
+			)
+			failed = append(failed, reponame)
+		}
+	}
+	for _, reponame := range r.cfg.statestore.getReplicas(region) {
+		if replicated[reponame] {
+			continue
+		}
+		// The repo was purged, renamed or no longer gets replicated to us. It stays around
+		// for the grace period, in case this was a mistake.
+		if err := r.cfg.statestore.deleteRepo(reponame, false); err != nil {
+			r.cfg.log.Infow("Error deleting replica",
+				"region", region,
+				"reponame", reponame,
+				"error", err,
+			)
+			failed = append(failed, reponame)
+		}
+	}
+
+	if len(failed) != 0 {
+		status.Error = fmt.Sprintf("Replicating failed for: %s", strings.Join(failed, ", "))
+		return
+	}
+	status.SyncedIndex = state.AppliedIndex
+	status.LastSync = contact
+	status.Error = ""
+}
+
+func (r *replicator) replicateRepo(region, url, reponame string, src datastructures.ReplicatedRepo) error {
+	store := r.cfg.statestore
+	store.mux.Lock()
+	info, exists := store.repoinfos[reponame]
+	store.mux.Unlock()
+
+	if exists && info.RenamedTo == "" && info.ReplicaOf != region {
+		return errors.Errorf("A repo with this name exists, which is not a replica from %s", region)
+	}
+	if src.Deleted {
+		if exists && isActiveRepo(info) {
+			return store.deleteRepo(reponame, false)
+		}
+		return nil
+	}
+	if !exists || info.RenamedTo != "" {
+		if err := store.createReplicaRepo(reponame, region, src.Public); err != nil {
+			return err
+		}
+	} else if info.DeletedAt != 0 {
+		if err := store.undeleteRepo(reponame); err != nil {
+			return err
+		}
+	}
+
+	if err := r.replicateSettings(reponame, src); err != nil {
+		return err
+	}
+	return r.replicateRefs(region, url, reponame, src)
+}
+
+// replicateSettings updates the settings of a replica that are relevant for clients
+func (r *replicator) replicateSettings(reponame string, src datastructures.ReplicatedRepo) error {
+	store := r.cfg.statestore
+	store.mux.Lock()
+	info := store.repoinfos[reponame]
+	store.mux.Unlock()
+
+	request := datastructures.RepoUpdateRequest{
+		Reponame:      reponame,
+		UpdateRequest: make(map[datastructures.RepoUpdateField]string),
+	}
+	if info.Public != src.Public {
+		request.UpdateRequest[datastructures.RepoUpdatePublic] = strconv.FormatBool(src.Public)
+	}
+	if strings.Join(info.HideRefs, ",") != strings.Join(src.HideRefs, ",") {
+		request.UpdateRequest[datastructures.RepoUpdateHideRefs] = strings.Join(src.HideRefs, ",")
+	}
+	var symrefs []string
+	for name, target := range src.Symrefs {
+		if info.Symrefs[name] != target {
+			symrefs = append(symrefs, name+"="+target)
+		}
+	}
+	for name := range info.Symrefs {
+		if _, exists := src.Symrefs[name]; !exists {
+			symrefs = append(symrefs, name+"=")
+		}
+	}
+	if len(symrefs) != 0 {
+		sort.Strings(symrefs)
+		request.UpdateRequest[datastructures.RepoUpdateSymrefs] = strings.Join(symrefs, ",")
+	}
+
+	if len(request.UpdateRequest) == 0 {
+		return nil
+	}
+	cts, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return store.editRepo(reponame, cts)
+}
+
+// replicateRefs fetches the objects of the refs of a replica that changed, and pushes them
+func (r *replicator) replicateRefs(region, url, reponame string, src datastructures.ReplicatedRepo) error {
+	store := r.cfg.statestore
+	store.mux.Lock()
+	localrefs := make(map[string]string)
+	for refname, refval := range store.repoinfos[reponame].Refs {
+		localrefs[refname] = refval
+	}
+	store.mux.Unlock()
+
+	var refnames []string
+	for refname, refval := range src.Refs {
+		if localrefs[refname] != refval {
+			refnames = append(refnames, refname)
+		}
+	}
+	for refname := range localrefs {
+		if _, exists := src.Refs[refname]; !exists {
+			refnames = append(refnames, refname)
+		}
+	}
+	if len(refnames) == 0 {
+		return nil
+	}
+	sort.Strings(refnames)
+
+	p := r.cfg.gitstore.GetProjectStorage(reponame)
+	toupdate := pb.NewPushRequest(r.cfg.nodeid, reponame)
+	toupdate.SetPusher("replication", region)
+	replicated := true
+	toupdate.Replicated = &replicated
+
+	var wants []storage.ObjectID
+	for _, refname := range refnames {
+		from, exists := localrefs[refname]
+		if !exists {
+			from = string(storage.ZeroID)
+		}
+		to, exists := src.Refs[refname]
+		if !exists {
+			to = string(storage.ZeroID)
+		} else {
+			wants = append(wants, storage.ObjectID(to))
+		}
+		toupdate.AddRequest(pb.NewUpdateRequest(refname, from, to))
+	}
+
+	// Everything reachable from the current refs is stored already
+	local := p
+	if clustered, isclustered := p.(*clusterStorageProjectDriverInstance); isclustered {
+		local = clustered.inner
+	}
+	var missing, haves []storage.ObjectID
+	for _, want := range wants {
+		has, err := hasObject(local, want)
+		if err != nil {
+			return err
+		}
+		if !has {
+			missing = append(missing, want)
+		}
+	}
+	seenhaves := make(map[string]bool)
+	for _, refval := range localrefs {
+		if !seenhaves[refval] {
+			seenhaves[refval] = true
+			haves = append(haves, storage.ObjectID(refval))
+		}
+	}
+
+	pusher := p.GetPusher(toupdate.UUID())
+	if len(missing) != 0 {
+		if err := r.fetchObjects(url, reponame, pusher, missing, haves); err != nil {
+			pusher.Done()
+			return errors.Wrap(err, "Error fetching objects")
+		}
+	}
+	pusher.Done()
+	if syncerr := <-pusher.GetPushResultChannel(); syncerr != nil {
+		return errors.Wrap(syncerr, "Error syncing objects")
+	}
+
+	if err := validateObjects(p, toupdate, false); err != nil {
+		return errors.Wrap(err, "Object validation failed")
+	}
+	if err := addPeeledTargets(p, toupdate); err != nil {
+		return errors.Wrap(err, "Error peeling tags")
+	}
+	if err := addFastForwardInfo(p, r.cfg.indexer.getCommitGraph(reponame), toupdate); err != nil {
+		return errors.Wrap(err, "Error determining fast-forwards")
+	}
+
+	pushresult := store.performPush(toupdate)
+	if !pushresult.success {
+		return pushresult.logerror
+	}
+	return nil
+}
+
+// fetchObjects retrieves a pack from another region with the wanted objects and everything they
+// refer to, leaving out what is reachable from haves, and stores its objects
+func (r *replicator) fetchObjects(url, reponame string, pusher storage.ProjectStoragePushDriver, wants, haves []storage.ObjectID) error {
+	var request bytes.Buffer
+	for i, want := range wants {
+		packet := "want " + string(want)
+		if i == 0 {
+			// Upload-pack sends full objects, so there is no need for any delta capabilities
+			packet += " side-band-64k"
+		}
+		if err := sendPacket(&request, []byte(packet+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := sendFlushPacket(&request); err != nil {
+		return err
+	}
+	for _, have := range haves {
+		if err := sendPacket(&request, []byte("have "+string(have)+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := sendPacket(&request, []byte("done\n")); err != nil {
+		return err
+	}
+
+	resp, err := r.cfg.rpcClient.Post(
+		url+"/rpc/replication/"+reponame+".git/git-upload-pack",
+		"application/x-git-upload-pack-request",
+		&request,
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.Errorf("Error retrieving objects: %s", resp.Status)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	ack, err := readPacket(body)
+	if err != nil {
+		return errors.Wrap(err, "Error reading acknowledgement")
+	}
+	if strings.HasPrefix(string(ack), "ERR ") {
+		return errors.Errorf("Remote error: %s", strings.TrimSpace(string(ack[4:])))
+	}
+	if !strings.HasPrefix(string(ack), "NAK") && !strings.HasPrefix(string(ack), "ACK ") {
+		return errors.Errorf("Unexpected acknowledgement %q", ack)
+	}
+	return storePackObjects(body, pusher)
+}
+
+// storePackObjects stores the objects of an undeltified pack, sent over side-band-64k
+func storePackObjects(r io.Reader, pusher storage.ProjectStoragePushDriver) error {
+	packbody := bufio.NewReader(&sideBandReader{r: r})
+	packhasher := sha1.New()
+	packreader := &hashWriter{r: packbody, w: packhasher}
+	version, numobjects, err := getPackHeader(packreader)
+	if err != nil {
+		return errors.Wrap(err, "Error reading packfile header")
+	}
+	if version != 2 {
+		return errors.Errorf("Unsupported packfile version %d", version)
+	}
+	if maxobjects := getReplicationMaxPackObjects(); maxobjects != 0 && numobjects > maxobjects {
+		return errors.Errorf("Packfile has %d objects, more than the limit of %d", numobjects, maxobjects)
+	}
+	for i := uint32(0); i < numobjects; i++ {
+		_, _, resolve, err := getSingleObjectFromPack(packreader, pusher)
+		if err != nil {
+			return errors.Wrap(err, "Error reading object from packfile")
+		}
+		if resolve.baseobj != "" {
+			return errors.New("Unexpected delta in packfile")
+		}
+	}
+	expectedsum := make([]byte, packhasher.Size())
+	if _, err := io.ReadFull(packbody, expectedsum); err != nil {
+		return errors.Wrap(err, "Error reading packfile checksum")
+	}
+	if !bytes.Equal(packhasher.Sum(nil), expectedsum) {
+		return errors.New("Packfile checksum mismatch")
+	}
+	return nil
+}
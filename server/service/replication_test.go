@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"repospanner.org/repospanner/server/datastructures"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// writeTestPack writes a pack with objects as upload-pack sends it over side-band-64k
+func writeTestPack(t *testing.T, p storage.ProjectStorageDriver, objects []storage.ObjectID) []byte {
+	packfile, numobjects, err := writeObjectsToTemporaryPackFile(p, objects)
+	if err != nil {
+		t.Fatalf("Error writing pack: %s", err)
+	}
+	defer packfile.Close()
+
+	var buf bytes.Buffer
+	sendPacket(&buf, []byte("\x02Progress message\n"))
+	sbsender := sideBandSender{w: &buf, sbstatus: sideBandStatusLarge, sb: sideBandData}
+	packhasher := sha1.New()
+	packwriter := io.MultiWriter(packhasher, sbsender)
+	if _, err := packwriter.Write(buildPackHeader(numobjects)); err != nil {
+		t.Fatalf("Error writing pack header: %s", err)
+	}
+	if _, err := io.Copy(packwriter, packfile); err != nil {
+		t.Fatalf("Error writing pack: %s", err)
+	}
+	sbsender.Write(packhasher.Sum(nil))
+	sendFlushPacket(&buf)
+	return buf.Bytes()
+}
+
+func TestStorePackObjects(t *testing.T) {
+	p, pusher, cleanup := newTestProject(t)
+	defer cleanup()
+
+	blob := writeTestBlob(t, pusher, strings.Repeat("Some content\n", 10000))
+	tree := writeTestTree(t, pusher, fileEntry("file", blob))
+	commit := writeTestCommit(t, pusher, tree, "Commit")
+	objects := []storage.ObjectID{commit, tree, blob}
+	pack := writeTestPack(t, p, objects)
+
+	dest, destpusher, destcleanup := newTestProject(t)
+	defer destcleanup()
+	if err := storePackObjects(bytes.NewReader(pack), destpusher); err != nil {
+		t.Fatalf("Error storing pack: %s", err)
+	}
+	for _, objid := range objects {
+		_, _, r, err := dest.ReadObject(objid)
+		if err != nil {
+			t.Fatalf("Object %s was not stored: %s", objid, err)
+		}
+		r.Close()
+	}
+
+	corrupted := append([]byte{}, pack...)
+	// Change a byte of the checksum, which is right before the flush packet
+	corrupted[len(corrupted)-5] ^= 0xff
+	if err := storePackObjects(bytes.NewReader(corrupted), destpusher); err == nil {
+		t.Error("Storing pack with invalid checksum succeeded")
+	}
+
+	viper.Set("replication.max_pack_objects", 2)
+	defer viper.Set("replication.max_pack_objects", 0)
+	if err := storePackObjects(bytes.NewReader(pack), destpusher); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("Storing pack with too many objects returned %v", err)
+	}
+}
+
+func TestSideBandReader(t *testing.T) {
+	var buf bytes.Buffer
+	sendPacket(&buf, []byte("\x01Hello "))
+	sendPacket(&buf, []byte("\x02Progress\n"))
+	sendPacket(&buf, []byte("\x01world"))
+	sendFlushPacket(&buf)
+	data, err := ioutil.ReadAll(&sideBandReader{r: &buf})
+	if err != nil {
+		t.Fatalf("Error reading side-band data: %s", err)
+	}
+	if string(data) != "Hello world" {
+		t.Errorf("Side-band data is %q", data)
+	}
+
+	buf.Reset()
+	sendPacket(&buf, []byte("\x01Partial"))
+	sendPacket(&buf, []byte("\x03Something failed\n"))
+	_, err = ioutil.ReadAll(&sideBandReader{r: &buf})
+	if err == nil || !strings.Contains(err.Error(), "Something failed") {
+		t.Errorf("Fatal side-band message returned %v", err)
+	}
+}
+
+func TestGetReplicationStateSince(t *testing.T) {
+	store := &stateStore{
+		cfg: &Service{region: "regiona"},
+		repoinfos: map[string]datastructures.RepoInfo{
+			"old":      {Regions: []string{"regionb"}, ChangedIndex: 5},
+			"changed":  {Regions: []string{"regionb"}, ChangedIndex: 12},
+			"unknown":  {Regions: []string{"regionb"}},
+			"other":    {Regions: []string{"regionc"}, ChangedIndex: 12},
+			"renamed":  {Regions: []string{"regionb"}, RenamedTo: "changed", ChangedIndex: 12},
+			"deleted":  {Regions: []string{"regionb"}, DeletedAt: 1, ChangedIndex: 11},
+			"local":    {ChangedIndex: 12},
+			"untagged": {Regions: []string{"regionb"}, ChangedIndex: 10},
+		},
+	}
+	store.setAppliedIndex(12)
+
+	checkState := func(since uint64, expectedSince uint64, expected ...string) {
+		state := store.getReplicationState("regionb", since)
+		var repos []string
+		for reponame := range state.Repos {
+			repos = append(repos, reponame)
+		}
+		sort.Strings(repos)
+		sort.Strings(state.Replicated)
+		if state.Since != expectedSince || state.AppliedIndex != 12 || fmt.Sprint(repos) != fmt.Sprint(expected) {
+			t.Errorf("Replication state since %d has repos %v since %d, expected %v since %d",
+				since, repos, state.Since, expected, expectedSince)
+		}
+		if fmt.Sprint(state.Replicated) != "[changed deleted old unknown untagged]" {
+			t.Errorf("Replicated repos are %v", state.Replicated)
+		}
+	}
+	checkState(0, 0, "changed", "deleted", "old", "unknown", "untagged")
+	checkState(10, 10, "changed", "deleted", "unknown")
+	checkState(12, 12, "unknown")
+	// An index the source never reached means its state was reset
+	checkState(20, 0, "changed", "deleted", "old", "unknown", "untagged")
+
+	if !store.getReplicationState("regionb", 10).Repos["deleted"].Deleted {
+		t.Error("Deleted repo is not marked as deleted")
+	}
+}
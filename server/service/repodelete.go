@@ -0,0 +1,242 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+)
+
+// defaultDeleteGracePeriod is how long a deleted repo can be undeleted, unless configured otherwise
+const defaultDeleteGracePeriod = 7 * 24 * time.Hour
+
+// repoPurgeInterval is how often the leader checks for deleted repos past their grace period
+const repoPurgeInterval = 10 * time.Minute
+
+func getDeleteGracePeriod() time.Duration {
+	if viper.IsSet("storage.delete_grace_period") {
+		return viper.GetDuration("storage.delete_grace_period")
+	}
+	return defaultDeleteGracePeriod
+}
+
+func (store *stateStore) isLeader() bool {
+	return store.raftnode.node.Status().Lead == store.cfg.nodeid
+}
+
+func (store *stateStore) GetDeletedRepos() map[string]datastructures.RepoInfo {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	repos := make(map[string]datastructures.RepoInfo)
+	for reponame, info := range store.repoinfos {
+		if info.DeletedAt != 0 && info.RenamedTo == "" {
+			repos[reponame] = info
+		}
+	}
+	return repos
+}
+
+// proposeRepoChange proposes a change request for repo, and waits for it to be applied
+func (store *stateStore) proposeRepoChange(repo string, creq *pb.ChangeRequest) error {
+	out, err := proto.Marshal(creq)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling repo change request")
+	}
+	crC := store.subscribeRepoChangeRequest(repo)
+	defer store.unsubscribeRepoChangeRequest(repo, crC)
+	store.proposeC <- out
+	rcreq := <-crC
+	if rcreq.GetCtype() != creq.GetCtype() {
+		return errors.New("Received an unexpected repo change response")
+	}
+	return nil
+}
+
+// deleteRepo marks a repo as deleted. It can be undeleted until it gets purged at the end of the
+// grace period. If purge is set, the repo and its objects are removed immediately.
+func (store *stateStore) deleteRepo(repo string, purge bool) error {
+	if repo == constants.HooksRepoName {
+		return errors.New("The hooks repo can not be deleted")
+	}
+	info, exists := store.repoinfos[repo]
+	if !exists {
+		return errors.Errorf("Repo %s does not exists", repo)
+	}
+	if info.DeletedAt != 0 && !purge {
+		return errors.Errorf("Repo %s is already deleted", repo)
+	}
+	deletetime := info.DeletedAt
+	if deletetime == 0 {
+		deletetime = time.Now().Unix()
+	}
+	store.cfg.log.Infow("Repo deletion requested",
+		"reponame", repo,
+		"purge", purge,
+	)
+	return store.proposeRepoChange(repo, &pb.ChangeRequest{
+		Ctype: pb.ChangeRequest_DELETEREPO.Enum(),
+		Deletereporeq: &pb.DeleteRepoRequest{
+			Reponame:   &repo,
+			Deletetime: &deletetime,
+			Purge:      &purge,
+		},
+	})
+}
+
+func (store *stateStore) undeleteRepo(repo string) error {
+	info, exists := store.repoinfos[repo]
+	if !exists {
+		return errors.Errorf("Repo %s does not exists", repo)
+	}
+	if info.DeletedAt == 0 {
+		return errors.Errorf("Repo %s is not deleted", repo)
+	}
+	store.cfg.log.Infow("Repo undeletion requested",
+		"reponame", repo,
+	)
+	return store.proposeRepoChange(repo, &pb.ChangeRequest{
+		Ctype: pb.ChangeRequest_UNDELETEREPO.Enum(),
+		Undeletereporeq: &pb.UndeleteRepoRequest{
+			Reponame: &repo,
+		},
+	})
+}
+
+func (store *stateStore) processDeleteRepo(req *pb.DeleteRepoRequest) {
+	if !store.applyDeleteRepo(req) {
+		return
+	}
+
+	// Whether the objects get purged is decided now: once this request is applied, the name can be
+	// used by a new repo, of which the objects must be left alone. Moving the objects aside only
+	// renames directories, removing them is queued.
+	reponame := req.GetReponame()
+	deletetime := req.GetDeletetime()
+	moved := store.retryLocalChange("Error moving objects of purged repo", func() error {
+		return store.movePurgedObjects(reponame, deletetime)
+	})
+	if !moved {
+		return
+	}
+	store.queueLocalChange(func() {
+		if err := store.removePurgedObjects(reponame, deletetime); err != nil {
+			// The repo is gone from the state either way, the objects are only wasting space
+			store.cfg.log.Errorw("Error purging repo objects",
+				"reponame", reponame,
+				"error", err,
+			)
+		}
+	})
+}
+
+// applyDeleteRepo applies a delete request to the state, and returns whether the repo got purged
+func (store *stateStore) applyDeleteRepo(req *pb.DeleteRepoRequest) bool {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	reponame := req.GetReponame()
+	info, exists := store.repoinfos[reponame]
+	if !exists {
+		return false
+	}
+	if !req.GetPurge() {
+		if info.DeletedAt == 0 {
+			info.DeletedAt = req.GetDeletetime()
+			store.repoinfos[reponame] = info
+		}
+		return false
+	}
+
+	delete(store.repoinfos, reponame)
+	delete(store.fakerefs, reponame)
+	return true
+}
+
+func (store *stateStore) processUndeleteRepo(req *pb.UndeleteRepoRequest) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	info, exists := store.repoinfos[req.GetReponame()]
+	if !exists {
+		return
+	}
+	info.DeletedAt = 0
+	store.repoinfos[req.GetReponame()] = info
+}
+
+// getPurgedProjectNames returns the storage projects that the objects and LFS objects of a purged
+// repo are moved to until they are removed
+func getPurgedProjectNames(reponame string, deletetime int64) (string, string) {
+	id := fmt.Sprintf("%s-%d", reponame, deletetime)
+	return constants.PurgedRepoPrefix + "objects/" + id, constants.PurgedRepoPrefix + "lfs/" + id
+}
+
+// movePurgedObjects moves the objects of a purged repo out of the way of any new repo with the
+// same name. This is only done once, when the purge is first applied.
+func (store *stateStore) movePurgedObjects(reponame string, deletetime int64) error {
+	return store.applyLocalOnce("purged", fmt.Sprintf("%s-%d", reponame, deletetime), func() error {
+		store.cfg.log.Infow("Moving objects of purged repo",
+			"reponame", reponame,
+		)
+		objproject, lfsproject := getPurgedProjectNames(reponame, deletetime)
+		if err := store.cfg.gitstore.RenameProject(reponame, objproject); err != nil {
+			return err
+		}
+		if err := store.cfg.gitstore.RenameProject(getLFSProjectName(reponame), lfsproject); err != nil {
+			return err
+		}
+		store.cfg.indexer.forget(reponame)
+		return nil
+	})
+}
+
+// removePurgedObjects removes the objects of a purged repo from local storage, after they were
+// moved aside. Removing them again when the raft log is replayed does nothing.
+func (store *stateStore) removePurgedObjects(reponame string, deletetime int64) error {
+	store.cfg.log.Infow("Purging repo objects",
+		"reponame", reponame,
+	)
+	objproject, lfsproject := getPurgedProjectNames(reponame, deletetime)
+	if err := store.cfg.gitstore.DeleteProject(objproject); err != nil {
+		return err
+	}
+	return store.cfg.gitstore.DeleteProject(lfsproject)
+}
+
+// runRepoPurger periodically purges deleted repos of which the grace period has expired
+func (store *stateStore) runRepoPurger() {
+	ticker := time.NewTicker(repoPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-store.raftnode.stoppedc:
+			return
+		case <-ticker.C:
+		}
+		if !store.isLeader() {
+			// Only one node needs to propose purges
+			continue
+		}
+
+		cutoff := time.Now().Add(-getDeleteGracePeriod()).Unix()
+		for reponame, info := range store.GetDeletedRepos() {
+			if info.DeletedAt > cutoff {
+				continue
+			}
+			if err := store.deleteRepo(reponame, true); err != nil {
+				store.cfg.log.Errorw("Error purging deleted repo",
+					"reponame", reponame,
+					"error", err,
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+	"repospanner.org/repospanner/server/storage"
+)
+
+// newTestLocalStore returns a state store with local storage, for testing changes to the objects
+func newTestLocalStore(t *testing.T) (*stateStore, func()) {
+	driver, _, storagecleanup := newTestStorage(t)
+	dir, err := ioutil.TempDir("", "repospanner_test_")
+	if err != nil {
+		storagecleanup()
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+	cfg := &Service{
+		log:      zap.NewNop().Sugar(),
+		gitstore: driver,
+	}
+	cfg.indexer = cfg.createRepoIndexer()
+	cfg.statestore = &stateStore{
+		cfg:       cfg,
+		directory: dir,
+		repoinfos: make(map[string]datastructures.RepoInfo),
+		fakerefs:  make(map[string]map[string]string),
+		raftnode:  &stateRaftNode{stopc: make(chan struct{})},
+	}
+	return cfg.statestore, func() {
+		storagecleanup()
+		os.RemoveAll(dir)
+	}
+}
+
+// runTestLocalChanges performs the queued changes to local storage
+func runTestLocalChanges(store *stateStore) {
+	for len(store.localChanges) != 0 {
+		change := store.localChanges[0]
+		store.localChanges = store.localChanges[1:]
+		change()
+	}
+}
+
+// writeTestProjectBlob stores a blob in project.
+// The blob is written to a separate project first, since newer Go versions don't allow staging
+// objects for projects with a slash in their name.
+func writeTestProjectBlob(t *testing.T, store *stateStore, project, content string) storage.ObjectID {
+	objid := writeTestBlob(t, store.cfg.gitstore.GetProjectStorage("staging").GetPusher(""), content)
+	if err := store.cfg.gitstore.RenameProject("staging", project); err != nil {
+		t.Fatalf("Error moving staged blob: %s", err)
+	}
+	return objid
+}
+
+func checkTestObject(t *testing.T, store *stateStore, project string, objid storage.ObjectID, expected bool) {
+	_, _, r, err := store.cfg.gitstore.GetProjectStorage(project).ReadObject(objid)
+	if err == nil {
+		r.Close()
+	} else if err != storage.ErrObjectNotFound {
+		t.Fatalf("Error reading object: %s", err)
+	}
+	if (err == nil) != expected {
+		t.Errorf("Object %s in %s exists: %t, expected %t", objid, project, err == nil, expected)
+	}
+}
+
+func TestPurgeRepoReusedName(t *testing.T) {
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+
+	reponame := "repo"
+	store.repoinfos[reponame] = datastructures.RepoInfo{DeletedAt: 5}
+	old := writeTestProjectBlob(t, store, reponame, "old\n")
+	oldlfs := writeTestProjectBlob(t, store, getLFSProjectName(reponame), "lfs\n")
+
+	deletetime := int64(5)
+	purge := true
+	req := &pb.DeleteRepoRequest{Reponame: &reponame, Deletetime: &deletetime, Purge: &purge}
+	store.processDeleteRepo(req)
+	if _, exists := store.repoinfos[reponame]; exists {
+		t.Fatal("Purged repo still exists")
+	}
+	checkTestObject(t, store, reponame, old, false)
+	checkTestObject(t, store, getLFSProjectName(reponame), oldlfs, false)
+
+	// A new repo with the same name gets objects before the queued removal runs
+	store.repoinfos[reponame] = datastructures.RepoInfo{}
+	recreated := writeTestProjectBlob(t, store, reponame, "new\n")
+	runTestLocalChanges(store)
+	checkTestObject(t, store, reponame, recreated, true)
+	objproject, lfsproject := getPurgedProjectNames(reponame, deletetime)
+	checkTestObject(t, store, objproject, old, false)
+	checkTestObject(t, store, lfsproject, oldlfs, false)
+
+	// Replaying the purge leaves the new repo alone
+	store.processDeleteRepo(req)
+	runTestLocalChanges(store)
+	checkTestObject(t, store, reponame, recreated, true)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"repospanner.org/repospanner/server/constants"
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+)
+
+// defaultRenameRedirectPeriod is how long the old name of a renamed repo redirects to the new name,
+// unless configured otherwise
+const defaultRenameRedirectPeriod = 30 * 24 * time.Hour
+
+// maxRenameRedirects is how many renames in a row are followed when redirecting
+const maxRenameRedirects = 10
+
+func getRenameRedirectPeriod() time.Duration {
+	if viper.IsSet("storage.rename_redirect_period") {
+		return viper.GetDuration("storage.rename_redirect_period")
+	}
+	return defaultRenameRedirectPeriod
+}
+
+// renameRepo renames a repo, including its objects.
+// Requests for the old name get redirected to the new name for the rename redirect period.
+func (store *stateStore) renameRepo(repo, newname string) error {
+	if repo == constants.HooksRepoName || newname == constants.HooksRepoName {
+		return errors.New("The hooks repo can not be renamed")
+	}
+	if strings.HasPrefix(newname, constants.LFSRepoPrefix) {
+		return errors.Errorf("Repo names starting with %s are reserved", constants.LFSRepoPrefix)
+	}
+	if !isValidRepoName(newname) || newname == repo {
+		return errors.Errorf("Invalid new repo name %s", newname)
+	}
+	if !store.hasRepo(repo) {
+		return errors.Errorf("Repo %s does not exists", repo)
+	}
+	info, exists := store.repoinfos[newname]
+	if exists && info.RenamedTo == "" {
+		return errors.Errorf("Repo %s already exists", newname)
+	}
+	renametime := time.Now().Unix()
+	store.cfg.log.Infow("Repo rename requested",
+		"reponame", repo,
+		"newname", newname,
+	)
+	return store.proposeRepoChange(repo, &pb.ChangeRequest{
+		Ctype: pb.ChangeRequest_RENAMEREPO.Enum(),
+		Renamereporeq: &pb.RenameRepoRequest{
+			Reponame:   &repo,
+			Newname:    &newname,
+			Renametime: &renametime,
+		},
+	})
+}
+
+func (store *stateStore) processRenameRepo(req *pb.RenameRepoRequest) {
+	reponame := req.GetReponame()
+	newname := req.GetNewname()
+	if !store.canApplyRename(reponame, newname) {
+		return
+	}
+
+	// The objects are moved before the new name shows up in the state, so that the renamed repo
+	// never advertises refs without having their objects. Only the apply loop changes the state,
+	// so the checks above still hold afterwards.
+	renametime := req.GetRenametime()
+	moved := store.retryLocalChange("Error moving objects of renamed repo", func() error {
+		return store.renameRepoObjects(reponame, newname, renametime)
+	})
+	if !moved {
+		return
+	}
+
+	store.mux.Lock()
+	info := store.repoinfos[reponame]
+	store.repoinfos[newname] = info
+	store.repoinfos[reponame] = datastructures.RepoInfo{
+		RenamedTo: newname,
+		RenamedAt: renametime,
+	}
+	if fakerefs, exists := store.fakerefs[reponame]; exists {
+		store.fakerefs[newname] = fakerefs
+		delete(store.fakerefs, reponame)
+	}
+	store.mux.Unlock()
+
+	store.cfg.indexer.forget(reponame)
+	store.cfg.indexer.queueUpdate(newname)
+}
+
+// canApplyRename returns whether an applied rename request can still be performed
+func (store *stateStore) canApplyRename(reponame, newname string) bool {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	info, exists := store.repoinfos[reponame]
+	if !exists || !isActiveRepo(info) {
+		return false
+	}
+	if newinfo, exists := store.repoinfos[newname]; exists && newinfo.RenamedTo == "" {
+		// Another repo took the name before this request got applied
+		store.cfg.log.Infow("Not renaming repo, new name is taken",
+			"reponame", reponame,
+			"newname", newname,
+		)
+		return false
+	}
+	return true
+}
+
+// renameRepoObjects moves the objects of a renamed repo in local storage
+func (store *stateStore) renameRepoObjects(reponame, newname string, renametime int64) error {
+	return store.applyLocalOnce("renamed", fmt.Sprintf("%s-%d", reponame, renametime), func() error {
+		store.cfg.log.Infow("Moving repo objects",
+			"reponame", reponame,
+			"newname", newname,
+		)
+		if err := store.cfg.gitstore.RenameProject(reponame, newname); err != nil {
+			return err
+		}
+		return store.cfg.gitstore.RenameProject(getLFSProjectName(reponame), getLFSProjectName(newname))
+	})
+}
+
+// getRepoRedirect returns the current name of a repo that was renamed, or an empty string if
+// requests for the repo should not be redirected
+func (store *stateStore) getRepoRedirect(repo string) string {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	cutoff := time.Now().Add(-getRenameRedirectPeriod()).Unix()
+	for i := 0; i < maxRenameRedirects; i++ {
+		info, exists := store.repoinfos[repo]
+		if !exists {
+			return ""
+		}
+		if isActiveRepo(info) {
+			if i == 0 {
+				return ""
+			}
+			return repo
+		}
+		if info.RenamedTo == "" || info.RenamedAt < cutoff {
+			return ""
+		}
+		repo = info.RenamedTo
+	}
+	return ""
+}
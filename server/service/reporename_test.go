@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+)
+
+func TestRenameRepoMovesObjectsFirst(t *testing.T) {
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+
+	reponame, newname := "repo", "group/renamed"
+	store.repoinfos[reponame] = datastructures.RepoInfo{Refs: map[string]string{"refs/heads/main": "x"}}
+	blob := writeTestProjectBlob(t, store, reponame, "blob\n")
+	lfsblob := writeTestProjectBlob(t, store, getLFSProjectName(reponame), "lfs\n")
+
+	renametime := int64(5)
+	req := &pb.RenameRepoRequest{Reponame: &reponame, Newname: &newname, Renametime: &renametime}
+	store.processRenameRepo(req)
+	if store.repoinfos[newname].Refs["refs/heads/main"] != "x" || store.repoinfos[reponame].RenamedTo != newname {
+		t.Fatalf("Repo was not renamed: %v", store.repoinfos)
+	}
+	if len(store.localChanges) != 0 {
+		t.Error("Objects of renamed repo are moved asynchronously")
+	}
+	checkTestObject(t, store, newname, blob, true)
+	checkTestObject(t, store, getLFSProjectName(newname), lfsblob, true)
+	checkTestObject(t, store, reponame, blob, false)
+
+	// Renaming into an existing repo does nothing
+	store.repoinfos["other"] = datastructures.RepoInfo{}
+	othername := "other"
+	store.processRenameRepo(&pb.RenameRepoRequest{Reponame: &newname, Newname: &othername, Renametime: &renametime})
+	if store.repoinfos[newname].RenamedTo != "" {
+		t.Error("Repo was renamed to a name that is taken")
+	}
+	checkTestObject(t, store, newname, blob, true)
+}
+
+func TestRetryLocalChange(t *testing.T) {
+	store, cleanup := newTestLocalStore(t)
+	defer cleanup()
+
+	attempts := 0
+	succeeded := store.retryLocalChange("Error", func() error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("Failed")
+		}
+		return nil
+	})
+	if !succeeded || attempts != 2 {
+		t.Errorf("Change succeeded: %t after %d attempts", succeeded, attempts)
+	}
+
+	close(store.raftnode.stopc)
+	if store.retryLocalChange("Error", func() error { return errors.New("Failed") }) {
+		t.Error("Failing change succeeded")
+	}
+}
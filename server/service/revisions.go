@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"repospanner.org/repospanner/server/storage"
+)
+
+var (
+	errRevisionNotFound = errors.New("Revision not found")
+	errPathNotFound     = errors.New("Path not found")
+)
+
+// resolveRevision resolves a ref name (short or full), HEAD or object ID to an object ID.
+// Only refs and objects visible to the requester are considered.
+func (cfg *Service) resolveRevision(p storage.ProjectStorageDriver, perminfo permissionInfo, reponame, rev string) (storage.ObjectID, error) {
+	if isValidRef(rev) {
+		if _, err := hex.DecodeString(rev); err == nil {
+			objid := storage.ObjectID(rev)
+			hidden, err := cfg.checkWantsVisible(p, perminfo, reponame, false, []storage.ObjectID{objid})
+			if err != nil {
+				return storage.ZeroID, err
+			}
+			if hidden != storage.ZeroID {
+				return storage.ZeroID, errRevisionNotFound
+			}
+			return objid, nil
+		}
+	}
+
+	refs, symrefs := cfg.getAdvertisedRefs(perminfo, reponame, false)
+	if target, issymref := symrefs[rev]; issymref {
+		rev = target
+	}
+	// Same order as git uses to disambiguate short ref names
+	for _, candidate := range []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev} {
+		if refval, exists := refs[candidate]; exists {
+			return storage.ObjectID(refval), nil
+		}
+	}
+	return storage.ZeroID, errRevisionNotFound
+}
+
+// peelToCommit follows tags until it finds a commit.
+func peelToCommit(p storage.ProjectStorageDriver, objid storage.ObjectID) (storage.ObjectID, commitInfo, error) {
+	for {
+		objtype, _, r, err := p.ReadObject(objid)
+		if err != nil {
+			return storage.ZeroID, commitInfo{}, err
+		}
+		if objtype == storage.ObjectTypeCommit {
+			commit, err := readCommit(r)
+			r.Close()
+			return objid, commit, err
+		} else if objtype == storage.ObjectTypeTag {
+			tag, err := readTag(r)
+			r.Close()
+			if err != nil {
+				return storage.ZeroID, commitInfo{}, err
+			}
+			objid = tag.object
+		} else {
+			r.Close()
+			return storage.ZeroID, commitInfo{}, errors.New("Revision does not point to a commit")
+		}
+	}
+}
+
+// findTreeEntry looks up a slash-separated path starting at tree treeid.
+// An empty path returns an entry for the tree itself.
+func findTreeEntry(p storage.ProjectStorageDriver, treeid storage.ObjectID, path string) (treeEntry, error) {
+	current := treeEntry{mode: os.ModeDir | 0755, objectid: treeid}
+	for _, component := range strings.Split(path, "/") {
+		if component == "" {
+			continue
+		}
+		if !current.isTree() {
+			return treeEntry{}, errPathNotFound
+		}
+		tree, err := readTreeObject(p, current.objectid)
+		if err != nil {
+			return treeEntry{}, err
+		}
+		found := false
+		for _, entry := range tree.entries {
+			if entry.name == component {
+				current = entry
+				found = true
+				break
+			}
+		}
+		if !found {
+			return treeEntry{}, errPathNotFound
+		}
+	}
+	return current, nil
+}
+
+func readTreeObject(p storage.ProjectStorageDriver, treeid storage.ObjectID) (treeInfo, error) {
+	objtype, _, r, err := p.ReadObject(treeid)
+	if err != nil {
+		return treeInfo{}, err
+	}
+	defer r.Close()
+	if objtype != storage.ObjectTypeTree {
+		return treeInfo{}, errors.New("Object is not a tree")
+	}
+	return readTree(r)
+}
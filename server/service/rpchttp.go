@@ -26,12 +26,14 @@ func (cfg *Service) runRPC(errchan chan<- error) {
 
 	// Add our RPC handlers here
 	muxer.HandleFunc("/rpc/join", cfg.rpcJoinNode)
+	muxer.HandleFunc("/rpc/nodestatus", cfg.rpcNodeStatus)
 	muxer.HandleFunc("/rpc/object/single/", cfg.rpcGetSingleObject)
 	muxer.HandleFunc("/rpc/object/write/", cfg.rpcWriteSingleObject)
 	muxer.HandleFunc("/rpc/repo/", cfg.rpcRepoHandler)
+	muxer.HandleFunc("/rpc/replication/", cfg.rpcReplicationHandler)
 
 	// Start serving
-	cfg.rpcServer = &http.Server{Handler: muxer}
+	cfg.rpcServer = &http.Server{Handler: cfg.filterRPCRegions(muxer)}
 	cfg.log.Debugw("RPC server listening",
 		"bind", cfg.ListenRPC,
 	)
@@ -71,7 +73,7 @@ func (cfg *Service) rpcRepoHandler(w http.ResponseWriter, r *http.Request) {
 	if command == "info/refs" {
 		cfg.serveGitDiscovery(w, r, perminfo, reqlogger, reponame, true)
 	} else if command == "git-upload-pack" {
-		cfg.serveGitUploadPack(w, r, reqlogger, reponame)
+		cfg.serveGitUploadPack(w, r, perminfo, reqlogger, reponame, true)
 	} else {
 		reqlogger.Info("Invalid action requested")
 		http.NotFound(w, r)
@@ -272,6 +274,11 @@ func (cfg *Service) rpcGetSingleObject(w http.ResponseWriter, r *http.Request) {
 		projdriver = clustered.inner
 	}
 
+	cfg.serveSingleObject(w, r, projdriver, projectname, objectid)
+}
+
+// serveSingleObject streams an object to a peer, with its type and size in the headers
+func (cfg *Service) serveSingleObject(w http.ResponseWriter, r *http.Request, projdriver storage.ProjectStorageDriver, projectname string, objectid storage.ObjectID) {
 	objtype, objsize, reader, err := projdriver.ReadObject(objectid)
 
 	if err == storage.ErrObjectNotFound {
@@ -291,6 +298,7 @@ func (cfg *Service) rpcGetSingleObject(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	defer reader.Close()
 
 	w.Header()["X-ObjectType"] = []string{objtype.HdrName()}
 	w.Header()["X-ObjectSize"] = []string{strconv.FormatUint(uint64(objsize), 10)}
@@ -329,6 +337,10 @@ func (cfg *Service) rpcGetSingleObject(w http.ResponseWriter, r *http.Request) {
 type rpcJoinNodeRequest struct {
 	NodeID uint64
 	RPCURL string
+	// ReplaceNodeID is the node this node takes over from, which gets removed first
+	ReplaceNodeID uint64
+	// Learner requests to join as a learner, which doesn't count towards quorum
+	Learner bool
 }
 
 type rpcJoinNodeReply struct {
@@ -337,6 +349,12 @@ type rpcJoinNodeReply struct {
 	NodeInfo     datastructures.NodeInfo
 }
 
+func (cfg *Service) rpcNodeStatus(w http.ResponseWriter, r *http.Request) {
+	cfg.prereq(w, r, "rpc")
+
+	cfg.respondJSONResponse(w, cfg.getLocalNodeStatus())
+}
+
 func (cfg *Service) rpcJoinNode(w http.ResponseWriter, r *http.Request) {
 	reqlogger, _ := cfg.prereq(w, r, "rpc")
 
@@ -348,38 +366,37 @@ func (cfg *Service) rpcJoinNode(w http.ResponseWriter, r *http.Request) {
 	reqlogger.Infow("Join request received",
 		"nodeid", joinrequest.NodeID,
 		"rpcurl", joinrequest.RPCURL,
+		"replacenodeid", joinrequest.ReplaceNodeID,
+		"learner", joinrequest.Learner,
 	)
 
-	reply := rpcJoinNodeReply{
-		NodeInfo: cfg.getNodeInfo(),
-	}
+	reply := rpcJoinNodeReply{}
 
-	confchange := raftpb.ConfChange{
-		Type:    raftpb.ConfChangeAddNode,
-		NodeID:  joinrequest.NodeID,
-		Context: []byte(joinrequest.RPCURL),
+	var err error
+	if joinrequest.ReplaceNodeID != 0 {
+		if joinrequest.ReplaceNodeID == joinrequest.NodeID {
+			err = errors.New("A node can not replace itself")
+		} else {
+			err = cfg.statestore.removeNode(joinrequest.ReplaceNodeID)
+		}
 	}
-	ccC := cfg.statestore.subscribeConfChange()
-	defer cfg.statestore.unsubscribeConfChange(ccC)
-	cfg.statestore.confChangeC <- confchange
-	// TODO: Add a resend timer
-	for msg := range ccC {
-		if msg.NodeID == joinrequest.NodeID {
-			// It's about the current node!
-			if msg.Type == raftpb.ConfChangeAddNode {
-				// We were added!
-				reply.Success = true
-			} else if msg.Type == raftpb.ConfChangeRemoveNode {
-				reply.Success = false
-				reply.ErrorMessage = "Node was removed?"
-			} else {
-				cfg.log.Infow("Unexpected config change for node arrived",
-					"change", msg,
-				)
-			}
-			break
+	if err == nil {
+		cctype := raftpb.ConfChangeAddNode
+		if joinrequest.Learner {
+			cctype = raftpb.ConfChangeAddLearnerNode
 		}
+		err = cfg.statestore.proposeConfChange(raftpb.ConfChange{
+			Type:    cctype,
+			NodeID:  joinrequest.NodeID,
+			Context: []byte(joinrequest.RPCURL),
+		})
+	}
+	if err == nil {
+		reply.Success = true
+	} else {
+		reply.ErrorMessage = err.Error()
 	}
+	reply.NodeInfo = cfg.getNodeInfo()
 
 	if reply.Success {
 		cfg.log.Infow("Node join request succesful",
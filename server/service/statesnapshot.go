@@ -0,0 +1,184 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	"repospanner.org/repospanner/server/datastructures"
+	pb "repospanner.org/repospanner/server/protobuf"
+)
+
+// stateSnapshotVersion is the version of the snapshot format written by this node.
+// Version 0 is the JSON encoded map of repos written by earlier releases.
+const stateSnapshotVersion uint32 = 1
+
+func encodeStateSnapshot(index uint64, repoinfos map[string]datastructures.RepoInfo) ([]byte, error) {
+	reponames := make([]string, 0, len(repoinfos))
+	for reponame := range repoinfos {
+		reponames = append(reponames, reponame)
+	}
+	sort.Strings(reponames)
+
+	snapshot := &pb.StateSnapshot{
+		Version: proto.Uint32(stateSnapshotVersion),
+		Index:   proto.Uint64(index),
+	}
+	for _, reponame := range reponames {
+		snapshot.Repos = append(snapshot.Repos, repoInfoToState(reponame, repoinfos[reponame]))
+	}
+	return proto.Marshal(snapshot)
+}
+
+func decodeStateSnapshot(data []byte) (map[string]datastructures.RepoInfo, error) {
+	repoinfos := make(map[string]datastructures.RepoInfo)
+
+	if len(data) > 0 && data[0] == '{' {
+		// Version 0 snapshot, which is JSON
+		if err := json.Unmarshal(data, &repoinfos); err != nil {
+			return nil, errors.Wrap(err, "Unable to parse legacy snapshot")
+		}
+		return repoinfos, nil
+	}
+
+	snapshot := &pb.StateSnapshot{}
+	if err := proto.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse snapshot")
+	}
+	if snapshot.GetVersion() > stateSnapshotVersion {
+		return nil, errors.Errorf("Snapshot version %d is newer than supported version %d",
+			snapshot.GetVersion(),
+			stateSnapshotVersion,
+		)
+	}
+	for _, repo := range snapshot.GetRepos() {
+		info := repoInfoFromState(repo)
+		if info.ChangedIndex == 0 {
+			// Snapshots from before this was tracked: the last change was no later than the snapshot
+			info.ChangedIndex = snapshot.GetIndex()
+		}
+		repoinfos[repo.GetReponame()] = info
+	}
+	return repoinfos, nil
+}
+
+func repoInfoToState(reponame string, info datastructures.RepoInfo) *pb.RepoState {
+	state := &pb.RepoState{
+		Reponame: proto.String(reponame),
+		Symrefs:  info.Symrefs,
+		Refs:     info.Refs,
+		Peeled:   info.Peeled,
+		Hooks: &pb.RepoHooksState{
+			Prereceive:  proto.String(info.Hooks.PreReceive),
+			Update:      proto.String(info.Hooks.Update),
+			Postreceive: proto.String(info.Hooks.PostReceive),
+		},
+		Lastpushnode:    proto.Uint64(info.LastPushNode),
+		Public:          proto.Bool(info.Public),
+		Hiderefs:        info.HideRefs,
+		Lfsobjects:      make(map[string]*pb.LFSObjectState),
+		Lfsquota:        proto.Int64(info.LFSQuota),
+		Creatablerefs:   info.CreatableRefs,
+		Deletedat:       proto.Int64(info.DeletedAt),
+		Renamedto:       proto.String(info.RenamedTo),
+		Renamedat:       proto.Int64(info.RenamedAt),
+		Regions:         info.Regions,
+		Replicaof:       proto.String(info.ReplicaOf),
+		Consistentreads: proto.Bool(info.ConsistentReads),
+		Changedindex:    proto.Uint64(info.ChangedIndex),
+	}
+	for oid, object := range info.LFSObjects {
+		state.Lfsobjects[oid] = &pb.LFSObjectState{
+			Objectid: proto.String(object.ObjectID),
+			Size:     proto.Int64(object.Size),
+		}
+	}
+	for _, protection := range info.BranchProtections {
+		state.Branchprotections = append(state.Branchprotections, &pb.BranchProtectionState{
+			Pattern:            proto.String(protection.Pattern),
+			Denynonfastforward: proto.Bool(protection.DenyNonFastForward),
+			Denydeletion:       proto.Bool(protection.DenyDeletion),
+			Allowedpushers:     protection.AllowedPushers,
+		})
+	}
+	for _, entry := range info.Reflog {
+		state.Reflog = append(state.Reflog, &pb.ReflogEntryState{
+			Ref:           proto.String(entry.Ref),
+			Oldvalue:      proto.String(entry.OldValue),
+			Newvalue:      proto.String(entry.NewValue),
+			Forced:        proto.Bool(entry.Forced),
+			Pusher:        proto.String(entry.Pusher),
+			Pusheraddress: proto.String(entry.PusherAddress),
+			Pushnode:      proto.Uint64(entry.PushNode),
+			Pushtime:      proto.Int64(entry.PushTime),
+			Raftindex:     proto.Uint64(entry.RaftIndex),
+		})
+	}
+	return state
+}
+
+func repoInfoFromState(state *pb.RepoState) datastructures.RepoInfo {
+	info := datastructures.RepoInfo{
+		Symrefs: state.GetSymrefs(),
+		Refs:    state.GetRefs(),
+		Peeled:  state.GetPeeled(),
+		Hooks: datastructures.RepoHookInfo{
+			PreReceive:  state.GetHooks().GetPrereceive(),
+			Update:      state.GetHooks().GetUpdate(),
+			PostReceive: state.GetHooks().GetPostreceive(),
+		},
+		LastPushNode:    state.GetLastpushnode(),
+		Public:          state.GetPublic(),
+		HideRefs:        state.GetHiderefs(),
+		LFSObjects:      make(map[string]datastructures.LFSObjectInfo),
+		LFSQuota:        state.GetLfsquota(),
+		CreatableRefs:   state.GetCreatablerefs(),
+		DeletedAt:       state.GetDeletedat(),
+		RenamedTo:       state.GetRenamedto(),
+		RenamedAt:       state.GetRenamedat(),
+		Regions:         state.GetRegions(),
+		ReplicaOf:       state.GetReplicaof(),
+		ConsistentReads: state.GetConsistentreads(),
+		ChangedIndex:    state.GetChangedindex(),
+	}
+	// The rest of the code expects these maps to exist, even for repos without any refs
+	if info.Symrefs == nil {
+		info.Symrefs = make(map[string]string)
+	}
+	if info.Refs == nil {
+		info.Refs = make(map[string]string)
+	}
+	if info.Peeled == nil {
+		info.Peeled = make(map[string]string)
+	}
+	for oid, object := range state.GetLfsobjects() {
+		info.LFSObjects[oid] = datastructures.LFSObjectInfo{
+			ObjectID: object.GetObjectid(),
+			Size:     object.GetSize(),
+		}
+	}
+	for _, protection := range state.GetBranchprotections() {
+		info.BranchProtections = append(info.BranchProtections, datastructures.BranchProtection{
+			Pattern:            protection.GetPattern(),
+			DenyNonFastForward: protection.GetDenynonfastforward(),
+			DenyDeletion:       protection.GetDenydeletion(),
+			AllowedPushers:     protection.GetAllowedpushers(),
+		})
+	}
+	for _, entry := range state.GetReflog() {
+		info.Reflog = append(info.Reflog, datastructures.ReflogEntry{
+			Ref:           entry.GetRef(),
+			OldValue:      entry.GetOldvalue(),
+			NewValue:      entry.GetNewvalue(),
+			Forced:        entry.GetForced(),
+			Pusher:        entry.GetPusher(),
+			PusherAddress: entry.GetPusheraddress(),
+			PushNode:      entry.GetPushnode(),
+			PushTime:      entry.GetPushtime(),
+			RaftIndex:     entry.GetRaftindex(),
+		})
+	}
+	return info
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+func testRepoInfos() map[string]datastructures.RepoInfo {
+	return map[string]datastructures.RepoInfo{
+		"test": {
+			Symrefs: map[string]string{"HEAD": "refs/heads/main"},
+			Refs:    map[string]string{"refs/heads/main": "0123456789abcdef0123456789abcdef01234567"},
+			Peeled:  map[string]string{},
+			Hooks: datastructures.RepoHookInfo{
+				PreReceive:  "aaaa",
+				Update:      "bbbb",
+				PostReceive: "cccc",
+			},
+			LastPushNode: 2,
+			Public:       true,
+			HideRefs:     []string{"refs/pipelines"},
+			LFSObjects: map[string]datastructures.LFSObjectInfo{
+				"oid": {ObjectID: "dddd", Size: 42},
+			},
+			LFSQuota: 1024,
+			BranchProtections: []datastructures.BranchProtection{
+				{Pattern: "refs/heads/main", DenyDeletion: true, AllowedPushers: []string{"admin"}},
+			},
+			CreatableRefs: []string{"refs/heads/*"},
+			Reflog: []datastructures.ReflogEntry{
+				{Ref: "refs/heads/main", OldValue: "0000", NewValue: "0123", Pusher: "admin", PushNode: 2, PushTime: 5, RaftIndex: 7},
+			},
+			Regions:         []string{"regionb"},
+			ConsistentReads: true,
+			ChangedIndex:    7,
+		},
+		"empty": {
+			Symrefs:      map[string]string{},
+			Refs:         map[string]string{},
+			Peeled:       map[string]string{},
+			LFSObjects:   map[string]datastructures.LFSObjectInfo{},
+			DeletedAt:    10,
+			RenamedTo:    "test",
+			RenamedAt:    11,
+			ReplicaOf:    "regionb",
+			ChangedIndex: 11,
+		},
+	}
+}
+
+func TestStateSnapshotRoundtrip(t *testing.T) {
+	infos := testRepoInfos()
+	data, err := encodeStateSnapshot(12, infos)
+	if err != nil {
+		t.Fatalf("Error encoding snapshot: %s", err)
+	}
+	decoded, err := decodeStateSnapshot(data)
+	if err != nil {
+		t.Fatalf("Error decoding snapshot: %s", err)
+	}
+	if !reflect.DeepEqual(infos, decoded) {
+		t.Fatalf("Snapshot changed state: %v != %v", infos, decoded)
+	}
+}
+
+func TestStateSnapshotLegacy(t *testing.T) {
+	infos := testRepoInfos()
+	data, err := json.Marshal(infos)
+	if err != nil {
+		t.Fatalf("Error encoding legacy snapshot: %s", err)
+	}
+	decoded, err := decodeStateSnapshot(data)
+	if err != nil {
+		t.Fatalf("Error decoding legacy snapshot: %s", err)
+	}
+	if !reflect.DeepEqual(infos, decoded) {
+		t.Fatalf("Legacy snapshot changed state: %v != %v", infos, decoded)
+	}
+}
+
+func TestStateSnapshotChangedIndex(t *testing.T) {
+	infos := testRepoInfos()
+	test := infos["test"]
+	test.ChangedIndex = 0
+	infos["test"] = test
+	data, err := encodeStateSnapshot(12, infos)
+	if err != nil {
+		t.Fatalf("Error encoding snapshot: %s", err)
+	}
+	decoded, err := decodeStateSnapshot(data)
+	if err != nil {
+		t.Fatalf("Error decoding snapshot: %s", err)
+	}
+	// Repos without a known last change count as changed at the snapshot index
+	if decoded["test"].ChangedIndex != 12 || decoded["empty"].ChangedIndex != 11 {
+		t.Errorf("Changed indexes are %d and %d", decoded["test"].ChangedIndex, decoded["empty"].ChangedIndex)
+	}
+}
@@ -2,13 +2,18 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/golang/protobuf/proto"
@@ -34,6 +39,7 @@ type stateStore struct {
 	directory string
 
 	Peers       map[uint64]string
+	Learners    map[uint64]bool
 	ClusterName string
 	RegionName  string
 	NodeName    string
@@ -49,12 +55,20 @@ type stateStore struct {
 	confChangeListeners    []chan raftpb.ConfChange
 	confChangeListenersMux sync.Mutex
 
+	appliedMux     sync.Mutex
+	appliedIndex   uint64
+	appliedWaiters []appliedWaiter
+
+	localChanges        []func()
+	localChangesMux     sync.Mutex
+	localChangesNotifyC chan struct{}
+
 	raftnode     *stateRaftNode
 	started      bool
 	stopOnFinish bool
 	joining      bool
 
-	commitC     <-chan *[]byte
+	commitC     <-chan *committedEntry
 	errorC      <-chan error
 	proposeC    chan<- []byte
 	confChangeC chan<- raftpb.ConfChange
@@ -65,8 +79,10 @@ type stateStore struct {
 
 func (store *stateStore) attemptJoin(joinnode string) (err error) {
 	req := rpcJoinNodeRequest{
-		NodeID: store.cfg.nodeid,
-		RPCURL: store.cfg.findRPCURL(),
+		NodeID:        store.cfg.nodeid,
+		RPCURL:        store.cfg.findRPCURL(),
+		ReplaceNodeID: store.cfg.JoinReplaceNodeID,
+		Learner:       store.cfg.JoinAsLearner,
 	}
 	var cts []byte
 	cts, err = json.Marshal(req)
@@ -101,9 +117,83 @@ func (store *stateStore) attemptJoin(joinnode string) (err error) {
 
 	// We were accepted into the region
 	store.Peers = reply.NodeInfo.Peers
+	if reply.NodeInfo.Learners != nil {
+		store.Learners = reply.NodeInfo.Learners
+	}
+	if store.cfg.JoinAsLearner {
+		store.Learners[store.cfg.nodeid] = true
+	}
 	return
 }
 
+// isLearner returns whether a node is a learner, which doesn't count towards quorum
+func (store *stateStore) isLearner(nodeid uint64) bool {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	return store.Learners[nodeid]
+}
+
+// getPeers returns a copy of the nodes in the region, mapped to their RPC URLs.
+// The membership is changed by the raft loop, so it must not be iterated directly.
+func (store *stateStore) getPeers() map[uint64]string {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	peers := make(map[uint64]string)
+	for nodeid, url := range store.Peers {
+		peers[nodeid] = url
+	}
+	return peers
+}
+
+// getLearners returns a copy of the set of learner nodes in the region
+func (store *stateStore) getLearners() map[uint64]bool {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	learners := make(map[uint64]bool)
+	for nodeid := range store.Learners {
+		learners[nodeid] = true
+	}
+	return learners
+}
+
+// getPeerURL returns the RPC URL of a node in the region
+func (store *stateStore) getPeerURL(nodeid uint64) (string, bool) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	url, exists := store.Peers[nodeid]
+	return url, exists
+}
+
+// setPeer adds a node to the region or changes its role
+func (store *stateStore) setPeer(nodeid uint64, url string, learner bool) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	store.Peers[nodeid] = url
+	if learner {
+		store.Learners[nodeid] = true
+	} else {
+		delete(store.Learners, nodeid)
+	}
+}
+
+// removePeer removes a node from the region, returning whether it was known
+func (store *stateStore) removePeer(nodeid uint64) bool {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	if _, known := store.Peers[nodeid]; !known {
+		return false
+	}
+	delete(store.Peers, nodeid)
+	delete(store.Learners, nodeid)
+	return true
+}
+
 func (cfg *Service) loadStateStore(spawning bool, joinnode string, directory string) (store *stateStore, err error) {
 	store = &stateStore{
 		directory:           directory,
@@ -112,6 +202,7 @@ func (cfg *Service) loadStateStore(spawning bool, joinnode string, directory str
 		repoChangeListeners: make(map[string][]chan *pb.ChangeRequest),
 		confChangeListeners: []chan raftpb.ConfChange{},
 		fakerefs:            make(map[string]map[string]string),
+		localChangesNotifyC: make(chan struct{}, 1),
 	}
 
 	cts, err := ioutil.ReadFile(path.Join(directory, "state.json"))
@@ -121,12 +212,21 @@ func (cfg *Service) loadStateStore(spawning bool, joinnode string, directory str
 			return
 		}
 		err = json.Unmarshal(cts, &store)
+		if store.Learners == nil {
+			// State saved before learners were supported
+			store.Learners = make(map[uint64]bool)
+		}
+		if cfg.ForceNewCluster {
+			// Start serving normally once the membership is rewritten
+			store.stopOnFinish = true
+		}
 		cfg.log.Infow("State loaded",
 			"clustername", store.ClusterName,
 			"regionname", store.RegionName,
 			"nodename", store.NodeName,
 			"nodeid", store.NodeID,
 			"peers", store.Peers,
+			"learners", store.Learners,
 		)
 	} else if os.IsNotExist(err) {
 		// No state yet, initialize
@@ -134,6 +234,10 @@ func (cfg *Service) loadStateStore(spawning bool, joinnode string, directory str
 			err = errors.New("No state found and not spawning or joining")
 			return
 		}
+		if cfg.ForceNewCluster {
+			err = errors.New("No state found to force a new cluster from")
+			return
+		}
 		cfg.log.Info("No state existed, initializing")
 		if err = os.MkdirAll(store.directory, 0755); err != nil {
 			return
@@ -154,6 +258,7 @@ func (cfg *Service) loadStateStore(spawning bool, joinnode string, directory str
 		store.NodeName = cfg.nodename
 		store.NodeID = cfg.nodeid
 		store.Peers = make(map[uint64]string)
+		store.Learners = make(map[uint64]bool)
 		if joinnode != "" {
 			err = store.attemptJoin(joinnode)
 			if err != nil {
@@ -163,6 +268,9 @@ func (cfg *Service) loadStateStore(spawning bool, joinnode string, directory str
 		}
 		store.Peers[cfg.nodeid] = cfg.findRPCURL()
 		err = store.Save()
+		if err == nil && cfg.RestoreBackup != "" {
+			err = store.restoreBackup(cfg.RestoreBackup)
+		}
 	}
 	if err != nil {
 		return
@@ -201,7 +309,13 @@ func (store *stateStore) GetRepos() map[string]datastructures.RepoInfo {
 	store.mux.Lock()
 	defer store.mux.Unlock()
 
-	return store.repoinfos
+	repos := make(map[string]datastructures.RepoInfo)
+	for reponame, info := range store.repoinfos {
+		if isActiveRepo(info) {
+			repos[reponame] = info
+		}
+	}
+	return repos
 }
 
 func (store *stateStore) GetLastPushNode(project string) uint64 {
@@ -218,6 +332,13 @@ func (store *stateStore) GetRepoHooks(project string) datastructures.RepoHookInf
 	return store.repoinfos[project].Hooks
 }
 
+func (store *stateStore) GetRepoHideRefs(project string) []string {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	return store.repoinfos[project].HideRefs
+}
+
 func (store *stateStore) IsRepoPublic(project string) bool {
 	store.mux.Lock()
 	defer store.mux.Unlock()
@@ -225,12 +346,58 @@ func (store *stateStore) IsRepoPublic(project string) bool {
 	return store.repoinfos[project].Public
 }
 
-func (store *stateStore) getSnapshot() ([]byte, error) {
-	store.cfg.log.Debug("Getting snapshot")
+// getSnapshot returns the state as of raft index, which has to be the last index sent to the
+// state store
+func (store *stateStore) getSnapshot(index uint64) ([]byte, error) {
+	store.cfg.log.Debugw("Getting snapshot",
+		"index", index,
+	)
+
+	// The last entry may still be in the process of being applied
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-store.raftnode.stopc:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := store.waitApplied(ctx, index); err != nil {
+		return nil, errors.Wrap(err, "Error waiting for state to be applied")
+	}
+
 	store.mux.Lock()
 	defer store.mux.Unlock()
 
-	return json.Marshal(store.repoinfos)
+	return encodeStateSnapshot(index, store.repoinfos)
+}
+
+// loadSnapshot recovers the state from the last snapshot, if it is newer than the applied state
+func (store *stateStore) loadSnapshot() error {
+	snapshot, err := store.snapshotter.Load()
+	if err == snap.ErrNoSnapshot {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	store.appliedMux.Lock()
+	applied := store.appliedIndex
+	store.appliedMux.Unlock()
+	if snapshot.Metadata.Index <= applied {
+		return nil
+	}
+
+	store.cfg.log.Debugw("Loading snapshot",
+		"term", snapshot.Metadata.Term,
+		"index", snapshot.Metadata.Index,
+	)
+	if err := store.recoverFromSnapshot(snapshot.Data); err != nil {
+		return err
+	}
+	store.setAppliedIndex(snapshot.Metadata.Index)
+	return nil
 }
 
 func (store *stateStore) recoverFromSnapshot(snapshot []byte) error {
@@ -238,8 +405,8 @@ func (store *stateStore) recoverFromSnapshot(snapshot []byte) error {
 	store.mux.Lock()
 	defer store.mux.Unlock()
 
-	var info map[string]datastructures.RepoInfo
-	if err := json.Unmarshal(snapshot, &store); err != nil {
+	info, err := decodeStateSnapshot(snapshot)
+	if err != nil {
 		return errors.Wrap(err, "Unable to recover from snapshot")
 	}
 	store.repoinfos = info
@@ -249,6 +416,92 @@ func (store *stateStore) recoverFromSnapshot(snapshot []byte) error {
 	return nil
 }
 
+// applyLocalOnce performs a change to local storage for an applied change request, unless it
+// was already performed. Change requests get applied again when the raft log is replayed, and
+// by then the storage may be in use by a repo that was created later.
+// id must be unique for the change request.
+func (store *stateStore) applyLocalOnce(kind, id string, apply func() error) error {
+	markerdir := path.Join(store.directory, kind)
+	marker := path.Join(markerdir, url.PathEscape(id))
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(markerdir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(marker, []byte{}, 0644)
+}
+
+// maxLocalChangeRetryDelay is the longest wait between attempts of retryLocalChange
+const maxLocalChangeRetryDelay = time.Minute
+
+// retryLocalChange performs a change to local storage that applying a change request depends on,
+// retrying until it succeeds. Applying further change requests waits for it, because the state
+// would otherwise refer to objects that aren't where it expects them.
+// It returns false if the node is stopping before the change succeeded.
+func (store *stateStore) retryLocalChange(description string, change func() error) bool {
+	delay := time.Second
+	for {
+		err := change()
+		if err == nil {
+			return true
+		}
+		store.cfg.log.Errorw(description+", retrying",
+			"delay", delay,
+			"error", err,
+		)
+		select {
+		case <-store.raftnode.stopc:
+			return false
+		case <-time.After(delay):
+		}
+		if delay < maxLocalChangeRetryDelay {
+			delay *= 2
+		}
+	}
+}
+
+// queueLocalChange queues a change to local storage for an applied change request, so that neither
+// the apply loop nor store.mux are held up by it. Changes are performed in the order they are queued.
+func (store *stateStore) queueLocalChange(change func()) {
+	store.localChangesMux.Lock()
+	store.localChanges = append(store.localChanges, change)
+	store.localChangesMux.Unlock()
+
+	select {
+	case store.localChangesNotifyC <- struct{}{}:
+	default:
+		// The queue is already being processed
+	}
+}
+
+// runLocalChanges performs the queued changes to local storage
+func (store *stateStore) runLocalChanges() {
+	for {
+		select {
+		case <-store.raftnode.stoppedc:
+			return
+		case <-store.localChangesNotifyC:
+		}
+
+		for {
+			store.localChangesMux.Lock()
+			if len(store.localChanges) == 0 {
+				store.localChangesMux.Unlock()
+				break
+			}
+			change := store.localChanges[0]
+			store.localChanges = store.localChanges[1:]
+			store.localChangesMux.Unlock()
+
+			change()
+		}
+	}
+}
+
 func (store *stateStore) Save() error {
 	store.mux.Lock()
 	defer store.mux.Unlock()
@@ -272,10 +525,17 @@ func (store *stateStore) RunStateStore(errchan chan<- error, startedC chan<- str
 	snapshotter := <-store.snapShotterReady
 	store.cfg.log.Debug("Got snapshotter")
 	store.snapshotter = snapshotter
-	// Now replay the logs
-	store.readCommits()
+	// Now replay the logs, on top of the last snapshot
+	if err := store.loadSnapshot(); err != nil {
+		errchan <- errors.Wrap(err, "Error loading snapshot")
+		return
+	}
+	store.readCommits(true)
 	store.cfg.log.Debug("WAL Replayed")
-	go store.readCommits()
+	go store.readCommits(false)
+	go store.runRepoPurger()
+	go store.runLocalChanges()
+	go store.cfg.replicator.Run()
 	store.cfg.log.Debug("stateStore ready")
 	if store.stopOnFinish {
 		// We have finished initialization, terminate
@@ -335,6 +595,18 @@ func (store *stateStore) unsubscribeConfChange(ccC chan raftpb.ConfChange) {
 	}
 }
 
+// markRepoChanged records the index of a change applied to a repo, which replication uses to
+// only send the repos that changed
+func (store *stateStore) markRepoChanged(repo string, index uint64) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	if info, exists := store.repoinfos[repo]; exists {
+		info.ChangedIndex = index
+		store.repoinfos[repo] = info
+	}
+}
+
 func (store *stateStore) announceRepoChanges(repo string, req *pb.ChangeRequest) {
 	store.repoChangeListenersMux.Lock()
 	defer store.repoChangeListenersMux.Unlock()
@@ -404,37 +676,81 @@ func (store *stateStore) applyUpdateRequest(reponame string, request datastructu
 			repo.Hooks.Update = val
 		case datastructures.RepoUpdateHookPostReceive:
 			repo.Hooks.PostReceive = val
+		case datastructures.RepoUpdateHideRefs:
+			repo.HideRefs = parseHideRefs(val)
+		case datastructures.RepoUpdateLFSQuota:
+			quota, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				store.cfg.log.Errorw(
+					"Invalid LFS quota requested",
+					"reponame", reponame,
+					"quota", val,
+				)
+				continue
+			}
+			repo.LFSQuota = quota
+		case datastructures.RepoUpdateDefaultBranch:
+			repo.Symrefs["HEAD"] = getBranchRefName(val)
+		case datastructures.RepoUpdateSymrefs:
+			updates, err := parseSymrefUpdates(val)
+			if err != nil {
+				store.cfg.log.Errorw(
+					"Invalid symrefs requested",
+					"reponame", reponame,
+					"symrefs", val,
+					"error", err,
+				)
+				continue
+			}
+			applySymrefUpdates(repo.Symrefs, updates)
+		case datastructures.RepoUpdateProtectBranch:
+			rule, err := parseBranchProtection(val)
+			if err != nil {
+				store.cfg.log.Errorw(
+					"Invalid branch protection requested",
+					"reponame", reponame,
+					"rule", val,
+					"error", err,
+				)
+				continue
+			}
+			repo.BranchProtections = setBranchProtection(repo.BranchProtections, rule)
+		case datastructures.RepoUpdateUnprotectBranch:
+			repo.BranchProtections = removeBranchProtection(repo.BranchProtections, val)
+		case datastructures.RepoUpdateCreatableRefs:
+			repo.CreatableRefs = parseHideRefs(val)
+		case datastructures.RepoUpdateRegions:
+			repo.Regions = parseHideRefs(val)
+		case datastructures.RepoUpdateConsistentReads:
+			repo.ConsistentReads = val == "true"
 		}
 	}
 	store.repoinfos[reponame] = repo
 }
 
-func (store *stateStore) readCommits() {
-	for data := range store.commitC {
-		if data == nil {
+// readCommits applies the committed entries. When replaying, it returns once the log is replayed.
+func (store *stateStore) readCommits(replaying bool) {
+	for entry := range store.commitC {
+		if entry == nil {
 			// done replaying log; now data incoming
 			// OR signaled to load snapshot
-			snapshot, err := store.snapshotter.Load()
-			if err == snap.ErrNoSnapshot {
-				return
-			}
-			if err != nil && err != snap.ErrNoSnapshot {
-				store.cfg.log.Fatalw("Error replaying log", "err", err)
+			if err := store.loadSnapshot(); err != nil {
+				store.cfg.log.Fatalw("Error loading snapshot", "err", err)
 				return
 			}
-			store.cfg.log.Debugw("Loading snapshot",
-				"term", snapshot.Metadata.Term,
-				"index", snapshot.Metadata.Index,
-			)
-			if err := store.recoverFromSnapshot(snapshot.Data); err != nil {
-				store.cfg.log.Fatalw("Error loading snapshot", "err", err)
+			if replaying {
 				return
 			}
 			continue
 		}
+		if len(entry.data) == 0 {
+			// Empty and configuration change entries only move the applied index
+			store.setAppliedIndex(entry.index)
+			continue
+		}
 
 		req := &pb.ChangeRequest{}
-		if err := proto.Unmarshal(*data, req); err != nil {
+		if err := proto.Unmarshal(entry.data, req); err != nil {
 			store.cfg.log.Fatalw("Unable to unmarshal", "err", err)
 		}
 
@@ -445,19 +761,29 @@ func (store *stateStore) readCommits() {
 			store.cfg.log.Debugw("New repo request received",
 				"reponame", r.GetReponame(),
 				"public", r.GetPublic(),
+				"defaultbranch", r.GetDefaultbranch(),
+				"replicaof", r.GetReplicaof(),
 			)
+			symrefs := make(map[string]string)
+			if r.GetDefaultbranch() != "" {
+				symrefs["HEAD"] = r.GetDefaultbranch()
+			}
 			store.mux.Lock()
 			store.repoinfos[r.GetReponame()] = datastructures.RepoInfo{
-				Public:  r.GetPublic(),
-				Refs:    make(map[string]string),
-				Symrefs: make(map[string]string),
+				Public:     r.GetPublic(),
+				Refs:       make(map[string]string),
+				Symrefs:    symrefs,
+				Peeled:     make(map[string]string),
+				LFSObjects: make(map[string]datastructures.LFSObjectInfo),
 				Hooks: datastructures.RepoHookInfo{
 					PreReceive:  string(storage.ZeroID),
 					Update:      string(storage.ZeroID),
 					PostReceive: string(storage.ZeroID),
 				},
+				ReplicaOf: r.GetReplicaof(),
 			}
 			store.mux.Unlock()
+			store.markRepoChanged(r.GetReponame(), entry.index)
 			store.announceRepoChanges(r.GetReponame(), req)
 
 		case pb.ChangeRequest_EDITREPO:
@@ -470,7 +796,7 @@ func (store *stateStore) readCommits() {
 					"Unable to apply update request",
 					"error", err,
 				)
-				continue
+				break
 			}
 
 			store.cfg.log.Debugw("Edit repo request received",
@@ -480,6 +806,42 @@ func (store *stateStore) readCommits() {
 			store.mux.Lock()
 			store.applyUpdateRequest(r.GetReponame(), updaterequest)
 			store.mux.Unlock()
+			store.markRepoChanged(r.GetReponame(), entry.index)
+			store.announceRepoChanges(r.GetReponame(), req)
+
+		case pb.ChangeRequest_DELETEREPO:
+			r := req.GetDeletereporeq()
+
+			store.cfg.log.Debugw("Delete repo request received",
+				"reponame", r.GetReponame(),
+				"deletetime", r.GetDeletetime(),
+				"purge", r.GetPurge(),
+			)
+			store.processDeleteRepo(r)
+			store.markRepoChanged(r.GetReponame(), entry.index)
+			store.announceRepoChanges(r.GetReponame(), req)
+
+		case pb.ChangeRequest_UNDELETEREPO:
+			r := req.GetUndeletereporeq()
+
+			store.cfg.log.Debugw("Undelete repo request received",
+				"reponame", r.GetReponame(),
+			)
+			store.processUndeleteRepo(r)
+			store.markRepoChanged(r.GetReponame(), entry.index)
+			store.announceRepoChanges(r.GetReponame(), req)
+
+		case pb.ChangeRequest_RENAMEREPO:
+			r := req.GetRenamereporeq()
+
+			store.cfg.log.Debugw("Rename repo request received",
+				"reponame", r.GetReponame(),
+				"newname", r.GetNewname(),
+				"renametime", r.GetRenametime(),
+			)
+			store.processRenameRepo(r)
+			store.markRepoChanged(r.GetReponame(), entry.index)
+			store.markRepoChanged(r.GetNewname(), entry.index)
 			store.announceRepoChanges(r.GetReponame(), req)
 
 		case pb.ChangeRequest_PUSHREQUEST:
@@ -489,28 +851,80 @@ func (store *stateStore) readCommits() {
 				"reponame", r.GetReponame(),
 				"requests", r.GetRequests(),
 			)
-			store.processPush(r)
+			store.processPush(r, entry.index)
+			store.cfg.indexer.queueUpdate(r.GetReponame())
+			store.markRepoChanged(r.GetReponame(), entry.index)
+			store.announceRepoChanges(r.GetReponame(), req)
+
+		case pb.ChangeRequest_LFSOBJECT:
+			r := req.GetLfsobjectreq()
+
+			store.cfg.log.Debugw("LFS object request received",
+				"reponame", r.GetReponame(),
+				"oid", r.GetOid(),
+				"objectid", r.GetObjectid(),
+				"size", r.GetSize(),
+			)
+			store.processLFSObject(r)
+			store.markRepoChanged(r.GetReponame(), entry.index)
 			store.announceRepoChanges(r.GetReponame(), req)
 
 		default:
 			store.cfg.log.Fatalw("Unknown ChangeRequest request received")
 		}
+		store.setAppliedIndex(entry.index)
 	}
 	if err, ok := <-store.errorC; ok {
 		store.cfg.log.Fatalw("Error received", "err", err)
 	}
 }
 
-func (store *stateStore) createRepo(repo string, public bool) error {
-	_, exists := store.repoinfos[repo]
+// isValidRepoName returns whether name can be used for a repo. It has to be a relative path without
+// empty, "." or ".." parts, and none of the parts can end in ".git", which ends the repo name in URLs.
+func isValidRepoName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." || strings.HasSuffix(part, ".git") {
+			return false
+		}
+	}
+	return true
+}
+
+func (store *stateStore) createRepo(repo string, public bool, defaultbranch string) error {
+	if !isValidRepoName(repo) {
+		return errors.Errorf("Invalid repo name %s", repo)
+	}
+	defaultref := getDefaultBranchRef()
+	if defaultbranch != "" {
+		defaultref = getBranchRefName(defaultbranch)
+	}
+	if !isValidSymrefPart(defaultref) {
+		return errors.Errorf("Invalid default branch %s", defaultref)
+	}
+	info, exists := store.repoinfos[repo]
+	if exists && info.RenamedTo != "" {
+		// Only a redirect to the new name remains, which the new repo replaces
+		exists = false
+	} else if exists && info.DeletedAt != 0 {
+		return errors.Errorf("Repo %s was deleted and can still be undeleted, purge it first", repo)
+	}
 	if exists {
 		return errors.Errorf("Repo %s already exists", repo)
 	}
 	creq := &pb.ChangeRequest{
 		Ctype: pb.ChangeRequest_NEWREPO.Enum(),
 		Newreporeq: &pb.NewRepoRequest{
-			Reponame: &repo,
-			Public:   &public,
+			Reponame:      &repo,
+			Public:        &public,
+			Defaultbranch: &defaultref,
 		},
 	}
 	out, err := proto.Marshal(creq)
@@ -531,8 +945,7 @@ func (store *stateStore) createRepo(repo string, public bool) error {
 }
 
 func (store *stateStore) editRepo(repo string, request []byte) error {
-	_, exists := store.repoinfos[repo]
-	if !exists {
+	if !store.hasRepo(repo) {
 		return errors.Errorf("Repo %s does not exists", repo)
 	}
 	creq := &pb.ChangeRequest{
@@ -560,25 +973,178 @@ func (store *stateStore) editRepo(repo string, request []byte) error {
 	return nil
 }
 
-func (store *stateStore) hasRepo(repo string) (exists bool) {
-	_, exists = store.repoinfos[repo]
-	return
+var errLFSQuotaExceeded = errors.New("LFS quota exceeded")
+
+// lfsObjectTimeout is how long addLFSObject waits for its request to be applied, the same as performPush
+const lfsObjectTimeout = maxRetries * 2 * time.Second
+
+func (store *stateStore) addLFSObject(repo, oid string, objectid storage.ObjectID, size int64) error {
+	if !store.hasRepo(repo) {
+		return errors.Errorf("Repo %s does not exists", repo)
+	}
+	if err := store.checkWritable(repo); err != nil {
+		return err
+	}
+	objectidS := string(objectid)
+	creq := &pb.ChangeRequest{
+		Ctype: pb.ChangeRequest_LFSOBJECT.Enum(),
+		Lfsobjectreq: &pb.LFSObjectRequest{
+			Reponame: &repo,
+			Oid:      &oid,
+			Objectid: &objectidS,
+			Size:     &size,
+		},
+	}
+	out, err := proto.Marshal(creq)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling lfsobject request")
+	}
+	store.cfg.log.Debugw("LFS object addition requested",
+		"reponame", repo,
+		"oid", oid,
+	)
+	timeout := time.NewTimer(lfsObjectTimeout)
+	defer timeout.Stop()
+	crC := store.subscribeRepoChangeRequest(repo)
+	defer store.unsubscribeRepoChangeRequest(repo, crC)
+	store.proposeC <- out
+	for {
+		select {
+		case rcreq := <-crC:
+			if rcreq.GetLfsobjectreq().GetOid() != oid {
+				continue
+			}
+			if _, stored := store.getLFSObject(repo, oid); !stored {
+				return errLFSQuotaExceeded
+			}
+			return nil
+		case <-timeout.C:
+			return errors.New("Timeout while recording LFS object")
+		}
+	}
+}
+
+func (store *stateStore) getLFSObject(repo, oid string) (datastructures.LFSObjectInfo, bool) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	info, exists := store.repoinfos[repo].LFSObjects[oid]
+	return info, exists
+}
+
+func (store *stateStore) getLFSUsage(repo string) (usage int64, quota int64) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	for _, info := range store.repoinfos[repo].LFSObjects {
+		usage += info.Size
+	}
+	return usage, store.repoinfos[repo].LFSQuota
+}
+
+func (store *stateStore) processLFSObject(req *pb.LFSObjectRequest) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	info := store.repoinfos[req.GetReponame()]
+	if info.LFSObjects == nil {
+		// Repos created before LFS was supported
+		info.LFSObjects = make(map[string]datastructures.LFSObjectInfo)
+	}
+	if _, exists := info.LFSObjects[req.GetOid()]; exists {
+		return
+	}
+
+	if info.LFSQuota > 0 {
+		var usage int64
+		for _, obj := range info.LFSObjects {
+			usage += obj.Size
+		}
+		if usage+req.GetSize() > info.LFSQuota {
+			// Another upload got in first: make sure all nodes agree on refusing this one
+			store.cfg.log.Infow("LFS object refused due to quota",
+				"reponame", req.GetReponame(),
+				"oid", req.GetOid(),
+				"usage", usage,
+				"quota", info.LFSQuota,
+			)
+			return
+		}
+	}
+
+	info.LFSObjects[req.GetOid()] = datastructures.LFSObjectInfo{
+		ObjectID: req.GetObjectid(),
+		Size:     req.GetSize(),
+	}
+	store.repoinfos[req.GetReponame()] = info
+}
+
+// isActiveRepo returns whether a repo is neither deleted nor renamed
+func isActiveRepo(info datastructures.RepoInfo) bool {
+	return info.DeletedAt == 0 && info.RenamedTo == ""
+}
+
+func (store *stateStore) hasRepo(repo string) bool {
+	info, exists := store.repoinfos[repo]
+	return exists && isActiveRepo(info)
+}
+
+// copyRefs returns a copy of one of the ref maps of a repo. The maps are updated in place when
+// pushes are applied, so they can't be used outside store.mux.
+func (store *stateStore) copyRefs(repo string, get func(datastructures.RepoInfo) map[string]string) map[string]string {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	refs := make(map[string]string)
+	for refname, refval := range get(store.repoinfos[repo]) {
+		refs[refname] = refval
+	}
+	return refs
 }
 
 func (store *stateStore) getSymRefs(repo string) map[string]string {
-	return store.repoinfos[repo].Symrefs
+	return store.copyRefs(repo, func(info datastructures.RepoInfo) map[string]string { return info.Symrefs })
 }
 
 func (store *stateStore) getGitRefs(repo string) map[string]string {
-	return store.repoinfos[repo].Refs
+	return store.copyRefs(repo, func(info datastructures.RepoInfo) map[string]string { return info.Refs })
+}
+
+func (store *stateStore) getPeeledRefs(repo string) map[string]string {
+	return store.copyRefs(repo, func(info datastructures.RepoInfo) map[string]string { return info.Peeled })
 }
 
-func (store *stateStore) getPushResult(req *pb.PushRequest) (result PushResult) {
-	refs := store.repoinfos[req.GetReponame()].Refs
+func (store *stateStore) getPushResult(req *pb.PushRequest) PushResult {
+	return store.computePushResult(req, false)
+}
+
+// getPushPrecheckResult determines whether req has any chance of success, before its objects are
+// received. Updates are assumed to be fast-forwards, since that isn't known yet.
+func (store *stateStore) getPushPrecheckResult(req *pb.PushRequest) PushResult {
+	return store.computePushResult(req, true)
+}
+
+func (store *stateStore) computePushResult(req *pb.PushRequest, precheck bool) (result PushResult) {
+	info := store.repoinfos[req.GetReponame()]
+	refs := info.Refs
 
 	result.success = true
 	result.branchresults = make(map[string]string)
 
+	if (info.ReplicaOf != "") != req.GetReplicated() {
+		// Replicas only get updated by replication, and replication only updates replicas
+		result.success = false
+		result.clienterror = fmt.Errorf("Repo is a read-only replica from region %s", info.ReplicaOf)
+		if info.ReplicaOf == "" {
+			result.clienterror = errors.New("Repo is not a replica")
+		}
+		result.logerror = result.clienterror
+		for _, request := range req.Requests {
+			result.branchresults[request.GetRef()] = "read-only"
+		}
+		return
+	}
+
 	for _, request := range req.Requests {
 		refname := request.GetRef()
 
@@ -613,6 +1179,14 @@ func (store *stateStore) getPushResult(req *pb.PushRequest) (result PushResult)
 			result.logerror = fmt.Errorf("Ref %s already updated", refname)
 			continue
 		}
+		if branchresult, err := checkRefPolicies(info, req, request, !precheck); err != nil {
+			// Failure: denied by a branch protection rule
+			result.success = false
+			result.branchresults[refname] = branchresult
+			result.clienterror = err
+			result.logerror = err
+			continue
+		}
 
 		// We passed all checks
 		result.branchresults[refname] = "OK"
@@ -685,7 +1259,7 @@ func (store *stateStore) performPush(req *pb.PushRequest) PushResult {
 	return result
 }
 
-func (store *stateStore) processPush(req *pb.PushRequest) {
+func (store *stateStore) processPush(req *pb.PushRequest, index uint64) {
 	store.mux.Lock()
 	defer store.mux.Unlock()
 
@@ -701,35 +1275,31 @@ func (store *stateStore) processPush(req *pb.PushRequest) {
 	}
 
 	info.LastPushNode = req.GetPushnode()
+	if info.Peeled == nil {
+		// Repos created before peeled tags were tracked
+		info.Peeled = make(map[string]string)
+	}
+	info.Reflog = appendReflog(info.Reflog, req, index)
 
 	for _, request := range req.Requests {
 		refname := request.GetRef()
 
+		delete(info.Peeled, refname)
 		if request.ToObject() == storage.ZeroID {
 			delete(info.Refs, refname)
 			continue
 		}
 		info.Refs[refname] = string(request.ToObject())
-	}
-
-	for symref, target := range info.Symrefs {
-		_, hasref := info.Refs[target]
-		if !hasref {
-			delete(info.Symrefs, symref)
+		if request.GetPeeled() != "" {
+			info.Peeled[refname] = string(request.PeeledObject())
 		}
 	}
 
 	_, hashead := info.Symrefs["HEAD"]
 	if !hashead {
-		// If this was an empty repo, try to get a default HEAD
-		_, hasmaster := info.Refs["refs/heads/master"]
-		if hasmaster {
-			info.Symrefs["HEAD"] = "refs/heads/master"
-		} else {
-			for ref := range info.Refs {
-				info.Symrefs["HEAD"] = ref
-				break
-			}
+		// Repos created without a default branch get their HEAD on the first push
+		if target := guessHeadTarget(info.Refs); target != "" {
+			info.Symrefs["HEAD"] = target
 		}
 	}
 
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestIsValidRepoName(t *testing.T) {
+	valid := []string{
+		"test",
+		"group/test",
+		"admin/hooks",
+		"test.github",
+		"a/b/c-d_e.f",
+	}
+	invalid := []string{
+		"",
+		"/test",
+		"test/",
+		"group//test",
+		"..",
+		"../test",
+		"group/../test",
+		"./test",
+		"test.git",
+		"group.git/test",
+		"test\x00",
+		"test\nname",
+	}
+	for _, name := range valid {
+		if !isValidRepoName(name) {
+			t.Errorf("Valid repo name %q was rejected", name)
+		}
+	}
+	for _, name := range invalid {
+		if isValidRepoName(name) {
+			t.Errorf("Invalid repo name %q was accepted", name)
+		}
+	}
+}
+
+func TestPeersConcurrentAccess(t *testing.T) {
+	store := &stateStore{
+		Peers:    map[uint64]string{1: "https://node1"},
+		Learners: make(map[uint64]bool),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			store.setPeer(2, "https://node2", i%2 == 0)
+			store.removePeer(2)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		for peer := range store.getPeers() {
+			store.isLearner(peer)
+		}
+	}
+	<-done
+
+	peers := store.getPeers()
+	if len(peers) != 1 || peers[1] != "https://node1" {
+		t.Errorf("Peers are %v", peers)
+	}
+	if store.removePeer(2) {
+		t.Error("Removing an unknown peer reported it as known")
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+// defaultBranch is the branch HEAD of new repos points to, unless configured otherwise
+const defaultBranch = "master"
+
+// getDefaultBranchRef returns the ref HEAD of new repos points to if no branch was requested
+func getDefaultBranchRef() string {
+	branch := viper.GetString("storage.default_branch")
+	if branch == "" {
+		branch = defaultBranch
+	}
+	return getBranchRefName(branch)
+}
+
+// isValidSymrefPart checks a symref name or target. Next to being valid ref names, they can't
+// contain the separators used in symref update requests and in the symref capability.
+func isValidSymrefPart(refname string) bool {
+	return isValidRefName(refname) && !strings.ContainsAny(refname, " :,=")
+}
+
+// parseSymrefUpdates parses a comma-separated list of name=target symrefs, as passed in
+// repo update requests. An empty target means that the symref should be removed.
+func parseSymrefUpdates(val string) (map[string]string, error) {
+	updates := make(map[string]string)
+	for _, update := range strings.Split(val, ",") {
+		update = strings.TrimSpace(update)
+		if update == "" {
+			continue
+		}
+		parts := strings.SplitN(update, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("Invalid symref update %s, expected name=target", update)
+		}
+		name, target := parts[0], parts[1]
+		if !isValidSymrefPart(name) {
+			return nil, errors.Errorf("Invalid symref name %s", name)
+		}
+		if target == "" {
+			updates[name] = ""
+			continue
+		}
+		if target == "HEAD" || !isValidSymrefPart(target) {
+			return nil, errors.Errorf("Invalid symref target %s", target)
+		}
+		updates[name] = target
+	}
+	return updates, nil
+}
+
+// validateSymrefUpdates verifies the default branch and symref fields of an update request,
+// so that they can be applied unconditionally once they are committed
+func validateSymrefUpdates(request datastructures.RepoUpdateRequest) error {
+	branch, hasbranch := request.UpdateRequest[datastructures.RepoUpdateDefaultBranch]
+	if hasbranch && !isValidSymrefPart(getBranchRefName(branch)) {
+		return errors.Errorf("Invalid default branch %s", branch)
+	}
+	symrefs, hassymrefs := request.UpdateRequest[datastructures.RepoUpdateSymrefs]
+	if !hassymrefs {
+		return nil
+	}
+	updates, err := parseSymrefUpdates(symrefs)
+	if err != nil {
+		return err
+	}
+	if _, updateshead := updates["HEAD"]; updateshead && hasbranch {
+		return errors.New("The default branch and a HEAD symref can not be set at the same time")
+	}
+	return nil
+}
+
+// applySymrefUpdates sets or removes the symrefs of a repo as requested
+func applySymrefUpdates(symrefs map[string]string, updates map[string]string) {
+	for name, target := range updates {
+		if target == "" {
+			delete(symrefs, name)
+		} else {
+			symrefs[name] = target
+		}
+	}
+}
+
+// guessHeadTarget returns the ref HEAD should point at for repos that were created before
+// they got a default branch: refs/heads/master if it exists, or else the first branch
+func guessHeadTarget(refs map[string]string) string {
+	if _, hasmaster := refs["refs/heads/master"]; hasmaster {
+		return "refs/heads/master"
+	}
+	var branches []string
+	for refname := range refs {
+		if strings.HasPrefix(refname, "refs/heads/") {
+			branches = append(branches, refname)
+		}
+	}
+	if len(branches) == 0 {
+		return ""
+	}
+	sort.Strings(branches)
+	return branches[0]
+}
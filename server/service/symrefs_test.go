@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"repospanner.org/repospanner/server/datastructures"
+)
+
+func TestParseSymrefUpdates(t *testing.T) {
+	updates, err := parseSymrefUpdates("HEAD=refs/heads/main, refs/heads/alias=refs/heads/other,,refs/heads/gone=")
+	if err != nil {
+		t.Fatalf("Error parsing symref updates: %s", err)
+	}
+	expected := map[string]string{
+		"HEAD":             "refs/heads/main",
+		"refs/heads/alias": "refs/heads/other",
+		"refs/heads/gone":  "",
+	}
+	if fmt.Sprint(updates) != fmt.Sprint(expected) {
+		t.Errorf("Symref updates are %v, expected %v", updates, expected)
+	}
+
+	for _, invalid := range []string{
+		"HEAD",
+		"HEAD:refs/heads/main",
+		"refs/heads/alias=HEAD",
+		"refs/heads/alias=refs/heads/a b",
+		"main=refs/heads/main",
+		"HEAD=main",
+		"refs/heads/../x=refs/heads/main",
+		"HEAD=refs/heads/main,refs/heads/a=b=c",
+	} {
+		if _, err := parseSymrefUpdates(invalid); err == nil {
+			t.Errorf("Invalid symref update %q was accepted", invalid)
+		}
+	}
+}
+
+func TestValidateSymrefUpdates(t *testing.T) {
+	cases := []struct {
+		update map[datastructures.RepoUpdateField]string
+		valid  bool
+	}{
+		{map[datastructures.RepoUpdateField]string{datastructures.RepoUpdateDefaultBranch: "main"}, true},
+		{map[datastructures.RepoUpdateField]string{datastructures.RepoUpdateDefaultBranch: "a b"}, false},
+		{map[datastructures.RepoUpdateField]string{datastructures.RepoUpdateSymrefs: "refs/heads/alias=refs/heads/main"}, true},
+		{map[datastructures.RepoUpdateField]string{
+			datastructures.RepoUpdateDefaultBranch: "main",
+			datastructures.RepoUpdateSymrefs:       "refs/heads/alias=refs/heads/main",
+		}, true},
+		{map[datastructures.RepoUpdateField]string{
+			datastructures.RepoUpdateDefaultBranch: "main",
+			datastructures.RepoUpdateSymrefs:       "HEAD=refs/heads/other",
+		}, false},
+	}
+	for _, c := range cases {
+		err := validateSymrefUpdates(datastructures.RepoUpdateRequest{UpdateRequest: c.update})
+		if (err == nil) != c.valid {
+			t.Errorf("Validating %v returned %v, expected valid: %t", c.update, err, c.valid)
+		}
+	}
+}
@@ -23,6 +23,11 @@ type syncer struct {
 	wg      *sync.WaitGroup
 
 	syncerStopC chan struct{}
+
+	peersMux   sync.Mutex
+	peerStopCs map[uint64]chan struct{}
+	// peerDoneCs are closed when the syncer of a peer has exited
+	peerDoneCs map[uint64]chan struct{}
 }
 
 func (cfg *Service) syncSingleObject(peer uint64, d storage.ProjectStorageDriver, reponame string, objid storage.ObjectID) error {
@@ -65,7 +70,7 @@ func (cfg *Service) syncSingleObject(peer uint64, d storage.ProjectStorageDriver
 func (s *syncer) Run(errchan chan error) {
 	s.errchan = errchan
 
-	for peerid := range s.cfg.statestore.Peers {
+	for peerid := range s.cfg.statestore.getPeers() {
 		s.AddPeer(peerid)
 	}
 }
@@ -75,7 +80,75 @@ func (s *syncer) AddPeer(peerid uint64) {
 		// We don't have to sync with ourselves
 		return
 	}
-	go s.runPeer(peerid)
+	s.peersMux.Lock()
+	defer s.peersMux.Unlock()
+	if _, running := s.peerStopCs[peerid]; running {
+		return
+	}
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	s.peerStopCs[peerid] = stopC
+	s.peerDoneCs[peerid] = doneC
+	s.wg.Add(1)
+	go s.runPeer(peerid, stopC, doneC)
+}
+
+// RemovePeer stops syncing to a peer that was removed from the region, and drops its outqueue
+// once the syncer of the peer has exited.
+// This doesn't wait for that, since a sync that is in progress can take a while.
+func (s *syncer) RemovePeer(peerid uint64) {
+	s.peersMux.Lock()
+	stopC, running := s.peerStopCs[peerid]
+	doneC := s.peerDoneCs[peerid]
+	delete(s.peerStopCs, peerid)
+	delete(s.peerDoneCs, peerid)
+	s.peersMux.Unlock()
+	if !running {
+		s.removePeerQueue(peerid)
+		return
+	}
+
+	close(stopC)
+	go func() {
+		<-doneC
+		s.removePeerQueue(peerid)
+	}()
+}
+
+func (s *syncer) removePeerQueue(peerid uint64) {
+	if err := os.RemoveAll(s.getPeerQueueDir(peerid)); err != nil {
+		s.cfg.log.Infow(
+			"Error removing outgoing queue of removed peer",
+			"peer", peerid,
+			"error", err,
+		)
+	}
+}
+
+func (s *syncer) getPeerQueueDir(peerid uint64) string {
+	return path.Join(
+		s.cfg.statestore.directory,
+		"async-outqueues",
+		"peer-"+strconv.FormatUint(peerid, 16),
+	)
+}
+
+// getPeerBacklog returns the number of pushes waiting to be synced to a peer
+func (s *syncer) getPeerBacklog(peerid uint64) (int, error) {
+	dirent, err := ioutil.ReadDir(s.getPeerQueueDir(peerid))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	backlog := 0
+	for _, file := range dirent {
+		if !file.IsDir() && !strings.HasSuffix(file.Name(), ".inprogress") {
+			backlog++
+		}
+	}
+	return backlog, nil
 }
 
 func (s *syncer) syncSingleFile(peerid uint64, queue *os.File) error {
@@ -125,11 +198,7 @@ func (s *syncer) runSingleSync(peerid uint64) {
 		"Running single sync",
 		"peer", peerid,
 	)
-	peerdir := path.Join(
-		s.cfg.statestore.directory,
-		"async-outqueues",
-		"peer-"+strconv.FormatUint(peerid, 16),
-	)
+	peerdir := s.getPeerQueueDir(peerid)
 	dirent, err := ioutil.ReadDir(peerdir)
 	if os.IsNotExist(err) {
 		// If there is no outgoing queue, there's nothing to sync
@@ -205,18 +274,23 @@ func (s *syncer) runSingleSync(peerid uint64) {
 	}
 }
 
-func (s *syncer) runPeer(peerid uint64) {
+func (s *syncer) runPeer(peerid uint64, peerStopC, peerDoneC chan struct{}) {
 	s.cfg.log.Debugw("Starting peer syncer", "peer", peerid)
-	s.wg.Add(1)
 	defer s.wg.Done()
+	defer close(peerDoneC)
 
 	peerticker := time.NewTicker(5 * time.Minute)
+	defer peerticker.Stop()
 
 	for {
 		select {
 		case <-s.syncerStopC:
 			// Stop syncer
 			return
+		case <-peerStopC:
+			// Peer was removed
+			s.cfg.log.Debugw("Stopping peer syncer", "peer", peerid)
+			return
 		case <-peerticker.C:
 			// Perform a sync
 			s.runSingleSync(peerid)
@@ -229,6 +303,8 @@ func (cfg *Service) createSyncer() (*syncer, error) {
 		cfg:         cfg,
 		syncerStopC: make(chan struct{}),
 		wg:          new(sync.WaitGroup),
+		peerStopCs:  make(map[uint64]chan struct{}),
+		peerDoneCs:  make(map[uint64]chan struct{}),
 	}, nil
 }
 
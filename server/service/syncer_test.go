@@ -0,0 +1,63 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSyncerRemovePeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repospanner_test_")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Service{
+		nodeid:     1,
+		log:        zap.NewNop().Sugar(),
+		statestore: &stateStore{directory: dir},
+	}
+	s, err := cfg.createSyncer()
+	if err != nil {
+		t.Fatalf("Error creating syncer: %s", err)
+	}
+	s.errchan = make(chan error, 1)
+
+	s.AddPeer(2)
+	queuedir := s.getPeerQueueDir(2)
+	if err := os.MkdirAll(queuedir, 0755); err != nil {
+		t.Fatalf("Error creating queue directory: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(queuedir, "push"), []byte("queued\n"), 0644); err != nil {
+		t.Fatalf("Error writing queue file: %s", err)
+	}
+
+	s.peersMux.Lock()
+	doneC := s.peerDoneCs[2]
+	s.peersMux.Unlock()
+	s.RemovePeer(2)
+	select {
+	case <-doneC:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Peer syncer did not exit")
+	}
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(queuedir); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("Queue of removed peer still exists: %v", err)
+	}
+
+	s.Stop()
+	if err := <-s.errchan; err != nil {
+		t.Errorf("Syncer stopped with error: %s", err)
+	}
+}
@@ -76,12 +76,18 @@ func ObjectTypeFromHdrName(hdrname string) ObjectType {
 
 type StorageDriver interface {
 	GetProjectStorage(project string) ProjectStorageDriver
+	// DeleteProject removes all objects stored for a project
+	DeleteProject(project string) error
+	// RenameProject moves all objects of a project to newproject
+	RenameProject(project, newproject string) error
 }
 
 var ErrObjectNotFound = errors.New("Object not found")
 
 type ProjectStorageDriver interface {
 	ReadObject(objectid ObjectID) (ObjectType, uint, io.ReadCloser, error)
+	// DeleteObject removes a single object, it is not an error if it doesn't exist
+	DeleteObject(objectid ObjectID) error
 
 	GetPusher(pushuuid string) ProjectStoragePushDriver
 }
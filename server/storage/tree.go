@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -18,6 +19,108 @@ func (d *treeStorageDriverInstance) GetProjectStorage(project string) ProjectSto
 	return &treeStorageProjectDriverInstance{t: d, p: project}
 }
 
+// getObjectDirs returns the object directories of a project.
+// Other entries in the project directory can be projects nested under this one.
+func (d *treeStorageDriverInstance) getObjectDirs(project string) ([]string, error) {
+	projdir := path.Join(d.dirname, project)
+	entries, err := ioutil.ReadDir(projdir)
+	if os.IsNotExist(err) {
+		// Nothing was ever stored
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var objdirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !isObjectDirName(entry.Name()) {
+			continue
+		}
+		objects, err := ioutil.ReadDir(path.Join(projdir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		isobjdir := true
+		for _, object := range objects {
+			if object.IsDir() {
+				isobjdir = false
+				break
+			}
+		}
+		if isobjdir {
+			objdirs = append(objdirs, entry.Name())
+		}
+	}
+	return objdirs, nil
+}
+
+func isObjectDirName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *treeStorageDriverInstance) DeleteProject(project string) error {
+	objdirs, err := d.getObjectDirs(project)
+	if err != nil {
+		return err
+	}
+	projdir := path.Join(d.dirname, project)
+	for _, objdir := range objdirs {
+		if err := os.RemoveAll(path.Join(projdir, objdir)); err != nil {
+			return err
+		}
+	}
+	// This fails if there are nested projects, which is fine
+	os.Remove(projdir)
+	return nil
+}
+
+func (d *treeStorageDriverInstance) RenameProject(project, newproject string) error {
+	objdirs, err := d.getObjectDirs(project)
+	if err != nil {
+		return err
+	}
+	projdir := path.Join(d.dirname, project)
+	newprojdir := path.Join(d.dirname, newproject)
+	for _, objdir := range objdirs {
+		olddir := path.Join(projdir, objdir)
+		newdir := path.Join(newprojdir, objdir)
+		if err := os.MkdirAll(newprojdir, 0755); err != nil {
+			return err
+		}
+		err := os.Rename(olddir, newdir)
+		if err == nil {
+			continue
+		}
+		if _, staterr := os.Stat(newdir); staterr != nil {
+			return err
+		}
+		// The new project already has objects in this directory, move the objects one by one
+		objects, err := ioutil.ReadDir(olddir)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if err := os.Rename(path.Join(olddir, object.Name()), path.Join(newdir, object.Name())); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(olddir); err != nil {
+			return err
+		}
+	}
+	// This fails if there are nested projects, which is fine
+	os.Remove(projdir)
+	return nil
+}
+
 type treeStorageProjectDriverInstance struct {
 	t *treeStorageDriverInstance
 	p string
@@ -58,6 +161,14 @@ func (t *treeStorageProjectDriverInstance) ReadObject(objectid ObjectID) (Object
 	return objtype, len, f, nil
 }
 
+func (t *treeStorageProjectDriverInstance) DeleteObject(objectid ObjectID) error {
+	err := os.Remove(t.getObjPath(objectid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 func (t *treeStorageProjectDriverInstance) GetPusher(_ string) ProjectStoragePushDriver {
 	return &treeStorageProjectPushDriverInstance{
 		t: t,
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestBlob(t *testing.T, d StorageDriver, project, content string) ObjectID {
+	pusher := d.GetProjectStorage(project).GetPusher("")
+	staged, err := pusher.StageObject(ObjectTypeBlob, uint(len(content)))
+	if err != nil {
+		t.Fatalf("StageObject returned error: %s", err)
+	}
+	defer staged.Close()
+	if _, err := staged.Write([]byte(content)); err != nil {
+		t.Fatalf("Staged write returned error: %s", err)
+	}
+	objid, err := staged.Finalize(ZeroID)
+	if err != nil {
+		t.Fatalf("Finalize returned error: %s", err)
+	}
+	pusher.Done()
+	return objid
+}
+
+func checkHasObject(t *testing.T, d StorageDriver, project string, objid ObjectID, expected bool) {
+	_, _, r, err := d.GetProjectStorage(project).ReadObject(objid)
+	if err == nil {
+		r.Close()
+	} else if err != ErrObjectNotFound {
+		t.Fatalf("ReadObject returned error: %s", err)
+	}
+	if (err == nil) != expected {
+		t.Errorf("Object %s in %s exists: %t, expected %t", objid, project, err == nil, expected)
+	}
+}
+
+// writeNestedTestBlob stores a blob in project parent/child.
+// The blob is written to a separate project first, since newer Go versions don't allow staging
+// objects for projects with a slash in their name.
+func writeNestedTestBlob(t *testing.T, d *treeStorageDriverInstance, parent, content string) ObjectID {
+	objid := writeTestBlob(t, d, "nested_child", content)
+	if err := os.MkdirAll(path.Join(d.dirname, parent), 0755); err != nil {
+		t.Fatalf("Error creating project directory: %s", err)
+	}
+	if err := os.Rename(path.Join(d.dirname, "nested_child"), path.Join(d.dirname, parent, "child")); err != nil {
+		t.Fatalf("Error moving nested project: %s", err)
+	}
+	return objid
+}
+
+func newTestTreeStorageDriver(t *testing.T) (*treeStorageDriverInstance, func()) {
+	dir, err := ioutil.TempDir("", "repospanner_test_")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s", err)
+	}
+	return &treeStorageDriverInstance{dirname: dir}, func() { os.RemoveAll(dir) }
+}
+
+func TestTreeDeleteProject(t *testing.T) {
+	d, cleanup := newTestTreeStorageDriver(t)
+	defer cleanup()
+
+	blob := writeTestBlob(t, d, "project", "project\n")
+	other := writeTestBlob(t, d, "other", "other\n")
+	nested := writeNestedTestBlob(t, d, "project", "nested\n")
+
+	if err := d.DeleteProject("project"); err != nil {
+		t.Fatalf("DeleteProject returned error: %s", err)
+	}
+	checkHasObject(t, d, "project", blob, false)
+	checkHasObject(t, d, "project/child", nested, true)
+	checkHasObject(t, d, "other", other, true)
+
+	if err := d.DeleteProject("project/child"); err != nil {
+		t.Fatalf("DeleteProject of nested project returned error: %s", err)
+	}
+	checkHasObject(t, d, "project/child", nested, false)
+	if err := d.DeleteProject("project"); err != nil {
+		t.Fatalf("DeleteProject of empty project returned error: %s", err)
+	}
+	if _, err := os.Stat(path.Join(d.dirname, "project")); !os.IsNotExist(err) {
+		t.Errorf("Project directory still exists: %v", err)
+	}
+
+	if err := d.DeleteProject("missing"); err != nil {
+		t.Errorf("DeleteProject of missing project returned error: %s", err)
+	}
+}
+
+func TestTreeRenameProject(t *testing.T) {
+	d, cleanup := newTestTreeStorageDriver(t)
+	defer cleanup()
+
+	blob := writeTestBlob(t, d, "old", "old\n")
+	shared := writeTestBlob(t, d, "old", "shared\n")
+	nested := writeNestedTestBlob(t, d, "old", "nested\n")
+	// The new project already has objects, in the same object directory as one of the moved objects
+	writeTestBlob(t, d, "new", "shared\n")
+	existing := writeTestBlob(t, d, "new", "existing\n")
+
+	if err := d.RenameProject("old", "new"); err != nil {
+		t.Fatalf("RenameProject returned error: %s", err)
+	}
+	checkHasObject(t, d, "new", blob, true)
+	checkHasObject(t, d, "new", shared, true)
+	checkHasObject(t, d, "new", existing, true)
+	checkHasObject(t, d, "old", blob, false)
+	checkHasObject(t, d, "old", shared, false)
+	checkHasObject(t, d, "old/child", nested, true)
+	checkHasObject(t, d, "new/child", nested, false)
+
+	if err := d.RenameProject("new", "group/renamed"); err != nil {
+		t.Fatalf("RenameProject to nested name returned error: %s", err)
+	}
+	checkHasObject(t, d, "group/renamed", blob, true)
+	if _, err := os.Stat(path.Join(d.dirname, "new")); !os.IsNotExist(err) {
+		t.Errorf("Old project directory still exists: %v", err)
+	}
+
+	if err := d.RenameProject("missing", "other"); err != nil {
+		t.Errorf("RenameProject of missing project returned error: %s", err)
+	}
+}
+
+func TestTreeDeleteObject(t *testing.T) {
+	d, cleanup := newTestTreeStorageDriver(t)
+	defer cleanup()
+
+	blob := writeTestBlob(t, d, "project", "project\n")
+	other := writeTestBlob(t, d, "project", "other\n")
+
+	p := d.GetProjectStorage("project")
+	if err := p.DeleteObject(blob); err != nil {
+		t.Fatalf("DeleteObject returned error: %s", err)
+	}
+	checkHasObject(t, d, "project", blob, false)
+	checkHasObject(t, d, "project", other, true)
+
+	if err := p.DeleteObject(blob); err != nil {
+		t.Errorf("DeleteObject of missing object returned error: %s", err)
+	}
+}